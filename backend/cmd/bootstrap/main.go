@@ -0,0 +1,93 @@
+// Command bootstrap creates the first admin user, or promotes an existing user to admin.
+// It refuses to run once an admin exists unless -force is set.
+//
+// Usage:
+//
+//	go run ./cmd/bootstrap -mode=dev -email=admin@example.com -password=secret123
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/AkifhanIlgaz/dictionary-api/config"
+	"github.com/AkifhanIlgaz/dictionary-api/models"
+	"github.com/AkifhanIlgaz/dictionary-api/services"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/db"
+	plan "github.com/AkifhanIlgaz/dictionary-api/utils/plans"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/rbac"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func main() {
+	email := flag.String("email", "", "Email of the admin user")
+	password := flag.String("password", "", "Password, required if the user does not exist yet")
+	force := flag.Bool("force", false, "Promote the user even if an admin already exists")
+
+	config, err := config.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *email == "" {
+		log.Fatal("-email is required")
+	}
+
+	ctx := context.TODO()
+
+	mongoClient, err := db.Connect(ctx, config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer func() {
+		if err = mongoClient.Disconnect(ctx); err != nil {
+			panic(err)
+		}
+	}()
+
+	plans, err := plan.LoadRegistry(config.PlansFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	authService, err := services.NewAuthService(ctx, mongoClient.Database(db.DatabaseName), plans)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	admins, err := authService.CountByRole(rbac.AdminRole)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if admins > 0 && !*force {
+		log.Fatal("an admin already exists, use -force to promote another user")
+	}
+
+	var uid string
+	user, err := authService.GetByEmail(*email)
+	switch {
+	case err == nil:
+		uid = user.Id.Hex()
+	case errors.Is(err, mongo.ErrNoDocuments):
+		if len(*password) < 6 {
+			log.Fatal("-password of at least 6 characters is required to create a new user")
+		}
+
+		id, err := authService.Create(models.AuthRequest{Email: *email, Password: *password})
+		if err != nil {
+			log.Fatal(err)
+		}
+		uid = id.Hex()
+	default:
+		log.Fatal(err)
+	}
+
+	if err := authService.SetRole(uid, rbac.AdminRole); err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("%s (%s) is now an admin\n", *email, uid)
+}
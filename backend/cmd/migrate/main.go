@@ -0,0 +1,110 @@
+// Command migrate runs a one-off data migration against the configured database.
+// Migrations are safe to run more than once.
+//
+// Usage:
+//
+//	go run ./cmd/migrate -mode=prod -name=hash-api-keys
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/AkifhanIlgaz/dictionary-api/config"
+	"github.com/AkifhanIlgaz/dictionary-api/services"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/db"
+	plan "github.com/AkifhanIlgaz/dictionary-api/utils/plans"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// migrations maps the migration names to the functions that run them.
+// Each function returns the number of migrated documents.
+var migrations = map[string]func(ctx context.Context, mongoDatabase *mongo.Database, config config.Config) (int, error){
+	"hash-api-keys":        hashApiKeys,
+	"normalize-plans":      normalizePlans,
+	"utc-usage":            utcUsage,
+	"quota-alert-webhooks": quotaAlertWebhooks,
+}
+
+func main() {
+	name := flag.String("name", "", "Name of the migration to run")
+
+	config, err := config.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	migration, ok := migrations[*name]
+	if !ok {
+		log.Fatalf("unknown migration: %q", *name)
+	}
+
+	ctx := context.TODO()
+
+	mongoClient, err := db.Connect(ctx, config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer func() {
+		if err = mongoClient.Disconnect(ctx); err != nil {
+			panic(err)
+		}
+	}()
+
+	count, err := migration(ctx, mongoClient.Database(db.DatabaseName), config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("%s: migrated %d documents\n", *name, count)
+}
+
+// hashApiKeys replaces API keys stored in clear text with their prefix and hash
+func hashApiKeys(ctx context.Context, mongoDatabase *mongo.Database, config config.Config) (int, error) {
+	userService, err := services.NewUserService(ctx, mongoDatabase, config)
+	if err != nil {
+		return 0, err
+	}
+
+	return userService.MigrateApiKeysToHashes()
+}
+
+// normalizePlans rewrites stored plans to the plan IDs of the registry, e.g. "Free" to "free"
+func normalizePlans(ctx context.Context, mongoDatabase *mongo.Database, config config.Config) (int, error) {
+	plans, err := plan.LoadRegistry(config.PlansFile)
+	if err != nil {
+		return 0, err
+	}
+
+	authService, err := services.NewAuthService(ctx, mongoDatabase, plans)
+	if err != nil {
+		return 0, err
+	}
+
+	return authService.NormalizePlans()
+}
+
+// quotaAlertWebhooks moves the webhook URLs of quota alert settings to webhook endpoints subscribed to quota events
+func quotaAlertWebhooks(ctx context.Context, mongoDatabase *mongo.Database, config config.Config) (int, error) {
+	webhookService, err := services.NewWebhookService(ctx, mongoDatabase, config)
+	if err != nil {
+		return 0, err
+	}
+
+	return webhookService.MigrateQuotaAlertWebhooks()
+}
+
+// utcUsage moves daily usage recorded in the timezone of the servers to UTC days.
+// It has to run with the TZ of the servers that recorded the usage.
+func utcUsage(ctx context.Context, mongoDatabase *mongo.Database, config config.Config) (int, error) {
+	// Only MongoDB is used by the migration
+	usageService, err := services.NewUsageService(ctx, mongoDatabase, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	return usageService.MigrateDailyUsageToUTC(time.Local)
+}
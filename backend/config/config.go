@@ -14,11 +14,83 @@ type Config struct {
 	AccessTokenPrivateKey string `mapstructure:"ACCESS_TOKEN_PRIVATE_KEY"`
 	AccessTokenPublicKey  string `mapstructure:"ACCESS_TOKEN_PUBLIC_KEY"`
 	AccessTokenExpiry     int    `mapstructure:"ACCESS_TOKEN_EXPIRY_HOUR"`
+	// AccessTokenRotation is the interval in hours for generating a new access token signing key, 0 disables rotation
+	AccessTokenRotation int `mapstructure:"ACCESS_TOKEN_ROTATION_HOUR"`
+	// SigningKeyEncryptionKey is the base64 encoded 32 byte key that rotated signing keys are encrypted with
+	// before they are stored, required with rotation
+	SigningKeyEncryptionKey string `mapstructure:"SIGNING_KEY_ENCRYPTION_KEY"`
 
 	RefreshTokenPrivateKey string `mapstructure:"REFRESH_TOKEN_PRIVATE_KEY"`
 	RefreshTokenPublicKey  string `mapstructure:"REFRESH_TOKEN_PUBLIC_KEY"`
 	RefreshTokenExpiry     int    `mapstructure:"REFRESH_TOKEN_EXPIRY_HOUR"`
 
+	// APIKeySecret is the HMAC secret API keys are hashed with before they are stored
+	APIKeySecret string `mapstructure:"API_KEY_HMAC_SECRET"`
+	// APIKeyRotationGrace is the default number of hours a rotated API key keeps working
+	APIKeyRotationGrace int `mapstructure:"API_KEY_ROTATION_GRACE_HOUR"`
+	// APIKeyQueryParam still accepts API keys in the deprecated ?apikey= query parameter
+	APIKeyQueryParam bool `mapstructure:"ALLOW_API_KEY_QUERY_PARAM"`
+	// LegacyAPIKeys still accepts API keys stored in clear text by earlier versions until they are migrated
+	LegacyAPIKeys bool `mapstructure:"ALLOW_LEGACY_API_KEYS"`
+
+	// OAuthRedirectURL is the frontend callback base URL, the provider name is appended to it
+	OAuthRedirectURL   string `mapstructure:"OAUTH_REDIRECT_URL"`
+	GoogleClientID     string `mapstructure:"GOOGLE_CLIENT_ID"`
+	GoogleClientSecret string `mapstructure:"GOOGLE_CLIENT_SECRET"`
+	GitHubClientID     string `mapstructure:"GITHUB_CLIENT_ID"`
+	GitHubClientSecret string `mapstructure:"GITHUB_CLIENT_SECRET"`
+	OIDCIssuer         string `mapstructure:"OIDC_ISSUER"`
+	OIDCClientID       string `mapstructure:"OIDC_CLIENT_ID"`
+	OIDCClientSecret   string `mapstructure:"OIDC_CLIENT_SECRET"`
+
+	// TrustedProxies is a comma separated list of proxy IPs or CIDR ranges whose X-Forwarded-For header
+	// is trusted for the client IP, none are trusted if empty
+	TrustedProxies string `mapstructure:"TRUSTED_PROXIES"`
+
+	// ChargedStatuses are the response statuses that count towards the quota, e.g. "200-299,304"
+	ChargedStatuses string `mapstructure:"CHARGED_STATUS_CODES"`
+
+	// PlansFile is the path of a JSON file defining the plans, the built-in plans are used if empty
+	PlansFile string `mapstructure:"PLANS_FILE"`
+
+	// PaymentProvider is the payment provider subscriptions are billed with. The local fake provider, "fake",
+	// is only available in dev mode.
+	PaymentProvider string `mapstructure:"PAYMENT_PROVIDER"`
+	// PaymentWebhookSecret verifies the signatures of the payment provider's webhooks
+	PaymentWebhookSecret string `mapstructure:"PAYMENT_WEBHOOK_SECRET"`
+	// BillingSuccessURL and BillingCancelURL are the frontend pages the checkout returns to
+	BillingSuccessURL string `mapstructure:"BILLING_SUCCESS_URL"`
+	BillingCancelURL  string `mapstructure:"BILLING_CANCEL_URL"`
+
+	// InvoiceNumberPrefix precedes the sequential invoice numbers, "INV" if empty
+	InvoiceNumberPrefix string `mapstructure:"INVOICE_NUMBER_PREFIX"`
+	// InvoiceTaxName and InvoiceTaxRate are the tax added to invoices, e.g. "VAT" and 20 for 20%, none if the rate is 0
+	InvoiceTaxName string  `mapstructure:"INVOICE_TAX_NAME"`
+	InvoiceTaxRate float64 `mapstructure:"INVOICE_TAX_RATE"`
+	// InvoiceSellerName, InvoiceSellerAddress and InvoiceSellerTaxId are printed on invoices as their issuer,
+	// the comma separated parts of the address on their own lines
+	InvoiceSellerName    string `mapstructure:"INVOICE_SELLER_NAME"`
+	InvoiceSellerAddress string `mapstructure:"INVOICE_SELLER_ADDRESS"`
+	InvoiceSellerTaxId   string `mapstructure:"INVOICE_SELLER_TAX_ID"`
+
+	// SMTPHost is the SMTP server emails are sent through, emails are only logged if empty
+	SMTPHost     string `mapstructure:"SMTP_HOST"`
+	SMTPPort     string `mapstructure:"SMTP_PORT"`
+	SMTPUsername string `mapstructure:"SMTP_USERNAME"`
+	SMTPPassword string `mapstructure:"SMTP_PASSWORD"`
+	// MailFrom is the sender address of emails
+	MailFrom string `mapstructure:"MAIL_FROM"`
+
+	// OrgInvitationURL is the frontend page organization invitations are accepted on, the token is appended as ?token=
+	OrgInvitationURL string `mapstructure:"ORG_INVITATION_URL"`
+
+	// WebhookAllowPrivateURLs allows webhooks to loopback and private network addresses, e.g. for local development
+	WebhookAllowPrivateURLs bool `mapstructure:"WEBHOOK_ALLOW_PRIVATE_URLS"`
+
+	// AuditHashChain chains the events of the audit log with hashes to make changes and deletions evident,
+	// at the cost of recording events one at a time
+	AuditHashChain bool `mapstructure:"AUDIT_HASH_CHAIN"`
+
 	Mode string
 
 	RedisConnectionString string `mapstructure:"REDIS_CONNECTION_STRING"`
@@ -32,6 +104,11 @@ func (c *Config) Validate() error {
 	return nil
 }
 
+// IsDev reports whether the app runs in dev mode
+func (c Config) IsDev() bool {
+	return c.Mode == devMode
+}
+
 func Load() (Config, error) {
 	var config Config
 
@@ -0,0 +1,826 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/AkifhanIlgaz/dictionary-api/middlewares"
+	"github.com/AkifhanIlgaz/dictionary-api/models"
+	"github.com/AkifhanIlgaz/dictionary-api/services"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/api"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/message"
+	plan "github.com/AkifhanIlgaz/dictionary-api/utils/plans"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/rbac"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/response"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const AdminPath = "/admin"
+
+// AdminController serves the tools of support staff and admins. Every action, including reading the data of a user,
+// is recorded in the audit log.
+type AdminController struct {
+	authService         services.AuthService
+	userService         services.UserService
+	usageService        services.UsageService
+	rateLimitService    services.RateLimitService
+	subscriptionService services.SubscriptionService
+	organizationService services.OrganizationService
+	webhookService      services.WebhookService
+	tokenService        services.TokenService
+	auditService        services.AuditService
+	userMiddleware      middlewares.UserMiddleware
+}
+
+func NewAdminController(authService services.AuthService, userService services.UserService, usageService services.UsageService, rateLimitService services.RateLimitService, subscriptionService services.SubscriptionService, organizationService services.OrganizationService, webhookService services.WebhookService, tokenService services.TokenService, auditService services.AuditService, userMiddleware middlewares.UserMiddleware) AdminController {
+	return AdminController{
+		authService:         authService,
+		userService:         userService,
+		usageService:        usageService,
+		rateLimitService:    rateLimitService,
+		subscriptionService: subscriptionService,
+		organizationService: organizationService,
+		webhookService:      webhookService,
+		tokenService:        tokenService,
+		auditService:        auditService,
+		userMiddleware:      userMiddleware,
+	}
+}
+
+func (controller AdminController) SetupRoutes(rg *gin.RouterGroup) {
+	router := rg.Group(AdminPath)
+	router.Use(controller.userMiddleware.AuthenticateUser())
+
+	router.GET("/users", controller.userMiddleware.Authorize(rbac.ReadUsers), controller.SearchUsers)
+	router.GET("/users/:id", controller.userMiddleware.Authorize(rbac.ReadUsers), controller.GetUser)
+	router.GET("/users/:id/api-keys", controller.userMiddleware.Authorize(rbac.ReadUsers), controller.GetUserAPIKeys)
+	router.DELETE("/users/:id/api-keys/:keyId", controller.userMiddleware.Authorize(rbac.RevokeKeys), controller.RevokeUserAPIKey)
+	router.GET("/users/:id/usage", controller.userMiddleware.Authorize(rbac.ReadUsers), controller.GetUserUsageHistory)
+	router.GET("/users/:id/quota", controller.userMiddleware.Authorize(rbac.ReadUsers), controller.GetUserQuota)
+	router.PUT("/users/:id/plan", controller.userMiddleware.Authorize(rbac.ManagePlans), controller.SetUserPlan)
+	router.PUT("/users/:id/suspension", controller.userMiddleware.Authorize(rbac.ManageUsers), controller.SuspendUser)
+	router.DELETE("/users/:id/suspension", controller.userMiddleware.Authorize(rbac.ManageUsers), controller.UnsuspendUser)
+	router.POST("/users/:id/impersonate", controller.userMiddleware.Authorize(rbac.ImpersonateUsers), controller.ImpersonateUser)
+	router.PUT("/users/:id/role", controller.userMiddleware.Authorize(rbac.ManageRoles), controller.SetRole)
+	router.GET("/audit-log", controller.userMiddleware.Authorize(rbac.ReadAuditLog), controller.GetAuditLog)
+	router.GET("/audit-log/verify", controller.userMiddleware.Authorize(rbac.ReadAuditLog), controller.VerifyAuditLog)
+	router.POST("/dataset/updated", controller.userMiddleware.Authorize(rbac.ManageDataset), controller.PublishDatasetUpdate)
+	router.PUT("/organizations/:id/plan", controller.userMiddleware.Authorize(rbac.ManagePlans), controller.SetOrganizationPlan)
+}
+
+// @Summary Search users
+// @Description Finds the users whose email starts with the given text, case insensitively, sorted by email
+// @Tags Admin
+// @Produce json
+// @Security BearerAuth
+// @Param email query string true "Start of the email"
+// @Param limit query int false "Maximum number of users, at most 100" default(20)
+// @Success 200 {object} response.Response{data=models.UsersResponse} "Users retrieved"
+// @Failure 400 {object} response.Response "Missing email"
+// @Failure 403 {object} response.Response "Forbidden"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /admin/users [get]
+func (controller AdminController) SearchUsers(ctx *gin.Context) {
+	var req models.UserSearchRequest
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		log.Println(err.Error())
+		response.WithError(ctx, http.StatusBadRequest, message.EmailQueryRequired)
+		return
+	}
+
+	users, err := controller.authService.SearchByEmail(req.Email, req.Limit)
+	if err != nil {
+		log.Println(err.Error())
+		response.WithError(ctx, http.StatusInternalServerError, message.UserError)
+		return
+	}
+
+	recordAuditEvent(controller.auditService, ctx, models.UsersSearchedAction, "", map[string]string{
+		"email":   req.Email,
+		"results": strconv.Itoa(len(users)),
+	})
+
+	response.WithSuccess(ctx, http.StatusOK, message.UsersRetrieved, models.UsersResponse{
+		Users: users,
+	})
+}
+
+// @Summary Get user
+// @Description Retrieves a user with their plan, role, billing settings and suspension
+// @Tags Admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "User ID"
+// @Success 200 {object} response.Response{data=models.AdminUserResponse} "User retrieved"
+// @Failure 403 {object} response.Response "Forbidden"
+// @Failure 404 {object} response.Response "User not found"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /admin/users/{id} [get]
+func (controller AdminController) GetUser(ctx *gin.Context) {
+	user, ok := controller.user(ctx)
+	if !ok {
+		return
+	}
+
+	recordAuditEvent(controller.auditService, ctx, models.UserViewedAction, user.Id.Hex(), nil)
+
+	response.WithSuccess(ctx, http.StatusOK, message.UserRetrieved, adminUserResponse(user))
+}
+
+// @Summary Get user API keys
+// @Description Lists the API keys a user created, personal keys and keys of organizations, oldest first
+// @Tags Admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "User ID"
+// @Success 200 {object} response.Response{data=models.APIKeysResponse} "API keys retrieved"
+// @Failure 403 {object} response.Response "Forbidden"
+// @Failure 404 {object} response.Response "User not found"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /admin/users/{id}/api-keys [get]
+func (controller AdminController) GetUserAPIKeys(ctx *gin.Context) {
+	user, ok := controller.user(ctx)
+	if !ok {
+		return
+	}
+	uid := user.Id.Hex()
+
+	apiKeys, err := controller.userService.ListUserApiKeys(uid)
+	if err != nil {
+		log.Println(err.Error())
+		response.WithError(ctx, http.StatusInternalServerError, message.ApiKeyError)
+		return
+	}
+
+	recordAuditEvent(controller.auditService, ctx, models.APIKeysViewedAction, uid, nil)
+
+	response.WithSuccess(ctx, http.StatusOK, message.ApiKeyRetrieved, models.APIKeysResponse{
+		APIKeys: apiKeys,
+	})
+}
+
+// @Summary Revoke user API key
+// @Description Revokes an API key a user created, personal or of an organization.
+// @Description The user is sent an api_key.revoked webhook event.
+// @Tags Admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "User ID"
+// @Param keyId path string true "API key ID"
+// @Success 200 {object} response.Response "API key revoked"
+// @Failure 403 {object} response.Response "Forbidden"
+// @Failure 404 {object} response.Response "API key not found"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /admin/users/{id}/api-keys/{keyId} [delete]
+func (controller AdminController) RevokeUserAPIKey(ctx *gin.Context) {
+	uid := ctx.Param(api.IdParam)
+
+	apiKey, err := controller.userService.DeleteUserApiKey(uid, ctx.Param(api.KeyIdParam))
+	if err != nil {
+		log.Println(err.Error())
+		handleApiKeyError(ctx, err)
+		return
+	}
+	publishApiKeyEvent(controller.webhookService, uid, models.APIKeyRevokedEvent, apiKey)
+
+	recordAuditEvent(controller.auditService, ctx, models.APIKeyRevokedAction, uid, apiKeyAuditDetails(apiKey))
+
+	response.WithSuccess(ctx, http.StatusOK, message.ApiKeyDeleted, nil)
+}
+
+// @Summary Get user usage history
+// @Description Retrieves the usage history of the personal API keys of a user like the user sees it
+// @Tags Admin
+// @Produce json,text/csv
+// @Security BearerAuth
+// @Param id path string true "User ID"
+// @Param from query string false "Start date (2006-01-02) or RFC 3339 time, 30 days or 24 hours before to by default"
+// @Param to query string false "End date, inclusive, or RFC 3339 time, exclusive, now by default"
+// @Param granularity query string false "Bucket size" Enums(day, hour) default(day)
+// @Param groupBy query string false "Comma separated dimensions to break down by, all by default" example(key,endpoint,status)
+// @Param format query string false "Response format, CSV can also be requested with Accept: text/csv" Enums(json, csv) default(json)
+// @Success 200 {object} response.Response{data=models.UsageHistoryResponse} "Usage history retrieved"
+// @Failure 400 {object} response.Response "Invalid range, granularity, groupBy or format"
+// @Failure 403 {object} response.Response "Forbidden"
+// @Failure 404 {object} response.Response "User not found"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /admin/users/{id}/usage [get]
+func (controller AdminController) GetUserUsageHistory(ctx *gin.Context) {
+	var req models.UsageHistoryRequest
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		response.WithError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	format, ok := usageFormat(ctx, req)
+	if !ok {
+		response.WithError(ctx, http.StatusBadRequest, message.InvalidUsageFormat)
+		return
+	}
+
+	user, ok := controller.user(ctx)
+	if !ok {
+		return
+	}
+	uid := user.Id.Hex()
+
+	history, err := controller.usageService.GetHistory(uid, req, time.Now())
+	if err != nil {
+		log.Println(err.Error())
+		handleUsageHistoryError(ctx, err)
+		return
+	}
+
+	recordAuditEvent(controller.auditService, ctx, models.UsageViewedAction, uid, map[string]string{
+		"view":  "history",
+		"query": ctx.Request.URL.RawQuery,
+	})
+
+	if format == api.CSVFormat {
+		writeUsageCSV(ctx, history)
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusOK, message.UsageRetrieved, history)
+}
+
+// @Summary Get user quota
+// @Description Retrieves the usage of the current quota window of a user like the user sees it
+// @Tags Admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "User ID"
+// @Success 200 {object} response.Response{data=models.QuotaUsage} "Quota retrieved"
+// @Failure 403 {object} response.Response "Forbidden"
+// @Failure 404 {object} response.Response "User not found"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /admin/users/{id}/quota [get]
+func (controller AdminController) GetUserQuota(ctx *gin.Context) {
+	user, ok := controller.user(ctx)
+	if !ok {
+		return
+	}
+	uid := user.Id.Hex()
+	now := time.Now()
+
+	userQuota, err := controller.authService.GetUserQuota(uid, now)
+	if err != nil {
+		log.Println(err.Error())
+		response.WithError(ctx, http.StatusInternalServerError, message.UserError)
+		return
+	}
+
+	quota, err := controller.rateLimitService.GetQuotaUsage(uid, userQuota, now)
+	if err != nil {
+		log.Println(err.Error())
+		response.WithError(ctx, http.StatusInternalServerError, message.UserError)
+		return
+	}
+
+	recordAuditEvent(controller.auditService, ctx, models.UsageViewedAction, uid, map[string]string{
+		"view": "quota",
+	})
+
+	response.WithSuccess(ctx, http.StatusOK, message.UsageRetrieved, quota)
+}
+
+// @Summary Set user plan
+// @Description Moves a user without a subscription to any plan, e.g. a complimentary upgrade, from the next request.
+// @Description The plans of users with a subscription follow the subscription. The user is sent a plan.changed webhook event,
+// @Description and their access tokens are revoked if the plan changed.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "User ID"
+// @Param request body models.SetUserPlanRequest true "Plan to move to"
+// @Success 200 {object} response.Response{data=models.AdminUserResponse} "Plan updated"
+// @Failure 400 {object} response.Response "Unknown plan"
+// @Failure 403 {object} response.Response "Forbidden"
+// @Failure 404 {object} response.Response "User not found"
+// @Failure 409 {object} response.Response "The user has a subscription"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /admin/users/{id}/plan [put]
+func (controller AdminController) SetUserPlan(ctx *gin.Context) {
+	var req models.SetUserPlanRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		log.Println(err.Error())
+		response.WithError(ctx, http.StatusBadRequest, message.MissingField)
+		return
+	}
+
+	user, ok := controller.user(ctx)
+	if !ok {
+		return
+	}
+	uid := user.Id.Hex()
+
+	subscription, err := controller.subscriptionService.GetSubscription(uid)
+	if err != nil && !errors.Is(err, services.ErrSubscriptionNotFound) {
+		log.Println(err.Error())
+		response.WithError(ctx, http.StatusInternalServerError, message.UserError)
+		return
+	}
+	if err == nil && subscription.Status.HasPlan() {
+		response.WithError(ctx, http.StatusConflict, message.UserHasSubscription)
+		return
+	}
+
+	current, previous, err := controller.authService.SetPlan(uid, req.Plan)
+	if err != nil {
+		log.Println(err.Error())
+		handleAdminUserError(ctx, err)
+		return
+	}
+	user.Plan = string(current.Type)
+
+	if previous != current.Type {
+		// Sessions started with the old plan must not keep its entitlements, e.g. after a downgrade
+		if err := controller.tokenService.RevokeAccessTokens(uid, time.Now()); err != nil {
+			log.Println(err.Error())
+			response.WithError(ctx, http.StatusInternalServerError, message.UserError)
+			return
+		}
+
+		err := controller.webhookService.Publish(uid, models.PlanChangedEvent, models.PlanChangedEventData{
+			PreviousPlan: previous,
+			Plan:         current.Type,
+		})
+		if err != nil {
+			log.Println(err.Error())
+		}
+	}
+
+	recordAuditEvent(controller.auditService, ctx, models.PlanChangedAction, uid, map[string]string{
+		"previousPlan": string(previous),
+		"plan":         string(current.Type),
+		"source":       "admin",
+	})
+
+	response.WithSuccess(ctx, http.StatusOK, message.UserPlanUpdated, adminUserResponse(user))
+}
+
+// @Summary Suspend user
+// @Description Suspends a user, or replaces the reason of a suspended user. Suspended users cannot log in or refresh
+// @Description their tokens, their access tokens are revoked right away, and all API keys they created are rejected.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "User ID"
+// @Param request body models.SuspendUserRequest true "Reason of the suspension"
+// @Success 200 {object} response.Response{data=models.AdminUserResponse} "User suspended"
+// @Failure 400 {object} response.Response "Missing reason or own account"
+// @Failure 403 {object} response.Response "Forbidden"
+// @Failure 404 {object} response.Response "User not found"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /admin/users/{id}/suspension [put]
+func (controller AdminController) SuspendUser(ctx *gin.Context) {
+	var req models.SuspendUserRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		log.Println(err.Error())
+		response.WithError(ctx, http.StatusBadRequest, message.MissingField)
+		return
+	}
+
+	actor := ctx.GetString(api.ContextUid)
+	if ctx.Param(api.IdParam) == actor {
+		response.WithError(ctx, http.StatusBadRequest, message.CannotSuspendSelf)
+		return
+	}
+
+	user, ok := controller.user(ctx)
+	if !ok {
+		return
+	}
+	uid := user.Id.Hex()
+	now := time.Now()
+
+	suspension := models.Suspension{
+		Reason:      req.Reason,
+		SuspendedBy: actor,
+		SuspendedAt: now,
+	}
+
+	// The user is suspended first, so that no new tokens are issued while the keys and tokens are revoked
+	if err := controller.authService.Suspend(uid, suspension); err != nil {
+		log.Println(err.Error())
+		handleAdminUserError(ctx, err)
+		return
+	}
+	if err := controller.userService.SetApiKeysSuspended(uid, true); err != nil {
+		log.Println(err.Error())
+		response.WithError(ctx, http.StatusInternalServerError, message.UserError)
+		return
+	}
+	if err := controller.tokenService.RevokeAccessTokens(uid, now); err != nil {
+		log.Println(err.Error())
+		response.WithError(ctx, http.StatusInternalServerError, message.UserError)
+		return
+	}
+	user.Suspension = &suspension
+
+	recordAuditEvent(controller.auditService, ctx, models.UserSuspendedAction, uid, map[string]string{
+		"reason": req.Reason,
+	})
+
+	response.WithSuccess(ctx, http.StatusOK, message.UserSuspended, adminUserResponse(user))
+}
+
+// @Summary Unsuspend user
+// @Description Lifts the suspension of a user, who can log in again and whose API keys work again
+// @Tags Admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "User ID"
+// @Success 200 {object} response.Response{data=models.AdminUserResponse} "User unsuspended"
+// @Failure 403 {object} response.Response "Forbidden"
+// @Failure 404 {object} response.Response "User not found"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /admin/users/{id}/suspension [delete]
+func (controller AdminController) UnsuspendUser(ctx *gin.Context) {
+	user, ok := controller.user(ctx)
+	if !ok {
+		return
+	}
+	uid := user.Id.Hex()
+
+	if err := controller.authService.Unsuspend(uid); err != nil {
+		log.Println(err.Error())
+		handleAdminUserError(ctx, err)
+		return
+	}
+	if err := controller.userService.SetApiKeysSuspended(uid, false); err != nil {
+		log.Println(err.Error())
+		response.WithError(ctx, http.StatusInternalServerError, message.UserError)
+		return
+	}
+	user.Suspension = nil
+
+	recordAuditEvent(controller.auditService, ctx, models.UserUnsuspendedAction, uid, nil)
+
+	response.WithSuccess(ctx, http.StatusOK, message.UserUnsuspended, adminUserResponse(user))
+}
+
+// @Summary Impersonate user
+// @Description Issues a read-only access token of a user, valid for 15 minutes and without a refresh token, to see the
+// @Description API as the user does. It only works for GET requests and never for admin routes. Support staff and admins
+// @Description cannot be impersonated.
+// @Tags Admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "User ID"
+// @Success 201 {object} response.Response{data=models.ImpersonationToken} "Impersonation token created"
+// @Failure 403 {object} response.Response "Forbidden or staff account"
+// @Failure 404 {object} response.Response "User not found"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /admin/users/{id}/impersonate [post]
+func (controller AdminController) ImpersonateUser(ctx *gin.Context) {
+	user, ok := controller.user(ctx)
+	if !ok {
+		return
+	}
+	uid := user.Id.Hex()
+
+	if rbac.Parse(user.Role) != rbac.UserRole {
+		response.WithError(ctx, http.StatusForbidden, message.CannotImpersonateStaff)
+		return
+	}
+
+	// Unlike other actions, no token is issued unless the impersonation is recorded
+	if err := controller.auditService.Record(newAuditEvent(ctx, models.UserImpersonatedAction, uid, nil)); err != nil {
+		log.Println(err.Error())
+		response.WithError(ctx, http.StatusInternalServerError, message.AuditError)
+		return
+	}
+
+	token, err := controller.tokenService.CreateImpersonationToken(uid, rbac.UserRole, ctx.GetString(api.ContextUid))
+	if err != nil {
+		log.Println(err.Error())
+		response.WithError(ctx, http.StatusInternalServerError, message.UserError)
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusCreated, message.ImpersonationStarted, token)
+}
+
+// @Summary Get audit log
+// @Description Retrieves the audit log, newest first, optionally filtered by the user acted on, the actor, the action
+// @Description and a time range. Older pages are requested with the nextBefore of a page as before.
+// @Tags Admin
+// @Produce json
+// @Security BearerAuth
+// @Param uid query string false "ID of the user acted on"
+// @Param actorId query string false "ID of the user who acted"
+// @Param action query string false "Action" example(user.suspended)
+// @Param from query string false "Start date (2006-01-02) or RFC 3339 time"
+// @Param to query string false "End date, inclusive, or RFC 3339 time, exclusive"
+// @Param before query string false "Return events before the event with this ID"
+// @Param limit query int false "Maximum number of events, at most 500" default(50)
+// @Success 200 {object} response.Response{data=models.AuditLogResponse} "Audit log retrieved"
+// @Failure 400 {object} response.Response "Invalid query"
+// @Failure 403 {object} response.Response "Forbidden"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /admin/audit-log [get]
+func (controller AdminController) GetAuditLog(ctx *gin.Context) {
+	var req models.AuditLogRequest
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		response.WithError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	auditLog, err := controller.auditService.List(req)
+	if err != nil {
+		log.Println(err.Error())
+		if errors.Is(err, services.ErrInvalidAuditQuery) {
+			response.WithError(ctx, http.StatusBadRequest, err.Error())
+			return
+		}
+		response.WithError(ctx, http.StatusInternalServerError, message.AuditError)
+		return
+	}
+
+	recordAuditEvent(controller.auditService, ctx, models.AuditLogViewedAction, req.Uid, map[string]string{
+		"query": ctx.Request.URL.RawQuery,
+	})
+
+	response.WithSuccess(ctx, http.StatusOK, message.AuditLogRetrieved, auditLog)
+}
+
+// @Summary Verify audit log
+// @Description Recomputes the hash chain of the events recorded while AUDIT_HASH_CHAIN is on and returns the first event
+// @Description that was changed or follows deleted events, if any. Compare headSeq and headHash with an earlier result
+// @Description to detect deleted newest events.
+// @Tags Admin
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} response.Response{data=models.AuditChainResponse} "Audit log verified"
+// @Failure 403 {object} response.Response "Forbidden"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /admin/audit-log/verify [get]
+func (controller AdminController) VerifyAuditLog(ctx *gin.Context) {
+	result, err := controller.auditService.VerifyChain()
+	if err != nil {
+		log.Println(err.Error())
+		response.WithError(ctx, http.StatusInternalServerError, message.AuditError)
+		return
+	}
+
+	details := map[string]string{
+		"valid":  strconv.FormatBool(result.Valid),
+		"events": strconv.FormatInt(result.Events, 10),
+	}
+	if !result.Valid {
+		details["brokenAt"] = result.BrokenAt
+		details["reason"] = result.Reason
+	}
+	recordAuditEvent(controller.auditService, ctx, models.AuditLogVerifiedAction, "", details)
+
+	response.WithSuccess(ctx, http.StatusOK, message.AuditChainVerified, result)
+}
+
+// @Summary Set organization plan
+// @Description Moves an organization to a plan, whose quota all keys of the organization share from the next request.
+// @Description The owners of the organization are sent a plan.changed webhook event, and the access tokens of all
+// @Description members are revoked if the plan changed.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Organization ID"
+// @Param request body models.SetOrgPlanRequest true "Plan to move to"
+// @Success 200 {object} response.Response{data=models.OrganizationResponse} "Organization plan updated"
+// @Failure 400 {object} response.Response "Unknown plan"
+// @Failure 403 {object} response.Response "Forbidden"
+// @Failure 404 {object} response.Response "Organization not found"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /admin/organizations/{id}/plan [put]
+func (controller AdminController) SetOrganizationPlan(ctx *gin.Context) {
+	var req models.SetOrgPlanRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		log.Println(err.Error())
+		response.WithError(ctx, http.StatusBadRequest, message.MissingField)
+		return
+	}
+
+	orgId := ctx.Param(api.IdParam)
+
+	org, previous, err := controller.organizationService.SetPlan(orgId, req.Plan)
+	if err != nil {
+		log.Println(err.Error())
+		handleOrganizationError(ctx, err)
+		return
+	}
+
+	if previous != plan.PlanType(org.Plan) {
+		members, err := controller.organizationService.ListMembers(orgId)
+		if err != nil {
+			log.Println(err.Error())
+			response.WithError(ctx, http.StatusInternalServerError, message.UserError)
+			return
+		}
+
+		now := time.Now()
+		for _, member := range members {
+			if err := controller.tokenService.RevokeAccessTokens(member.Uid, now); err != nil {
+				log.Println(err.Error())
+				response.WithError(ctx, http.StatusInternalServerError, message.UserError)
+				return
+			}
+		}
+
+		controller.publishOrgPlanChanged(orgId, members, previous, plan.PlanType(org.Plan))
+	}
+
+	recordAuditEvent(controller.auditService, ctx, models.OrgPlanChangedAction, "", map[string]string{
+		"orgId":        orgId,
+		"previousPlan": string(previous),
+		"plan":         org.Plan,
+	})
+
+	response.WithSuccess(ctx, http.StatusOK, message.OrgPlanUpdated, models.OrganizationResponse{
+		Organization: org,
+	})
+}
+
+// publishOrgPlanChanged sends a plan.changed event to the owners among the members of an organization.
+// Failing to publish does not change the response.
+func (controller AdminController) publishOrgPlanChanged(orgId string, members []models.OrgMembership, previous plan.PlanType, current plan.PlanType) {
+	for _, member := range members {
+		if member.Role != models.OrgOwnerRole {
+			continue
+		}
+
+		err := controller.webhookService.Publish(member.Uid, models.PlanChangedEvent, models.PlanChangedEventData{
+			PreviousPlan: previous,
+			Plan:         current,
+			OrgId:        orgId,
+		})
+		if err != nil {
+			log.Println(err.Error())
+		}
+	}
+}
+
+// @Summary Publish dataset update
+// @Description Announces that the word dataset was updated, e.g. after a new import, with a dataset.updated
+// @Description webhook event to every webhook endpoint subscribed to it
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.DatasetUpdatedRequest false "Description of the update and the updated words"
+// @Success 202 {object} response.Response "Dataset update published"
+// @Failure 400 {object} response.Response "Invalid request"
+// @Failure 403 {object} response.Response "Forbidden"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /admin/dataset/updated [post]
+func (controller AdminController) PublishDatasetUpdate(ctx *gin.Context) {
+	var req models.DatasetUpdatedRequest
+	if ctx.Request.ContentLength > 0 {
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			log.Println(err.Error())
+			response.WithError(ctx, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	err := controller.webhookService.Broadcast(models.DatasetUpdatedEvent, models.DatasetUpdatedEventData{
+		Description: req.Description,
+		Words:       req.Words,
+	})
+	if err != nil {
+		log.Println(err.Error())
+		response.WithError(ctx, http.StatusInternalServerError, message.WebhookError)
+		return
+	}
+
+	recordAuditEvent(controller.auditService, ctx, models.DatasetUpdatePublishAction, "", map[string]string{
+		"description": req.Description,
+		"words":       strconv.Itoa(len(req.Words)),
+	})
+
+	response.WithSuccess(ctx, http.StatusAccepted, message.DatasetUpdatePublished, nil)
+}
+
+// @Summary Set user role
+// @Description Changes the role of a user (user, support, admin). Takes effect at once: the user's access tokens are revoked, so the next refresh issues tokens with the new role.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "User ID"
+// @Param request body models.RoleRequest true "New role"
+// @Success 200 {object} response.Response "Role updated"
+// @Failure 400 {object} response.Response "Invalid role"
+// @Failure 403 {object} response.Response "Forbidden"
+// @Failure 404 {object} response.Response "User not found"
+// @Router /admin/users/{id}/role [put]
+func (controller AdminController) SetRole(ctx *gin.Context) {
+	var req models.RoleRequest
+
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		log.Println(err.Error())
+		response.WithError(ctx, http.StatusBadRequest, message.MissingField)
+		return
+	}
+
+	role := rbac.Role(req.Role)
+	if !rbac.IsValid(role) {
+		response.WithError(ctx, http.StatusBadRequest, message.InvalidRole)
+		return
+	}
+
+	// Admins cannot demote themselves, so there is always at least one admin left
+	uid := ctx.Param(api.IdParam)
+	if uid == ctx.GetString(api.ContextUid) {
+		response.WithError(ctx, http.StatusBadRequest, message.CannotChangeOwnRole)
+		return
+	}
+
+	if err := controller.authService.SetRole(uid, role); err != nil {
+		log.Println(err.Error())
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			response.WithError(ctx, http.StatusNotFound, message.UserNotFound)
+			return
+		}
+		response.WithError(ctx, http.StatusInternalServerError, message.UserError)
+		return
+	}
+
+	// Tokens carry the role, so a demoted user must not keep the rights of the old one until they expire
+	if err := controller.tokenService.RevokeAccessTokens(uid, time.Now()); err != nil {
+		log.Println(err.Error())
+		response.WithError(ctx, http.StatusInternalServerError, message.UserError)
+		return
+	}
+
+	recordAuditEvent(controller.auditService, ctx, models.RoleChangedAction, uid, map[string]string{
+		"role": string(role),
+	})
+
+	response.WithSuccess(ctx, http.StatusOK, message.RoleUpdated, nil)
+}
+
+// user returns the user of the id path parameter, or responds with an error and returns false
+func (controller AdminController) user(ctx *gin.Context) (models.User, bool) {
+	user, err := controller.authService.GetById(ctx.Param(api.IdParam))
+	if err != nil {
+		log.Println(err.Error())
+		handleAdminUserError(ctx, err)
+		return models.User{}, false
+	}
+
+	return user, true
+}
+
+func adminUserResponse(user models.User) models.AdminUserResponse {
+	return models.AdminUserResponse{
+		User:       user,
+		MFAEnabled: user.MFA.Enabled,
+	}
+}
+
+// handleAdminUserError maps errors of managing a user to HTTP responses
+func handleAdminUserError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments), errors.Is(err, primitive.ErrInvalidHex):
+		response.WithError(ctx, http.StatusNotFound, message.UserNotFound)
+	case errors.Is(err, services.ErrUnknownPlan):
+		response.WithError(ctx, http.StatusBadRequest, err.Error())
+	default:
+		response.WithError(ctx, http.StatusInternalServerError, message.UserError)
+	}
+}
+
+// newAuditEvent returns an audit event of an action the authenticated user performed on the user with the given ID.
+// Requests that are not authenticated yet, like logins, are actions of the user themselves.
+func newAuditEvent(ctx *gin.Context, action models.AuditAction, uid string, details map[string]string) models.AuditEvent {
+	actorId := ctx.GetString(api.ContextUid)
+	if actorId == "" {
+		actorId = uid
+	}
+
+	return models.AuditEvent{
+		Action:    action,
+		ActorId:   actorId,
+		ActorRole: ctx.GetString(api.ContextRole),
+		Uid:       uid,
+		IP:        ctx.ClientIP(),
+		UserAgent: ctx.GetHeader(api.UserAgentHeader),
+		Details:   details,
+	}
+}
+
+// recordAuditEvent writes an action of the authenticated user to the audit log.
+// Failing to record it does not change the response.
+func recordAuditEvent(auditService services.AuditService, ctx *gin.Context, action models.AuditAction, uid string, details map[string]string) {
+	if err := auditService.Record(newAuditEvent(ctx, action, uid, details)); err != nil {
+		log.Println(err.Error())
+	}
+}
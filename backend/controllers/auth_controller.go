@@ -1,27 +1,40 @@
 package controllers
 
 import (
+	"errors"
+	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
 
 	"github.com/AkifhanIlgaz/dictionary-api/models"
 	"github.com/AkifhanIlgaz/dictionary-api/services"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/api"
 	"github.com/AkifhanIlgaz/dictionary-api/utils/message"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/rbac"
 	"github.com/AkifhanIlgaz/dictionary-api/utils/response"
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 const AuthPath string = "/auth"
 
 type AuthController struct {
-	authService  services.AuthService
-	tokenService services.TokenService
+	authService         services.AuthService
+	tokenService        services.TokenService
+	mfaService          services.MFAService
+	loginAttemptService services.LoginAttemptService
+	auditService        services.AuditService
 }
 
-func NewAuthController(authService services.AuthService, tokenService services.TokenService) AuthController {
+func NewAuthController(authService services.AuthService, tokenService services.TokenService, mfaService services.MFAService, loginAttemptService services.LoginAttemptService, auditService services.AuditService) AuthController {
 	return AuthController{
-		authService:  authService,
-		tokenService: tokenService,
+		authService:         authService,
+		tokenService:        tokenService,
+		mfaService:          mfaService,
+		loginAttemptService: loginAttemptService,
+		auditService:        auditService,
 	}
 }
 
@@ -30,6 +43,7 @@ func (controller AuthController) SetupRoutes(rg *gin.RouterGroup) {
 
 	router.POST("/register", controller.Register)
 	router.POST("/login", controller.Login)
+	router.POST("/login/mfa", controller.LoginMFA)
 	router.POST("/refresh-token", controller.RefreshToken)
 
 }
@@ -61,7 +75,9 @@ func (controller AuthController) Register(ctx *gin.Context) {
 		return
 	}
 
-	tokens, err := controller.tokenService.CreateTokens(uid.Hex())
+	recordAuditEvent(controller.auditService, ctx, models.UserRegisteredAction, uid.Hex(), nil)
+
+	tokens, err := controller.tokenService.CreateTokens(uid.Hex(), rbac.UserRole)
 	if err != nil {
 		log.Println(err.Error())
 		response.WithError(ctx, http.StatusInternalServerError, err.Error())
@@ -73,14 +89,17 @@ func (controller AuthController) Register(ctx *gin.Context) {
 
 // Login godoc
 // @Summary      Login user
-// @Description  Login with email and password
+// @Description  Login with email and password. Users with MFA enabled receive a short-lived mfa token instead of tokens.
 // @Tags         auth
 // @Accept       json
 // @Produce      json
 // @Param        request body models.AuthRequest true "Login credentials"
-// @Success      200  {object}  response.Response
+// @Success      200  {object}  response.Response{data=models.Tokens}
+// @Success      202  {object}  response.Response{data=models.MFAChallenge}
 // @Failure      400  {object}  response.Response
 // @Failure      401  {object}  response.Response
+// @Failure      403  {object}  response.Response "Account suspended"
+// @Failure      429  {object}  response.Response
 // @Router       /auth/login [post]
 func (controller AuthController) Login(ctx *gin.Context) {
 	var req models.AuthRequest
@@ -91,20 +110,225 @@ func (controller AuthController) Login(ctx *gin.Context) {
 		return
 	}
 
+	lockedFor, err := controller.loginAttemptService.LockedFor(req.Email, ctx.ClientIP())
+	if err != nil {
+		log.Println(err.Error())
+		response.WithError(ctx, http.StatusInternalServerError, message.LoginError)
+		return
+	}
+
+	if lockedFor > 0 {
+		controller.recordLoginFailure(ctx, req.Email, models.LockedOutReason)
+		ctx.Header(api.RetryAfterHeader, strconv.Itoa(int(math.Ceil(lockedFor.Seconds()))))
+		response.WithError(ctx, http.StatusTooManyRequests, message.TooManyAttempts)
+		return
+	}
+
 	uid, err := controller.authService.AuthenticateUser(req)
 	if err != nil {
 		log.Println(err.Error())
-		response.WithError(ctx, http.StatusInternalServerError, err.Error())
+		if errors.Is(err, services.ErrInvalidCredentials) {
+			if err := controller.loginAttemptService.RegisterFailure(req.Email, ctx.ClientIP()); err != nil {
+				log.Println(err.Error())
+			}
+			controller.recordLoginFailure(ctx, req.Email, models.InvalidCredentialsReason)
+			response.WithError(ctx, http.StatusUnauthorized, message.InvalidCredentials)
+			return
+		}
+		response.WithError(ctx, http.StatusInternalServerError, message.LoginError)
+		return
+	}
+
+	if err := controller.loginAttemptService.RegisterSuccess(req.Email); err != nil {
+		log.Println(err.Error())
+	}
+
+	completeLogin(ctx, controller.authService, controller.tokenService, controller.mfaService, controller.auditService, uid.Hex(), passwordLogin)
+}
+
+// Login methods recorded in the audit log
+const (
+	passwordLogin string = "password"
+	oauthLogin    string = "oauth"
+	mfaLogin      string = "mfa"
+)
+
+// recordLoginFailure records a failed login of an email in the audit log, on the user with that email if there is one.
+// The actor is unknown, as the credentials were not verified.
+func (controller AuthController) recordLoginFailure(ctx *gin.Context, email string, reason string) {
+	uid := ""
+	user, err := controller.authService.GetByEmail(email)
+	if err == nil {
+		uid = user.Id.Hex()
+	} else if !errors.Is(err, mongo.ErrNoDocuments) {
+		log.Println(err.Error())
+	}
+
+	event := newAuditEvent(ctx, models.LoginFailedAction, uid, map[string]string{
+		"email":  email,
+		"reason": reason,
+	})
+	event.ActorId = ""
+
+	if err := controller.auditService.Record(event); err != nil {
+		log.Println(err.Error())
+	}
+}
+
+// completeLogin responds with tokens for a user whose first factor has been verified,
+// or with an mfa token to be exchanged at /auth/login/mfa if the user has MFA enabled.
+// Logins are recorded in the audit log once tokens are issued, with the method that verified the last factor.
+func completeLogin(ctx *gin.Context, authService services.AuthService, tokenService services.TokenService, mfaService services.MFAService, auditService services.AuditService, uid string, method string) {
+	mfaEnabled, err := mfaService.IsEnabled(uid)
+	if err != nil {
+		log.Println(err.Error())
+		response.WithError(ctx, http.StatusInternalServerError, message.MFAError)
 		return
 	}
 
-	tokens, err := controller.tokenService.CreateTokens(uid.Hex())
+	if mfaEnabled {
+		mfaToken, err := tokenService.CreateMFAToken(uid)
+		if err != nil {
+			log.Println(err.Error())
+			response.WithError(ctx, http.StatusInternalServerError, message.MFAError)
+			return
+		}
+
+		response.WithSuccess(ctx, http.StatusAccepted, message.MFARequired, models.MFAChallenge{
+			MFARequired: true,
+			MFAToken:    mfaToken,
+		})
+		return
+	}
+
+	tokens, err := createTokens(authService, tokenService, uid)
 	if err != nil {
 		log.Println(err.Error())
-		response.WithError(ctx, http.StatusInternalServerError, err.Error())
+		recordSuspendedLogin(auditService, ctx, uid, err)
+		handleCreateTokensError(ctx, err)
 		return
 	}
 
+	recordAuditEvent(auditService, ctx, models.LoginAction, uid, map[string]string{"method": method})
+
+	response.WithSuccess(ctx, http.StatusOK, "logged in", tokens)
+}
+
+// recordSuspendedLogin records a login refused because the account is suspended, if that is the error of createTokens
+func recordSuspendedLogin(auditService services.AuditService, ctx *gin.Context, uid string, err error) {
+	if !errors.Is(err, services.ErrAccountSuspended) {
+		return
+	}
+
+	recordAuditEvent(auditService, ctx, models.LoginFailedAction, uid, map[string]string{
+		"reason": models.SuspendedReason,
+	})
+}
+
+// createTokens issues tokens carrying the user's current role, so that role changes apply on the next refresh.
+// Returns ErrAccountSuspended for suspended users, whose refresh tokens stop working too.
+func createTokens(authService services.AuthService, tokenService services.TokenService, uid string) (models.Tokens, error) {
+	user, err := authService.GetById(uid)
+	if err != nil {
+		return models.Tokens{}, fmt.Errorf("create tokens: %w", err)
+	}
+
+	if user.IsSuspended() {
+		return models.Tokens{}, services.ErrAccountSuspended
+	}
+
+	return tokenService.CreateTokens(uid, rbac.Parse(user.Role))
+}
+
+// handleCreateTokensError responds to an error of createTokens
+func handleCreateTokensError(ctx *gin.Context, err error) {
+	if errors.Is(err, services.ErrAccountSuspended) {
+		response.WithError(ctx, http.StatusForbidden, message.AccountSuspended)
+		return
+	}
+
+	response.WithError(ctx, http.StatusInternalServerError, err.Error())
+}
+
+// LoginMFA godoc
+// @Summary      Complete MFA login
+// @Description  Exchange the mfa token returned by login and a TOTP or recovery code for access and refresh tokens
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        request body models.MFALoginRequest true "MFA token and code"
+// @Success      200  {object}  response.Response{data=models.Tokens}
+// @Failure      400  {object}  response.Response
+// @Failure      401  {object}  response.Response
+// @Failure      403  {object}  response.Response "Account suspended"
+// @Failure      429  {object}  response.Response "Too many wrong codes"
+// @Router       /auth/login/mfa [post]
+func (controller AuthController) LoginMFA(ctx *gin.Context) {
+	var req models.MFALoginRequest
+
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		log.Println(err.Error())
+		response.WithError(ctx, http.StatusBadRequest, message.MissingField)
+		return
+	}
+
+	uid, err := controller.tokenService.ParseMFAToken(req.MFAToken)
+	if err != nil {
+		log.Println(err.Error())
+		response.WithError(ctx, http.StatusUnauthorized, message.InvalidMFAToken)
+		return
+	}
+
+	lockedFor, err := controller.loginAttemptService.MFALockedFor(uid)
+	if err != nil {
+		log.Println(err.Error())
+		response.WithError(ctx, http.StatusInternalServerError, message.MFAError)
+		return
+	}
+
+	if lockedFor > 0 {
+		recordAuditEvent(controller.auditService, ctx, models.LoginFailedAction, uid, map[string]string{
+			"reason": models.LockedOutReason,
+		})
+		ctx.Header(api.RetryAfterHeader, strconv.Itoa(int(math.Ceil(lockedFor.Seconds()))))
+		response.WithError(ctx, http.StatusTooManyRequests, message.TooManyAttempts)
+		return
+	}
+
+	if err := controller.mfaService.Verify(uid, req.Code); err != nil {
+		log.Println(err.Error())
+		if errors.Is(err, services.ErrInvalidMFACode) {
+			if err := controller.loginAttemptService.RegisterMFAFailure(uid); err != nil {
+				log.Println(err.Error())
+			}
+			recordAuditEvent(controller.auditService, ctx, models.LoginFailedAction, uid, map[string]string{
+				"reason": models.InvalidMFACodeReason,
+			})
+			response.WithError(ctx, http.StatusUnauthorized, message.InvalidMFACode)
+			return
+		}
+		response.WithError(ctx, http.StatusInternalServerError, message.MFAError)
+		return
+	}
+
+	if err := controller.tokenService.RevokeMFAToken(req.MFAToken); err != nil {
+		log.Println(err.Error())
+	}
+
+	if err := controller.loginAttemptService.RegisterMFASuccess(uid); err != nil {
+		log.Println(err.Error())
+	}
+
+	tokens, err := createTokens(controller.authService, controller.tokenService, uid)
+	if err != nil {
+		log.Println(err.Error())
+		recordSuspendedLogin(controller.auditService, ctx, uid, err)
+		handleCreateTokensError(ctx, err)
+		return
+	}
+
+	recordAuditEvent(controller.auditService, ctx, models.LoginAction, uid, map[string]string{"method": mfaLogin})
+
 	response.WithSuccess(ctx, http.StatusOK, "logged in", tokens)
 }
 
@@ -118,6 +342,7 @@ func (controller AuthController) Login(ctx *gin.Context) {
 // @Success      200  {object}  response.Response
 // @Failure      400  {object}  response.Response
 // @Failure      401  {object}  response.Response
+// @Failure      403  {object}  response.Response "Account suspended"
 // @Router       /auth/refresh [post]
 func (controller AuthController) RefreshToken(ctx *gin.Context) {
 	var req models.RefreshTokenRequest
@@ -147,10 +372,10 @@ func (controller AuthController) RefreshToken(ctx *gin.Context) {
 		return
 	}
 
-	tokens, err := controller.tokenService.CreateTokens(uid)
+	tokens, err := createTokens(controller.authService, controller.tokenService, uid)
 	if err != nil {
 		log.Println(err.Error())
-		response.WithError(ctx, http.StatusInternalServerError, err.Error())
+		handleCreateTokensError(ctx, err)
 		return
 	}
 
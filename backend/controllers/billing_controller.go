@@ -0,0 +1,415 @@
+package controllers
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/AkifhanIlgaz/dictionary-api/middlewares"
+	"github.com/AkifhanIlgaz/dictionary-api/models"
+	"github.com/AkifhanIlgaz/dictionary-api/services"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/api"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/message"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/payment"
+	plan "github.com/AkifhanIlgaz/dictionary-api/utils/plans"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/response"
+	"github.com/gin-gonic/gin"
+)
+
+const FakeCheckoutPath = "/fake/checkout"
+
+// maxWebhookSize limits the webhook payloads read into memory
+const maxWebhookSize int64 = 1 << 20
+
+type BillingController struct {
+	subscriptionService services.SubscriptionService
+	invoiceService      services.InvoiceService
+	authService         services.AuthService
+	plans               *plan.Registry
+	userMiddleware      middlewares.UserMiddleware
+}
+
+func NewBillingController(subscriptionService services.SubscriptionService, invoiceService services.InvoiceService, authService services.AuthService, plans *plan.Registry, userMiddleware middlewares.UserMiddleware) BillingController {
+	return BillingController{
+		subscriptionService: subscriptionService,
+		invoiceService:      invoiceService,
+		authService:         authService,
+		plans:               plans,
+		userMiddleware:      userMiddleware,
+	}
+}
+
+func (controller BillingController) SetupRoutes(rg *gin.RouterGroup) {
+	router := rg.Group(BillingPath)
+
+	router.GET("/plans", controller.GetPlans)
+	router.POST("/webhook", controller.Webhook)
+
+	// Stand-ins for the checkout page and the billing cycle of a real provider.
+	// The fake provider is only available in dev mode, as these routes let anyone pay for a checkout.
+	if controller.subscriptionService.IsFake() {
+		router.POST(FakeCheckoutPath+"/:id", controller.CompleteFakeCheckout)
+		router.POST("/fake/period", controller.userMiddleware.AuthenticateUser(), controller.EndFakePeriod)
+	}
+
+	billing := rg.Group(UserPath).Group(BillingPath)
+	billing.Use(controller.userMiddleware.AuthenticateUser())
+
+	billing.POST("/checkout", controller.Checkout)
+	billing.GET("/subscription", controller.GetSubscription)
+	billing.PUT("/subscription", controller.ChangePlan)
+	billing.DELETE("/subscription", controller.Cancel)
+	billing.GET("/overage", controller.GetOverage)
+	billing.PUT("/overage", controller.SetOveragePolicy)
+	billing.GET("/alerts", controller.GetQuotaAlerts)
+	billing.PUT("/alerts", controller.SetQuotaAlerts)
+}
+
+// @Summary Get Plans
+// @Description Lists the plans from the lowest to the highest tier with their limits, features, prices and trials
+// @Tags Billing
+// @Produce json
+// @Success 200 {object} response.Response{data=models.PlansResponse} "Plans retrieved successfully"
+// @Router /billing/plans [get]
+func (controller BillingController) GetPlans(ctx *gin.Context) {
+	response.WithSuccess(ctx, http.StatusOK, message.PlansRetrieved, models.PlansResponse{
+		Plans: controller.plans.Plans(),
+	})
+}
+
+// @Summary Checkout
+// @Description Creates a checkout session of the payment provider for subscribing to a paid plan, which must be
+// @Description an upgrade of the current plan. The plan applies once the provider reports the completed checkout.
+// @Tags Billing
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.CheckoutRequest true "Plan to subscribe to"
+// @Success 201 {object} response.Response{data=models.CheckoutSessionResponse} "Checkout session created successfully"
+// @Failure 400 {object} response.Response "Unknown plan or not an upgrade"
+// @Failure 409 {object} response.Response "The user already has a subscription"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /user/billing/checkout [post]
+func (controller BillingController) Checkout(ctx *gin.Context) {
+	uid := ctx.GetString(api.UidParam)
+
+	var req models.CheckoutRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response.WithError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	session, err := controller.subscriptionService.Checkout(uid, plan.PlanType(req.Plan))
+	if err != nil {
+		log.Println(err.Error())
+		handleBillingError(ctx, err)
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusCreated, message.CheckoutCreated, session)
+}
+
+// @Summary Get Subscription
+// @Description Retrieves the subscription of the authenticated user
+// @Tags Billing
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} response.Response{data=models.SubscriptionResponse} "Subscription retrieved successfully"
+// @Failure 404 {object} response.Response "The user never subscribed"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /user/billing/subscription [get]
+func (controller BillingController) GetSubscription(ctx *gin.Context) {
+	uid := ctx.GetString(api.UidParam)
+
+	subscription, err := controller.subscriptionService.GetSubscription(uid)
+	if err != nil {
+		log.Println(err.Error())
+		handleBillingError(ctx, err)
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusOK, message.SubscriptionRetrieved, models.SubscriptionResponse{
+		Subscription: subscription,
+	})
+}
+
+// @Summary Change Plan
+// @Description Moves the trialing or active subscription of the authenticated user to another paid plan right away.
+// @Description The rest of the current period is prorated: upgrades are charged and downgrades credited the difference.
+// @Tags Billing
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.ChangePlanRequest true "Plan to move to"
+// @Success 200 {object} response.Response{data=models.PlanChangeResponse} "Subscription updated successfully"
+// @Failure 400 {object} response.Response "Unknown plan or invalid plan change"
+// @Failure 404 {object} response.Response "No active subscription"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /user/billing/subscription [put]
+func (controller BillingController) ChangePlan(ctx *gin.Context) {
+	uid := ctx.GetString(api.UidParam)
+
+	var req models.ChangePlanRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response.WithError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	change, err := controller.subscriptionService.ChangePlan(uid, plan.PlanType(req.Plan), time.Now())
+	if err != nil {
+		log.Println(err.Error())
+		handleBillingError(ctx, err)
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusOK, message.SubscriptionUpdated, change)
+}
+
+// @Summary Cancel Subscription
+// @Description Cancels the subscription of the authenticated user at the end of the current period,
+// @Description after which the user is moved back to the default plan
+// @Tags Billing
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} response.Response{data=models.SubscriptionResponse} "Subscription will be canceled"
+// @Failure 404 {object} response.Response "No active subscription"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /user/billing/subscription [delete]
+func (controller BillingController) Cancel(ctx *gin.Context) {
+	uid := ctx.GetString(api.UidParam)
+
+	subscription, err := controller.subscriptionService.Cancel(uid, time.Now())
+	if err != nil {
+		log.Println(err.Error())
+		handleBillingError(ctx, err)
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusOK, message.SubscriptionCanceled, models.SubscriptionResponse{
+		Subscription: subscription,
+	})
+}
+
+// @Summary Get Overage
+// @Description Retrieves the overage policy of the authenticated user, the overage price of the current plan
+// @Description and the requests over the quota in the overage ledger that the next invoice bills. Amounts are in cents.
+// @Tags Billing
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} response.Response{data=models.OverageResponse} "Overage retrieved successfully"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /user/billing/overage [get]
+func (controller BillingController) GetOverage(ctx *gin.Context) {
+	uid := ctx.GetString(api.UidParam)
+
+	policy, userPlan, err := controller.authService.GetOveragePolicy(uid)
+	if err != nil {
+		log.Println(err.Error())
+		handleBillingError(ctx, err)
+		return
+	}
+
+	unbilled, err := controller.invoiceService.GetUnbilledOverage(uid)
+	if err != nil {
+		log.Println(err.Error())
+		handleBillingError(ctx, err)
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusOK, message.OverageRetrieved, models.OverageResponse{
+		Policy:       policy,
+		PricePer1000: userPlan.OverageAmount(),
+		Currency:     payment.Currency,
+		Unbilled:     unbilled,
+	})
+}
+
+// @Summary Set Overage Policy
+// @Description Sets what happens to the requests of the authenticated user over the quota, from the next request:
+// @Description "block" rejects them with 429, "bill" allows them and bills every started 1,000 at the overage price
+// @Description of the plan, and "soft_cap" allows softCapPercent (10 by default) more for free and alerts when the
+// @Description quota is exceeded. Only plans with an overage price allow requests over the quota.
+// @Tags Billing
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.SetOveragePolicyRequest true "Overage policy"
+// @Success 200 {object} response.Response{data=models.OveragePolicy} "Overage policy updated successfully"
+// @Failure 400 {object} response.Response "Invalid mode or soft cap"
+// @Failure 403 {object} response.Response "The plan does not allow requests over the quota"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /user/billing/overage [put]
+func (controller BillingController) SetOveragePolicy(ctx *gin.Context) {
+	uid := ctx.GetString(api.UidParam)
+
+	var req models.SetOveragePolicyRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response.WithError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	policy, err := controller.authService.SetOveragePolicy(uid, req)
+	if err != nil {
+		log.Println(err.Error())
+		handleBillingError(ctx, err)
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusOK, message.OveragePolicyUpdated, policy)
+}
+
+// @Summary Get Quota Alerts
+// @Description Retrieves the quota alert thresholds of the authenticated user and whether alerts are emailed
+// @Tags Billing
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} response.Response{data=models.QuotaAlertSettings} "Quota alerts retrieved successfully"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /user/billing/alerts [get]
+func (controller BillingController) GetQuotaAlerts(ctx *gin.Context) {
+	uid := ctx.GetString(api.UidParam)
+
+	settings, err := controller.authService.GetQuotaAlerts(uid)
+	if err != nil {
+		log.Println(err.Error())
+		handleBillingError(ctx, err)
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusOK, message.QuotaAlertsRetrieved, settings)
+}
+
+// @Summary Set Quota Alerts
+// @Description Replaces the quota alert settings of the authenticated user. Thresholds are percentages of the daily or
+// @Description monthly quota, e.g. [50, 80, 100], and alert once per quota window when the usage reaches them.
+// @Description Alerts, and overage alerts, are sent as quota.threshold and quota.overage events to the webhook
+// @Description endpoints of the user subscribed to them, and by email if enabled.
+// @Tags Billing
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.SetQuotaAlertsRequest true "Quota alert settings"
+// @Success 200 {object} response.Response{data=models.QuotaAlertSettings} "Quota alerts updated successfully"
+// @Failure 400 {object} response.Response "Invalid thresholds"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /user/billing/alerts [put]
+func (controller BillingController) SetQuotaAlerts(ctx *gin.Context) {
+	uid := ctx.GetString(api.UidParam)
+
+	var req models.SetQuotaAlertsRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response.WithError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	settings, err := controller.authService.SetQuotaAlerts(uid, req)
+	if err != nil {
+		log.Println(err.Error())
+		handleBillingError(ctx, err)
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusOK, message.QuotaAlertsUpdated, settings)
+}
+
+// @Summary Payment Webhook
+// @Description Receives the signed subscription events of the payment provider. Redelivered events are ignored.
+// @Tags Billing
+// @Accept json
+// @Produce json
+// @Param Payment-Signature header string true "t=<unix time>,v1=<hex HMAC-SHA256 of the time, a dot and the body>"
+// @Success 200 {object} response.Response "Webhook processed successfully"
+// @Failure 400 {object} response.Response "Invalid signature or payload"
+// @Failure 500 {object} response.Response "Internal server error, the provider retries the event"
+// @Router /billing/webhook [post]
+func (controller BillingController) Webhook(ctx *gin.Context) {
+	payload, err := io.ReadAll(io.LimitReader(ctx.Request.Body, maxWebhookSize))
+	if err != nil {
+		response.WithError(ctx, http.StatusBadRequest, message.InvalidWebhook)
+		return
+	}
+
+	if err := controller.subscriptionService.HandleWebhook(payload, ctx.Request.Header); err != nil {
+		log.Println(err.Error())
+		handleBillingError(ctx, err)
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusOK, message.WebhookProcessed, nil)
+}
+
+// @Summary Complete Fake Checkout
+// @Description Pays for a checkout session of the fake payment provider, as if the user finished the checkout page.
+// @Description Only available with PAYMENT_PROVIDER=fake.
+// @Tags Billing
+// @Produce json
+// @Param id path string true "Checkout session ID"
+// @Success 200 {object} response.Response "Webhook processed successfully"
+// @Failure 404 {object} response.Response "Checkout session not found"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /billing/fake/checkout/{id} [post]
+func (controller BillingController) CompleteFakeCheckout(ctx *gin.Context) {
+	if err := controller.subscriptionService.CompleteFakeCheckout(ctx.Param(api.IdParam)); err != nil {
+		log.Println(err.Error())
+		handleBillingError(ctx, err)
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusOK, message.WebhookProcessed, nil)
+}
+
+// @Summary End Fake Billing Period
+// @Description Ends the current period of the authenticated user's subscription at the fake payment provider.
+// @Description Canceled subscriptions are deleted, others renew if paid and become past due otherwise.
+// @Description Only available with PAYMENT_PROVIDER=fake.
+// @Tags Billing
+// @Produce json
+// @Security BearerAuth
+// @Param paid query bool false "Whether the renewal payment succeeds" default(true)
+// @Success 200 {object} response.Response "Webhook processed successfully"
+// @Failure 404 {object} response.Response "No subscription"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /billing/fake/period [post]
+func (controller BillingController) EndFakePeriod(ctx *gin.Context) {
+	uid := ctx.GetString(api.UidParam)
+
+	paid, err := strconv.ParseBool(ctx.DefaultQuery(api.PaidParam, "true"))
+	if err != nil {
+		response.WithError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := controller.subscriptionService.EndFakePeriod(uid, paid); err != nil {
+		log.Println(err.Error())
+		handleBillingError(ctx, err)
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusOK, message.WebhookProcessed, nil)
+}
+
+// handleBillingError maps subscription service errors to HTTP responses
+func handleBillingError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrUnknownPlan),
+		errors.Is(err, services.ErrInvalidPlanChange),
+		errors.Is(err, services.ErrInvalidOverageMode),
+		errors.Is(err, services.ErrInvalidSoftCap),
+		errors.Is(err, services.ErrInvalidAlertThresholds):
+		response.WithError(ctx, http.StatusBadRequest, err.Error())
+	case errors.Is(err, services.ErrOverageNotAvailable):
+		response.WithError(ctx, http.StatusForbidden, err.Error())
+	case errors.Is(err, services.ErrInvalidWebhook):
+		response.WithError(ctx, http.StatusBadRequest, message.InvalidWebhook)
+	case errors.Is(err, services.ErrSubscriptionExists):
+		response.WithError(ctx, http.StatusConflict, err.Error())
+	case errors.Is(err, services.ErrSubscriptionNotFound),
+		errors.Is(err, services.ErrCheckoutNotFound):
+		response.WithError(ctx, http.StatusNotFound, err.Error())
+	default:
+		response.WithError(ctx, http.StatusInternalServerError, message.BillingError)
+	}
+}
@@ -0,0 +1,123 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/AkifhanIlgaz/dictionary-api/middlewares"
+	"github.com/AkifhanIlgaz/dictionary-api/models"
+	"github.com/AkifhanIlgaz/dictionary-api/services"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/api"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/message"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/response"
+	"github.com/gin-gonic/gin"
+)
+
+const InvoicesPath = "/invoices"
+
+type InvoiceController struct {
+	invoiceService services.InvoiceService
+	userMiddleware middlewares.UserMiddleware
+}
+
+func NewInvoiceController(invoiceService services.InvoiceService, userMiddleware middlewares.UserMiddleware) InvoiceController {
+	return InvoiceController{
+		invoiceService: invoiceService,
+		userMiddleware: userMiddleware,
+	}
+}
+
+func (controller InvoiceController) SetupRoutes(rg *gin.RouterGroup) {
+	router := rg.Group(UserPath).Group(InvoicesPath)
+	router.Use(controller.userMiddleware.AuthenticateUser())
+
+	router.GET("", controller.GetInvoices)
+	router.GET("/:id", controller.GetInvoice)
+	router.GET("/:id/pdf", controller.GetInvoicePDF)
+}
+
+// @Summary Get Invoices
+// @Description Lists the invoices of the authenticated user from the newest to the oldest.
+// @Description An invoice is issued when a paid period of the subscription closes. Amounts are in cents.
+// @Tags Invoices
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} response.Response{data=models.InvoicesResponse} "Invoices retrieved successfully"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /user/invoices [get]
+func (controller InvoiceController) GetInvoices(ctx *gin.Context) {
+	uid := ctx.GetString(api.UidParam)
+
+	invoices, err := controller.invoiceService.ListInvoices(uid)
+	if err != nil {
+		log.Println(err.Error())
+		handleInvoiceError(ctx, err)
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusOK, message.InvoicesRetrieved, models.InvoicesResponse{
+		Invoices: invoices,
+	})
+}
+
+// @Summary Get Invoice
+// @Description Retrieves an invoice of the authenticated user. Amounts are in cents.
+// @Tags Invoices
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Invoice ID"
+// @Success 200 {object} response.Response{data=models.InvoiceResponse} "Invoice retrieved successfully"
+// @Failure 404 {object} response.Response "Invoice not found"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /user/invoices/{id} [get]
+func (controller InvoiceController) GetInvoice(ctx *gin.Context) {
+	uid := ctx.GetString(api.UidParam)
+
+	invoice, err := controller.invoiceService.GetInvoice(uid, ctx.Param(api.IdParam))
+	if err != nil {
+		log.Println(err.Error())
+		handleInvoiceError(ctx, err)
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusOK, message.InvoiceRetrieved, models.InvoiceResponse{
+		Invoice: invoice,
+	})
+}
+
+// @Summary Get Invoice PDF
+// @Description Downloads an invoice of the authenticated user as a PDF document
+// @Tags Invoices
+// @Produce application/pdf
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Invoice ID"
+// @Success 200 {file} file "Invoice PDF"
+// @Failure 404 {object} response.Response "Invoice not found"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /user/invoices/{id}/pdf [get]
+func (controller InvoiceController) GetInvoicePDF(ctx *gin.Context) {
+	uid := ctx.GetString(api.UidParam)
+
+	invoice, err := controller.invoiceService.GetInvoice(uid, ctx.Param(api.IdParam))
+	if err != nil {
+		log.Println(err.Error())
+		handleInvoiceError(ctx, err)
+		return
+	}
+
+	ctx.Header(api.ContentDispositionHeader, fmt.Sprintf("attachment; filename=%q", invoice.Number+".pdf"))
+	ctx.Data(http.StatusOK, api.MIMEPDF, controller.invoiceService.RenderPDF(*invoice))
+}
+
+// handleInvoiceError maps invoice service errors to HTTP responses
+func handleInvoiceError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvoiceNotFound):
+		response.WithError(ctx, http.StatusNotFound, err.Error())
+	default:
+		response.WithError(ctx, http.StatusInternalServerError, message.BillingError)
+	}
+}
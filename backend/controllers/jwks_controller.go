@@ -0,0 +1,38 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/AkifhanIlgaz/dictionary-api/services"
+	"github.com/gin-gonic/gin"
+)
+
+const JWKSPath = "/.well-known/jwks.json"
+
+type JWKSController struct {
+	signingKeyService services.SigningKeyService
+}
+
+func NewJWKSController(signingKeyService services.SigningKeyService) JWKSController {
+	return JWKSController{
+		signingKeyService: signingKeyService,
+	}
+}
+
+// SetupRoutes registers the JWKS endpoint. It is expected to be mounted at the server root, not under /api.
+func (controller JWKSController) SetupRoutes(rg *gin.RouterGroup) {
+	rg.GET(JWKSPath, controller.GetJWKS)
+}
+
+// GetJWKS godoc
+// @Summary      JSON Web Key Set
+// @Description  Public keys for verifying access tokens by their kid header. Includes keys published ahead of activation.
+// @Tags         auth
+// @Produce      json
+// @Success      200  {object}  keyring.JSONWebKeySet
+// @Router       /.well-known/jwks.json [get]
+func (controller JWKSController) GetJWKS(ctx *gin.Context) {
+	ctx.Header("Cache-Control", "public, max-age=300")
+	ctx.JSON(http.StatusOK, controller.signingKeyService.Keyring().JWKS(time.Now()))
+}
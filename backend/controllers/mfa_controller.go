@@ -0,0 +1,176 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/AkifhanIlgaz/dictionary-api/middlewares"
+	"github.com/AkifhanIlgaz/dictionary-api/models"
+	"github.com/AkifhanIlgaz/dictionary-api/services"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/api"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/message"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/response"
+	"github.com/gin-gonic/gin"
+)
+
+const MFAPath = "/mfa"
+
+type MFAController struct {
+	mfaService     services.MFAService
+	userMiddleware middlewares.UserMiddleware
+}
+
+func NewMFAController(mfaService services.MFAService, userMiddleware middlewares.UserMiddleware) MFAController {
+	return MFAController{
+		mfaService:     mfaService,
+		userMiddleware: userMiddleware,
+	}
+}
+
+func (controller MFAController) SetupRoutes(rg *gin.RouterGroup) {
+	router := rg.Group(UserPath).Group(MFAPath)
+	router.Use(controller.userMiddleware.AuthenticateUser())
+
+	router.POST("/enroll", controller.Enroll)
+	router.POST("/confirm", controller.Confirm)
+	router.POST("/recovery-codes", controller.RegenerateRecoveryCodes)
+	router.DELETE("", controller.Disable)
+}
+
+// @Summary Start MFA enrollment
+// @Description Generates a TOTP secret and provisioning URI for the authenticated user. MFA is enabled only after confirmation.
+// @Tags MFA
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} response.Response{data=models.MFAEnrollment} "Enrollment started"
+// @Failure 409 {object} response.Response "MFA already enabled"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /user/mfa/enroll [post]
+func (controller MFAController) Enroll(ctx *gin.Context) {
+	uid := ctx.GetString(api.UidParam)
+
+	enrollment, err := controller.mfaService.BeginEnrollment(uid)
+	if err != nil {
+		log.Println(err.Error())
+		if errors.Is(err, services.ErrMFAAlreadyEnabled) {
+			response.WithError(ctx, http.StatusConflict, message.MFAAlreadyEnabled)
+			return
+		}
+		response.WithError(ctx, http.StatusInternalServerError, message.MFAError)
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusOK, message.MFAEnrollmentStarted, enrollment)
+}
+
+// @Summary Confirm MFA enrollment
+// @Description Verifies the first TOTP code and enables MFA. The returned recovery codes are shown only once.
+// @Tags MFA
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.MFACodeRequest true "TOTP code"
+// @Success 200 {object} response.Response{data=models.RecoveryCodesResponse} "MFA enabled"
+// @Failure 400 {object} response.Response "Invalid code or no pending enrollment"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /user/mfa/confirm [post]
+func (controller MFAController) Confirm(ctx *gin.Context) {
+	uid := ctx.GetString(api.UidParam)
+
+	var req models.MFACodeRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		log.Println(err.Error())
+		response.WithError(ctx, http.StatusBadRequest, message.MissingField)
+		return
+	}
+
+	recoveryCodes, err := controller.mfaService.ConfirmEnrollment(uid, req.Code)
+	if err != nil {
+		log.Println(err.Error())
+		controller.handleError(ctx, err)
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusOK, message.MFAEnabled, models.RecoveryCodesResponse{
+		RecoveryCodes: recoveryCodes,
+	})
+}
+
+// @Summary Regenerate recovery codes
+// @Description Replaces all recovery codes after verifying a TOTP or recovery code
+// @Tags MFA
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.MFACodeRequest true "TOTP or recovery code"
+// @Success 200 {object} response.Response{data=models.RecoveryCodesResponse} "Recovery codes created"
+// @Failure 400 {object} response.Response "Invalid code or MFA not enabled"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /user/mfa/recovery-codes [post]
+func (controller MFAController) RegenerateRecoveryCodes(ctx *gin.Context) {
+	uid := ctx.GetString(api.UidParam)
+
+	var req models.MFACodeRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		log.Println(err.Error())
+		response.WithError(ctx, http.StatusBadRequest, message.MissingField)
+		return
+	}
+
+	recoveryCodes, err := controller.mfaService.RegenerateRecoveryCodes(uid, req.Code)
+	if err != nil {
+		log.Println(err.Error())
+		controller.handleError(ctx, err)
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusOK, message.RecoveryCodesCreated, models.RecoveryCodesResponse{
+		RecoveryCodes: recoveryCodes,
+	})
+}
+
+// @Summary Disable MFA
+// @Description Disables MFA after verifying a TOTP or recovery code
+// @Tags MFA
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.MFACodeRequest true "TOTP or recovery code"
+// @Success 200 {object} response.Response "MFA disabled"
+// @Failure 400 {object} response.Response "Invalid code or MFA not enabled"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /user/mfa [delete]
+func (controller MFAController) Disable(ctx *gin.Context) {
+	uid := ctx.GetString(api.UidParam)
+
+	var req models.MFACodeRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		log.Println(err.Error())
+		response.WithError(ctx, http.StatusBadRequest, message.MissingField)
+		return
+	}
+
+	if err := controller.mfaService.Disable(uid, req.Code); err != nil {
+		log.Println(err.Error())
+		controller.handleError(ctx, err)
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusOK, message.MFADisabled, nil)
+}
+
+// handleError maps MFA service errors to HTTP responses
+func (controller MFAController) handleError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidMFACode),
+		errors.Is(err, services.ErrMFANotEnabled),
+		errors.Is(err, services.ErrMFANoPendingEnrollment):
+		response.WithError(ctx, http.StatusBadRequest, err.Error())
+	case errors.Is(err, services.ErrMFAAlreadyEnabled):
+		response.WithError(ctx, http.StatusConflict, err.Error())
+	default:
+		response.WithError(ctx, http.StatusInternalServerError, message.MFAError)
+	}
+}
@@ -0,0 +1,252 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/AkifhanIlgaz/dictionary-api/middlewares"
+	"github.com/AkifhanIlgaz/dictionary-api/models"
+	"github.com/AkifhanIlgaz/dictionary-api/services"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/api"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/message"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/response"
+	"github.com/gin-gonic/gin"
+)
+
+const OAuthPath = "/oauth"
+
+// oauthBindingMaxAge is how long the browser keeps the binding of a login, as long as its state is kept
+const oauthBindingMaxAge int = 10 * 60
+
+type OAuthController struct {
+	oauthService   services.OAuthService
+	authService    services.AuthService
+	tokenService   services.TokenService
+	mfaService     services.MFAService
+	auditService   services.AuditService
+	userMiddleware middlewares.UserMiddleware
+	// secureCookies only sends the login binding cookie over HTTPS, which is not available in dev mode
+	secureCookies bool
+}
+
+func NewOAuthController(oauthService services.OAuthService, authService services.AuthService, tokenService services.TokenService, mfaService services.MFAService, auditService services.AuditService, userMiddleware middlewares.UserMiddleware, secureCookies bool) OAuthController {
+	return OAuthController{
+		oauthService:   oauthService,
+		authService:    authService,
+		tokenService:   tokenService,
+		mfaService:     mfaService,
+		auditService:   auditService,
+		userMiddleware: userMiddleware,
+		secureCookies:  secureCookies,
+	}
+}
+
+func (controller OAuthController) SetupRoutes(rg *gin.RouterGroup) {
+	login := rg.Group(AuthPath).Group(OAuthPath)
+	login.GET("/:provider", controller.Authorize)
+	login.POST("/:provider/callback", controller.Callback)
+
+	identities := rg.Group(UserPath).Group(OAuthPath)
+	identities.Use(controller.userMiddleware.AuthenticateUser())
+	identities.GET("", controller.GetIdentities)
+	identities.POST("/:provider/link", controller.Link)
+	identities.POST("/:provider/callback", controller.LinkCallback)
+	identities.DELETE("/:provider", controller.Unlink)
+}
+
+// Authorize godoc
+// @Summary      Start social login
+// @Description  Returns the provider authorization URL for the authorization code flow with PKCE, and sets the
+// @Description  oauth_binding cookie that the callback must be sent with from the same browser
+// @Tags         auth
+// @Produce      json
+// @Param        provider path string true "Identity provider (google, github, oidc)"
+// @Success      200  {object}  response.Response{data=models.OAuthAuthorization}
+// @Failure      404  {object}  response.Response
+// @Router       /auth/oauth/{provider} [get]
+func (controller OAuthController) Authorize(ctx *gin.Context) {
+	authorizationURL, binding, err := controller.oauthService.Authorize(ctx.Param(api.ProviderParam))
+	if err != nil {
+		log.Println(err.Error())
+		controller.handleError(ctx, err)
+		return
+	}
+
+	controller.setBindingCookie(ctx, binding, oauthBindingMaxAge)
+
+	response.WithSuccess(ctx, http.StatusOK, message.AuthorizationStarted, models.OAuthAuthorization{
+		AuthorizationURL: authorizationURL,
+	})
+}
+
+// Callback godoc
+// @Summary      Complete social login
+// @Description  Exchanges the code and state received from the provider for tokens. Must be sent with the
+// @Description  oauth_binding cookie of the browser that started the login.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        provider path string true "Identity provider (google, github, oidc)"
+// @Param        request body models.OAuthCallbackRequest true "Code and state from the provider redirect"
+// @Success      200  {object}  response.Response{data=models.Tokens}
+// @Success      202  {object}  response.Response{data=models.MFAChallenge}
+// @Failure      400  {object}  response.Response "Invalid state, or the login was started by another browser"
+// @Failure      403  {object}  response.Response "Account suspended"
+// @Failure      409  {object}  response.Response "The account with the email must link the identity"
+// @Router       /auth/oauth/{provider}/callback [post]
+func (controller OAuthController) Callback(ctx *gin.Context) {
+	var req models.OAuthCallbackRequest
+
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		log.Println(err.Error())
+		response.WithError(ctx, http.StatusBadRequest, message.MissingField)
+		return
+	}
+
+	// A missing cookie leaves the binding empty, which never matches
+	binding, _ := ctx.Cookie(api.OAuthBindingCookie)
+	controller.setBindingCookie(ctx, "", -1)
+
+	uid, err := controller.oauthService.HandleCallback(ctx.Param(api.ProviderParam), req.Code, req.State, binding)
+	if err != nil {
+		log.Println(err.Error())
+		controller.handleError(ctx, err)
+		return
+	}
+
+	completeLogin(ctx, controller.authService, controller.tokenService, controller.mfaService, controller.auditService, uid, oauthLogin)
+}
+
+// @Summary Get linked identities
+// @Description Lists the external identities linked to the authenticated user
+// @Tags OAuth
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} response.Response{data=[]models.Identity} "Identities retrieved"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /user/oauth [get]
+func (controller OAuthController) GetIdentities(ctx *gin.Context) {
+	uid := ctx.GetString(api.UidParam)
+
+	identities, err := controller.oauthService.ListIdentities(uid)
+	if err != nil {
+		log.Println(err.Error())
+		response.WithError(ctx, http.StatusInternalServerError, message.OAuthError)
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusOK, message.IdentitiesRetrieved, identities)
+}
+
+// @Summary Link identity
+// @Description Starts the authorization flow to link an external identity to the authenticated user
+// @Tags OAuth
+// @Produce json
+// @Security BearerAuth
+// @Param provider path string true "Identity provider (google, github, oidc)"
+// @Success 200 {object} response.Response{data=models.OAuthAuthorization} "Authorization started"
+// @Failure 404 {object} response.Response "Unknown provider"
+// @Router /user/oauth/{provider}/link [post]
+func (controller OAuthController) Link(ctx *gin.Context) {
+	uid := ctx.GetString(api.UidParam)
+
+	authorizationURL, err := controller.oauthService.AuthorizeLink(ctx.Param(api.ProviderParam), uid)
+	if err != nil {
+		log.Println(err.Error())
+		controller.handleError(ctx, err)
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusOK, message.AuthorizationStarted, models.OAuthAuthorization{
+		AuthorizationURL: authorizationURL,
+	})
+}
+
+// @Summary Complete linking
+// @Description Exchanges the code and state received from the provider and links the identity to the authenticated
+// @Description user, who must be the user who started the flow
+// @Tags OAuth
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param provider path string true "Identity provider (google, github, oidc)"
+// @Param request body models.OAuthCallbackRequest true "Code and state from the provider redirect"
+// @Success 200 {object} response.Response "Identity linked"
+// @Failure 400 {object} response.Response "Invalid state, or the flow was started by another user"
+// @Failure 404 {object} response.Response "Unknown provider"
+// @Failure 409 {object} response.Response "Identity already linked"
+// @Router /user/oauth/{provider}/callback [post]
+func (controller OAuthController) LinkCallback(ctx *gin.Context) {
+	uid := ctx.GetString(api.UidParam)
+
+	var req models.OAuthCallbackRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		log.Println(err.Error())
+		response.WithError(ctx, http.StatusBadRequest, message.MissingField)
+		return
+	}
+
+	if err := controller.oauthService.HandleLinkCallback(ctx.Param(api.ProviderParam), req.Code, req.State, uid); err != nil {
+		log.Println(err.Error())
+		controller.handleError(ctx, err)
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusOK, message.IdentityLinked, nil)
+}
+
+// @Summary Unlink identity
+// @Description Removes the authenticated user's identity at the given provider
+// @Tags OAuth
+// @Produce json
+// @Security BearerAuth
+// @Param provider path string true "Identity provider (google, github, oidc)"
+// @Success 200 {object} response.Response "Identity unlinked"
+// @Failure 404 {object} response.Response "Identity not found"
+// @Failure 409 {object} response.Response "Only login method"
+// @Router /user/oauth/{provider} [delete]
+func (controller OAuthController) Unlink(ctx *gin.Context) {
+	uid := ctx.GetString(api.UidParam)
+
+	if err := controller.oauthService.Unlink(uid, ctx.Param(api.ProviderParam)); err != nil {
+		log.Println(err.Error())
+		controller.handleError(ctx, err)
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusOK, message.IdentityUnlinked, nil)
+}
+
+// setBindingCookie sets the login binding cookie for the login routes, or deletes it with a negative maxAge.
+// It is sent cross-site, since the frontend may be on another site, so it requires HTTPS outside dev mode.
+func (controller OAuthController) setBindingCookie(ctx *gin.Context, binding string, maxAge int) {
+	sameSite := http.SameSiteLaxMode
+	if controller.secureCookies {
+		sameSite = http.SameSiteNoneMode
+	}
+	ctx.SetSameSite(sameSite)
+
+	// The cookie is only sent to the login routes, e.g. /api/auth/oauth
+	cookiePath, _, _ := strings.Cut(ctx.FullPath(), "/:"+api.ProviderParam)
+	ctx.SetCookie(api.OAuthBindingCookie, binding, maxAge, cookiePath, "", controller.secureCookies, true)
+}
+
+// handleError maps OAuth service errors to HTTP responses
+func (controller OAuthController) handleError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrUnknownProvider),
+		errors.Is(err, services.ErrIdentityNotFound):
+		response.WithError(ctx, http.StatusNotFound, err.Error())
+	case errors.Is(err, services.ErrInvalidOAuthState),
+		errors.Is(err, services.ErrEmailNotVerified):
+		response.WithError(ctx, http.StatusBadRequest, err.Error())
+	case errors.Is(err, services.ErrIdentityAlreadyLinked),
+		errors.Is(err, services.ErrLinkRequired),
+		errors.Is(err, services.ErrLastLoginMethod):
+		response.WithError(ctx, http.StatusConflict, err.Error())
+	default:
+		response.WithError(ctx, http.StatusInternalServerError, message.OAuthError)
+	}
+}
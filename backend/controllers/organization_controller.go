@@ -0,0 +1,735 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/AkifhanIlgaz/dictionary-api/middlewares"
+	"github.com/AkifhanIlgaz/dictionary-api/models"
+	"github.com/AkifhanIlgaz/dictionary-api/services"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/api"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/message"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/response"
+	"github.com/gin-gonic/gin"
+)
+
+const OrganizationsPath = "/orgs"
+
+type OrganizationController struct {
+	organizationService services.OrganizationService
+	userService         services.UserService
+	usageService        services.UsageService
+	authService         services.AuthService
+	rateLimitService    services.RateLimitService
+	webhookService      services.WebhookService
+	auditService        services.AuditService
+	userMiddleware      middlewares.UserMiddleware
+}
+
+func NewOrganizationController(organizationService services.OrganizationService, userService services.UserService, usageService services.UsageService, authService services.AuthService, rateLimitService services.RateLimitService, webhookService services.WebhookService, auditService services.AuditService, userMiddleware middlewares.UserMiddleware) OrganizationController {
+	return OrganizationController{
+		organizationService: organizationService,
+		userService:         userService,
+		usageService:        usageService,
+		authService:         authService,
+		rateLimitService:    rateLimitService,
+		webhookService:      webhookService,
+		auditService:        auditService,
+		userMiddleware:      userMiddleware,
+	}
+}
+
+func (controller OrganizationController) SetupRoutes(rg *gin.RouterGroup) {
+	router := rg.Group(OrganizationsPath)
+	router.Use(controller.userMiddleware.AuthenticateUser())
+
+	router.GET("", controller.GetOrganizations)
+	router.POST("", controller.CreateOrganization)
+	router.POST("/invitations/accept", controller.AcceptInvitation)
+	router.GET("/:id", controller.GetOrganization)
+	router.PATCH("/:id", controller.UpdateOrganization)
+	router.DELETE("/:id", controller.DeleteOrganization)
+
+	router.GET("/:id/members", controller.GetMembers)
+	router.PUT("/:id/members/:memberId/role", controller.SetMemberRole)
+	router.DELETE("/:id/members/:memberId", controller.RemoveMember)
+
+	router.GET("/:id/invitations", controller.GetInvitations)
+	router.POST("/:id/invitations", controller.InviteMember)
+	router.DELETE("/:id/invitations/:invitationId", controller.RevokeInvitation)
+
+	router.GET("/:id/api-keys", controller.GetAPIKeys)
+	router.POST("/:id/api-keys", controller.GenerateAPIKey)
+	router.DELETE("/:id/api-keys/:keyId", controller.RevokeAPIKey)
+
+	router.GET("/:id/usage", controller.GetUsageHistory)
+	router.GET("/:id/quota", controller.GetQuota)
+}
+
+// @Summary Get Organizations
+// @Description Lists the organizations the authenticated user is a member of, with their role in each
+// @Tags Organizations
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} response.Response{data=models.OrganizationsResponse} "Organizations retrieved successfully"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /orgs [get]
+func (controller OrganizationController) GetOrganizations(ctx *gin.Context) {
+	uid := ctx.GetString(api.UidParam)
+
+	organizations, err := controller.organizationService.ListForUser(uid)
+	if err != nil {
+		log.Println(err.Error())
+		handleOrganizationError(ctx, err)
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusOK, message.OrganizationsRetrieved, models.OrganizationsResponse{
+		Organizations: organizations,
+	})
+}
+
+// @Summary Create Organization
+// @Description Creates an organization on the default plan with the authenticated user as its owner
+// @Tags Organizations
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.CreateOrganizationRequest true "Name of the organization"
+// @Success 201 {object} response.Response{data=models.OrganizationResponse} "Organization created successfully"
+// @Failure 400 {object} response.Response "Missing or too long name"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /orgs [post]
+func (controller OrganizationController) CreateOrganization(ctx *gin.Context) {
+	uid := ctx.GetString(api.UidParam)
+
+	var req models.CreateOrganizationRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response.WithError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	user, err := controller.authService.GetById(uid)
+	if err != nil {
+		log.Println(err.Error())
+		response.WithError(ctx, http.StatusInternalServerError, message.OrganizationError)
+		return
+	}
+
+	org, err := controller.organizationService.Create(uid, user.Email, req)
+	if err != nil {
+		log.Println(err.Error())
+		handleOrganizationError(ctx, err)
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusCreated, message.OrganizationCreated, models.OrganizationResponse{
+		Organization: org,
+		Role:         models.OrgOwnerRole,
+	})
+}
+
+// @Summary Get Organization
+// @Description Retrieves an organization the authenticated user is a member of, with their role in it
+// @Tags Organizations
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Organization ID"
+// @Success 200 {object} response.Response{data=models.OrganizationResponse} "Organization retrieved successfully"
+// @Failure 404 {object} response.Response "Organization not found"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /orgs/{id} [get]
+func (controller OrganizationController) GetOrganization(ctx *gin.Context) {
+	membership, ok := controller.membership(ctx, false)
+	if !ok {
+		return
+	}
+
+	org, err := controller.organizationService.Get(ctx.Param(api.IdParam))
+	if err != nil {
+		log.Println(err.Error())
+		handleOrganizationError(ctx, err)
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusOK, message.OrganizationRetrieved, models.OrganizationResponse{
+		Organization: org,
+		Role:         membership.Role,
+	})
+}
+
+// @Summary Update Organization
+// @Description Renames an organization. Requires the owner or admin role.
+// @Tags Organizations
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Organization ID"
+// @Param request body models.UpdateOrganizationRequest true "New name of the organization"
+// @Success 200 {object} response.Response{data=models.OrganizationResponse} "Organization updated successfully"
+// @Failure 400 {object} response.Response "Missing or too long name"
+// @Failure 403 {object} response.Response "Role does not allow this"
+// @Failure 404 {object} response.Response "Organization not found"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /orgs/{id} [patch]
+func (controller OrganizationController) UpdateOrganization(ctx *gin.Context) {
+	membership, ok := controller.membership(ctx, true)
+	if !ok {
+		return
+	}
+
+	var req models.UpdateOrganizationRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response.WithError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	org, err := controller.organizationService.Rename(ctx.Param(api.IdParam), req)
+	if err != nil {
+		log.Println(err.Error())
+		handleOrganizationError(ctx, err)
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusOK, message.OrganizationUpdated, models.OrganizationResponse{
+		Organization: org,
+		Role:         membership.Role,
+	})
+}
+
+// @Summary Delete Organization
+// @Description Deletes an organization with its members, invitations and API keys. Requires the owner role.
+// @Tags Organizations
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Organization ID"
+// @Success 200 {object} response.Response "Organization deleted successfully"
+// @Failure 403 {object} response.Response "Role does not allow this"
+// @Failure 404 {object} response.Response "Organization not found"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /orgs/{id} [delete]
+func (controller OrganizationController) DeleteOrganization(ctx *gin.Context) {
+	membership, ok := controller.membership(ctx, true)
+	if !ok {
+		return
+	}
+
+	if membership.Role != models.OrgOwnerRole {
+		response.WithError(ctx, http.StatusForbidden, message.OrgPermissionDenied)
+		return
+	}
+
+	if err := controller.organizationService.Delete(ctx.Param(api.IdParam)); err != nil {
+		log.Println(err.Error())
+		handleOrganizationError(ctx, err)
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusOK, message.OrganizationDeleted, nil)
+}
+
+// @Summary Get Organization Members
+// @Description Lists the members of an organization with their roles
+// @Tags Organizations
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Organization ID"
+// @Success 200 {object} response.Response{data=models.OrgMembersResponse} "Organization members retrieved successfully"
+// @Failure 404 {object} response.Response "Organization not found"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /orgs/{id}/members [get]
+func (controller OrganizationController) GetMembers(ctx *gin.Context) {
+	if _, ok := controller.membership(ctx, false); !ok {
+		return
+	}
+
+	members, err := controller.organizationService.ListMembers(ctx.Param(api.IdParam))
+	if err != nil {
+		log.Println(err.Error())
+		handleOrganizationError(ctx, err)
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusOK, message.OrgMembersRetrieved, models.OrgMembersResponse{
+		Members: members,
+	})
+}
+
+// @Summary Set Organization Member Role
+// @Description Changes the role of a member of an organization. Requires the owner role.
+// @Description An organization always keeps at least one owner.
+// @Tags Organizations
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Organization ID"
+// @Param memberId path string true "User ID of the member"
+// @Param request body models.SetOrgRoleRequest true "Role: owner, admin or member"
+// @Success 200 {object} response.Response{data=models.OrgMembership} "Organization member updated successfully"
+// @Failure 400 {object} response.Response "Invalid role"
+// @Failure 403 {object} response.Response "Role does not allow this"
+// @Failure 404 {object} response.Response "Organization or member not found"
+// @Failure 409 {object} response.Response "Last owner"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /orgs/{id}/members/{memberId}/role [put]
+func (controller OrganizationController) SetMemberRole(ctx *gin.Context) {
+	membership, ok := controller.membership(ctx, true)
+	if !ok {
+		return
+	}
+
+	if membership.Role != models.OrgOwnerRole {
+		response.WithError(ctx, http.StatusForbidden, message.OrgPermissionDenied)
+		return
+	}
+
+	var req models.SetOrgRoleRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response.WithError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	member, err := controller.organizationService.SetRole(ctx.Param(api.IdParam), ctx.Param(api.MemberIdParam), req.Role)
+	if err != nil {
+		log.Println(err.Error())
+		handleOrganizationError(ctx, err)
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusOK, message.OrgMemberUpdated, member)
+}
+
+// @Summary Remove Organization Member
+// @Description Removes a member from an organization and revokes the keys of the organization they created.
+// @Description Members can remove themselves, owners and admins can remove others, but only owners can remove owners.
+// @Tags Organizations
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Organization ID"
+// @Param memberId path string true "User ID of the member"
+// @Success 200 {object} response.Response "Organization member removed successfully"
+// @Failure 403 {object} response.Response "Role does not allow this"
+// @Failure 404 {object} response.Response "Organization or member not found"
+// @Failure 409 {object} response.Response "Last owner"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /orgs/{id}/members/{memberId} [delete]
+func (controller OrganizationController) RemoveMember(ctx *gin.Context) {
+	uid := ctx.GetString(api.UidParam)
+	orgId, memberId := ctx.Param(api.IdParam), ctx.Param(api.MemberIdParam)
+
+	membership, ok := controller.membership(ctx, memberId != uid)
+	if !ok {
+		return
+	}
+
+	if memberId != uid && membership.Role != models.OrgOwnerRole {
+		member, err := controller.organizationService.GetMember(orgId, memberId)
+		if err != nil {
+			log.Println(err.Error())
+			handleOrganizationError(ctx, err)
+			return
+		}
+		if member.Role == models.OrgOwnerRole {
+			response.WithError(ctx, http.StatusForbidden, message.OrgPermissionDenied)
+			return
+		}
+	}
+
+	if err := controller.organizationService.RemoveMember(orgId, memberId); err != nil {
+		log.Println(err.Error())
+		handleOrganizationError(ctx, err)
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusOK, message.OrgMemberRemoved, nil)
+}
+
+// @Summary Get Organization Invitations
+// @Description Lists the pending invitations of an organization. Requires the owner or admin role.
+// @Tags Organizations
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Organization ID"
+// @Success 200 {object} response.Response{data=models.OrgInvitationsResponse} "Invitations retrieved successfully"
+// @Failure 403 {object} response.Response "Role does not allow this"
+// @Failure 404 {object} response.Response "Organization not found"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /orgs/{id}/invitations [get]
+func (controller OrganizationController) GetInvitations(ctx *gin.Context) {
+	if _, ok := controller.membership(ctx, true); !ok {
+		return
+	}
+
+	invitations, err := controller.organizationService.ListInvitations(ctx.Param(api.IdParam))
+	if err != nil {
+		log.Println(err.Error())
+		handleOrganizationError(ctx, err)
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusOK, message.OrgInvitationsRetrieved, models.OrgInvitationsResponse{
+		Invitations: invitations,
+	})
+}
+
+// @Summary Invite Organization Member
+// @Description Emails an invitation token to join an organization, valid for 7 days. Inviting an address again sends
+// @Description a new token. Requires the owner or admin role, and only owners can invite owners. Members by default.
+// @Tags Organizations
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Organization ID"
+// @Param request body models.InviteOrgMemberRequest true "Email address and role"
+// @Success 201 {object} response.Response{data=models.OrgInvitationResponse} "Invitation sent successfully"
+// @Failure 400 {object} response.Response "Invalid email or role, or too many members"
+// @Failure 403 {object} response.Response "Role does not allow this"
+// @Failure 404 {object} response.Response "Organization not found"
+// @Failure 409 {object} response.Response "Already a member"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /orgs/{id}/invitations [post]
+func (controller OrganizationController) InviteMember(ctx *gin.Context) {
+	uid := ctx.GetString(api.UidParam)
+
+	membership, ok := controller.membership(ctx, true)
+	if !ok {
+		return
+	}
+
+	var req models.InviteOrgMemberRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response.WithError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if req.Role == models.OrgOwnerRole && membership.Role != models.OrgOwnerRole {
+		response.WithError(ctx, http.StatusForbidden, message.OrgPermissionDenied)
+		return
+	}
+
+	invitation, err := controller.organizationService.Invite(ctx.Param(api.IdParam), uid, req)
+	if err != nil {
+		log.Println(err.Error())
+		handleOrganizationError(ctx, err)
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusCreated, message.OrgInvitationSent, models.OrgInvitationResponse{
+		Invitation: invitation,
+	})
+}
+
+// @Summary Revoke Organization Invitation
+// @Description Revokes a pending invitation of an organization. Requires the owner or admin role.
+// @Tags Organizations
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Organization ID"
+// @Param invitationId path string true "Invitation ID"
+// @Success 200 {object} response.Response "Invitation revoked successfully"
+// @Failure 403 {object} response.Response "Role does not allow this"
+// @Failure 404 {object} response.Response "Organization or invitation not found"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /orgs/{id}/invitations/{invitationId} [delete]
+func (controller OrganizationController) RevokeInvitation(ctx *gin.Context) {
+	if _, ok := controller.membership(ctx, true); !ok {
+		return
+	}
+
+	if err := controller.organizationService.RevokeInvitation(ctx.Param(api.IdParam), ctx.Param(api.InvitationIdParam)); err != nil {
+		log.Println(err.Error())
+		handleOrganizationError(ctx, err)
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusOK, message.OrgInvitationRevoked, nil)
+}
+
+// @Summary Accept Organization Invitation
+// @Description Makes the authenticated user a member of the organization an emailed invitation token invites them to.
+// @Description The invitation must have been sent to the email address of the user.
+// @Tags Organizations
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.AcceptOrgInvitationRequest true "Invitation token"
+// @Success 200 {object} response.Response{data=models.OrgMembership} "Invitation accepted successfully"
+// @Failure 400 {object} response.Response "Missing token"
+// @Failure 403 {object} response.Response "Invitation sent to another email address"
+// @Failure 404 {object} response.Response "Invitation not found or expired"
+// @Failure 409 {object} response.Response "Already a member"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /orgs/invitations/accept [post]
+func (controller OrganizationController) AcceptInvitation(ctx *gin.Context) {
+	uid := ctx.GetString(api.UidParam)
+
+	var req models.AcceptOrgInvitationRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response.WithError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	user, err := controller.authService.GetById(uid)
+	if err != nil {
+		log.Println(err.Error())
+		response.WithError(ctx, http.StatusInternalServerError, message.OrganizationError)
+		return
+	}
+
+	membership, err := controller.organizationService.AcceptInvitation(uid, user.Email, req.Token)
+	if err != nil {
+		log.Println(err.Error())
+		handleOrganizationError(ctx, err)
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusOK, message.OrgInvitationAccepted, membership)
+}
+
+// @Summary Get Organization API Keys
+// @Description Lists the API keys of an organization, oldest first. The uid of a key is the member who created it.
+// @Tags Organizations
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Organization ID"
+// @Success 200 {object} response.Response{data=models.APIKeysResponse} "API keys retrieved successfully"
+// @Failure 404 {object} response.Response "Organization not found"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /orgs/{id}/api-keys [get]
+func (controller OrganizationController) GetAPIKeys(ctx *gin.Context) {
+	if _, ok := controller.membership(ctx, false); !ok {
+		return
+	}
+
+	apiKeys, err := controller.userService.ListOrgApiKeys(ctx.Param(api.IdParam))
+	if err != nil {
+		log.Println(err.Error())
+		response.WithError(ctx, http.StatusInternalServerError, message.ApiKeyError)
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusOK, message.ApiKeyRetrieved, models.APIKeysResponse{
+		APIKeys: apiKeys,
+	})
+}
+
+// @Summary Create Organization API Key
+// @Description Creates an API key of an organization, attributed to the authenticated member.
+// @Description Requests with the key count towards the quota of the organization. Keys without scopes get words:read.
+// @Tags Organizations
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Organization ID"
+// @Param request body models.CreateAPIKeyRequest false "Name, scopes and optional expiry"
+// @Success 201 {object} response.Response{data=models.APIKeyResponse} "API key created successfully"
+// @Failure 400 {object} response.Response "Invalid scope or expiry"
+// @Failure 404 {object} response.Response "Organization not found"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /orgs/{id}/api-keys [post]
+func (controller OrganizationController) GenerateAPIKey(ctx *gin.Context) {
+	uid := ctx.GetString(api.UidParam)
+
+	if _, ok := controller.membership(ctx, false); !ok {
+		return
+	}
+
+	var req models.CreateAPIKeyRequest
+	if ctx.Request.ContentLength > 0 {
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			log.Println(err.Error())
+			response.WithError(ctx, http.StatusBadRequest, message.MissingField)
+			return
+		}
+	}
+
+	apiKey, err := controller.userService.CreateOrgApiKey(ctx.Param(api.IdParam), uid, req)
+	if err != nil {
+		log.Println(err.Error())
+		handleApiKeyError(ctx, err)
+		return
+	}
+	publishApiKeyEvent(controller.webhookService, uid, models.APIKeyCreatedEvent, apiKey)
+	recordAuditEvent(controller.auditService, ctx, models.APIKeyCreatedAction, uid, apiKeyAuditDetails(apiKey))
+
+	response.WithSuccess(ctx, http.StatusCreated, message.ApiKeyCreated, models.APIKeyResponse{
+		APIKey: apiKey,
+	})
+}
+
+// @Summary Revoke Organization API Key
+// @Description Revokes an API key of an organization. Members can revoke the keys they created, owners and admins any key.
+// @Tags Organizations
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Organization ID"
+// @Param keyId path string true "API key ID"
+// @Success 200 {object} response.Response "API key deleted successfully"
+// @Failure 404 {object} response.Response "Organization or API key not found"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /orgs/{id}/api-keys/{keyId} [delete]
+func (controller OrganizationController) RevokeAPIKey(ctx *gin.Context) {
+	membership, ok := controller.membership(ctx, false)
+	if !ok {
+		return
+	}
+
+	// Members can only revoke their own keys
+	creator := membership.Uid
+	if membership.Role.CanManage() {
+		creator = ""
+	}
+
+	apiKey, err := controller.userService.DeleteOrgApiKey(ctx.Param(api.IdParam), ctx.Param(api.KeyIdParam), creator)
+	if err != nil {
+		log.Println(err.Error())
+		handleApiKeyError(ctx, err)
+		return
+	}
+	publishApiKeyEvent(controller.webhookService, apiKey.Uid, models.APIKeyRevokedEvent, apiKey)
+	recordAuditEvent(controller.auditService, ctx, models.APIKeyRevokedAction, apiKey.Uid, apiKeyAuditDetails(apiKey))
+
+	response.WithSuccess(ctx, http.StatusOK, message.ApiKeyDeleted, nil)
+}
+
+// @Summary Get Organization Usage History
+// @Description Retrieves the usage of the API keys of an organization like the usage history of a user,
+// @Description which can also be broken down by the member who created each key.
+// @Tags Organizations
+// @Accept json
+// @Produce json,text/csv
+// @Security BearerAuth
+// @Param id path string true "Organization ID"
+// @Param from query string false "Start date (2006-01-02) or RFC 3339 time, 30 days or 24 hours before to by default"
+// @Param to query string false "End date, inclusive, or RFC 3339 time, exclusive, now by default"
+// @Param granularity query string false "Bucket size" Enums(day, hour) default(day)
+// @Param groupBy query string false "Comma separated dimensions to break down by, all by default" example(key,endpoint,status,member)
+// @Param format query string false "Response format, CSV can also be requested with Accept: text/csv" Enums(json, csv) default(json)
+// @Success 200 {object} response.Response{data=models.UsageHistoryResponse} "Usage history retrieved successfully"
+// @Failure 400 {object} response.Response "Invalid range, granularity, groupBy or format"
+// @Failure 404 {object} response.Response "Organization not found"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /orgs/{id}/usage [get]
+func (controller OrganizationController) GetUsageHistory(ctx *gin.Context) {
+	if _, ok := controller.membership(ctx, false); !ok {
+		return
+	}
+	orgId := ctx.Param(api.IdParam)
+
+	var req models.UsageHistoryRequest
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		response.WithError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	format, ok := usageFormat(ctx, req)
+	if !ok {
+		response.WithError(ctx, http.StatusBadRequest, message.InvalidUsageFormat)
+		return
+	}
+
+	history, err := controller.usageService.GetOrgHistory(orgId, req, time.Now())
+	if err != nil {
+		log.Println(err.Error())
+		handleUsageHistoryError(ctx, err)
+		return
+	}
+
+	members, err := controller.organizationService.ListMembers(orgId)
+	if err != nil {
+		log.Println(err.Error())
+		handleOrganizationError(ctx, err)
+		return
+	}
+
+	// Former members only have their user ID
+	emails := map[string]string{}
+	for _, member := range members {
+		emails[member.Uid] = member.Email
+	}
+	for i := range history.Rows {
+		history.Rows[i].Email = emails[history.Rows[i].Uid]
+	}
+
+	if format == api.CSVFormat {
+		writeUsageCSV(ctx, history)
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusOK, message.UsageRetrieved, history)
+}
+
+// @Summary Get Organization Quota
+// @Description Retrieves the usage of the current quota window of an organization, shared by all of its keys.
+// @Description Quota windows of organizations start at midnight UTC.
+// @Tags Organizations
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Organization ID"
+// @Success 200 {object} response.Response{data=models.QuotaUsage} "Quota retrieved successfully"
+// @Failure 404 {object} response.Response "Organization not found"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /orgs/{id}/quota [get]
+func (controller OrganizationController) GetQuota(ctx *gin.Context) {
+	if _, ok := controller.membership(ctx, false); !ok {
+		return
+	}
+	orgId := ctx.Param(api.IdParam)
+
+	orgQuota, err := controller.organizationService.GetQuota(orgId)
+	if err != nil {
+		log.Println(err.Error())
+		handleOrganizationError(ctx, err)
+		return
+	}
+
+	quota, err := controller.rateLimitService.GetQuotaUsage(models.OrgQuotaSubject(orgId), orgQuota, time.Now())
+	if err != nil {
+		log.Println(err.Error())
+		response.WithError(ctx, http.StatusInternalServerError, message.OrganizationError)
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusOK, message.UsageRetrieved, quota)
+}
+
+// membership returns the membership of the authenticated user in the organization of the request.
+// It responds with 404 if the user is not a member, and with 403 if manage is set and their role cannot manage it.
+func (controller OrganizationController) membership(ctx *gin.Context, manage bool) (*models.OrgMembership, bool) {
+	membership, err := controller.organizationService.GetMembership(ctx.Param(api.IdParam), ctx.GetString(api.UidParam))
+	if err != nil {
+		log.Println(err.Error())
+		handleOrganizationError(ctx, err)
+		return nil, false
+	}
+
+	if manage && !membership.Role.CanManage() {
+		response.WithError(ctx, http.StatusForbidden, message.OrgPermissionDenied)
+		return nil, false
+	}
+
+	return membership, true
+}
+
+// handleOrganizationError maps organization service errors to HTTP responses
+func handleOrganizationError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidOrgRole),
+		errors.Is(err, services.ErrTooManyOrgMembers),
+		errors.Is(err, services.ErrUnknownPlan):
+		response.WithError(ctx, http.StatusBadRequest, err.Error())
+	case errors.Is(err, services.ErrOrgInvitationEmail):
+		response.WithError(ctx, http.StatusForbidden, err.Error())
+	case errors.Is(err, services.ErrOrganizationNotFound),
+		errors.Is(err, services.ErrOrgMemberNotFound),
+		errors.Is(err, services.ErrOrgInvitationNotFound):
+		response.WithError(ctx, http.StatusNotFound, err.Error())
+	case errors.Is(err, services.ErrAlreadyOrgMember),
+		errors.Is(err, services.ErrLastOrgOwner):
+		response.WithError(ctx, http.StatusConflict, err.Error())
+	default:
+		response.WithError(ctx, http.StatusInternalServerError, message.OrganizationError)
+	}
+}
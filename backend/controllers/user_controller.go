@@ -1,9 +1,14 @@
 package controllers
 
 import (
+	"encoding/csv"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"slices"
+	"strconv"
+	"time"
 
 	"github.com/AkifhanIlgaz/dictionary-api/middlewares"
 	"github.com/AkifhanIlgaz/dictionary-api/models"
@@ -17,55 +22,98 @@ import (
 
 const UserPath = "/user"
 const ApiKeyPath = "/api-key"
+const BillingPath = "/billing"
 
 type UserController struct {
-	userService    services.UserService
-	userMiddleware middlewares.UserMiddleware
+	userService      services.UserService
+	usageService     services.UsageService
+	authService      services.AuthService
+	rateLimitService services.RateLimitService
+	webhookService   services.WebhookService
+	auditService     services.AuditService
+	userMiddleware   middlewares.UserMiddleware
 }
 
-func NewUserController(userService services.UserService, userMiddleware middlewares.UserMiddleware) UserController {
+func NewUserController(userService services.UserService, usageService services.UsageService, authService services.AuthService, rateLimitService services.RateLimitService, webhookService services.WebhookService, auditService services.AuditService, userMiddleware middlewares.UserMiddleware) UserController {
 	return UserController{
-		userService:    userService,
-		userMiddleware: userMiddleware,
+		userService:      userService,
+		usageService:     usageService,
+		authService:      authService,
+		rateLimitService: rateLimitService,
+		webhookService:   webhookService,
+		auditService:     auditService,
+		userMiddleware:   userMiddleware,
 	}
 }
 
 func (controller UserController) SetupRoutes(rg *gin.RouterGroup) {
 	router := rg.Group(UserPath)
 
+	router.GET("/audit-log", controller.userMiddleware.AuthenticateUser(), controller.GetAuditLog)
+
 	apiKey := router.Group(ApiKeyPath)
 	apiKey.Use(controller.userMiddleware.AuthenticateUser())
 
-	apiKey.GET("", controller.GetAPIKey)
+	apiKey.GET("", controller.GetAPIKeys)
 	apiKey.POST("", controller.GenerateAPIKey)
-	apiKey.DELETE("", controller.RevokeAPIKey)
+	apiKey.GET("/:id", controller.GetAPIKey)
+	apiKey.PATCH("/:id", controller.UpdateAPIKey)
+	apiKey.DELETE("/:id", controller.RevokeAPIKey)
+	apiKey.POST("/:id/rotate", controller.RotateAPIKey)
+	apiKey.GET("/:id/security-log", controller.GetSecurityLog)
+	apiKey.GET("/usage", controller.GetUsageHistory)
 	apiKey.GET("/usage/today", controller.GetTodayUsage)
 	apiKey.GET("/usage/total", controller.GetTotalUsage)
+
+	billing := router.Group(BillingPath)
+	billing.Use(controller.userMiddleware.AuthenticateUser())
+
+	billing.GET("/quota", controller.GetQuota)
+	billing.PUT("/timezone", controller.SetBillingTimezone)
+}
+
+// @Summary Get API Keys
+// @Description Retrieves all API keys of the authenticated user
+// @Tags API Keys
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} models.APIKeysResponse "API keys retrieved successfully"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /user/api-key [get]
+func (controller UserController) GetAPIKeys(ctx *gin.Context) {
+	uid := ctx.GetString(api.UidParam)
+
+	apiKeys, err := controller.userService.ListApiKeys(uid)
+	if err != nil {
+		log.Println(err.Error())
+		response.WithError(ctx, http.StatusInternalServerError, message.ApiKeyError)
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusOK, message.ApiKeyRetrieved, models.APIKeysResponse{
+		APIKeys: apiKeys,
+	})
 }
 
 // @Summary Get API Key
-// @Description Retrieves the API key for the authenticated user
+// @Description Retrieves an API key of the authenticated user
 // @Tags API Keys
 // @Accept json
 // @Produce json
 // @Security BearerAuth
+// @Param id path string true "API key ID"
 // @Success 200 {object} models.APIKeyResponse "API key retrieved successfully"
 // @Failure 404 {object} response.Response "No API key found"
 // @Failure 500 {object} response.Response "Internal server error"
-// @Router /user/api-key [get]
+// @Router /user/api-key/{id} [get]
 func (controller UserController) GetAPIKey(ctx *gin.Context) {
 	uid := ctx.GetString(api.UidParam)
 
-	apiKey, err := controller.userService.GetApiKey(uid)
+	apiKey, err := controller.userService.GetApiKey(uid, ctx.Param(api.IdParam))
 	if err != nil {
-		if errors.Is(err, mongo.ErrNoDocuments) {
-			response.WithSuccess(ctx, http.StatusOK, message.ApiKeyRetrieved, models.APIKeyResponse{
-				APIKey: nil,
-			})
-			return
-		}
 		log.Println(err.Error())
-		response.WithError(ctx, http.StatusInternalServerError, message.ApiKeyError)
+		handleApiKeyError(ctx, err)
 		return
 	}
 
@@ -75,53 +123,179 @@ func (controller UserController) GetAPIKey(ctx *gin.Context) {
 }
 
 // @Summary Create API Key
-// @Description Creates a new API key for the authenticated user
+// @Description Creates a new API key for the authenticated user. Keys without scopes get words:read.
 // @Tags API Keys
 // @Accept json
 // @Produce json
 // @Security BearerAuth
-// @Param name query string true "Name for the API key"
+// @Param request body models.CreateAPIKeyRequest false "Name, scopes and optional expiry"
 // @Success 201 {object} models.APIKeyResponse "API key created successfully"
+// @Failure 400 {object} response.Response "Invalid scope or expiry"
 // @Failure 500 {object} response.Response "Internal server error"
 // @Router /user/api-key [post]
 func (controller UserController) GenerateAPIKey(ctx *gin.Context) {
 	uid := ctx.GetString(api.UidParam)
 
-	apiKey, err := controller.userService.CreateApiKey(uid)
+	var req models.CreateAPIKeyRequest
+	if ctx.Request.ContentLength > 0 {
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			log.Println(err.Error())
+			response.WithError(ctx, http.StatusBadRequest, message.MissingField)
+			return
+		}
+	}
+
+	apiKey, err := controller.userService.CreateApiKey(uid, req)
 	if err != nil {
 		log.Println(err.Error())
-		response.WithError(ctx, http.StatusInternalServerError, message.ApiKeyError)
+		handleApiKeyError(ctx, err)
 		return
 	}
+	publishApiKeyEvent(controller.webhookService, uid, models.APIKeyCreatedEvent, apiKey)
+	recordAuditEvent(controller.auditService, ctx, models.APIKeyCreatedAction, uid, apiKeyAuditDetails(apiKey))
 
 	response.WithSuccess(ctx, http.StatusCreated, message.ApiKeyCreated, models.APIKeyResponse{
 		APIKey: apiKey,
 	})
 }
 
+// @Summary Update API Key
+// @Description Changes the name, scopes or expiry of an API key of the authenticated user
+// @Tags API Keys
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "API key ID"
+// @Param request body models.UpdateAPIKeyRequest true "Fields to change"
+// @Success 200 {object} models.APIKeyResponse "API key updated successfully"
+// @Failure 400 {object} response.Response "Invalid scope or expiry"
+// @Failure 404 {object} response.Response "No API key found"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /user/api-key/{id} [patch]
+func (controller UserController) UpdateAPIKey(ctx *gin.Context) {
+	uid := ctx.GetString(api.UidParam)
+
+	var req models.UpdateAPIKeyRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		log.Println(err.Error())
+		response.WithError(ctx, http.StatusBadRequest, message.MissingField)
+		return
+	}
+
+	apiKey, err := controller.userService.UpdateApiKey(uid, ctx.Param(api.IdParam), req)
+	if err != nil {
+		log.Println(err.Error())
+		handleApiKeyError(ctx, err)
+		return
+	}
+	recordAuditEvent(controller.auditService, ctx, models.APIKeyUpdatedAction, uid, apiKeyAuditDetails(apiKey))
+
+	response.WithSuccess(ctx, http.StatusOK, message.ApiKeyUpdated, models.APIKeyResponse{
+		APIKey: apiKey,
+	})
+}
+
 // @Summary Delete API Key
-// @Description Deletes the API key for the authenticated user
+// @Description Deletes an API key of the authenticated user
 // @Tags API Keys
 // @Accept json
 // @Produce json
 // @Security BearerAuth
+// @Param id path string true "API key ID"
 // @Success 200 {object} response.Response "API key deleted successfully"
+// @Failure 404 {object} response.Response "No API key found"
 // @Failure 500 {object} response.Response "Internal server error"
-// @Router /user/api-key [delete]
+// @Router /user/api-key/{id} [delete]
 func (controller UserController) RevokeAPIKey(ctx *gin.Context) {
 	uid := ctx.GetString(api.UidParam)
 
-	if err := controller.userService.DeleteApiKey(uid); err != nil {
+	apiKey, err := controller.userService.DeleteApiKey(uid, ctx.Param(api.IdParam))
+	if err != nil {
 		log.Println(err.Error())
-		response.WithError(ctx, http.StatusInternalServerError, message.ApiKeyError)
+		handleApiKeyError(ctx, err)
 		return
 	}
+	publishApiKeyEvent(controller.webhookService, uid, models.APIKeyRevokedEvent, apiKey)
+	recordAuditEvent(controller.auditService, ctx, models.APIKeyRevokedAction, uid, apiKeyAuditDetails(apiKey))
 
 	response.WithSuccess(ctx, http.StatusOK, message.ApiKeyDeleted, nil)
 }
 
+// @Summary Rotate API Key
+// @Description Issues a new key for an API key of the authenticated user. The replaced key keeps working for the grace period and its usage counts towards the same API key.
+// @Tags API Keys
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "API key ID"
+// @Param request body models.RotateAPIKeyRequest false "Grace period of the replaced key"
+// @Success 200 {object} models.APIKeyResponse "API key rotated successfully"
+// @Failure 400 {object} response.Response "Invalid grace period"
+// @Failure 404 {object} response.Response "No API key found"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /user/api-key/{id}/rotate [post]
+func (controller UserController) RotateAPIKey(ctx *gin.Context) {
+	uid := ctx.GetString(api.UidParam)
+
+	var req models.RotateAPIKeyRequest
+	if ctx.Request.ContentLength > 0 {
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			log.Println(err.Error())
+			response.WithError(ctx, http.StatusBadRequest, message.MissingField)
+			return
+		}
+	}
+
+	var gracePeriod *time.Duration
+	if req.GracePeriodHours != nil {
+		grace := time.Duration(*req.GracePeriodHours) * time.Hour
+		gracePeriod = &grace
+	}
+
+	apiKey, err := controller.userService.RotateApiKey(uid, ctx.Param(api.IdParam), gracePeriod)
+	if err != nil {
+		log.Println(err.Error())
+		handleApiKeyError(ctx, err)
+		return
+	}
+	recordAuditEvent(controller.auditService, ctx, models.APIKeyRotatedAction, uid, apiKeyAuditDetails(apiKey))
+
+	response.WithSuccess(ctx, http.StatusOK, message.ApiKeyRotated, models.APIKeyResponse{
+		APIKey: apiKey,
+	})
+}
+
+// @Summary Get API Key Security Log
+// @Description Lists the most recent requests with an API key of the authenticated user that were rejected by its IP or referrer allowlist, newest first. Events are kept for 90 days.
+// @Tags API Keys
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "API key ID"
+// @Param limit query int false "Maximum number of events, 50 by default and at most 500"
+// @Success 200 {object} response.Response{data=models.APIKeySecurityEventsResponse} "Security log retrieved successfully"
+// @Failure 404 {object} response.Response "No API key found"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /user/api-key/{id}/security-log [get]
+func (controller UserController) GetSecurityLog(ctx *gin.Context) {
+	uid := ctx.GetString(api.UidParam)
+
+	limit, _ := strconv.ParseInt(ctx.Query(api.LimitParam), 10, 64)
+
+	events, err := controller.userService.ListSecurityEvents(uid, ctx.Param(api.IdParam), limit)
+	if err != nil {
+		log.Println(err.Error())
+		handleApiKeyError(ctx, err)
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusOK, message.SecurityLogRetrieved, models.APIKeySecurityEventsResponse{
+		Events: events,
+	})
+}
+
 // @Summary Get Today Usage
-// @Description Retrieves the today usage for the authenticated user
+// @Description Retrieves the today usage for the authenticated user, starting at midnight in their billing timezone
 // @Tags Usage
 // @Accept json
 // @Produce json
@@ -132,7 +306,14 @@ func (controller UserController) RevokeAPIKey(ctx *gin.Context) {
 func (controller UserController) GetTodayUsage(ctx *gin.Context) {
 	uid := ctx.GetString(api.UidParam)
 
-	usage, err := controller.userService.GetTodayUsage(uid)
+	userQuota, err := controller.authService.GetUserQuota(uid, time.Now())
+	if err != nil {
+		log.Println(err.Error())
+		response.WithError(ctx, http.StatusInternalServerError, message.ApiKeyError)
+		return
+	}
+
+	usage, err := controller.userService.GetTodayUsage(uid, userQuota.Location)
 	if err != nil {
 		log.Println(err.Error())
 		response.WithError(ctx, http.StatusInternalServerError, message.ApiKeyError)
@@ -167,3 +348,271 @@ func (controller UserController) GetTotalUsage(ctx *gin.Context) {
 		"usage": usage,
 	})
 }
+
+// @Summary Get Usage History
+// @Description Retrieves the usage of the API keys of the authenticated user in daily or hourly buckets,
+// @Description broken down by key, endpoint and status class. Requests are every request that reached the
+// @Description handler, charged are the ones counted towards the quota. Hourly usage is kept for 90 days.
+// @Tags Usage
+// @Accept json
+// @Produce json,text/csv
+// @Security BearerAuth
+// @Param from query string false "Start date (2006-01-02) or RFC 3339 time, 30 days or 24 hours before to by default"
+// @Param to query string false "End date, inclusive, or RFC 3339 time, exclusive, now by default"
+// @Param granularity query string false "Bucket size" Enums(day, hour) default(day)
+// @Param groupBy query string false "Comma separated dimensions to break down by, all by default" example(key,endpoint,status)
+// @Param format query string false "Response format, CSV can also be requested with Accept: text/csv" Enums(json, csv) default(json)
+// @Success 200 {object} response.Response{data=models.UsageHistoryResponse} "Usage history retrieved successfully"
+// @Failure 400 {object} response.Response "Invalid range, granularity, groupBy or format"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /user/api-key/usage [get]
+func (controller UserController) GetUsageHistory(ctx *gin.Context) {
+	uid := ctx.GetString(api.UidParam)
+
+	var req models.UsageHistoryRequest
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		response.WithError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	format, ok := usageFormat(ctx, req)
+	if !ok {
+		response.WithError(ctx, http.StatusBadRequest, message.InvalidUsageFormat)
+		return
+	}
+
+	history, err := controller.usageService.GetHistory(uid, req, time.Now())
+	if err != nil {
+		log.Println(err.Error())
+		handleUsageHistoryError(ctx, err)
+		return
+	}
+
+	if format == api.CSVFormat {
+		writeUsageCSV(ctx, history)
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusOK, message.UsageRetrieved, history)
+}
+
+// usageFormat returns the requested format of a usage history, CSV if it is not set and the Accept header prefers it.
+// Returns false for unknown formats.
+func usageFormat(ctx *gin.Context, req models.UsageHistoryRequest) (string, bool) {
+	format := req.Format
+	if format == "" && ctx.NegotiateFormat(gin.MIMEJSON, api.MIMECSV) == api.MIMECSV {
+		format = api.CSVFormat
+	}
+
+	return format, format == "" || format == api.JSONFormat || format == api.CSVFormat
+}
+
+// handleUsageHistoryError responds to an error getting a usage history
+func handleUsageHistoryError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidGranularity),
+		errors.Is(err, services.ErrInvalidUsageRange),
+		errors.Is(err, services.ErrInvalidGroupBy):
+		response.WithError(ctx, http.StatusBadRequest, err.Error())
+	default:
+		response.WithError(ctx, http.StatusInternalServerError, message.ApiKeyError)
+	}
+}
+
+// writeUsageCSV writes the rows of a usage history as a CSV attachment, one row per bucket and dimension values.
+// Histories of organizations grouped by member have the uid and email of the member in two more columns.
+func writeUsageCSV(ctx *gin.Context, history *models.UsageHistoryResponse) {
+	filename := fmt.Sprintf("usage-%s-%s.csv", history.From.Format(time.DateOnly), history.To.Format(time.DateOnly))
+	ctx.Header(api.ContentDispositionHeader, fmt.Sprintf("attachment; filename=%q", filename))
+	ctx.Header(api.ContentTypeHeader, api.MIMECSV)
+	ctx.Status(http.StatusOK)
+
+	writer := csv.NewWriter(ctx.Writer)
+	byMember := slices.Contains(history.GroupBy, models.MemberDimension)
+
+	header := []string{"period", "key_id", "key_name", "key_prefix", "endpoint", "status_class", "requests", "charged"}
+	if byMember {
+		header = append(header, "member_uid", "member_email")
+	}
+
+	records := [][]string{header}
+	for _, row := range history.Rows {
+		record := []string{
+			row.Period.Format(time.RFC3339),
+			row.KeyId,
+			row.KeyName,
+			row.KeyPrefix,
+			row.Endpoint,
+			row.StatusClass,
+			strconv.Itoa(row.Requests),
+			strconv.Itoa(row.Charged),
+		}
+		if byMember {
+			record = append(record, row.Uid, row.Email)
+		}
+		records = append(records, record)
+	}
+
+	if err := writer.WriteAll(records); err != nil {
+		log.Println(err.Error())
+	}
+}
+
+// @Summary Get Quota
+// @Description Retrieves the usage of the current daily or monthly quota window of the authenticated user.
+// @Description Windows start at midnight in the billing timezone of the user. Unlike the usage history, it is not delayed by the usage rollup.
+// @Tags Usage
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} response.Response{data=models.QuotaUsage} "Quota retrieved successfully"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /user/billing/quota [get]
+func (controller UserController) GetQuota(ctx *gin.Context) {
+	uid := ctx.GetString(api.UidParam)
+	now := time.Now()
+
+	userQuota, err := controller.authService.GetUserQuota(uid, now)
+	if err != nil {
+		log.Println(err.Error())
+		response.WithError(ctx, http.StatusInternalServerError, message.UserError)
+		return
+	}
+
+	quota, err := controller.rateLimitService.GetQuotaUsage(uid, userQuota, now)
+	if err != nil {
+		log.Println(err.Error())
+		response.WithError(ctx, http.StatusInternalServerError, message.UserError)
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusOK, message.UsageRetrieved, quota)
+}
+
+// @Summary Set Billing Timezone
+// @Description Changes the IANA time zone the quota windows of the authenticated user start in.
+// @Description The change takes effect when the current quota window ends.
+// @Tags Usage
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.SetBillingTimezoneRequest true "Billing timezone"
+// @Success 200 {object} response.Response{data=models.BillingTimezoneResponse} "Billing timezone updated successfully"
+// @Failure 400 {object} response.Response "Invalid timezone"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /user/billing/timezone [put]
+func (controller UserController) SetBillingTimezone(ctx *gin.Context) {
+	uid := ctx.GetString(api.UidParam)
+
+	var req models.SetBillingTimezoneRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response.WithError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	timezone, err := controller.authService.SetBillingTimezone(uid, req.Timezone, time.Now())
+	if err != nil {
+		log.Println(err.Error())
+		if errors.Is(err, services.ErrInvalidTimezone) {
+			response.WithError(ctx, http.StatusBadRequest, err.Error())
+			return
+		}
+		response.WithError(ctx, http.StatusInternalServerError, message.UserError)
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusOK, message.TimezoneUpdated, timezone)
+}
+
+// @Summary Get audit log
+// @Description Retrieves the security events of the authenticated user, newest first, like logins, failed logins,
+// @Description password and API key changes and actions of support staff on the account. Older pages are requested
+// @Description with the nextBefore of a page as before.
+// @Tags User
+// @Produce json
+// @Security BearerAuth
+// @Param action query string false "Action" example(auth.login)
+// @Param from query string false "Start date (2006-01-02) or RFC 3339 time"
+// @Param to query string false "End date, inclusive, or RFC 3339 time, exclusive"
+// @Param before query string false "Return events before the event with this ID"
+// @Param limit query int false "Maximum number of events, at most 500" default(50)
+// @Success 200 {object} response.Response{data=models.AuditLogResponse} "Audit log retrieved"
+// @Failure 400 {object} response.Response "Invalid query"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /user/audit-log [get]
+func (controller UserController) GetAuditLog(ctx *gin.Context) {
+	uid := ctx.GetString(api.UidParam)
+
+	var req models.AuditLogRequest
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		response.WithError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	req.Uid = uid
+	req.ActorId = ""
+
+	auditLog, err := controller.auditService.List(req)
+	if err != nil {
+		log.Println(err.Error())
+		if errors.Is(err, services.ErrInvalidAuditQuery) {
+			response.WithError(ctx, http.StatusBadRequest, err.Error())
+			return
+		}
+		response.WithError(ctx, http.StatusInternalServerError, message.AuditError)
+		return
+	}
+
+	// The addresses and accounts of support staff who acted on the user are not shown to the user
+	for i, event := range auditLog.Events {
+		if event.ActorId != "" && event.ActorId != uid {
+			auditLog.Events[i].ActorId = ""
+			auditLog.Events[i].IP = ""
+			auditLog.Events[i].UserAgent = ""
+		}
+	}
+
+	response.WithSuccess(ctx, http.StatusOK, message.AuditLogRetrieved, auditLog)
+}
+
+// apiKeyAuditDetails returns the details of an API key recorded with its audit events
+func apiKeyAuditDetails(apiKey *models.APIKey) map[string]string {
+	details := map[string]string{
+		"keyId":     apiKey.Id.Hex(),
+		"keyName":   apiKey.Name,
+		"keyPrefix": apiKey.Prefix,
+	}
+	if apiKey.OrgId != "" {
+		details["orgId"] = apiKey.OrgId
+	}
+
+	return details
+}
+
+// publishApiKeyEvent sends an api_key webhook event to the endpoints of the user.
+// Failing to publish it does not change the response.
+func publishApiKeyEvent(webhookService services.WebhookService, uid string, eventType models.WebhookEventType, apiKey *models.APIKey) {
+	err := webhookService.Publish(uid, eventType, models.APIKeyEventData{
+		Id:     apiKey.Id.Hex(),
+		Name:   apiKey.Name,
+		Prefix: apiKey.Prefix,
+		OrgId:  apiKey.OrgId,
+	})
+	if err != nil {
+		log.Println(err.Error())
+	}
+}
+
+// handleApiKeyError maps API key service errors to HTTP responses
+func handleApiKeyError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		response.WithError(ctx, http.StatusNotFound, message.ApiKeyNotFound)
+	case errors.Is(err, services.ErrInvalidScope),
+		errors.Is(err, services.ErrInvalidExpiry),
+		errors.Is(err, services.ErrInvalidCIDR),
+		errors.Is(err, services.ErrInvalidReferrer):
+		response.WithError(ctx, http.StatusBadRequest, err.Error())
+	default:
+		response.WithError(ctx, http.StatusInternalServerError, message.ApiKeyError)
+	}
+}
@@ -0,0 +1,308 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/AkifhanIlgaz/dictionary-api/middlewares"
+	"github.com/AkifhanIlgaz/dictionary-api/models"
+	"github.com/AkifhanIlgaz/dictionary-api/services"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/api"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/message"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/response"
+	"github.com/gin-gonic/gin"
+)
+
+const WebhooksPath = "/webhooks"
+
+type WebhookController struct {
+	webhookService services.WebhookService
+	userMiddleware middlewares.UserMiddleware
+}
+
+func NewWebhookController(webhookService services.WebhookService, userMiddleware middlewares.UserMiddleware) WebhookController {
+	return WebhookController{
+		webhookService: webhookService,
+		userMiddleware: userMiddleware,
+	}
+}
+
+func (controller WebhookController) SetupRoutes(rg *gin.RouterGroup) {
+	router := rg.Group(UserPath).Group(WebhooksPath)
+	router.Use(controller.userMiddleware.AuthenticateUser())
+
+	router.GET("/events", controller.GetEventTypes)
+	router.GET("", controller.GetEndpoints)
+	router.POST("", controller.CreateEndpoint)
+	router.GET("/:id", controller.GetEndpoint)
+	router.PATCH("/:id", controller.UpdateEndpoint)
+	router.DELETE("/:id", controller.DeleteEndpoint)
+	router.GET("/:id/deliveries", controller.GetDeliveries)
+	router.GET("/:id/deliveries/:deliveryId", controller.GetDelivery)
+	router.POST("/:id/deliveries/:deliveryId/redeliver", controller.Redeliver)
+}
+
+// @Summary Get Webhook Event Types
+// @Description Lists the event types webhook endpoints can subscribe to
+// @Tags Webhooks
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} response.Response{data=models.WebhookEventTypesResponse} "Webhook event types retrieved successfully"
+// @Router /user/webhooks/events [get]
+func (controller WebhookController) GetEventTypes(ctx *gin.Context) {
+	response.WithSuccess(ctx, http.StatusOK, message.WebhookEventTypesRetrieved, models.WebhookEventTypesResponse{
+		EventTypes: models.WebhookEventTypes,
+	})
+}
+
+// @Summary Get Webhook Endpoints
+// @Description Lists the webhook endpoints of the authenticated user with their signing secrets
+// @Tags Webhooks
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} response.Response{data=models.WebhookEndpointsResponse} "Webhook endpoints retrieved successfully"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /user/webhooks [get]
+func (controller WebhookController) GetEndpoints(ctx *gin.Context) {
+	uid := ctx.GetString(api.UidParam)
+
+	endpoints, err := controller.webhookService.ListEndpoints(uid)
+	if err != nil {
+		log.Println(err.Error())
+		handleWebhookError(ctx, err)
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusOK, message.WebhookEndpointsRetrieved, models.WebhookEndpointsResponse{
+		Endpoints: endpoints,
+	})
+}
+
+// @Summary Create Webhook Endpoint
+// @Description Registers a URL that the events of the authenticated user are posted to, signed with a new secret in
+// @Description the Webhook-Signature header. An endpoint without events is sent all event types.
+// @Tags Webhooks
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.CreateWebhookEndpointRequest true "URL, description and event types"
+// @Success 201 {object} response.Response{data=models.WebhookEndpointSecretResponse} "Webhook endpoint created successfully, with its secret"
+// @Failure 400 {object} response.Response "Invalid URL or event type, or too many endpoints"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /user/webhooks [post]
+func (controller WebhookController) CreateEndpoint(ctx *gin.Context) {
+	uid := ctx.GetString(api.UidParam)
+
+	var req models.CreateWebhookEndpointRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response.WithError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	endpoint, err := controller.webhookService.CreateEndpoint(uid, req)
+	if err != nil {
+		log.Println(err.Error())
+		handleWebhookError(ctx, err)
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusCreated, message.WebhookEndpointCreated, models.WebhookEndpointSecretResponse{
+		Endpoint: withSecret(endpoint),
+	})
+}
+
+// @Summary Get Webhook Endpoint
+// @Description Retrieves a webhook endpoint of the authenticated user
+// @Tags Webhooks
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Webhook endpoint ID"
+// @Success 200 {object} response.Response{data=models.WebhookEndpointResponse} "Webhook endpoint retrieved successfully"
+// @Failure 404 {object} response.Response "Webhook endpoint not found"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /user/webhooks/{id} [get]
+func (controller WebhookController) GetEndpoint(ctx *gin.Context) {
+	uid := ctx.GetString(api.UidParam)
+
+	endpoint, err := controller.webhookService.GetEndpoint(uid, ctx.Param(api.IdParam))
+	if err != nil {
+		log.Println(err.Error())
+		handleWebhookError(ctx, err)
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusOK, message.WebhookEndpointRetrieved, models.WebhookEndpointResponse{
+		Endpoint: endpoint,
+	})
+}
+
+// @Summary Update Webhook Endpoint
+// @Description Changes the URL, description, event types or enabled state of a webhook endpoint of the authenticated
+// @Description user, and replaces its secret if rotateSecret is set. Pending deliveries use the new URL and secret.
+// @Description The secret is only returned if it was rotated.
+// @Tags Webhooks
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Webhook endpoint ID"
+// @Param request body models.UpdateWebhookEndpointRequest true "Fields to change"
+// @Success 200 {object} response.Response{data=models.WebhookEndpointSecretResponse} "Webhook endpoint updated successfully"
+// @Failure 400 {object} response.Response "Invalid URL or event type"
+// @Failure 404 {object} response.Response "Webhook endpoint not found"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /user/webhooks/{id} [patch]
+func (controller WebhookController) UpdateEndpoint(ctx *gin.Context) {
+	uid := ctx.GetString(api.UidParam)
+
+	var req models.UpdateWebhookEndpointRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response.WithError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	endpoint, err := controller.webhookService.UpdateEndpoint(uid, ctx.Param(api.IdParam), req)
+	if err != nil {
+		log.Println(err.Error())
+		handleWebhookError(ctx, err)
+		return
+	}
+
+	if req.RotateSecret {
+		response.WithSuccess(ctx, http.StatusOK, message.WebhookEndpointUpdated, models.WebhookEndpointSecretResponse{
+			Endpoint: withSecret(endpoint),
+		})
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusOK, message.WebhookEndpointUpdated, models.WebhookEndpointResponse{
+		Endpoint: endpoint,
+	})
+}
+
+// @Summary Delete Webhook Endpoint
+// @Description Deletes a webhook endpoint of the authenticated user. Its pending deliveries fail.
+// @Tags Webhooks
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Webhook endpoint ID"
+// @Success 200 {object} response.Response "Webhook endpoint deleted successfully"
+// @Failure 404 {object} response.Response "Webhook endpoint not found"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /user/webhooks/{id} [delete]
+func (controller WebhookController) DeleteEndpoint(ctx *gin.Context) {
+	uid := ctx.GetString(api.UidParam)
+
+	if err := controller.webhookService.DeleteEndpoint(uid, ctx.Param(api.IdParam)); err != nil {
+		log.Println(err.Error())
+		handleWebhookError(ctx, err)
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusOK, message.WebhookEndpointDeleted, nil)
+}
+
+// @Summary Get Webhook Deliveries
+// @Description Lists the most recent deliveries of a webhook endpoint of the authenticated user, newest first,
+// @Description with their payloads and attempts. Deliveries are kept for 30 days.
+// @Tags Webhooks
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Webhook endpoint ID"
+// @Param limit query int false "Maximum number of deliveries, 50 by default and at most 500"
+// @Success 200 {object} response.Response{data=models.WebhookDeliveriesResponse} "Webhook deliveries retrieved successfully"
+// @Failure 404 {object} response.Response "Webhook endpoint not found"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /user/webhooks/{id}/deliveries [get]
+func (controller WebhookController) GetDeliveries(ctx *gin.Context) {
+	uid := ctx.GetString(api.UidParam)
+
+	limit, _ := strconv.ParseInt(ctx.Query(api.LimitParam), 10, 64)
+
+	deliveries, err := controller.webhookService.ListDeliveries(uid, ctx.Param(api.IdParam), limit)
+	if err != nil {
+		log.Println(err.Error())
+		handleWebhookError(ctx, err)
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusOK, message.WebhookDeliveriesRetrieved, models.WebhookDeliveriesResponse{
+		Deliveries: deliveries,
+	})
+}
+
+// @Summary Get Webhook Delivery
+// @Description Retrieves a delivery of a webhook endpoint of the authenticated user
+// @Tags Webhooks
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Webhook endpoint ID"
+// @Param deliveryId path string true "Webhook delivery ID"
+// @Success 200 {object} response.Response{data=models.WebhookDeliveryResponse} "Webhook delivery retrieved successfully"
+// @Failure 404 {object} response.Response "Webhook delivery not found"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /user/webhooks/{id}/deliveries/{deliveryId} [get]
+func (controller WebhookController) GetDelivery(ctx *gin.Context) {
+	uid := ctx.GetString(api.UidParam)
+
+	delivery, err := controller.webhookService.GetDelivery(uid, ctx.Param(api.IdParam), ctx.Param(api.DeliveryIdParam))
+	if err != nil {
+		log.Println(err.Error())
+		handleWebhookError(ctx, err)
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusOK, message.WebhookDeliveryRetrieved, models.WebhookDeliveryResponse{
+		Delivery: delivery,
+	})
+}
+
+// @Summary Redeliver Webhook
+// @Description Queues the event of a delivery again for its endpoint, as a new delivery with the same event ID
+// @Tags Webhooks
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Webhook endpoint ID"
+// @Param deliveryId path string true "Webhook delivery ID"
+// @Success 202 {object} response.Response{data=models.WebhookDeliveryResponse} "Webhook queued for redelivery"
+// @Failure 404 {object} response.Response "Webhook endpoint or delivery not found"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /user/webhooks/{id}/deliveries/{deliveryId}/redeliver [post]
+func (controller WebhookController) Redeliver(ctx *gin.Context) {
+	uid := ctx.GetString(api.UidParam)
+
+	delivery, err := controller.webhookService.Redeliver(uid, ctx.Param(api.IdParam), ctx.Param(api.DeliveryIdParam))
+	if err != nil {
+		log.Println(err.Error())
+		handleWebhookError(ctx, err)
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusAccepted, message.WebhookRedelivered, models.WebhookDeliveryResponse{
+		Delivery: delivery,
+	})
+}
+
+// handleWebhookError maps webhook service errors to HTTP responses
+func handleWebhookError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidWebhookURL),
+		errors.Is(err, services.ErrInvalidEventType),
+		errors.Is(err, services.ErrTooManyWebhookEndpoints):
+		response.WithError(ctx, http.StatusBadRequest, err.Error())
+	case errors.Is(err, services.ErrWebhookEndpointNotFound),
+		errors.Is(err, services.ErrWebhookDeliveryNotFound):
+		response.WithError(ctx, http.StatusNotFound, err.Error())
+	default:
+		response.WithError(ctx, http.StatusInternalServerError, message.WebhookError)
+	}
+}
+
+// withSecret returns the endpoint with its secret, for the responses that hand out a new secret
+func withSecret(endpoint *models.WebhookEndpoint) *models.WebhookEndpointWithSecret {
+	return &models.WebhookEndpointWithSecret{
+		WebhookEndpoint: *endpoint,
+		Secret:          endpoint.Secret,
+	}
+}
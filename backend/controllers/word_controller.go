@@ -1,14 +1,21 @@
 package controllers
 
 import (
+	"encoding/csv"
+	"fmt"
 	"log"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/AkifhanIlgaz/dictionary-api/middlewares"
 	"github.com/AkifhanIlgaz/dictionary-api/models"
 	"github.com/AkifhanIlgaz/dictionary-api/services"
 	"github.com/AkifhanIlgaz/dictionary-api/utils/api"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/apikey"
 	"github.com/AkifhanIlgaz/dictionary-api/utils/message"
+	plan "github.com/AkifhanIlgaz/dictionary-api/utils/plans"
 	"github.com/AkifhanIlgaz/dictionary-api/utils/response"
 	"github.com/gin-gonic/gin"
 )
@@ -34,23 +41,40 @@ func NewWordController(wordService services.WordService, wordMiddleware middlewa
 
 func (controller WordController) SetupRoutes(rg *gin.RouterGroup) {
 	router := rg.Group(WordPath)
-	router.Use(controller.wordMiddleware.TrackUsage())
+	router.Use(controller.wordMiddleware.TrackUsage(apikey.WordsReadScope))
 
 	router.GET("/search/:word", controller.GetWord)
+	router.POST("/batch", controller.wordMiddleware.RequireFeature(plan.BatchFeature), controller.GetWords)
+
+	// Bulk exports need their own scope, so that keys for lookups cannot copy the whole dataset
+	export := rg.Group(WordPath)
+	export.Use(controller.wordMiddleware.TrackUsage(apikey.ExportScope))
+
+	export.GET("/export", controller.wordMiddleware.RequireFeature(plan.ExportFeature), controller.ExportWords)
 }
 
 // GetWord godoc
 // @Summary Get word information
-// @Description Retrieves detailed information about a word including definitions, examples, and usage
+// @Description Retrieves detailed information about a word including definitions, examples, and usage.
+// @Description Examples, idioms and audio are only included if the plan of the user has the examples, idioms and audio features.
 // @Tags word
 // @Accept json
 // @Produce json
+// @Security ApiKeyAuth
 // @Param word path string true "Word to look up"
 // @Param part_of_speech query string false "Filter by part of speech (noun, verb, adjective, etc.)"
 // @Success 200 {object} response.Response{data=models.WordInfo} "Word found successfully"
-// @Failure 400 {object} response.Response "Invalid part of speech"
+// @Failure 400 {object} response.Response "Invalid part of speech or missing API key"
+// @Failure 401 {object} response.Response "Invalid or expired API key"
+// @Failure 403 {object} response.Response "Missing scope, or IP or origin not allowed"
+// @Failure 429 {object} response.Response "Burst or daily limit exceeded"
 // @Failure 500 {object} response.Response "Internal server error"
-// @Router /word/{word} [get]
+// @Header 200,429 {integer} RateLimit-Limit "Request limit of the window closest to being exhausted"
+// @Header 200,429 {integer} RateLimit-Remaining "Requests remaining in that window"
+// @Header 200,429 {integer} RateLimit-Reset "Seconds until that window resets"
+// @Header 200,429 {string} RateLimit-Policy "All limits of the plan, e.g. 5;w=15, 100;w=86400"
+// @Header 429 {integer} Retry-After "Seconds until the next request can be allowed"
+// @Router /word/search/{word} [get]
 func (controller WordController) GetWord(ctx *gin.Context) {
 	word := ctx.Param(api.WordParam)
 
@@ -68,5 +92,216 @@ func (controller WordController) GetWord(ctx *gin.Context) {
 		return
 	}
 
+	shapeWord(&wordInfo, middlewares.PlanFromContext(ctx))
+
 	response.WithSuccess(ctx, http.StatusOK, message.WordFound, wordInfo)
 }
+
+// @Summary Get words
+// @Description Retrieves several words at once, shaped by the plan like single lookups. Requires the batch feature.
+// @Description A batch counts as one request towards the limits.
+// @Tags word
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body models.BatchWordsRequest true "Words to look up, at most 50, and an optional part of speech"
+// @Success 200 {object} response.Response{data=models.BatchWordsResponse} "Words found successfully"
+// @Failure 400 {object} response.Response "No or too many words, invalid part of speech or missing API key"
+// @Failure 401 {object} response.Response "Invalid or expired API key"
+// @Failure 403 {object} response.Response "Missing scope or feature, or IP or origin not allowed"
+// @Failure 429 {object} response.Response "Burst or daily limit exceeded"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /word/batch [post]
+func (controller WordController) GetWords(ctx *gin.Context) {
+	var req models.BatchWordsRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response.WithError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	words := make([]string, 0, len(req.Words))
+	for _, word := range req.Words {
+		word = strings.TrimSpace(word)
+		if word != "" && !slices.Contains(words, word) {
+			words = append(words, word)
+		}
+	}
+
+	if len(words) == 0 {
+		response.WithError(ctx, http.StatusBadRequest, message.NoWordsToFetch)
+		return
+	}
+	if len(words) > models.MaxBatchWords {
+		response.WithError(ctx, http.StatusBadRequest, fmt.Sprintf(message.TooManyWords, models.MaxBatchWords))
+		return
+	}
+
+	if req.PartOfSpeech != "" {
+		if err := models.IsValidPartOfSpeech(req.PartOfSpeech); err != nil {
+			response.WithError(ctx, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	wordInfos, err := controller.wordService.GetByNames(words, req.PartOfSpeech)
+	if err != nil {
+		log.Println(err.Error())
+		response.WithError(ctx, http.StatusInternalServerError, message.WordError)
+		return
+	}
+
+	userPlan := middlewares.PlanFromContext(ctx)
+	found := map[string]bool{}
+	for i := range wordInfos {
+		shapeWord(&wordInfos[i], userPlan)
+		found[wordInfos[i].Word] = true
+	}
+
+	notFound := []string{}
+	for _, word := range words {
+		if !found[word] {
+			notFound = append(notFound, word)
+		}
+	}
+
+	response.WithSuccess(ctx, http.StatusOK, message.WordsFound, models.BatchWordsResponse{
+		Words:    wordInfos,
+		NotFound: notFound,
+	})
+}
+
+// @Summary Export words
+// @Description Exports the dictionary, or the words of a CEFR level, ordered by index and shaped by the plan like single lookups.
+// @Description Requires an API key with the export scope and the export feature. An export counts as one request towards the limits.
+// @Tags word
+// @Accept json
+// @Produce json,text/csv
+// @Security ApiKeyAuth
+// @Param cefr query string false "CEFR level to export" Enums(A1, A2, B1, B2, C1)
+// @Param format query string false "Response format" Enums(json, csv) default(json)
+// @Success 200 {object} response.Response{data=models.ExportWordsResponse} "Words exported successfully"
+// @Failure 400 {object} response.Response "Invalid CEFR level or format, or missing API key"
+// @Failure 401 {object} response.Response "Invalid or expired API key"
+// @Failure 403 {object} response.Response "Missing scope or feature, or IP or origin not allowed"
+// @Failure 429 {object} response.Response "Burst or daily limit exceeded"
+// @Failure 500 {object} response.Response "Internal server error"
+// @Router /word/export [get]
+func (controller WordController) ExportWords(ctx *gin.Context) {
+	var req models.ExportWordsRequest
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		response.WithError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if req.Format != "" && req.Format != api.JSONFormat && req.Format != api.CSVFormat {
+		response.WithError(ctx, http.StatusBadRequest, message.InvalidFormat)
+		return
+	}
+	if req.CEFRLevel != "" && !slices.Contains(models.CEFRLevels, req.CEFRLevel) {
+		response.WithError(ctx, http.StatusBadRequest, message.InvalidCEFR)
+		return
+	}
+
+	userPlan := middlewares.PlanFromContext(ctx)
+
+	if req.Format == api.CSVFormat {
+		controller.writeWordsCSV(ctx, req.CEFRLevel, userPlan)
+		return
+	}
+
+	words := []models.WordInfo{}
+	err := controller.wordService.ForEach(req.CEFRLevel, func(word models.WordInfo) error {
+		shapeWord(&word, userPlan)
+		words = append(words, word)
+		return nil
+	})
+	if err != nil {
+		log.Println(err.Error())
+		response.WithError(ctx, http.StatusInternalServerError, message.WordError)
+		return
+	}
+
+	response.WithSuccess(ctx, http.StatusOK, message.WordsExported, models.ExportWordsResponse{Words: words})
+}
+
+// writeWordsCSV streams the words as a CSV attachment, one row per definition.
+// Idioms are left out, and examples are joined with " | ".
+// An error after the first row can only be logged, since the status is already sent.
+func (controller WordController) writeWordsCSV(ctx *gin.Context, cefrLevel string, userPlan plan.Plan) {
+	filename := "words.csv"
+	if cefrLevel != "" {
+		filename = fmt.Sprintf("words-%s.csv", cefrLevel)
+	}
+
+	writer := csv.NewWriter(ctx.Writer)
+	started := false
+	// start sends the headers and the header row once the first word is read, so that failing to query the words is still a 500
+	start := func() error {
+		started = true
+		ctx.Header(api.ContentDispositionHeader, fmt.Sprintf("attachment; filename=%q", filename))
+		ctx.Header(api.ContentTypeHeader, api.MIMECSV)
+		ctx.Status(http.StatusOK)
+		return writer.Write([]string{"index", "word", "part_of_speech", "cefr_level", "audio_uk", "audio_us", "meaning", "examples"})
+	}
+
+	err := controller.wordService.ForEach(cefrLevel, func(word models.WordInfo) error {
+		if !started {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+
+		shapeWord(&word, userPlan)
+		for _, definition := range word.Definitions {
+			err := writer.Write([]string{
+				strconv.Itoa(word.Index),
+				word.Word,
+				word.PartOfSpeech,
+				word.CEFRLevel,
+				word.Audio.UK,
+				word.Audio.US,
+				definition.Meaning,
+				strings.Join(definition.Examples, " | "),
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		writer.Flush()
+		return writer.Error()
+	})
+	if err == nil && !started {
+		err = start()
+		writer.Flush()
+	}
+	if err != nil {
+		log.Println(err.Error())
+		if !started {
+			response.WithError(ctx, http.StatusInternalServerError, message.WordError)
+		}
+	}
+}
+
+// shapeWord leaves out the parts of the word the plan is not entitled to
+func shapeWord(word *models.WordInfo, userPlan plan.Plan) {
+	if !userPlan.HasFeature(plan.AudioFeature) {
+		word.Audio.UK = ""
+		word.Audio.US = ""
+	}
+
+	if !userPlan.HasFeature(plan.IdiomsFeature) {
+		word.Idioms = nil
+	}
+
+	if !userPlan.HasFeature(plan.ExamplesFeature) {
+		for i := range word.Definitions {
+			word.Definitions[i].Examples = nil
+		}
+		for i := range word.Idioms {
+			for j := range word.Idioms[i].Definitions {
+				word.Idioms[i].Definitions[j].Examples = nil
+			}
+		}
+	}
+}
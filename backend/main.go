@@ -3,13 +3,21 @@ package main
 import (
 	"context"
 	"log"
+	"strings"
+	// Billing timezones must load on hosts without a time zone database
+	_ "time/tzdata"
 
 	"github.com/AkifhanIlgaz/dictionary-api/config"
 	"github.com/AkifhanIlgaz/dictionary-api/controllers"
 	_ "github.com/AkifhanIlgaz/dictionary-api/docs"
 	"github.com/AkifhanIlgaz/dictionary-api/middlewares"
 	"github.com/AkifhanIlgaz/dictionary-api/services"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/api"
 	"github.com/AkifhanIlgaz/dictionary-api/utils/db"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/mail"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/metering"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/payment"
+	plan "github.com/AkifhanIlgaz/dictionary-api/utils/plans"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	swaggerFiles "github.com/swaggo/files"
@@ -35,6 +43,11 @@ import (
 // @in header
 // @name Authorization
 // @description Type "Bearer" followed by a space and JWT token.
+
+// @securityDefinitions.apikey ApiKeyAuth
+// @in header
+// @name X-API-Key
+// @description API key, also accepted as "Authorization: ApiKey <key>".
 func main() {
 	config, err := config.Load()
 	if err != nil {
@@ -63,40 +76,134 @@ func main() {
 		}
 	}()
 
+	plans, err := plan.LoadRegistry(config.PlansFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	wordService := services.NewWordService(ctx, mongoDatabase)
-	tokenService := services.NewTokenService(config, redisClient)
+	signingKeyService, err := services.NewSigningKeyService(ctx, mongoDatabase, config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	signingKeyService.StartRotation(ctx)
+
+	tokenService, err := services.NewTokenService(config, redisClient, signingKeyService.Keyring())
+	if err != nil {
+		log.Fatal(err)
+	}
+	mfaService := services.NewMFAService(ctx, mongoDatabase)
+	loginAttemptService := services.NewLoginAttemptService(redisClient, services.LogLockoutNotifier{})
+
+	userService, err := services.NewUserService(ctx, mongoDatabase, config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	authService, err := services.NewAuthService(ctx, mongoDatabase, plans)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	userService, err := services.NewUserService(ctx, mongoDatabase)
+	auditService, err := services.NewAuditService(ctx, mongoDatabase, config)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	authService, err := services.NewAuthService(ctx, mongoDatabase)
+	webhookService, err := services.NewWebhookService(ctx, mongoDatabase, config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	webhookService.StartDelivery(ctx)
+
+	mailer := mail.New(config.SMTPHost, config.SMTPPort, config.SMTPUsername, config.SMTPPassword, config.MailFrom)
+	alertService := services.NewAlertService(authService, webhookService, mailer)
+
+	organizationService, err := services.NewOrganizationService(ctx, mongoDatabase, plans, mailer, config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	rateLimitService := services.NewRateLimitService(redisClient, alertService)
+	usageService, err := services.NewUsageService(ctx, mongoDatabase, redisClient)
+	if err != nil {
+		log.Fatal(err)
+	}
+	usageService.StartRollup(ctx)
+
+	oauthService, err := services.NewOAuthService(ctx, mongoDatabase, redisClient, authService, config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	paymentProvider, err := payment.New(config.PaymentProvider, config.PaymentWebhookSecret, "/api"+controllers.BillingPath+controllers.FakeCheckoutPath, config.IsDev())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	invoiceService, err := services.NewInvoiceService(ctx, mongoDatabase, authService, usageService, plans, config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	subscriptionService, err := services.NewSubscriptionService(ctx, mongoDatabase, authService, invoiceService, webhookService, auditService, plans, paymentProvider, config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	chargedStatuses, err := metering.ParseStatusSet(config.ChargedStatuses)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	userMiddleware := middlewares.NewUserMiddleware(tokenService)
-	wordMiddleware := middlewares.NewWordMiddleware(wordService, userService, authService)
+	wordMiddleware := middlewares.NewWordMiddleware(wordService, userService, authService, rateLimitService, usageService, organizationService, plans, chargedStatuses, config.APIKeyQueryParam)
 
 	wordController := controllers.NewWordController(wordService, wordMiddleware)
-	authController := controllers.NewAuthController(authService, tokenService)
-	userController := controllers.NewUserController(userService, userMiddleware)
+	authController := controllers.NewAuthController(authService, tokenService, mfaService, loginAttemptService, auditService)
+	userController := controllers.NewUserController(userService, usageService, authService, rateLimitService, webhookService, auditService, userMiddleware)
+	mfaController := controllers.NewMFAController(mfaService, userMiddleware)
+	oauthController := controllers.NewOAuthController(oauthService, authService, tokenService, mfaService, auditService, userMiddleware, !config.IsDev())
+	jwksController := controllers.NewJWKSController(signingKeyService)
+	adminController := controllers.NewAdminController(authService, userService, usageService, rateLimitService, subscriptionService, organizationService, webhookService, tokenService, auditService, userMiddleware)
+	billingController := controllers.NewBillingController(subscriptionService, invoiceService, authService, plans, userMiddleware)
+	invoiceController := controllers.NewInvoiceController(invoiceService, userMiddleware)
+	webhookController := controllers.NewWebhookController(webhookService, userMiddleware)
+	organizationController := controllers.NewOrganizationController(organizationService, userService, usageService, authService, rateLimitService, webhookService, auditService, userMiddleware)
 
 	server := gin.Default()
 
+	// Client IPs are used for login throttling and API key allowlists, so only configured proxies may set them
+	var trustedProxies []string
+	if config.TrustedProxies != "" {
+		trustedProxies = strings.Split(config.TrustedProxies, ",")
+	}
+	if err := server.SetTrustedProxies(trustedProxies); err != nil {
+		log.Fatal(err)
+	}
+
 	server.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:3000"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", api.ApiKeyHeader},
+		ExposeHeaders:    []string{api.RateLimitLimitHeader, api.RateLimitRemainingHeader, api.RateLimitResetHeader, api.RateLimitPolicyHeader, api.RetryAfterHeader},
 		AllowCredentials: true,
 	}))
 
+	jwksController.SetupRoutes(&server.RouterGroup)
+
 	router := server.Group("/api")
 
 	wordController.SetupRoutes(router)
 	authController.SetupRoutes(router)
 	userController.SetupRoutes(router)
+	mfaController.SetupRoutes(router)
+	oauthController.SetupRoutes(router)
+	adminController.SetupRoutes(router)
+	billingController.SetupRoutes(router)
+	invoiceController.SetupRoutes(router)
+	webhookController.SetupRoutes(router)
+	organizationController.SetupRoutes(router)
 
 	router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
@@ -2,12 +2,14 @@ package middlewares
 
 import (
 	"fmt"
+	"log"
 	"net/http"
 	"strings"
 
 	"github.com/AkifhanIlgaz/dictionary-api/services"
 	"github.com/AkifhanIlgaz/dictionary-api/utils/api"
 	"github.com/AkifhanIlgaz/dictionary-api/utils/message"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/rbac"
 	"github.com/AkifhanIlgaz/dictionary-api/utils/response"
 	"github.com/gin-gonic/gin"
 )
@@ -49,7 +51,7 @@ func parseIdTokenFromHeader(header http.Header) (string, error) {
 	if len(fields) > 2 {
 		return "", fmt.Errorf("authorization header is invalid")
 	}
-	if fields[0] != "Bearer" {
+	if fields[0] != api.BearerScheme {
 		return "", fmt.Errorf("invalid authorization header scheme")
 	}
 
@@ -58,13 +60,16 @@ func parseIdTokenFromHeader(header http.Header) (string, error) {
 
 // AuthenticateUser returns a Gin middleware handler that authenticates users
 // by validating their access token. If authentication is successful, the user's
-// UID is added to the Gin context.
+// UID is added to the Gin context. Tokens revoked since they were issued, e.g. of suspended users,
+// are rejected, and impersonation tokens can only be used for GET and HEAD requests.
 //
 // Returns:
 //   - gin.HandlerFunc: Middleware handler for authentication
 //
 // Context Sets:
 //   - api.ContextUid: User ID extracted from the token
+//   - api.ContextRole: User role extracted from the token
+//   - api.ContextImpersonator: Impersonator of an impersonation token
 func (m UserMiddleware) AuthenticateUser() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		// Get token from header
@@ -75,13 +80,55 @@ func (m UserMiddleware) AuthenticateUser() gin.HandlerFunc {
 		}
 
 		// Parse and validate token
-		uid, err := m.tokenService.ParseToken("access", token)
+		claims, err := m.tokenService.ParseAccessToken(token)
 		if err != nil {
 			response.WithError(ctx, http.StatusUnauthorized, message.InvalidToken)
 			return
 		}
 
-		ctx.Set(api.ContextUid, uid)
+		revoked, err := m.tokenService.IsRevoked(claims)
+		if err != nil {
+			log.Println(err.Error())
+			response.WithError(ctx, http.StatusInternalServerError, message.UserError)
+			return
+		}
+		if revoked {
+			response.WithError(ctx, http.StatusUnauthorized, message.InvalidToken)
+			return
+		}
+
+		if claims.Impersonator != "" {
+			if ctx.Request.Method != http.MethodGet && ctx.Request.Method != http.MethodHead {
+				response.WithError(ctx, http.StatusForbidden, message.ImpersonationReadOnly)
+				return
+			}
+			ctx.Set(api.ContextImpersonator, claims.Impersonator)
+		}
+
+		ctx.Set(api.ContextUid, claims.Subject)
+		ctx.Set(api.ContextRole, string(rbac.Parse(claims.Role)))
+		ctx.Next()
+	}
+}
+
+// Authorize returns a Gin middleware handler that only lets requests through
+// if the role set by AuthenticateUser grants the given permission. Impersonation tokens are never authorized.
+// It must be registered after AuthenticateUser.
+//
+// Parameters:
+//   - permission: Permission required for the route
+//
+// Returns:
+//   - gin.HandlerFunc: Middleware handler for authorization
+func (m UserMiddleware) Authorize(permission rbac.Permission) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		role := rbac.Role(ctx.GetString(api.ContextRole))
+
+		if !rbac.HasPermission(role, permission) || ctx.GetString(api.ContextImpersonator) != "" {
+			response.WithError(ctx, http.StatusForbidden, message.Forbidden)
+			return
+		}
+
 		ctx.Next()
 	}
 }
@@ -1,34 +1,68 @@
 package middlewares
 
 import (
+	"fmt"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/AkifhanIlgaz/dictionary-api/models"
 	"github.com/AkifhanIlgaz/dictionary-api/services"
 	"github.com/AkifhanIlgaz/dictionary-api/utils/api"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/apikey"
 	"github.com/AkifhanIlgaz/dictionary-api/utils/message"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/metering"
+	plan "github.com/AkifhanIlgaz/dictionary-api/utils/plans"
 	"github.com/AkifhanIlgaz/dictionary-api/utils/response"
 	"github.com/gin-gonic/gin"
 )
 
 type WordMiddleware struct {
-	wordService services.WordService
-	userService services.UserService
-	authService services.AuthService
+	wordService      services.WordService
+	userService      services.UserService
+	authService      services.AuthService
+	rateLimitService services.RateLimitService
+	usageService     services.UsageService
+	// organizationService provides the shared quota of the keys of organizations
+	organizationService services.OrganizationService
+	plans               *plan.Registry
+	// chargedStatuses are the response statuses that count towards the quota
+	chargedStatuses metering.StatusSet
+	// allowQueryKey accepts the deprecated ?apikey= query parameter
+	allowQueryKey bool
 }
 
-func NewWordMiddleware(wordService services.WordService, userService services.UserService, authService services.AuthService) WordMiddleware {
+func NewWordMiddleware(wordService services.WordService, userService services.UserService, authService services.AuthService, rateLimitService services.RateLimitService, usageService services.UsageService, organizationService services.OrganizationService, plans *plan.Registry, chargedStatuses metering.StatusSet, allowQueryKey bool) WordMiddleware {
 	return WordMiddleware{
-		wordService: wordService,
-		userService: userService,
-		authService: authService,
+		wordService:         wordService,
+		userService:         userService,
+		authService:         authService,
+		rateLimitService:    rateLimitService,
+		usageService:        usageService,
+		organizationService: organizationService,
+		plans:               plans,
+		chargedStatuses:     chargedStatuses,
+		allowQueryKey:       allowQueryKey,
 	}
 }
 
-func (m *WordMiddleware) TrackUsage() gin.HandlerFunc {
+// TrackUsage returns a Gin middleware handler that authenticates the API key,
+// requires it to grant the given scope, enforces its IP and referrer allowlists and meters the request
+// against the burst, per-minute and daily or monthly limits of the plan and the overage policy of the user.
+// Keys of organizations are metered against the plan of the organization instead, shared by all of its keys. Requests rejected by an allowlist are recorded in the security log of the key.
+// A request is reserved against the limits before the handler runs, and only charged if the handler responds
+// with one of the charged statuses. Otherwise the reservation is refunded.
+// Every request that reaches the handler is recorded in the usage history with its endpoint and status,
+// and charged requests over the quota in the overage ledger.
+// The key is read from the X-API-Key or "Authorization: ApiKey <key>" header, and from the
+// deprecated apikey query parameter if allowed. The owner of the key, or the member who created a key of an
+// organization, is set as the uid in the context, and the plan the request is metered against as the plan.
+func (m *WordMiddleware) TrackUsage(scope apikey.Scope) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		uid, _ := ctx.Get("uid")
-
-		key := ctx.Query(api.ApiKeyParam)
+		key := m.parseApiKey(ctx)
 		if key == "" {
 			response.WithError(ctx, http.StatusBadRequest, message.ApiKeyRequired)
 			return
@@ -40,24 +74,223 @@ func (m *WordMiddleware) TrackUsage() gin.HandlerFunc {
 			return
 		}
 
-		dailyUsage, err := m.userService.IncrementUsage(uid.(string), apiKeyDoc.Key)
+		if apiKeyDoc.IsExpired(time.Now()) {
+			response.WithError(ctx, http.StatusUnauthorized, message.ApiKeyExpired)
+			return
+		}
+
+		if apiKeyDoc.Suspended {
+			response.WithError(ctx, http.StatusForbidden, message.AccountSuspended)
+			return
+		}
+
+		if !apikey.HasScope(apiKeyDoc.Scopes, scope) {
+			response.WithError(ctx, http.StatusForbidden, message.MissingScope)
+			return
+		}
+
+		if !apikey.IsIPAllowed(apiKeyDoc.AllowedCIDRs, ctx.ClientIP()) {
+			m.recordSecurityEvent(ctx, apiKeyDoc, models.IPNotAllowedReason)
+			response.WithError(ctx, http.StatusForbidden, message.IPNotAllowed)
+			return
+		}
+
+		if !apikey.IsReferrerAllowed(apiKeyDoc.AllowedReferrers, ctx.GetHeader(api.OriginHeader), ctx.GetHeader(api.RefererHeader)) {
+			m.recordSecurityEvent(ctx, apiKeyDoc, models.ReferrerNotAllowedReason)
+			response.WithError(ctx, http.StatusForbidden, message.OriginNotAllowed)
+			return
+		}
+
+		ctx.Set(api.ContextUid, apiKeyDoc.Uid)
+
+		now := time.Now()
+
+		subject, userQuota, err := m.quota(apiKeyDoc, now)
 		if err != nil {
+			log.Println(err.Error())
 			response.WithError(ctx, http.StatusInternalServerError, message.ApiKeyError)
 			return
 		}
 
-		plan, err := m.authService.GetUserPlan(apiKeyDoc.Uid)
+		limit, err := m.rateLimitService.Reserve(subject, userQuota, now)
 		if err != nil {
+			log.Println(err.Error())
 			response.WithError(ctx, http.StatusInternalServerError, message.ApiKeyError)
 			return
 		}
 
-		usageLimit := api.PlanUsageLimits[plan]
-		if dailyUsage > usageLimit {
-			response.WithError(ctx, http.StatusPaymentRequired, message.UsageLimitReached)
+		ctx.Set(api.ContextPlan, userQuota.Plan)
+
+		setRateLimitHeaders(ctx, limit)
+
+		switch limit.Exceeded {
+		case models.QuotaWindow:
+			ctx.Header(api.RetryAfterHeader, seconds(limit.RetryAfter))
+			response.WithError(ctx, http.StatusTooManyRequests, message.UsageLimitReached)
+			return
+		case models.BurstWindow:
+			ctx.Header(api.RetryAfterHeader, seconds(limit.RetryAfter))
+			response.WithError(ctx, http.StatusTooManyRequests, message.RateLimitExceeded)
 			return
 		}
 
+		// The reservation is refunded if the handler panics, before the recovery middleware writes a 500
+		defer func() {
+			if r := recover(); r != nil {
+				m.refund(subject, userQuota, now)
+				panic(r)
+			}
+		}()
+
 		ctx.Next()
+
+		status := ctx.Writer.Status()
+		charged := m.chargedStatuses.Contains(status)
+		if !charged {
+			m.refund(subject, userQuota, now)
+		}
+
+		// The request is already counted by the rate limiter, so failing to record it for the rollup only loses statistics
+		err = m.usageService.Record(models.UsageRecord{
+			Uid:      apiKeyDoc.Uid,
+			OrgId:    apiKeyDoc.OrgId,
+			KeyId:    apiKeyDoc.Id.Hex(),
+			Endpoint: ctx.FullPath(),
+			Status:   status,
+			Charged:  charged,
+			Plan:     userQuota.Plan.Type,
+			Overage:  limit.Overage,
+			Time:     now,
+		})
+		if err != nil {
+			log.Println(err.Error())
+		}
+	}
+}
+
+// RequireFeature returns a Gin middleware handler that rejects requests of users whose plan is not
+// entitled to the feature, naming the lowest plan that is. It must run after TrackUsage,
+// and the rejected request is refunded unless 403 is a charged status.
+func (m *WordMiddleware) RequireFeature(feature plan.Feature) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if PlanFromContext(ctx).HasFeature(feature) {
+			ctx.Next()
+			return
+		}
+
+		lowest, ok := m.plans.LowestWith(feature)
+		if !ok {
+			response.WithError(ctx, http.StatusForbidden, fmt.Sprintf(message.FeatureUnavailable, feature))
+			return
+		}
+
+		response.WithError(ctx, http.StatusForbidden, fmt.Sprintf(message.FeatureRequiresPlan, feature, lowest.Name))
+	}
+}
+
+// PlanFromContext returns the plan TrackUsage set in the context, or an empty plan without features
+func PlanFromContext(ctx *gin.Context) plan.Plan {
+	p, _ := ctx.Value(api.ContextPlan).(plan.Plan)
+	return p
+}
+
+// quota returns what the requests of the key are metered as and the quota they are metered against:
+// the owner of a personal key, or the organization that owns the key
+func (m *WordMiddleware) quota(apiKeyDoc *models.APIKey, now time.Time) (string, models.UserQuota, error) {
+	if apiKeyDoc.OrgId != "" {
+		userQuota, err := m.organizationService.GetQuota(apiKeyDoc.OrgId)
+		return models.OrgQuotaSubject(apiKeyDoc.OrgId), userQuota, err
+	}
+
+	userQuota, err := m.authService.GetUserQuota(apiKeyDoc.Uid, now)
+	return apiKeyDoc.Uid, userQuota, err
+}
+
+// refund gives back a reserved request that is not charged.
+// Failing to refund only charges the request.
+func (m *WordMiddleware) refund(uid string, userQuota models.UserQuota, reservedAt time.Time) {
+	if err := m.rateLimitService.Refund(uid, userQuota, reservedAt); err != nil {
+		log.Println(err.Error())
+	}
+}
+
+// setRateLimitHeaders sets the RateLimit headers for the limit closest to being exhausted,
+// or the limit that rejected the request. RateLimit-Policy lists every limit of the plan.
+func setRateLimitHeaders(ctx *gin.Context, limit models.RateLimitResult) {
+	type window struct {
+		name      models.RateLimitWindow
+		limit     int
+		remaining int
+		reset     time.Duration
+		period    time.Duration
+	}
+
+	var windows []window
+	if limit.BurstPeriod > 0 {
+		windows = append(windows, window{models.BurstWindow, limit.BurstLimit, limit.BurstRemaining, limit.BurstReset, limit.BurstPeriod})
+	}
+	if limit.QuotaLimit > 0 {
+		windows = append(windows, window{models.QuotaWindow, limit.QuotaLimit, limit.QuotaRemaining, limit.QuotaReset, limit.QuotaPeriod})
+	}
+	if len(windows) == 0 {
+		return
 	}
+
+	current := windows[0]
+	policies := make([]string, 0, len(windows))
+	for _, w := range windows {
+		if w.name == limit.Exceeded || (limit.Exceeded == "" && w.remaining < current.remaining) {
+			current = w
+		}
+		policies = append(policies, fmt.Sprintf("%d;w=%s", w.limit, seconds(w.period)))
+	}
+
+	ctx.Header(api.RateLimitLimitHeader, strconv.Itoa(current.limit))
+	ctx.Header(api.RateLimitRemainingHeader, strconv.Itoa(current.remaining))
+	ctx.Header(api.RateLimitResetHeader, seconds(current.reset))
+	ctx.Header(api.RateLimitPolicyHeader, strings.Join(policies, ", "))
+}
+
+// seconds formats the duration as whole seconds, rounded up
+func seconds(duration time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(duration.Seconds())))
+}
+
+// recordSecurityEvent adds a rejected request to the security log of the key.
+// Failing to record does not change the response.
+func (m *WordMiddleware) recordSecurityEvent(ctx *gin.Context, apiKeyDoc *models.APIKey, reason string) {
+	err := m.userService.RecordSecurityEvent(models.APIKeySecurityEvent{
+		KeyId:     apiKeyDoc.Id,
+		Uid:       apiKeyDoc.Uid,
+		Reason:    reason,
+		IP:        ctx.ClientIP(),
+		Origin:    ctx.GetHeader(api.OriginHeader),
+		Referrer:  ctx.GetHeader(api.RefererHeader),
+		UserAgent: ctx.GetHeader(api.UserAgentHeader),
+		Path:      ctx.Request.URL.Path,
+	})
+	if err != nil {
+		log.Println(err.Error())
+	}
+}
+
+// parseApiKey returns the API key of the request, or an empty string if there is none.
+// Using the query parameter adds deprecation headers to the response.
+func (m *WordMiddleware) parseApiKey(ctx *gin.Context) string {
+	if key := ctx.GetHeader(api.ApiKeyHeader); key != "" {
+		return key
+	}
+
+	fields := strings.Fields(ctx.GetHeader(api.AuthHeader))
+	if len(fields) == 2 && strings.EqualFold(fields[0], api.ApiKeyScheme) {
+		return fields[1]
+	}
+
+	if key := ctx.Query(api.ApiKeyParam); key != "" && m.allowQueryKey {
+		ctx.Header(api.DeprecationHeader, "true")
+		ctx.Header(api.WarningHeader, message.ApiKeyQueryDeprecated)
+		return key
+	}
+
+	return ""
 }
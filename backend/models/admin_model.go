@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+
+	plan "github.com/AkifhanIlgaz/dictionary-api/utils/plans"
+)
+
+type RoleRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+// UserSearchRequest finds users whose email starts with Email, case insensitively
+type UserSearchRequest struct {
+	Email string `form:"email" binding:"required"`
+	Limit int64  `form:"limit"`
+}
+
+type UsersResponse struct {
+	Users []User `json:"users"`
+}
+
+// AdminUserResponse is a user as support staff and admins see it
+type AdminUserResponse struct {
+	User       User `json:"user"`
+	MFAEnabled bool `json:"mfaEnabled"`
+}
+
+type SetUserPlanRequest struct {
+	Plan plan.PlanType `json:"plan" binding:"required"`
+}
+
+type SuspendUserRequest struct {
+	Reason string `json:"reason" binding:"required,max=500"`
+}
+
+// ImpersonationToken is a short-lived, read-only access token of a user issued to support staff or an admin
+type ImpersonationToken struct {
+	AccessToken string    `json:"accessToken"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"time"
+
+	plan "github.com/AkifhanIlgaz/dictionary-api/utils/plans"
+)
+
+const (
+	// MaxAlertThresholds is how many quota alert thresholds a user can set
+	MaxAlertThresholds int = 10
+	// MaxAlertThreshold is the highest threshold in percent of the quota, thresholds over 100 alert about overage
+	MaxAlertThreshold int = 1000
+)
+
+// QuotaAlertSettings are the thresholds a user is alerted at. Alerts are sent to the webhook endpoints
+// of the user subscribed to quota events, and by email if enabled.
+type QuotaAlertSettings struct {
+	// Thresholds are percentages of the quota in ascending order
+	Thresholds []int `json:"thresholds" bson:"thresholds,omitempty"`
+	Email      bool  `json:"email" bson:"email,omitempty"`
+}
+
+type SetQuotaAlertsRequest struct {
+	Thresholds []int `json:"thresholds"`
+	Email      bool  `json:"email"`
+}
+
+// QuotaAlertEvent is the first request of a quota window that reaches a threshold of the quota
+type QuotaAlertEvent struct {
+	Uid         string
+	Plan        plan.PlanType
+	Window      plan.Window
+	WindowStart time.Time
+	WindowEnd   time.Time
+	// Threshold is the percentage of the quota that was reached
+	Threshold int
+	Quota     int
+	Used      int
+}
+
+// QuotaAlertData is the data of quota events
+type QuotaAlertData struct {
+	Plan        plan.PlanType `json:"plan"`
+	Window      plan.Window   `json:"window"`
+	WindowStart time.Time     `json:"windowStart"`
+	WindowEnd   time.Time     `json:"windowEnd"`
+	// Threshold is the percentage of the quota that was reached, 0 for overage alerts
+	Threshold int `json:"threshold,omitempty"`
+	Quota     int `json:"quota"`
+	Used      int `json:"used"`
+	// Overage is the overage mode that allowed the requests over the quota, only set for overage alerts
+	Overage OverageMode `json:"overage,omitempty"`
+}
@@ -1,20 +1,118 @@
 package models
 
-import "time"
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
 
 type APIKey struct {
-	Key        string    `json:"key" bson:"key"`
-	Uid        string    `json:"uid" bson:"uid"`
-	TotalUsage int       `json:"totalUsage" bson:"totalUsage"`
-	CreatedAt  time.Time `json:"createdAt" bson:"createdAt"`
+	Id     primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Name   string             `json:"name" bson:"name"`
+	Key    string             `json:"key,omitempty" bson:"-"`
+	Prefix string             `json:"prefix" bson:"prefix"`
+	Hash   string             `json:"-" bson:"hash"`
+	Uid    string             `json:"uid" bson:"uid"`
+	// OrgId is the organization that owns the key, empty for personal keys. Uid is then the member who created it.
+	OrgId  string   `json:"orgId,omitempty" bson:"orgId,omitempty"`
+	Scopes []string `json:"scopes" bson:"scopes"`
+	// AllowedCIDRs restricts the client IPs that may use the key, empty allows all
+	AllowedCIDRs []string `json:"allowedCidrs,omitempty" bson:"allowedCidrs,omitempty"`
+	// AllowedReferrers restricts the origins or referrers that may use the key, empty allows all
+	AllowedReferrers []string   `json:"allowedReferrers,omitempty" bson:"allowedReferrers,omitempty"`
+	TotalUsage       int        `json:"totalUsage" bson:"totalUsage"`
+	CreatedAt        time.Time  `json:"createdAt" bson:"createdAt"`
+	ExpiresAt        *time.Time `json:"expiresAt,omitempty" bson:"expiresAt,omitempty"`
+	LastUsedAt       *time.Time `json:"lastUsedAt,omitempty" bson:"lastUsedAt,omitempty"`
+	// Previous is the key replaced by the last rotation, which keeps working until its grace period ends
+	Previous *RotatedAPIKey `json:"previous,omitempty" bson:"previous,omitempty"`
+	// Suspended keys belong to a suspended user and are rejected until the user is unsuspended
+	Suspended bool `json:"suspended,omitempty" bson:"suspended,omitempty"`
+}
+
+// RotatedAPIKey is a replaced key of a logical API key that is still accepted until ExpiresAt
+type RotatedAPIKey struct {
+	Prefix    string    `json:"prefix" bson:"prefix"`
+	Hash      string    `json:"-" bson:"hash"`
+	ExpiresAt time.Time `json:"expiresAt" bson:"expiresAt"`
+}
+
+// IsActive reports whether the rotated key is still within its grace period
+func (rotatedKey RotatedAPIKey) IsActive(now time.Time) bool {
+	return now.Before(rotatedKey.ExpiresAt)
+}
+
+// IsExpired reports whether the key has an expiry in the past
+func (apiKey APIKey) IsExpired(now time.Time) bool {
+	return apiKey.ExpiresAt != nil && now.After(*apiKey.ExpiresAt)
 }
 
 type APIKeyResponse struct {
 	APIKey *APIKey `json:"apiKey"`
 }
 
+type APIKeysResponse struct {
+	APIKeys []APIKey `json:"apiKeys"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name             string     `json:"name" binding:"max=64"`
+	Scopes           []string   `json:"scopes"`
+	ExpiresAt        *time.Time `json:"expiresAt"`
+	AllowedCIDRs     []string   `json:"allowedCidrs" binding:"max=50"`
+	AllowedReferrers []string   `json:"allowedReferrers" binding:"max=50"`
+}
+
+// UpdateAPIKeyRequest changes only the fields that are set.
+// An empty allowlist removes the restriction.
+type UpdateAPIKeyRequest struct {
+	Name             *string    `json:"name" binding:"omitempty,max=64"`
+	Scopes           []string   `json:"scopes"`
+	ExpiresAt        *time.Time `json:"expiresAt"`
+	AllowedCIDRs     []string   `json:"allowedCidrs" binding:"max=50"`
+	AllowedReferrers []string   `json:"allowedReferrers" binding:"max=50"`
+}
+
+// Reasons an API key request was rejected, recorded in the security log of the key
+const (
+	IPNotAllowedReason       string = "ip_not_allowed"
+	ReferrerNotAllowedReason string = "referrer_not_allowed"
+)
+
+// APIKeySecurityEvent is a rejected attempt to use an API key
+type APIKeySecurityEvent struct {
+	Id        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	KeyId     primitive.ObjectID `json:"keyId" bson:"keyId"`
+	Uid       string             `json:"-" bson:"uid"`
+	Reason    string             `json:"reason" bson:"reason"`
+	IP        string             `json:"ip" bson:"ip"`
+	Origin    string             `json:"origin,omitempty" bson:"origin,omitempty"`
+	Referrer  string             `json:"referrer,omitempty" bson:"referrer,omitempty"`
+	UserAgent string             `json:"userAgent,omitempty" bson:"userAgent,omitempty"`
+	Path      string             `json:"path" bson:"path"`
+	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
+}
+
+type APIKeySecurityEventsResponse struct {
+	Events []APIKeySecurityEvent `json:"events"`
+}
+
+// RotateAPIKeyRequest overrides the configured grace period of the replaced key.
+// A grace period of 0 revokes the replaced key immediately.
+type RotateAPIKeyRequest struct {
+	GracePeriodHours *int `json:"gracePeriodHours" binding:"omitempty,min=0,max=720"`
+}
+
+// LegacyAPIKey is an API key stored in clear text by earlier versions, read only during migration
+type LegacyAPIKey struct {
+	Id  primitive.ObjectID `bson:"_id"`
+	Key string             `bson:"key"`
+}
+
+// DailyUsageEntry is the number of requests with an API key on a day
 type DailyUsageEntry struct {
-	APIKey string    `json:"apiKey" bson:"apiKey"`
-	Date   time.Time `json:"date" bson:"date"`
-	Usage  int       `json:"usage" bson:"usage"`
+	KeyId string    `json:"keyId" bson:"key"`
+	Uid   string    `json:"uid" bson:"uid"`
+	Date  time.Time `json:"date" bson:"date"`
+	Count int       `json:"count" bson:"count"`
 }
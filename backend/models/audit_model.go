@@ -0,0 +1,108 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AuditAction is the kind of action an audit event records
+type AuditAction string
+
+// Security events of users, performed by the user themselves unless an admin or the system acted on them
+const (
+	UserRegisteredAction AuditAction = "user.registered"
+	LoginAction          AuditAction = "auth.login"
+	LoginFailedAction    AuditAction = "auth.login_failed"
+	APIKeyCreatedAction  AuditAction = "api_key.created"
+	APIKeyUpdatedAction  AuditAction = "api_key.updated"
+	APIKeyRotatedAction  AuditAction = "api_key.rotated"
+	APIKeyRevokedAction  AuditAction = "api_key.revoked"
+	PlanChangedAction    AuditAction = "plan.changed"
+)
+
+// Actions of support staff and admins
+const (
+	UsersSearchedAction        AuditAction = "user.searched"
+	UserViewedAction           AuditAction = "user.viewed"
+	APIKeysViewedAction        AuditAction = "api_keys.viewed"
+	UsageViewedAction          AuditAction = "usage.viewed"
+	UserSuspendedAction        AuditAction = "user.suspended"
+	UserUnsuspendedAction      AuditAction = "user.unsuspended"
+	UserImpersonatedAction     AuditAction = "user.impersonated"
+	RoleChangedAction          AuditAction = "role.changed"
+	OrgPlanChangedAction       AuditAction = "organization.plan_changed"
+	DatasetUpdatePublishAction AuditAction = "dataset.update_published"
+	AuditLogViewedAction       AuditAction = "audit_log.viewed"
+	AuditLogVerifiedAction     AuditAction = "audit_log.verified"
+)
+
+// Reasons of failed logins
+const (
+	InvalidCredentialsReason string = "invalid_credentials"
+	LockedOutReason          string = "locked_out"
+	InvalidMFACodeReason     string = "invalid_mfa_code"
+	SuspendedReason          string = "suspended"
+)
+
+// Reasons the hash chain of the audit log is broken at an event
+const (
+	SeqGapReason       string = "seq_gap"
+	PrevHashReason     string = "prev_hash_mismatch"
+	HashMismatchReason string = "hash_mismatch"
+)
+
+// AuditEvent is an action recorded in the audit log, which is never changed or deleted
+type AuditEvent struct {
+	Id     primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Action AuditAction        `json:"action" bson:"action"`
+	// ActorId is the user who performed the action and ActorRole their role at the time.
+	// Both are empty for actions of the system, like plan changes of subscriptions, and logins of unknown emails.
+	ActorId   string `json:"actorId,omitempty" bson:"actorId,omitempty"`
+	ActorRole string `json:"actorRole,omitempty" bson:"actorRole,omitempty"`
+	// Uid is the user the action was performed on, if any
+	Uid       string `json:"uid,omitempty" bson:"uid,omitempty"`
+	IP        string `json:"ip,omitempty" bson:"ip,omitempty"`
+	UserAgent string `json:"userAgent,omitempty" bson:"userAgent,omitempty"`
+	// Details are the parameters and outcome of the action, e.g. the previous and new plan
+	Details   map[string]string `json:"details,omitempty" bson:"details,omitempty"`
+	CreatedAt time.Time         `json:"createdAt" bson:"createdAt"`
+	// Seq, PrevHash and Hash chain the events recorded while hash chaining is on. Hash is the SHA-256 of the event
+	// and the hash of the event before it, so that changing or deleting an event breaks the chain after it.
+	Seq      int64  `json:"seq,omitempty" bson:"seq,omitempty"`
+	PrevHash string `json:"prevHash,omitempty" bson:"prevHash,omitempty"`
+	Hash     string `json:"hash,omitempty" bson:"hash,omitempty"`
+}
+
+// AuditLogRequest filters the audit log. Events are returned newest first, older pages are requested
+// with the ID of the last event of a page as before.
+type AuditLogRequest struct {
+	Uid     string `form:"uid"`
+	ActorId string `form:"actorId"`
+	Action  string `form:"action"`
+	// From and To are UTC dates or RFC 3339 times, a date as To includes the whole day
+	From   string `form:"from"`
+	To     string `form:"to"`
+	Before string `form:"before"`
+	Limit  int64  `form:"limit"`
+}
+
+// AuditChainResponse is the result of verifying the hash chain of the audit log
+type AuditChainResponse struct {
+	Valid bool `json:"valid"`
+	// Events is the number of chained events checked
+	Events int64 `json:"events"`
+	// HeadSeq and HeadHash identify the last event of the chain. Deleting the newest events cannot be detected
+	// from the chain alone, so they should be kept elsewhere to compare against.
+	HeadSeq  int64  `json:"headSeq,omitempty"`
+	HeadHash string `json:"headHash,omitempty"`
+	// BrokenAt is the ID of the first event that does not match the chain, and Reason why
+	BrokenAt string `json:"brokenAt,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+type AuditLogResponse struct {
+	Events []AuditEvent `json:"events"`
+	// NextBefore requests the next page, it is empty on the last page
+	NextBefore string `json:"nextBefore,omitempty"`
+}
@@ -1,6 +1,17 @@
 package models
 
+import "time"
+
 type AuthRequest struct {
 	Email    string `json:"email" binding:"required" validate:"email"`
 	Password string `json:"password" binding:"required,min=6"`
 }
+
+// LockoutEvent describes an account or IP address being locked out after repeated failed logins
+type LockoutEvent struct {
+	Scope    string        `json:"scope"`
+	Subject  string        `json:"subject"`
+	Failures int64         `json:"failures"`
+	Duration time.Duration `json:"duration"`
+	LockedAt time.Time     `json:"lockedAt"`
+}
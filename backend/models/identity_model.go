@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Identity links an account at an external OAuth2 / OpenID Connect provider to a user
+type Identity struct {
+	Id       primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Uid      string             `json:"uid" bson:"uid"`
+	Provider string             `json:"provider" bson:"provider"`
+	Subject  string             `json:"subject" bson:"subject"`
+	Email    string             `json:"email" bson:"email"`
+	LinkedAt time.Time          `json:"linkedAt" bson:"linkedAt"`
+}
+
+// OAuthState is stored on Redis between redirecting to the provider and handling its callback.
+// LinkUid is set when an authenticated user links a new identity instead of logging in.
+// Binding is set for logins, and must be presented by the browser that started the login.
+type OAuthState struct {
+	Provider     string `json:"provider"`
+	CodeVerifier string `json:"codeVerifier"`
+	Nonce        string `json:"nonce"`
+	LinkUid      string `json:"linkUid,omitempty"`
+	Binding      string `json:"binding,omitempty"`
+}
+
+type OAuthCallbackRequest struct {
+	Code  string `json:"code" binding:"required"`
+	State string `json:"state" binding:"required"`
+}
+
+type OAuthAuthorization struct {
+	AuthorizationURL string `json:"authorizationUrl"`
+}
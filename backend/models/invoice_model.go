@@ -0,0 +1,66 @@
+package models
+
+import (
+	"time"
+
+	plan "github.com/AkifhanIlgaz/dictionary-api/utils/plans"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Invoice bills a closed period of a subscription: the plan price, the charges and credits of the period
+// and, for information, the usage it was charged for. Amounts are in the smallest currency unit, e.g. cents.
+type Invoice struct {
+	Id primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	// Number is sequential over all invoices
+	Number      string        `json:"number" bson:"number"`
+	Uid         string        `json:"-" bson:"uid"`
+	Plan        plan.PlanType `json:"plan" bson:"plan"`
+	Seller      InvoiceParty  `json:"seller" bson:"seller"`
+	Customer    InvoiceParty  `json:"customer" bson:"customer"`
+	PeriodStart time.Time     `json:"periodStart" bson:"periodStart"`
+	PeriodEnd   time.Time     `json:"periodEnd" bson:"periodEnd"`
+	Lines       []InvoiceLine `json:"lines" bson:"lines"`
+	// Overage is the overage of the ledger billed by the invoice
+	Overage  []OverageTotal `json:"overage,omitempty" bson:"overage,omitempty"`
+	Currency string         `json:"currency" bson:"currency"`
+	Subtotal int64          `json:"subtotal" bson:"subtotal"`
+	TaxName  string         `json:"taxName" bson:"taxName"`
+	// TaxRate is a percentage of the subtotal
+	TaxRate  float64   `json:"taxRate" bson:"taxRate"`
+	Tax      int64     `json:"tax" bson:"tax"`
+	Total    int64     `json:"total" bson:"total"`
+	IssuedAt time.Time `json:"issuedAt" bson:"issuedAt"`
+}
+
+type InvoiceParty struct {
+	Name    string `json:"name,omitempty" bson:"name,omitempty"`
+	Email   string `json:"email,omitempty" bson:"email,omitempty"`
+	Address string `json:"address,omitempty" bson:"address,omitempty"`
+	TaxId   string `json:"taxId,omitempty" bson:"taxId,omitempty"`
+}
+
+type InvoiceLine struct {
+	Description string `json:"description" bson:"description"`
+	Quantity    int64  `json:"quantity" bson:"quantity"`
+	UnitAmount  int64  `json:"unitAmount" bson:"unitAmount"`
+	Amount      int64  `json:"amount" bson:"amount"`
+}
+
+// InvoiceItem is a charge, or a credit if negative, outside of the plan price, e.g. the proration of a plan change.
+// It is added to the next invoice of the user.
+type InvoiceItem struct {
+	Id          primitive.ObjectID  `bson:"_id,omitempty"`
+	Uid         string              `bson:"uid"`
+	Description string              `bson:"description"`
+	Amount      int64               `bson:"amount"`
+	CreatedAt   time.Time           `bson:"createdAt"`
+	InvoiceId   *primitive.ObjectID `bson:"invoiceId,omitempty"`
+}
+
+type InvoicesResponse struct {
+	Invoices []Invoice `json:"invoices"`
+}
+
+type InvoiceResponse struct {
+	Invoice *Invoice `json:"invoice"`
+}
@@ -0,0 +1,128 @@
+package models
+
+import (
+	"time"
+
+	plan "github.com/AkifhanIlgaz/dictionary-api/utils/plans"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// OrgRole is the role of a member in an organization
+type OrgRole string
+
+const (
+	// OrgOwnerRole can do everything admins can, change roles and delete the organization
+	OrgOwnerRole OrgRole = "owner"
+	// OrgAdminRole can rename the organization, invite and remove members and revoke any key of the organization
+	OrgAdminRole OrgRole = "admin"
+	// OrgMemberRole can create keys of the organization and see its usage
+	OrgMemberRole OrgRole = "member"
+)
+
+// IsValid reports whether the role is a known organization role
+func (role OrgRole) IsValid() bool {
+	return role == OrgOwnerRole || role == OrgAdminRole || role == OrgMemberRole
+}
+
+// CanManage reports whether the role can manage the members and keys of the organization
+func (role OrgRole) CanManage() bool {
+	return role == OrgOwnerRole || role == OrgAdminRole
+}
+
+const (
+	// MaxOrgMembers is how many members and pending invitations an organization can have
+	MaxOrgMembers int = 100
+	// OrgInvitationExpiry is how long an invitation can be accepted
+	OrgInvitationExpiry time.Duration = 7 * 24 * time.Hour
+)
+
+// Organization owns API keys whose requests share the quota of its plan
+type Organization struct {
+	Id   primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Name string             `json:"name" bson:"name"`
+	// Plan is set by platform admins, organizations start on the default plan
+	Plan      string    `json:"plan" bson:"plan"`
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt" bson:"updatedAt"`
+}
+
+// OrgQuotaSubject is what the requests of the keys of an organization are rate limited as, instead of a user ID
+func OrgQuotaSubject(orgId string) string {
+	return "org:" + orgId
+}
+
+// OrgMembership is a user's membership in an organization
+type OrgMembership struct {
+	Id       primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	OrgId    primitive.ObjectID `json:"orgId" bson:"orgId"`
+	Uid      string             `json:"uid" bson:"uid"`
+	Email    string             `json:"email" bson:"email"`
+	Role     OrgRole            `json:"role" bson:"role"`
+	JoinedAt time.Time          `json:"joinedAt" bson:"joinedAt"`
+}
+
+// OrgInvitation invites an email address to an organization. Only the hash of its token is stored,
+// the token itself is only sent to the invited address.
+type OrgInvitation struct {
+	Id        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	OrgId     primitive.ObjectID `json:"orgId" bson:"orgId"`
+	Email     string             `json:"email" bson:"email"`
+	Role      OrgRole            `json:"role" bson:"role"`
+	TokenHash string             `json:"-" bson:"tokenHash"`
+	InvitedBy string             `json:"invitedBy" bson:"invitedBy"`
+	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
+	ExpiresAt time.Time          `json:"expiresAt" bson:"expiresAt"`
+}
+
+type CreateOrganizationRequest struct {
+	Name string `json:"name" binding:"required,max=100"`
+}
+
+type UpdateOrganizationRequest struct {
+	Name string `json:"name" binding:"required,max=100"`
+}
+
+type InviteOrgMemberRequest struct {
+	Email string  `json:"email" binding:"required,email"`
+	Role  OrgRole `json:"role"`
+}
+
+type AcceptOrgInvitationRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type SetOrgRoleRequest struct {
+	Role OrgRole `json:"role" binding:"required"`
+}
+
+type SetOrgPlanRequest struct {
+	Plan plan.PlanType `json:"plan" binding:"required"`
+}
+
+// OrganizationResponse is an organization with the role of the requesting member in it
+type OrganizationResponse struct {
+	Organization *Organization `json:"organization"`
+	Role         OrgRole       `json:"role,omitempty"`
+}
+
+// UserOrganization is an organization a user is a member of, and their role in it
+type UserOrganization struct {
+	Organization
+	Role OrgRole `json:"role"`
+}
+
+type OrganizationsResponse struct {
+	Organizations []UserOrganization `json:"organizations"`
+}
+
+type OrgMembersResponse struct {
+	Members []OrgMembership `json:"members"`
+}
+
+type OrgInvitationResponse struct {
+	Invitation *OrgInvitation `json:"invitation"`
+}
+
+type OrgInvitationsResponse struct {
+	Invitations []OrgInvitation `json:"invitations"`
+}
@@ -0,0 +1,108 @@
+package models
+
+import (
+	"time"
+
+	plan "github.com/AkifhanIlgaz/dictionary-api/utils/plans"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// OverageMode decides what happens to requests over the quota of a plan
+type OverageMode string
+
+const (
+	// BlockOverage rejects requests over the quota
+	BlockOverage OverageMode = "block"
+	// BillOverage allows requests over the quota and bills them per started 1,000 at the overage price of the plan
+	BillOverage OverageMode = "bill"
+	// SoftCapOverage allows requests up to SoftCapPercent over the quota for free, and alerts when the quota is exceeded
+	SoftCapOverage OverageMode = "soft_cap"
+)
+
+const (
+	DefaultSoftCapPercent int = 10
+	MaxSoftCapPercent     int = 100
+)
+
+// IsValid reports whether the mode is a known overage mode
+func (mode OverageMode) IsValid() bool {
+	return mode == BlockOverage || mode == BillOverage || mode == SoftCapOverage
+}
+
+// OveragePolicy is what a user chose to happen to requests over the quota of their plan
+type OveragePolicy struct {
+	Mode OverageMode `json:"mode" bson:"mode"`
+	// SoftCapPercent is how far past the quota the soft cap is, as a percentage of the quota
+	SoftCapPercent int `json:"softCapPercent,omitempty" bson:"softCapPercent,omitempty"`
+}
+
+// Limit returns the number of requests allowed in a quota window including overage, 0 if unlimited
+func (policy OveragePolicy) Limit(quota int) int {
+	switch policy.Mode {
+	case BillOverage:
+		return 0
+	case SoftCapOverage:
+		return quota + (quota*policy.SoftCapPercent+99)/100
+	default:
+		return quota
+	}
+}
+
+// UserQuota is what the requests of a user are metered against
+type UserQuota struct {
+	Plan plan.Plan
+	// Location is the billing timezone quota windows start in
+	Location *time.Location
+	// Overage is the overage policy in effect, which is always BlockOverage on plans without overage
+	Overage OveragePolicy
+	// AlertThresholds are the percentages of the quota the user is alerted at
+	AlertThresholds []int
+}
+
+// OverageEvent is the first request of a quota window over the quota, allowed by the overage policy
+type OverageEvent struct {
+	Uid         string
+	Plan        plan.PlanType
+	Mode        OverageMode
+	Window      plan.Window
+	WindowStart time.Time
+	WindowEnd   time.Time
+	Quota       int
+	// Limit is the number of requests allowed in the window including overage, 0 if unlimited
+	Limit int
+}
+
+// OverageLedgerEntry is the overage of a user in an hour, rolled up from the usage counters.
+// Entries are claimed by the invoice of the billing period that closes after them.
+type OverageLedgerEntry struct {
+	Id        primitive.ObjectID  `json:"-" bson:"_id,omitempty"`
+	Uid       string              `json:"-" bson:"uid"`
+	Hour      time.Time           `json:"hour" bson:"hour"`
+	Plan      plan.PlanType       `json:"plan" bson:"plan"`
+	Mode      OverageMode         `json:"mode" bson:"mode"`
+	Requests  int64               `json:"requests" bson:"requests"`
+	InvoiceId *primitive.ObjectID `json:"invoiceId,omitempty" bson:"invoiceId,omitempty"`
+}
+
+// OverageTotal is the overage of a user in the ledger on a plan with an overage mode
+type OverageTotal struct {
+	Plan     plan.PlanType `json:"plan" bson:"plan"`
+	Mode     OverageMode   `json:"mode" bson:"mode"`
+	Requests int64         `json:"requests" bson:"requests"`
+	// Amount is what the requests are billed, in cents
+	Amount int64 `json:"amount" bson:"amount"`
+}
+
+type SetOveragePolicyRequest struct {
+	Mode           string `json:"mode" binding:"required"`
+	SoftCapPercent int    `json:"softCapPercent"`
+}
+
+// OverageResponse is the overage policy of a user and the overage that has not been invoiced yet.
+// PricePer1000 is the overage price of the current plan in cents, 0 if the plan has no overage.
+type OverageResponse struct {
+	Policy       OveragePolicy  `json:"policy"`
+	PricePer1000 int64          `json:"pricePer1000"`
+	Currency     string         `json:"currency"`
+	Unbilled     []OverageTotal `json:"unbilled"`
+}
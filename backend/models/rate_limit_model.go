@@ -0,0 +1,57 @@
+package models
+
+import (
+	"time"
+
+	plan "github.com/AkifhanIlgaz/dictionary-api/utils/plans"
+)
+
+// RateLimitWindow names the limit that rejected a request
+type RateLimitWindow string
+
+const (
+	BurstWindow RateLimitWindow = "burst"
+	QuotaWindow RateLimitWindow = "quota"
+)
+
+// RateLimitResult is the outcome of counting a request against the limits of a plan.
+// Limits of 0 are unlimited and their remaining counts are meaningless.
+type RateLimitResult struct {
+	Allowed bool
+	// Exceeded is the limit that rejected the request, empty if it was allowed
+	Exceeded RateLimitWindow
+	// RetryAfter is how long to wait before the next request can be allowed
+	RetryAfter time.Duration
+	// Overage is the overage mode that allowed the request over the quota, empty if it was within the quota
+	Overage OverageMode
+
+	// BurstLimit is the number of requests that can be sent at once, refilled at the per-minute rate
+	BurstLimit     int
+	BurstRemaining int
+	// BurstReset is how long until the full burst is available again
+	BurstReset time.Duration
+	// BurstPeriod is how long it takes to refill the full burst from empty
+	BurstPeriod time.Duration
+
+	// QuotaLimit is the daily or monthly quota of the plan
+	QuotaLimit     int
+	QuotaRemaining int
+	// QuotaReset is how long until the quota window ends
+	QuotaReset time.Duration
+	// QuotaPeriod is the length of the current quota window
+	QuotaPeriod time.Duration
+}
+
+// QuotaUsage is the usage of the current quota window of a user
+type QuotaUsage struct {
+	Window   plan.Window `json:"window"`
+	Timezone string      `json:"timezone"`
+	Start    time.Time   `json:"start"`
+	ResetsAt time.Time   `json:"resetsAt"`
+	// Limit is the quota of the window, 0 if unlimited
+	Limit     int `json:"limit"`
+	Used      int `json:"used"`
+	Remaining int `json:"remaining"`
+	// Overage is what happens to requests over the limit
+	Overage OveragePolicy `json:"overage"`
+}
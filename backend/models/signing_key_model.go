@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// SigningKey is a rotated access token signing key shared by all server instances.
+// Period is the start of the rotation period the key was generated for and is unique,
+// so that only one instance generates a key per period.
+type SigningKey struct {
+	Id     string    `bson:"_id"`
+	Period time.Time `bson:"period"`
+	// EncryptedPrivateKey is the PEM of the private key encrypted with the key-encryption key of the config.
+	// Keys stored unencrypted by earlier versions have none and are deleted.
+	EncryptedPrivateKey string    `bson:"encryptedPrivateKey"`
+	ActivatesAt         time.Time `bson:"activatesAt"`
+	CreatedAt           time.Time `bson:"createdAt"`
+}
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"time"
+
+	"github.com/AkifhanIlgaz/dictionary-api/utils/payment"
+	plan "github.com/AkifhanIlgaz/dictionary-api/utils/plans"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Subscription is the paid subscription of a user, synced from the events of the payment provider.
+// Users have at most one, which is reused when they subscribe again.
+type Subscription struct {
+	Id                 primitive.ObjectID         `json:"-" bson:"_id,omitempty"`
+	Uid                string                     `json:"-" bson:"uid"`
+	Provider           string                     `json:"provider" bson:"provider"`
+	CustomerId         string                     `json:"-" bson:"customerId"`
+	SubscriptionId     string                     `json:"-" bson:"subscriptionId"`
+	Plan               plan.PlanType              `json:"plan" bson:"plan"`
+	Amount             int64                      `json:"amount" bson:"amount"`
+	Currency           string                     `json:"currency" bson:"currency"`
+	Status             payment.SubscriptionStatus `json:"status" bson:"status"`
+	CurrentPeriodStart time.Time                  `json:"currentPeriodStart" bson:"currentPeriodStart"`
+	CurrentPeriodEnd   time.Time                  `json:"currentPeriodEnd" bson:"currentPeriodEnd"`
+	TrialEnd           *time.Time                 `json:"trialEnd,omitempty" bson:"trialEnd,omitempty"`
+	// PeriodPlan and PeriodAmount are the plan and price the current period started with, the price is 0 for trials.
+	// Plan changes during the period are billed as prorations instead.
+	PeriodPlan   plan.PlanType `json:"-" bson:"periodPlan,omitempty"`
+	PeriodAmount int64         `json:"-" bson:"periodAmount"`
+	// CancelAtPeriodEnd subscriptions end and move the user back to the default plan when the current period ends
+	CancelAtPeriodEnd bool      `json:"cancelAtPeriodEnd" bson:"cancelAtPeriodEnd"`
+	CreatedAt         time.Time `json:"createdAt" bson:"createdAt"`
+	UpdatedAt         time.Time `json:"updatedAt" bson:"updatedAt"`
+	// LastEventAt is the creation time of the last applied webhook event, older events are ignored
+	LastEventAt time.Time `json:"-" bson:"lastEventAt"`
+}
+
+// PeriodCharge returns the price charged for the current period
+func (subscription Subscription) PeriodCharge() int64 {
+	if subscription.PeriodPlan != "" {
+		return subscription.PeriodAmount
+	}
+
+	// Subscriptions synced before the price of their period was stored
+	if subscription.TrialEnd != nil && !subscription.CurrentPeriodEnd.After(*subscription.TrialEnd) {
+		return 0
+	}
+	return subscription.Amount
+}
+
+type CheckoutRequest struct {
+	Plan string `json:"plan" binding:"required"`
+}
+
+type ChangePlanRequest struct {
+	Plan string `json:"plan" binding:"required"`
+}
+
+type CheckoutSessionResponse struct {
+	SessionId string    `json:"sessionId"`
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type SubscriptionResponse struct {
+	Subscription *Subscription `json:"subscription"`
+}
+
+// PlanChangeResponse is a subscription after a plan change and the proration billed for it.
+// A negative proration is credited.
+type PlanChangeResponse struct {
+	Subscription    *Subscription `json:"subscription"`
+	ProrationAmount int64         `json:"prorationAmount"`
+	Currency        string        `json:"currency"`
+}
+
+type PlansResponse struct {
+	Plans []plan.Plan `json:"plans"`
+}
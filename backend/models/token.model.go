@@ -1,5 +1,7 @@
 package models
 
+import "github.com/golang-jwt/jwt/v4"
+
 type RefreshTokenRequest struct {
 	RefreshToken string `json:"refreshToken" binding:"required"`
 }
@@ -8,3 +10,11 @@ type Tokens struct {
 	AccessToken  string `json:"accessToken"`
 	RefreshToken string `json:"refreshToken"`
 }
+
+// TokenClaims are the claims carried by access and refresh tokens
+type TokenClaims struct {
+	jwt.RegisteredClaims
+	Role string `json:"role,omitempty"`
+	// Impersonator is the support staff member or admin a read-only impersonation token was issued to
+	Impersonator string `json:"impersonator,omitempty"`
+}
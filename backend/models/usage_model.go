@@ -0,0 +1,94 @@
+package models
+
+import (
+	"time"
+
+	plan "github.com/AkifhanIlgaz/dictionary-api/utils/plans"
+)
+
+// Granularity is the bucket size of a usage history
+type Granularity string
+
+const (
+	DayGranularity  Granularity = "day"
+	HourGranularity Granularity = "hour"
+)
+
+// Dimensions a usage history can be broken down by
+const (
+	KeyDimension      string = "key"
+	EndpointDimension string = "endpoint"
+	StatusDimension   string = "status"
+	// MemberDimension breaks the usage of an organization down by the members who created the keys
+	MemberDimension string = "member"
+)
+
+var UsageDimensions = []string{
+	KeyDimension,
+	EndpointDimension,
+	StatusDimension,
+}
+
+// OrgUsageDimensions are the dimensions the usage history of an organization can be broken down by
+var OrgUsageDimensions = []string{
+	KeyDimension,
+	EndpointDimension,
+	StatusDimension,
+	MemberDimension,
+}
+
+// UsageRecord is a request with an API key that reached its handler
+type UsageRecord struct {
+	// Uid is the owner of the key, the member who created it for keys of organizations
+	Uid string
+	// OrgId is the organization of the key, empty for personal keys
+	OrgId    string
+	KeyId    string
+	Endpoint string
+	Status   int
+	// Charged reports whether the request counts towards the quota
+	Charged bool
+	// Plan is the plan the request was metered against
+	Plan plan.PlanType
+	// Overage is the overage mode that allowed the request over the quota, empty if it was within the quota
+	Overage OverageMode
+	Time    time.Time
+}
+
+// UsageHistoryRequest are the query parameters of a usage history.
+// From and To are dates (2006-01-02) or RFC 3339 times, To is inclusive for dates.
+type UsageHistoryRequest struct {
+	From        string `form:"from"`
+	To          string `form:"to"`
+	Granularity string `form:"granularity"`
+	// GroupBy is a comma separated list of dimensions: key, endpoint and status
+	GroupBy string `form:"groupBy"`
+	// Format is json or csv
+	Format string `form:"format"`
+}
+
+// UsageHistoryRow is the usage in one bucket for one combination of the grouped dimensions.
+// Dimensions that are not grouped by are empty.
+type UsageHistoryRow struct {
+	Period    time.Time `json:"period" bson:"period"`
+	KeyId     string    `json:"keyId,omitempty" bson:"key,omitempty"`
+	KeyName   string    `json:"keyName,omitempty" bson:"-"`
+	KeyPrefix string    `json:"keyPrefix,omitempty" bson:"-"`
+	// Uid and Email are the member who created the key, only set in organization usage grouped by member
+	Uid         string `json:"uid,omitempty" bson:"uid,omitempty"`
+	Email       string `json:"email,omitempty" bson:"-"`
+	Endpoint    string `json:"endpoint,omitempty" bson:"endpoint,omitempty"`
+	StatusClass string `json:"statusClass,omitempty" bson:"statusClass,omitempty"`
+	Requests    int    `json:"requests" bson:"requests"`
+	Charged     int    `json:"charged" bson:"charged"`
+}
+
+type UsageHistoryResponse struct {
+	From        time.Time         `json:"from"`
+	To          time.Time         `json:"to"`
+	Granularity Granularity       `json:"granularity"`
+	GroupBy     []string          `json:"groupBy"`
+	Rows        []UsageHistoryRow `json:"rows"`
+	Requests    int               `json:"requests"`
+	Charged     int               `json:"charged"`
+}
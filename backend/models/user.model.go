@@ -1,10 +1,94 @@
 package models
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
 
 type User struct {
 	Id           primitive.ObjectID `json:"id" bson:"_id,omitempty"`
 	Email        string             `json:"email" bson:"email"`
 	Plan         string             `json:"plan" bson:"plan"`
+	Role         string             `json:"role" bson:"role,omitempty"`
 	PasswordHash string             `json:"-" bson:"passwordHash"`
+	// EmailVerified is set when ownership of the email was proven, e.g. by signing up with a verified email
+	// of an identity provider. Registering with a password does not verify it.
+	EmailVerified bool        `json:"emailVerified" bson:"emailVerified,omitempty"`
+	MFA           MFASettings `json:"-" bson:"mfa,omitempty"`
+	// BillingTimezone is the IANA time zone quota windows start in, UTC if empty
+	BillingTimezone string `json:"billingTimezone,omitempty" bson:"billingTimezone,omitempty"`
+	// PendingBillingTimezone replaces BillingTimezone when the quota window it was requested in ends
+	PendingBillingTimezone *PendingBillingTimezone `json:"pendingBillingTimezone,omitempty" bson:"pendingBillingTimezone,omitempty"`
+	// OveragePolicy decides what happens to requests over the quota, they are blocked if it is empty
+	OveragePolicy OveragePolicy `json:"overagePolicy" bson:"overagePolicy,omitempty"`
+	// QuotaAlerts are the quota thresholds the user is alerted at
+	QuotaAlerts QuotaAlertSettings `json:"-" bson:"quotaAlerts,omitempty"`
+	// Suspension is set while an admin has suspended the user, who can then neither log in nor use their API keys
+	Suspension *Suspension `json:"suspension,omitempty" bson:"suspension,omitempty"`
+}
+
+// Suspension records why and by whom a user was suspended
+type Suspension struct {
+	Reason      string    `json:"reason" bson:"reason"`
+	SuspendedBy string    `json:"suspendedBy" bson:"suspendedBy"`
+	SuspendedAt time.Time `json:"suspendedAt" bson:"suspendedAt"`
+}
+
+// IsSuspended reports whether the user is suspended
+func (user User) IsSuspended() bool {
+	return user.Suspension != nil
+}
+
+// PendingBillingTimezone is a billing timezone change that applies from EffectiveAt
+type PendingBillingTimezone struct {
+	Timezone    string    `json:"timezone" bson:"timezone"`
+	EffectiveAt time.Time `json:"effectiveAt" bson:"effectiveAt"`
+}
+
+// billingLocations caches the loaded billing timezones by name, since they are needed on every metered request
+// and time.LoadLocation reads the timezone database each time
+var billingLocations sync.Map
+
+// BillingLocation returns the billing timezone of the user at now.
+// Users without a billing timezone, or with one that no longer loads, are billed in UTC.
+func (user User) BillingLocation(now time.Time) *time.Location {
+	timezone := user.BillingTimezone
+	if pending := user.PendingBillingTimezone; pending != nil && !now.Before(pending.EffectiveAt) {
+		timezone = pending.Timezone
+	}
+
+	if loc, ok := billingLocations.Load(timezone); ok {
+		return loc.(*time.Location)
+	}
+
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	billingLocations.Store(timezone, loc)
+
+	return loc
+}
+
+type SetBillingTimezoneRequest struct {
+	Timezone string `json:"timezone" binding:"required"`
+}
+
+// BillingTimezoneResponse is the billing timezone of a user and when it takes effect
+type BillingTimezoneResponse struct {
+	Timezone    string    `json:"timezone"`
+	EffectiveAt time.Time `json:"effectiveAt"`
+}
+
+// MFASettings holds the TOTP state of a user.
+// PendingSecret is set during enrollment and replaces Secret once the first code is confirmed.
+type MFASettings struct {
+	Enabled       bool      `bson:"enabled"`
+	Secret        string    `bson:"secret,omitempty"`
+	PendingSecret string    `bson:"pendingSecret,omitempty"`
+	RecoveryCodes []string  `bson:"recoveryCodes,omitempty"`
+	LastUsedStep  int64     `bson:"lastUsedStep,omitempty"`
+	EnabledAt     time.Time `bson:"enabledAt,omitempty"`
 }
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBillingLocation(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		user User
+		want string
+	}{
+		{"no timezone", User{}, "UTC"},
+		{"timezone", User{BillingTimezone: "Asia/Kolkata"}, "Asia/Kolkata"},
+		{"unknown timezone", User{BillingTimezone: "Mars/Olympus_Mons"}, "UTC"},
+		{"pending change", User{
+			BillingTimezone:        "Asia/Kolkata",
+			PendingBillingTimezone: &PendingBillingTimezone{Timezone: "Europe/Istanbul", EffectiveAt: now.Add(time.Hour)},
+		}, "Asia/Kolkata"},
+		{"effective change", User{
+			BillingTimezone:        "Asia/Kolkata",
+			PendingBillingTimezone: &PendingBillingTimezone{Timezone: "Europe/Istanbul", EffectiveAt: now},
+		}, "Europe/Istanbul"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.user.BillingLocation(now).String(); got != test.want {
+				t.Errorf("BillingLocation() = %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestBillingLocationIsCached(t *testing.T) {
+	user := User{BillingTimezone: "America/St_Johns"}
+	now := time.Now()
+
+	if user.BillingLocation(now) != user.BillingLocation(now) {
+		t.Error("BillingLocation() loaded the timezone again")
+	}
+}
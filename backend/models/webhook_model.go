@@ -0,0 +1,199 @@
+package models
+
+import (
+	"time"
+
+	plan "github.com/AkifhanIlgaz/dictionary-api/utils/plans"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// WebhookEventType is the kind of event a webhook reports
+type WebhookEventType string
+
+const (
+	APIKeyCreatedEvent  WebhookEventType = "api_key.created"
+	APIKeyRevokedEvent  WebhookEventType = "api_key.revoked"
+	PlanChangedEvent    WebhookEventType = "plan.changed"
+	DatasetUpdatedEvent WebhookEventType = "dataset.updated"
+	QuotaThresholdEvent WebhookEventType = "quota.threshold"
+	QuotaOverageEvent   WebhookEventType = "quota.overage"
+)
+
+// WebhookEventTypes are the event types endpoints can subscribe to
+var WebhookEventTypes = []WebhookEventType{
+	APIKeyCreatedEvent,
+	APIKeyRevokedEvent,
+	PlanChangedEvent,
+	DatasetUpdatedEvent,
+	QuotaThresholdEvent,
+	QuotaOverageEvent,
+}
+
+// IsValid reports whether the event type is a known event type
+func (eventType WebhookEventType) IsValid() bool {
+	for _, known := range WebhookEventTypes {
+		if eventType == known {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MaxWebhookEndpoints is how many webhook endpoints a user can register
+const MaxWebhookEndpoints int = 10
+
+// WebhookEndpoint is a URL of a user that events are posted to, signed with its secret
+type WebhookEndpoint struct {
+	Id          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Uid         string             `json:"-" bson:"uid"`
+	URL         string             `json:"url" bson:"url"`
+	Description string             `json:"description,omitempty" bson:"description,omitempty"`
+	// Events are the event types sent to the endpoint, all event types if empty
+	Events  []WebhookEventType `json:"events" bson:"events"`
+	Enabled bool               `json:"enabled" bson:"enabled"`
+	// Secret signs the webhooks of the endpoint, it is only returned when created or rotated, see WebhookEndpointWithSecret
+	Secret    string    `json:"-" bson:"secret"`
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt" bson:"updatedAt"`
+}
+
+// Subscribes reports whether events of the given type are sent to the endpoint
+func (endpoint WebhookEndpoint) Subscribes(eventType WebhookEventType) bool {
+	if len(endpoint.Events) == 0 {
+		return true
+	}
+
+	for _, subscribed := range endpoint.Events {
+		if subscribed == eventType {
+			return true
+		}
+	}
+
+	return false
+}
+
+type CreateWebhookEndpointRequest struct {
+	URL         string   `json:"url" binding:"required"`
+	Description string   `json:"description" binding:"max=256"`
+	Events      []string `json:"events"`
+}
+
+// UpdateWebhookEndpointRequest changes only the fields that are set.
+// An empty events list subscribes the endpoint to all event types.
+type UpdateWebhookEndpointRequest struct {
+	URL          *string  `json:"url"`
+	Description  *string  `json:"description" binding:"omitempty,max=256"`
+	Events       []string `json:"events"`
+	Enabled      *bool    `json:"enabled"`
+	RotateSecret bool     `json:"rotateSecret"`
+}
+
+// WebhookEndpointWithSecret is a webhook endpoint with its signing secret, which is only returned when the endpoint
+// is created or its secret is rotated
+type WebhookEndpointWithSecret struct {
+	WebhookEndpoint
+	Secret string `json:"secret"`
+}
+
+type WebhookEndpointResponse struct {
+	Endpoint *WebhookEndpoint `json:"endpoint"`
+}
+
+type WebhookEndpointSecretResponse struct {
+	Endpoint *WebhookEndpointWithSecret `json:"endpoint"`
+}
+
+type WebhookEndpointsResponse struct {
+	Endpoints []WebhookEndpoint `json:"endpoints"`
+}
+
+type WebhookEventTypesResponse struct {
+	EventTypes []WebhookEventType `json:"eventTypes"`
+}
+
+// WebhookEvent is the body of webhook requests. Its ID stays the same when a delivery is retried or redelivered,
+// so that endpoints can ignore events they have already processed.
+type WebhookEvent struct {
+	Id        string           `json:"id"`
+	Type      WebhookEventType `json:"type"`
+	CreatedAt time.Time        `json:"createdAt"`
+	Data      any              `json:"data"`
+}
+
+// APIKeyEventData is the data of api_key events
+type APIKeyEventData struct {
+	Id     string `json:"id"`
+	Name   string `json:"name,omitempty"`
+	Prefix string `json:"prefix,omitempty"`
+	// OrgId is the organization of the key, empty for personal keys
+	OrgId string `json:"orgId,omitempty"`
+}
+
+// PlanChangedEventData is the data of plan.changed events
+type PlanChangedEventData struct {
+	PreviousPlan plan.PlanType `json:"previousPlan"`
+	Plan         plan.PlanType `json:"plan"`
+	// OrgId is the organization whose plan changed, empty if it was the plan of the user
+	OrgId string `json:"orgId,omitempty"`
+}
+
+// DatasetUpdatedEventData is the data of dataset.updated events
+type DatasetUpdatedEventData struct {
+	Description string `json:"description,omitempty"`
+	// Words are the updated words, empty if the whole dataset was updated
+	Words []string `json:"words,omitempty"`
+}
+
+type DatasetUpdatedRequest struct {
+	Description string   `json:"description" binding:"max=1024"`
+	Words       []string `json:"words" binding:"max=1000"`
+}
+
+type WebhookDeliveryStatus string
+
+const (
+	// PendingDelivery is waiting for its next attempt
+	PendingDelivery WebhookDeliveryStatus = "pending"
+	// SucceededDelivery was acknowledged by the endpoint with a 2xx status
+	SucceededDelivery WebhookDeliveryStatus = "succeeded"
+	// FailedDelivery ran out of attempts, or its endpoint was deleted or disabled
+	FailedDelivery WebhookDeliveryStatus = "failed"
+)
+
+// WebhookDelivery is an event queued for an endpoint, and the log of the attempts to deliver it
+type WebhookDelivery struct {
+	Id         primitive.ObjectID    `json:"id" bson:"_id,omitempty"`
+	Uid        string                `json:"-" bson:"uid"`
+	EndpointId primitive.ObjectID    `json:"endpointId" bson:"endpointId"`
+	EventId    string                `json:"eventId" bson:"eventId"`
+	EventType  WebhookEventType      `json:"eventType" bson:"eventType"`
+	Payload    string                `json:"payload" bson:"payload"`
+	Status     WebhookDeliveryStatus `json:"status" bson:"status"`
+	// AttemptCount is the number of attempts so far, Attempts the log of the last of them
+	AttemptCount int              `json:"attemptCount" bson:"attemptCount"`
+	Attempts     []WebhookAttempt `json:"attempts" bson:"attempts"`
+	// NextAttemptAt is when a pending delivery is attempted next
+	NextAttemptAt *time.Time `json:"nextAttemptAt,omitempty" bson:"nextAttemptAt,omitempty"`
+	// RedeliveryOf is the delivery this one was manually redelivered from
+	RedeliveryOf *primitive.ObjectID `json:"redeliveryOf,omitempty" bson:"redeliveryOf,omitempty"`
+	CreatedAt    time.Time           `json:"createdAt" bson:"createdAt"`
+	CompletedAt  *time.Time          `json:"completedAt,omitempty" bson:"completedAt,omitempty"`
+}
+
+// WebhookAttempt is an attempt to deliver a webhook
+type WebhookAttempt struct {
+	At time.Time `json:"at" bson:"at"`
+	// StatusCode is the response status of the endpoint, 0 if it did not respond
+	StatusCode int    `json:"statusCode,omitempty" bson:"statusCode,omitempty"`
+	Error      string `json:"error,omitempty" bson:"error,omitempty"`
+	DurationMs int64  `json:"durationMs" bson:"durationMs"`
+}
+
+type WebhookDeliveryResponse struct {
+	Delivery *WebhookDelivery `json:"delivery"`
+}
+
+type WebhookDeliveriesResponse struct {
+	Deliveries []WebhookDelivery `json:"deliveries"`
+}
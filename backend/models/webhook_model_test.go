@@ -0,0 +1,29 @@
+package models
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestWebhookEndpointSecret(t *testing.T) {
+	endpoint := WebhookEndpoint{URL: "https://example.com/hook", Secret: "whsec_test"}
+
+	data, err := json.Marshal(WebhookEndpointResponse{Endpoint: &endpoint})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "whsec_test") {
+		t.Errorf("endpoint response contains the secret: %s", data)
+	}
+
+	data, err = json.Marshal(WebhookEndpointSecretResponse{
+		Endpoint: &WebhookEndpointWithSecret{WebhookEndpoint: endpoint, Secret: endpoint.Secret},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"secret":"whsec_test"`) {
+		t.Errorf("secret response is missing the secret: %s", data)
+	}
+}
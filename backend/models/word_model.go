@@ -8,7 +8,7 @@ type WordInfo struct {
 	Word   string `json:"word"`
 	Header
 	Definitions []Definition `json:"definitions"`
-	Idioms      []Idiom      `json:"idioms"`
+	Idioms      []Idiom      `json:"idioms,omitempty"`
 }
 
 type Header struct {
@@ -30,10 +30,33 @@ type Definition struct {
 	// Meaning contains the actual definition
 	// @Description The actual definition of the word
 	Meaning  string   `json:"meaning"`
-	Examples []string `json:"examples"`
+	Examples []string `json:"examples,omitempty"`
 }
 
 type Idiom struct {
 	Usage       string       `json:"usage"`
 	Definitions []Definition `json:"definition"`
 }
+
+// MaxBatchWords is how many words can be looked up in one batch request
+const MaxBatchWords int = 50
+
+type BatchWordsRequest struct {
+	Words        []string `json:"words"`
+	PartOfSpeech string   `json:"partOfSpeech"`
+}
+
+type BatchWordsResponse struct {
+	Words []WordInfo `json:"words"`
+	// NotFound are the requested words that are not in the dictionary
+	NotFound []string `json:"notFound"`
+}
+
+type ExportWordsRequest struct {
+	Format    string `form:"format"`
+	CEFRLevel string `form:"cefr"`
+}
+
+type ExportWordsResponse struct {
+	Words []WordInfo `json:"words"`
+}
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/AkifhanIlgaz/dictionary-api/models"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/mail"
+	plan "github.com/AkifhanIlgaz/dictionary-api/utils/plans"
+)
+
+// AlertService is the QuotaNotifier that sends quota alerts to the webhook endpoints of users subscribed to
+// quota events, and by email to users with email alerts. Alerts are sent in the background,
+// so that they never slow down the request that triggered them.
+type AlertService struct {
+	authService    AuthService
+	webhookService WebhookService
+	mailer         mail.Mailer
+}
+
+// NewAlertService creates a new AlertService instance
+func NewAlertService(authService AuthService, webhookService WebhookService, mailer mail.Mailer) AlertService {
+	return AlertService{
+		authService:    authService,
+		webhookService: webhookService,
+		mailer:         mailer,
+	}
+}
+
+func (service AlertService) NotifyThreshold(event models.QuotaAlertEvent) error {
+	_ = LogQuotaNotifier{}.NotifyThreshold(event)
+
+	go service.deliver(event.Uid, models.QuotaThresholdEvent, models.QuotaAlertData{
+		Plan:        event.Plan,
+		Window:      event.Window,
+		WindowStart: event.WindowStart,
+		WindowEnd:   event.WindowEnd,
+		Threshold:   event.Threshold,
+		Quota:       event.Quota,
+		Used:        event.Used,
+	})
+	return nil
+}
+
+func (service AlertService) NotifyOverage(event models.OverageEvent) error {
+	_ = LogQuotaNotifier{}.NotifyOverage(event)
+
+	go service.deliver(event.Uid, models.QuotaOverageEvent, models.QuotaAlertData{
+		Plan:        event.Plan,
+		Window:      event.Window,
+		WindowStart: event.WindowStart,
+		WindowEnd:   event.WindowEnd,
+		Quota:       event.Quota,
+		Used:        event.Quota + 1,
+		Overage:     event.Mode,
+	})
+	return nil
+}
+
+// deliver publishes an alert to the webhook endpoints of the user and emails it if the user has email alerts
+func (service AlertService) deliver(uid string, eventType models.WebhookEventType, data models.QuotaAlertData) {
+	if err := service.webhookService.Publish(uid, eventType, data); err != nil {
+		log.Println(err.Error())
+	}
+
+	user, err := service.authService.GetById(uid)
+	if err != nil {
+		log.Println(fmt.Errorf("deliver quota alert: %w", err).Error())
+		return
+	}
+
+	if user.QuotaAlerts.Email {
+		if err := service.mailer.Send(quotaAlertMail(user.Email, eventType, data)); err != nil {
+			log.Println(fmt.Errorf("deliver quota alert: %w", err).Error())
+		}
+	}
+}
+
+func quotaAlertMail(to string, eventType models.WebhookEventType, data models.QuotaAlertData) mail.Message {
+	window := "daily"
+	if data.Window == plan.MonthlyWindow {
+		window = "monthly"
+	}
+
+	var subject, summary string
+	if eventType == models.QuotaOverageEvent {
+		subject = fmt.Sprintf("You went over your %s quota", window)
+		summary = fmt.Sprintf("Your requests went over the %s quota of %d requests of your %s plan. Requests over the quota are allowed by your %s overage policy.", window, data.Quota, data.Plan, data.Overage)
+		if data.Overage == models.BillOverage {
+			summary += " They are billed with your next invoice."
+		}
+	} else {
+		subject = fmt.Sprintf("You reached %d%% of your %s quota", data.Threshold, window)
+		summary = fmt.Sprintf("You have used %d of the %s quota of %d requests of your %s plan.", data.Used, window, data.Quota, data.Plan)
+	}
+
+	body := fmt.Sprintf("%s\n\nThe quota resets at %s.\n\nYou can change your quota alerts in your billing settings.\n",
+		summary, data.WindowEnd.UTC().Format("2006-01-02 15:04 MST"))
+
+	return mail.Message{
+		To:      to,
+		Subject: subject,
+		Body:    body,
+	}
+}
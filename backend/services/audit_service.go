@@ -0,0 +1,278 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/AkifhanIlgaz/dictionary-api/config"
+	"github.com/AkifhanIlgaz/dictionary-api/models"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/db"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/message"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultAuditLogLimit int64 = 50
+	maxAuditLogLimit     int64 = 500
+	// maxAuditChainAttempts is how often recording a chained event is retried when other events take its place
+	maxAuditChainAttempts int = 10
+)
+
+var ErrInvalidAuditQuery = errors.New(message.InvalidAuditQuery)
+
+// AuditService records actions in the audit log. Events are only ever inserted, never changed or deleted,
+// and are kept indefinitely. With hash chaining, every event is numbered and carries the hash of the event before it.
+type AuditService struct {
+	ctx        context.Context
+	collection *mongo.Collection
+	hashChain  bool
+}
+
+// NewAuditService creates a new AuditService instance and initializes the indexes of the audit log collection
+func NewAuditService(ctx context.Context, mongoDatabase *mongo.Database, config config.Config) (AuditService, error) {
+	collection := mongoDatabase.Collection(db.AuditLogCollection)
+
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			// Unique, so that two events cannot extend the chain from the same event
+			Keys: bson.D{{Key: "seq", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{
+				"seq": bson.M{"$exists": true},
+			}),
+		},
+		{
+			Keys: bson.D{{Key: "uid", Value: 1}, {Key: "_id", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "actorId", Value: 1}, {Key: "_id", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "action", Value: 1}, {Key: "_id", Value: -1}},
+		},
+	})
+	if err != nil {
+		return AuditService{}, fmt.Errorf("initialize audit log collection: %w", err)
+	}
+
+	return AuditService{
+		ctx:        ctx,
+		collection: collection,
+		hashChain:  config.AuditHashChain,
+	}, nil
+}
+
+// Record appends an event to the audit log, at the current time unless CreatedAt is set
+func (service AuditService) Record(event models.AuditEvent) error {
+	event.Id = primitive.NewObjectID()
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+	// Times are stored with millisecond precision, and hashed as they are stored
+	event.CreatedAt = event.CreatedAt.UTC().Truncate(time.Millisecond)
+
+	if !service.hashChain {
+		if _, err := service.collection.InsertOne(service.ctx, event); err != nil {
+			return fmt.Errorf("record audit event %s: %w", event.Action, err)
+		}
+		return nil
+	}
+
+	for range maxAuditChainAttempts {
+		head, err := service.head()
+		if err != nil {
+			return fmt.Errorf("record audit event %s: %w", event.Action, err)
+		}
+
+		event.Seq = head.Seq + 1
+		event.PrevHash = head.Hash
+		event.Hash = auditHash(event)
+
+		_, err = service.collection.InsertOne(service.ctx, event)
+		if err == nil {
+			return nil
+		}
+		// Another event took the sequence number, so the event is chained to that one instead
+		if !mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("record audit event %s: %w", event.Action, err)
+		}
+	}
+
+	return fmt.Errorf("record audit event %s: too many concurrent events", event.Action)
+}
+
+// VerifyChain recomputes the hash chain of the audit log from its first event and reports the first event
+// that was changed, or that follows deleted events
+func (service AuditService) VerifyChain() (*models.AuditChainResponse, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "seq", Value: 1}})
+
+	cursor, err := service.collection.Find(service.ctx, bson.M{"seq": bson.M{"$exists": true}}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("verify audit chain: %w", err)
+	}
+	defer cursor.Close(service.ctx)
+
+	result := models.AuditChainResponse{Valid: true}
+	for cursor.Next(service.ctx) {
+		var event models.AuditEvent
+		if err := cursor.Decode(&event); err != nil {
+			return nil, fmt.Errorf("verify audit chain: %w", err)
+		}
+
+		reason := ""
+		switch {
+		case event.Seq != result.HeadSeq+1:
+			reason = models.SeqGapReason
+		case event.PrevHash != result.HeadHash:
+			reason = models.PrevHashReason
+		case event.Hash != auditHash(event):
+			reason = models.HashMismatchReason
+		}
+		if reason != "" {
+			result.Valid = false
+			result.BrokenAt = event.Id.Hex()
+			result.Reason = reason
+			return &result, nil
+		}
+
+		result.Events++
+		result.HeadSeq = event.Seq
+		result.HeadHash = event.Hash
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("verify audit chain: %w", err)
+	}
+
+	return &result, nil
+}
+
+// head returns the last chained event, or an empty event if there is none
+func (service AuditService) head() (models.AuditEvent, error) {
+	opts := options.FindOne().SetSort(bson.D{{Key: "seq", Value: -1}})
+
+	var head models.AuditEvent
+	err := service.collection.FindOne(service.ctx, bson.M{"seq": bson.M{"$exists": true}}, opts).Decode(&head)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return models.AuditEvent{}, err
+	}
+
+	return head, nil
+}
+
+// auditHash returns the hex SHA-256 of the JSON of the fields of an event, including the hash of the event before it.
+// Map keys are sorted in JSON, so the hash does not depend on the order details are stored in.
+func auditHash(event models.AuditEvent) string {
+	details := event.Details
+	if len(details) == 0 {
+		details = nil
+	}
+
+	// Marshaling strings, string maps and times cannot fail
+	data, _ := json.Marshal(struct {
+		Id        string            `json:"id"`
+		Seq       int64             `json:"seq"`
+		PrevHash  string            `json:"prevHash"`
+		Action    string            `json:"action"`
+		ActorId   string            `json:"actorId"`
+		ActorRole string            `json:"actorRole"`
+		Uid       string            `json:"uid"`
+		IP        string            `json:"ip"`
+		UserAgent string            `json:"userAgent"`
+		Details   map[string]string `json:"details"`
+		CreatedAt time.Time         `json:"createdAt"`
+	}{
+		Id:        event.Id.Hex(),
+		Seq:       event.Seq,
+		PrevHash:  event.PrevHash,
+		Action:    string(event.Action),
+		ActorId:   event.ActorId,
+		ActorRole: event.ActorRole,
+		Uid:       event.Uid,
+		IP:        event.IP,
+		UserAgent: event.UserAgent,
+		Details:   details,
+		CreatedAt: event.CreatedAt.UTC(),
+	})
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// List retrieves the events of the audit log matching the request, newest first.
+// Returns ErrInvalidAuditQuery if the range or the before cursor cannot be parsed.
+func (service AuditService) List(req models.AuditLogRequest) (*models.AuditLogResponse, error) {
+	filter := bson.M{}
+	if req.Uid != "" {
+		filter["uid"] = req.Uid
+	}
+	if req.ActorId != "" {
+		filter["actorId"] = req.ActorId
+	}
+	if req.Action != "" {
+		filter["action"] = req.Action
+	}
+
+	createdAt := bson.M{}
+	if req.From != "" {
+		from, _, err := parseUsageTime(req.From)
+		if err != nil {
+			return nil, ErrInvalidAuditQuery
+		}
+		createdAt["$gte"] = from
+	}
+	if req.To != "" {
+		to, isDate, err := parseUsageTime(req.To)
+		if err != nil {
+			return nil, ErrInvalidAuditQuery
+		}
+		if isDate {
+			to = to.AddDate(0, 0, 1)
+		}
+		createdAt["$lt"] = to
+	}
+	if len(createdAt) > 0 {
+		filter["createdAt"] = createdAt
+	}
+
+	if req.Before != "" {
+		before, err := primitive.ObjectIDFromHex(req.Before)
+		if err != nil {
+			return nil, ErrInvalidAuditQuery
+		}
+		filter["_id"] = bson.M{"$lt": before}
+	}
+
+	limit := req.Limit
+	if limit <= 0 {
+		limit = defaultAuditLogLimit
+	}
+	limit = min(limit, maxAuditLogLimit)
+
+	// One more event than requested tells whether there is a next page
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetLimit(limit + 1)
+
+	cursor, err := service.collection.Find(service.ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("list audit log: %w", err)
+	}
+
+	events := []models.AuditEvent{}
+	if err := cursor.All(service.ctx, &events); err != nil {
+		return nil, fmt.Errorf("list audit log: %w", err)
+	}
+
+	result := models.AuditLogResponse{Events: events}
+	if int64(len(events)) > limit {
+		result.Events = events[:limit]
+		result.NextBefore = result.Events[limit-1].Id.Hex()
+	}
+
+	return &result, nil
+}
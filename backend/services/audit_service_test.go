@@ -0,0 +1,179 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/AkifhanIlgaz/dictionary-api/config"
+	"github.com/AkifhanIlgaz/dictionary-api/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func testAuditEvent() models.AuditEvent {
+	return models.AuditEvent{
+		Id:        primitive.NewObjectID(),
+		Seq:       2,
+		PrevHash:  "previous",
+		Action:    models.LoginAction,
+		ActorId:   "actor",
+		ActorRole: "user",
+		Uid:       "uid",
+		IP:        "203.0.113.1",
+		UserAgent: "test",
+		Details:   map[string]string{"method": "password", "keyName": "ci"},
+		CreatedAt: time.Date(2026, 1, 2, 3, 4, 5, 6_000_000, time.UTC),
+	}
+}
+
+func TestAuditHash(t *testing.T) {
+	event := testAuditEvent()
+	hash := auditHash(event)
+
+	if len(hash) != 64 {
+		t.Fatalf("auditHash() = %q, want a hex SHA-256", hash)
+	}
+
+	// Details are hashed in key order and times in UTC, so a decoded event hashes like the recorded one
+	same := event
+	same.Details = map[string]string{"keyName": "ci", "method": "password"}
+	same.CreatedAt = event.CreatedAt.In(time.FixedZone("UTC+3", 3*60*60))
+	same.Hash = "the stored hash is not hashed"
+	if auditHash(same) != hash {
+		t.Error("auditHash() differs for the same event")
+	}
+
+	empty, none := event, event
+	empty.Details = map[string]string{}
+	none.Details = nil
+	if auditHash(empty) != auditHash(none) {
+		t.Error("auditHash() differs for empty and missing details")
+	}
+
+	changes := map[string]func(event *models.AuditEvent){
+		"id":        func(event *models.AuditEvent) { event.Id = primitive.NewObjectID() },
+		"seq":       func(event *models.AuditEvent) { event.Seq++ },
+		"prevHash":  func(event *models.AuditEvent) { event.PrevHash = "other" },
+		"action":    func(event *models.AuditEvent) { event.Action = models.LoginFailedAction },
+		"actorId":   func(event *models.AuditEvent) { event.ActorId = "other" },
+		"actorRole": func(event *models.AuditEvent) { event.ActorRole = "admin" },
+		"uid":       func(event *models.AuditEvent) { event.Uid = "other" },
+		"ip":        func(event *models.AuditEvent) { event.IP = "203.0.113.2" },
+		"userAgent": func(event *models.AuditEvent) { event.UserAgent = "other" },
+		"details":   func(event *models.AuditEvent) { event.Details = map[string]string{"method": "oauth"} },
+		"createdAt": func(event *models.AuditEvent) { event.CreatedAt = event.CreatedAt.Add(time.Millisecond) },
+	}
+	for field, change := range changes {
+		changed := testAuditEvent()
+		changed.Id = event.Id
+		change(&changed)
+		if auditHash(changed) == hash {
+			t.Errorf("auditHash() does not change with the %s", field)
+		}
+	}
+}
+
+// newTestAuditService returns an AuditService with hash chaining on a fresh MongoDB database
+func newTestAuditService(t *testing.T) AuditService {
+	t.Helper()
+
+	service, err := NewAuditService(context.Background(), newTestDatabase(t), config.Config{AuditHashChain: true})
+	if err != nil {
+		t.Fatalf("NewAuditService: %v", err)
+	}
+
+	return service
+}
+
+// recordTestEvents records count login events and returns them in the order of the chain
+func recordTestEvents(t *testing.T, service AuditService, count int) []models.AuditEvent {
+	t.Helper()
+
+	uid := randomSubject()
+	for i := 0; i < count; i++ {
+		if err := service.Record(models.AuditEvent{Action: models.LoginAction, Uid: uid, Details: map[string]string{"method": "password"}}); err != nil {
+			t.Fatalf("Record: %v", err)
+		}
+	}
+
+	cursor, err := service.collection.Find(context.Background(), bson.M{}, options.Find().SetSort(bson.D{{Key: "seq", Value: 1}}))
+	if err != nil {
+		t.Fatalf("find events: %v", err)
+	}
+	var events []models.AuditEvent
+	if err := cursor.All(context.Background(), &events); err != nil {
+		t.Fatalf("find events: %v", err)
+	}
+
+	return events
+}
+
+func TestAuditChain(t *testing.T) {
+	service := newTestAuditService(t)
+	events := recordTestEvents(t, service, 3)
+
+	for i, event := range events {
+		if event.Seq != int64(i+1) {
+			t.Errorf("event %d has seq %d", i, event.Seq)
+		}
+		if i > 0 && event.PrevHash != events[i-1].Hash {
+			t.Errorf("event %d is not chained to the event before it", i)
+		}
+	}
+
+	result, err := service.VerifyChain()
+	if err != nil {
+		t.Fatalf("VerifyChain: %v", err)
+	}
+	if !result.Valid || result.Events != 3 || result.HeadSeq != 3 || result.HeadHash != events[2].Hash {
+		t.Errorf("VerifyChain() = %+v, want a valid chain of 3 events", result)
+	}
+}
+
+func TestAuditChainDetectsTampering(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(service AuditService, events []models.AuditEvent) error
+		want   string
+	}{
+		{"changed event", func(service AuditService, events []models.AuditEvent) error {
+			_, err := service.collection.UpdateByID(context.Background(), events[1].Id, bson.M{"$set": bson.M{"details.method": "oauth"}})
+			return err
+		}, models.HashMismatchReason},
+		{"deleted event", func(service AuditService, events []models.AuditEvent) error {
+			_, err := service.collection.DeleteOne(context.Background(), bson.M{"_id": events[1].Id})
+			return err
+		}, models.SeqGapReason},
+		{"rehashed event", func(service AuditService, events []models.AuditEvent) error {
+			// Recomputing the hash of a changed event still breaks the link of the event after it
+			event := events[1]
+			event.Details = map[string]string{"method": "oauth"}
+			_, err := service.collection.UpdateByID(context.Background(), event.Id, bson.M{"$set": bson.M{
+				"details": event.Details,
+				"hash":    auditHash(event),
+			}})
+			return err
+		}, models.PrevHashReason},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			service := newTestAuditService(t)
+			events := recordTestEvents(t, service, 3)
+
+			if err := test.tamper(service, events); err != nil {
+				t.Fatalf("tamper: %v", err)
+			}
+
+			result, err := service.VerifyChain()
+			if err != nil {
+				t.Fatalf("VerifyChain: %v", err)
+			}
+			if result.Valid || result.Reason != test.want {
+				t.Errorf("VerifyChain() = %+v, want a chain broken by %s", result, test.want)
+			}
+		})
+	}
+}
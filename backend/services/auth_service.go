@@ -4,29 +4,63 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"time"
 
 	"github.com/AkifhanIlgaz/dictionary-api/models"
 	"github.com/AkifhanIlgaz/dictionary-api/utils/crypto"
 	"github.com/AkifhanIlgaz/dictionary-api/utils/db"
 	"github.com/AkifhanIlgaz/dictionary-api/utils/message"
 	plan "github.com/AkifhanIlgaz/dictionary-api/utils/plans"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/rbac"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// ErrInvalidCredentials is returned for both unknown emails and wrong passwords
+// so that login responses do not reveal which accounts exist.
+var ErrInvalidCredentials = errors.New(message.InvalidCredentials)
+
+var ErrInvalidTimezone = errors.New(message.InvalidTimezone)
+
+var (
+	ErrInvalidOverageMode  = errors.New(message.InvalidOverageMode)
+	ErrInvalidSoftCap      = errors.New(message.InvalidSoftCap)
+	ErrOverageNotAvailable = errors.New(message.OverageNotAvailable)
+)
+
+var ErrInvalidAlertThresholds = errors.New(message.InvalidAlertThresholds)
+
+// ErrAccountSuspended is returned instead of tokens for suspended users
+var ErrAccountSuspended = errors.New(message.AccountSuspended)
+
+const (
+	defaultUserSearchLimit int64 = 20
+	maxUserSearchLimit     int64 = 100
+)
+
+// dummyPasswordHash is compared against when the email is unknown,
+// so that the response time does not reveal whether the account exists.
+var dummyPasswordHash, _ = crypto.HashPassword("dummy-password-for-timing")
+
 // AuthService handles user authentication operations using MongoDB
 type AuthService struct {
 	ctx        context.Context
 	collection *mongo.Collection
+	plans      *plan.Registry
 }
 
-// NewAuthService creates a new AuthService instance and initializes a unique index on the email field.
-// It returns an error if the index creation fails.
-func NewAuthService(ctx context.Context, mongodb *mongo.Database) (AuthService, error) {
+// NewAuthService creates a new AuthService instance.
+// New users are put on the default plan of the registry.
+func NewAuthService(ctx context.Context, mongodb *mongo.Database, plans *plan.Registry) (AuthService, error) {
 	return AuthService{
 		ctx:        ctx,
 		collection: mongodb.Collection(db.UsersCollection),
+		plans:      plans,
 	}, nil
 }
 
@@ -42,7 +76,8 @@ func (service AuthService) Create(req models.AuthRequest) (primitive.ObjectID, e
 
 	userToCreate := models.User{
 		Email:        req.Email,
-		Plan:         string(plan.FreePlan),
+		Plan:         string(service.plans.Default().Type),
+		Role:         string(rbac.UserRole),
 		PasswordHash: passwordHash,
 	}
 
@@ -54,10 +89,92 @@ func (service AuthService) Create(req models.AuthRequest) (primitive.ObjectID, e
 	return result.InsertedID.(primitive.ObjectID), nil
 }
 
+// CreateExternal registers a new user signing up through an external identity provider with a verified email.
+// The user has no password and can only log in through a linked identity until one is set.
+// Returns the created user's ObjectID or an error if the operation fails.
+func (service AuthService) CreateExternal(email string) (primitive.ObjectID, error) {
+	userToCreate := models.User{
+		Email:         email,
+		EmailVerified: true,
+		Plan:          string(service.plans.Default().Type),
+		Role:          string(rbac.UserRole),
+	}
+
+	result, err := service.collection.InsertOne(service.ctx, userToCreate)
+	if err != nil {
+		return primitive.NilObjectID, fmt.Errorf("create external user: %w", err)
+	}
+
+	return result.InsertedID.(primitive.ObjectID), nil
+}
+
+// GetByEmail retrieves a user by email.
+// Returns mongo.ErrNoDocuments wrapped in the error if no user has that email.
+func (service AuthService) GetByEmail(email string) (models.User, error) {
+	filter := bson.M{
+		"email": email,
+	}
+
+	var user models.User
+	err := service.collection.FindOne(service.ctx, filter).Decode(&user)
+	if err != nil {
+		return models.User{}, fmt.Errorf("get user by email: %w", err)
+	}
+
+	return user, nil
+}
+
+// GetById retrieves a user by ID
+func (service AuthService) GetById(uid string) (models.User, error) {
+	objectId, err := primitive.ObjectIDFromHex(uid)
+	if err != nil {
+		return models.User{}, fmt.Errorf("invalid user id: %w", err)
+	}
+
+	filter := bson.M{
+		"_id": objectId,
+	}
+
+	var user models.User
+	err = service.collection.FindOne(service.ctx, filter).Decode(&user)
+	if err != nil {
+		return models.User{}, fmt.Errorf("get user by id: %w", err)
+	}
+
+	return user, nil
+}
+
+// SearchByEmail retrieves the users whose email starts with the given query, case insensitively, sorted by email.
+// At most limit users are returned, 20 if it is not positive.
+func (service AuthService) SearchByEmail(query string, limit int64) ([]models.User, error) {
+	if limit <= 0 {
+		limit = defaultUserSearchLimit
+	}
+
+	filter := bson.M{
+		"email": primitive.Regex{Pattern: "^" + regexp.QuoteMeta(query), Options: "i"},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "email", Value: 1}}).SetLimit(min(limit, maxUserSearchLimit))
+
+	cursor, err := service.collection.Find(service.ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("search users by email: %w", err)
+	}
+
+	users := []models.User{}
+	if err := cursor.All(service.ctx, &users); err != nil {
+		return nil, fmt.Errorf("search users by email: %w", err)
+	}
+
+	return users, nil
+}
+
 // AuthenticateUser verifies user credentials against stored data.
 // It takes an AuthRequest containing email and password, finds the user by email,
 // and verifies the password hash.
-// Returns the user's ObjectID if authentication succeeds, or an error if credentials are invalid.
+// Returns the user's ObjectID if authentication succeeds, or ErrInvalidCredentials if the email
+// is unknown or the password is wrong. Unknown emails still go through a password comparison
+// so both cases take the same time.
 func (service AuthService) AuthenticateUser(req models.AuthRequest) (primitive.ObjectID, error) {
 	filter := bson.M{
 		"email": req.Email,
@@ -67,22 +184,33 @@ func (service AuthService) AuthenticateUser(req models.AuthRequest) (primitive.O
 
 	err := service.collection.FindOne(service.ctx, filter).Decode(&user)
 	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			crypto.VerifyPassword(dummyPasswordHash, req.Password)
+			return primitive.NilObjectID, ErrInvalidCredentials
+		}
 		return primitive.NilObjectID, fmt.Errorf("authenticate user: %w", err)
 	}
 
+	// Users created through an external identity provider have no password
+	if user.PasswordHash == "" {
+		crypto.VerifyPassword(dummyPasswordHash, req.Password)
+		return primitive.NilObjectID, ErrInvalidCredentials
+	}
+
 	if err := crypto.VerifyPassword(user.PasswordHash, req.Password); err != nil {
-		return primitive.NilObjectID, errors.New(message.WrongPassword)
+		return primitive.NilObjectID, ErrInvalidCredentials
 	}
 
 	return user.Id, nil
 }
 
-// GetUserPlan retrieves the subscription plan for a given user ID.
-// Returns the plan type (e.g. "free", "pro") or an error if the user is not found.
-func (service AuthService) GetUserPlan(uid string) (string, error) {
+// GetUserPlan retrieves the subscription plan for a given user ID from the plan registry.
+// Users with a missing or unknown plan are on the default plan.
+// Returns an error if the user is not found.
+func (service AuthService) GetUserPlan(uid string) (plan.Plan, error) {
 	objectId, err := primitive.ObjectIDFromHex(uid)
 	if err != nil {
-		return "", fmt.Errorf("invalid user id: %w", err)
+		return plan.Plan{}, fmt.Errorf("invalid user id: %w", err)
 	}
 
 	filter := bson.M{
@@ -92,61 +220,382 @@ func (service AuthService) GetUserPlan(uid string) (string, error) {
 	var user models.User
 	err = service.collection.FindOne(service.ctx, filter).Decode(&user)
 	if err != nil {
-		return "", fmt.Errorf("get user plan: %w", err)
+		return plan.Plan{}, fmt.Errorf("get user plan: %w", err)
+	}
+
+	return service.plans.Resolve(user.Plan), nil
+}
+
+// GetUserQuota retrieves the subscription plan of a given user ID, the billing timezone its quota windows start in
+// and the overage policy in effect on the plan
+func (service AuthService) GetUserQuota(uid string, now time.Time) (models.UserQuota, error) {
+	user, err := service.GetById(uid)
+	if err != nil {
+		return models.UserQuota{}, fmt.Errorf("get user quota: %w", err)
 	}
 
-	return user.Plan, nil
+	userPlan := service.plans.Resolve(user.Plan)
+
+	return models.UserQuota{
+		Plan:            userPlan,
+		Location:        user.BillingLocation(now),
+		Overage:         effectiveOveragePolicy(user.OveragePolicy, userPlan),
+		AlertThresholds: user.QuotaAlerts.Thresholds,
+	}, nil
 }
 
-// UpgradePlan upgrades a user's subscription plan to "pro".
-// It takes a user ID string, converts it to an ObjectID, and updates the user's plan in the database.
-// Returns an error if the user ID is invalid or if the database update fails.
-func (service AuthService) UpgradePlan(uid string) error {
+// SetOveragePolicy changes what happens to the requests of a given user ID over the quota, from the next request.
+// Requests can only be allowed over the quota on plans with an overage price, the soft cap defaults to
+// models.DefaultSoftCapPercent. Returns ErrOverageNotAvailable on other plans.
+func (service AuthService) SetOveragePolicy(uid string, req models.SetOveragePolicyRequest) (*models.OveragePolicy, error) {
+	policy := models.OveragePolicy{Mode: models.OverageMode(req.Mode)}
+	if !policy.Mode.IsValid() {
+		return nil, ErrInvalidOverageMode
+	}
+
+	if policy.Mode == models.SoftCapOverage {
+		policy.SoftCapPercent = req.SoftCapPercent
+		if policy.SoftCapPercent == 0 {
+			policy.SoftCapPercent = models.DefaultSoftCapPercent
+		}
+		if policy.SoftCapPercent < 1 || policy.SoftCapPercent > models.MaxSoftCapPercent {
+			return nil, ErrInvalidSoftCap
+		}
+	}
+
+	userPlan, err := service.GetUserPlan(uid)
+	if err != nil {
+		return nil, fmt.Errorf("set overage policy: %w", err)
+	}
+	if policy.Mode != models.BlockOverage && userPlan.OverageAmount() == 0 {
+		return nil, ErrOverageNotAvailable
+	}
+
 	objectId, err := primitive.ObjectIDFromHex(uid)
 	if err != nil {
-		return fmt.Errorf("invalid user id: %w", err)
+		return nil, fmt.Errorf("invalid user id: %w", err)
 	}
 
-	filter := bson.M{
-		"_id": objectId,
+	if _, err := service.collection.UpdateByID(service.ctx, objectId, bson.M{"$set": bson.M{"overagePolicy": policy}}); err != nil {
+		return nil, fmt.Errorf("set overage policy: %w", err)
+	}
+
+	return &policy, nil
+}
+
+// GetOveragePolicy retrieves the overage policy in effect for a given user ID on their current plan
+func (service AuthService) GetOveragePolicy(uid string) (models.OveragePolicy, plan.Plan, error) {
+	user, err := service.GetById(uid)
+	if err != nil {
+		return models.OveragePolicy{}, plan.Plan{}, fmt.Errorf("get overage policy: %w", err)
+	}
+
+	userPlan := service.plans.Resolve(user.Plan)
+
+	return effectiveOveragePolicy(user.OveragePolicy, userPlan), userPlan, nil
+}
+
+// effectiveOveragePolicy returns the stored policy of a user, or blocking if the user has none
+// or their plan has no overage price, e.g. after a downgrade
+func effectiveOveragePolicy(policy models.OveragePolicy, userPlan plan.Plan) models.OveragePolicy {
+	if !policy.Mode.IsValid() || userPlan.OverageAmount() == 0 {
+		return models.OveragePolicy{Mode: models.BlockOverage}
+	}
+
+	return policy
+}
+
+// GetQuotaAlerts retrieves the quota alert settings of a given user ID
+func (service AuthService) GetQuotaAlerts(uid string) (models.QuotaAlertSettings, error) {
+	user, err := service.GetById(uid)
+	if err != nil {
+		return models.QuotaAlertSettings{}, fmt.Errorf("get quota alerts: %w", err)
+	}
+
+	if user.QuotaAlerts.Thresholds == nil {
+		user.QuotaAlerts.Thresholds = []int{}
+	}
+
+	return user.QuotaAlerts, nil
+}
+
+// SetQuotaAlerts replaces the quota alert settings of a given user ID.
+// Thresholds are percentages of the quota from 1 to models.MaxAlertThreshold.
+func (service AuthService) SetQuotaAlerts(uid string, req models.SetQuotaAlertsRequest) (*models.QuotaAlertSettings, error) {
+	if len(req.Thresholds) > models.MaxAlertThresholds {
+		return nil, ErrInvalidAlertThresholds
+	}
+
+	thresholds := []int{}
+	for _, threshold := range req.Thresholds {
+		if threshold < 1 || threshold > models.MaxAlertThreshold {
+			return nil, ErrInvalidAlertThresholds
+		}
+		if !slices.Contains(thresholds, threshold) {
+			thresholds = append(thresholds, threshold)
+		}
+	}
+	slices.Sort(thresholds)
+
+	objectId, err := primitive.ObjectIDFromHex(uid)
+	if err != nil {
+		return nil, fmt.Errorf("invalid user id: %w", err)
+	}
+
+	settings := models.QuotaAlertSettings{
+		Thresholds: thresholds,
+		Email:      req.Email,
+	}
+
+	if _, err := service.collection.UpdateByID(service.ctx, objectId, bson.M{"$set": bson.M{"quotaAlerts": settings}}); err != nil {
+		return nil, fmt.Errorf("set quota alerts: %w", err)
+	}
+
+	return &settings, nil
+}
+
+// SetBillingTimezone changes the billing timezone of a given user ID.
+// The change applies when the current quota window ends, so that changing the timezone cannot open a fresh window.
+// Returns ErrInvalidTimezone if the timezone is not a known IANA time zone.
+func (service AuthService) SetBillingTimezone(uid string, timezone string, now time.Time) (*models.BillingTimezoneResponse, error) {
+	loc, err := time.LoadLocation(timezone)
+	if err != nil || timezone == "" || timezone == "Local" {
+		return nil, ErrInvalidTimezone
+	}
+
+	user, err := service.GetById(uid)
+	if err != nil {
+		return nil, fmt.Errorf("set billing timezone: %w", err)
+	}
+
+	current := user.BillingLocation(now)
+	_, end := service.plans.Resolve(user.Plan).QuotaWindow.Bounds(now, current)
+
+	update := bson.M{
+		"$set":   bson.M{"billingTimezone": current.String()},
+		"$unset": bson.M{"pendingBillingTimezone": ""},
+	}
+	result := models.BillingTimezoneResponse{Timezone: current.String(), EffectiveAt: now}
+
+	if loc.String() != current.String() {
+		pending := models.PendingBillingTimezone{Timezone: loc.String(), EffectiveAt: end}
+		update = bson.M{
+			"$set": bson.M{"billingTimezone": current.String(), "pendingBillingTimezone": pending},
+		}
+		result = models.BillingTimezoneResponse{Timezone: pending.Timezone, EffectiveAt: pending.EffectiveAt}
+	}
+
+	if _, err := service.collection.UpdateByID(service.ctx, user.Id, update); err != nil {
+		return nil, fmt.Errorf("set billing timezone: %w", err)
+	}
+
+	return &result, nil
+}
+
+// GetUserRole retrieves the role of a given user ID.
+// Users created before roles existed are returned as rbac.UserRole.
+func (service AuthService) GetUserRole(uid string) (rbac.Role, error) {
+	user, err := service.GetById(uid)
+	if err != nil {
+		return "", fmt.Errorf("get user role: %w", err)
+	}
+
+	return rbac.Parse(user.Role), nil
+}
+
+// SetRole changes the role of a given user ID.
+// Returns mongo.ErrNoDocuments wrapped in the error if the user does not exist.
+func (service AuthService) SetRole(uid string, role rbac.Role) error {
+	objectId, err := primitive.ObjectIDFromHex(uid)
+	if err != nil {
+		return fmt.Errorf("invalid user id: %w", err)
 	}
 
 	update := bson.M{
 		"$set": bson.M{
-			"plan": "pro",
+			"role": role,
 		},
 	}
 
-	_, err = service.collection.UpdateOne(service.ctx, filter, update)
+	result, err := service.collection.UpdateByID(service.ctx, objectId, update)
 	if err != nil {
-		return fmt.Errorf("upgrade plan: %w", err)
+		return fmt.Errorf("set role: %w", err)
+	}
+
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("set role: %w", mongo.ErrNoDocuments)
 	}
 
 	return nil
 }
 
-// DowngradePlan downgrades a user's subscription plan to "free".
-// It takes a user ID string, converts it to an ObjectID, and updates the user's plan in the database.
-// Returns an error if the user ID is invalid or if the database update fails.
-func (service AuthService) DowngradePlan(uid string) error {
-	objectId, err := primitive.ObjectIDFromHex(uid)
+// Suspend suspends a given user ID, or replaces the reason of a suspended user.
+// Returns mongo.ErrNoDocuments wrapped in the error if the user does not exist.
+func (service AuthService) Suspend(uid string, suspension models.Suspension) error {
+	return service.updateUser(uid, "suspend user", bson.M{"$set": bson.M{"suspension": suspension}})
+}
+
+// Unsuspend lifts the suspension of a given user ID, if any.
+// Returns mongo.ErrNoDocuments wrapped in the error if the user does not exist.
+func (service AuthService) Unsuspend(uid string) error {
+	return service.updateUser(uid, "unsuspend user", bson.M{"$unset": bson.M{"suspension": ""}})
+}
+
+// SetPlan moves a given user ID to any plan of the registry, regardless of its tier, and returns the new plan and
+// the plan the user was on. It is meant for admins: the plans of users with a subscription follow the subscription instead.
+// Returns ErrUnknownPlan if the plan is not in the registry.
+func (service AuthService) SetPlan(uid string, planType plan.PlanType) (plan.Plan, plan.PlanType, error) {
+	target, ok := service.plans.Get(planType)
+	if !ok {
+		return plan.Plan{}, "", ErrUnknownPlan
+	}
+
+	current, err := service.GetUserPlan(uid)
 	if err != nil {
-		return fmt.Errorf("invalid user id: %w", err)
+		return plan.Plan{}, "", fmt.Errorf("set plan: %w", err)
 	}
 
-	filter := bson.M{
-		"_id": objectId,
+	if err := service.setPlan(uid, target.Type); err != nil {
+		return plan.Plan{}, "", err
+	}
+
+	return target, current.Type, nil
+}
+
+// CountByRole returns the number of users with the given role
+func (service AuthService) CountByRole(role rbac.Role) (int64, error) {
+	count, err := service.collection.CountDocuments(service.ctx, bson.M{"role": role})
+	if err != nil {
+		return 0, fmt.Errorf("count users by role: %w", err)
+	}
+
+	return count, nil
+}
+
+// UpgradePlan moves a user to a higher tier plan.
+// Returns an error if the user ID is invalid, the target plan is not a higher tier or the database update fails.
+func (service AuthService) UpgradePlan(uid string, targetPlan plan.PlanType) error {
+	currentPlan, err := service.GetUserPlan(uid)
+	if err != nil {
+		return fmt.Errorf("upgrade plan: %w", err)
+	}
+
+	target, err := service.plans.UpgradePlan(currentPlan.Type, targetPlan)
+	if err != nil {
+		return fmt.Errorf("upgrade plan: %w", err)
+	}
+
+	return service.setPlan(uid, target.Type)
+}
+
+// DowngradePlan moves a user to a lower tier plan.
+// Returns an error if the user ID is invalid, the target plan is not a lower tier or the database update fails.
+func (service AuthService) DowngradePlan(uid string, targetPlan plan.PlanType) error {
+	currentPlan, err := service.GetUserPlan(uid)
+	if err != nil {
+		return fmt.Errorf("downgrade plan: %w", err)
+	}
+
+	target, err := service.plans.DowngradePlan(currentPlan.Type, targetPlan)
+	if err != nil {
+		return fmt.Errorf("downgrade plan: %w", err)
+	}
+
+	return service.setPlan(uid, target.Type)
+}
+
+// NormalizePlans rewrites the plan stored on every user to its canonical plan ID,
+// e.g. "Free" to "free", and sets the default plan on users without one.
+// Unknown plans are logged and left unchanged. Returns the number of updated users.
+func (service AuthService) NormalizePlans() (int, error) {
+	missing := bson.M{
+		"$or": bson.A{
+			bson.M{"plan": bson.M{"$exists": false}},
+			bson.M{"plan": nil},
+			bson.M{"plan": ""},
+		},
+	}
+
+	result, err := service.collection.UpdateMany(service.ctx, missing, bson.M{
+		"$set": bson.M{"plan": service.plans.Default().Type},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("normalize plans: %w", err)
+	}
+	updated := int(result.ModifiedCount)
+
+	storedPlans, err := service.collection.Distinct(service.ctx, "plan", bson.M{})
+	if err != nil {
+		return 0, fmt.Errorf("normalize plans: %w", err)
+	}
+
+	for _, value := range storedPlans {
+		stored, ok := value.(string)
+		if !ok {
+			log.Printf("normalize plans: unknown plan %v\n", value)
+			continue
+		}
+
+		canonical, ok := service.plans.Normalize(stored)
+		if !ok {
+			log.Printf("normalize plans: unknown plan %q\n", stored)
+			continue
+		}
+		if string(canonical) == stored {
+			continue
+		}
+
+		result, err := service.collection.UpdateMany(service.ctx, bson.M{"plan": stored}, bson.M{
+			"$set": bson.M{"plan": canonical},
+		})
+		if err != nil {
+			return 0, fmt.Errorf("normalize plan %q: %w", stored, err)
+		}
+		updated += int(result.ModifiedCount)
+	}
+
+	return updated, nil
+}
+
+func (service AuthService) setPlan(uid string, planType plan.PlanType) error {
+	objectId, err := primitive.ObjectIDFromHex(uid)
+	if err != nil {
+		return fmt.Errorf("invalid user id: %w", err)
 	}
 
 	update := bson.M{
 		"$set": bson.M{
-			"plan": "free",
+			"plan": planType,
 		},
 	}
 
-	_, err = service.collection.UpdateOne(service.ctx, filter, update)
+	result, err := service.collection.UpdateByID(service.ctx, objectId, update)
 	if err != nil {
-		return fmt.Errorf("downgrade plan: %w", err)
+		return fmt.Errorf("set plan: %w", err)
+	}
+
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("set plan: %w", mongo.ErrNoDocuments)
+	}
+
+	return nil
+}
+
+// updateUser applies an update to a given user ID.
+// Returns mongo.ErrNoDocuments wrapped in the error if the user does not exist.
+func (service AuthService) updateUser(uid string, operation string, update bson.M) error {
+	objectId, err := primitive.ObjectIDFromHex(uid)
+	if err != nil {
+		return fmt.Errorf("invalid user id: %w", err)
+	}
+
+	result, err := service.collection.UpdateByID(service.ctx, objectId, update)
+	if err != nil {
+		return fmt.Errorf("%s: %w", operation, err)
+	}
+
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("%s: %w", operation, mongo.ErrNoDocuments)
 	}
 
 	return nil
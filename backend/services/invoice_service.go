@@ -0,0 +1,519 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AkifhanIlgaz/dictionary-api/config"
+	"github.com/AkifhanIlgaz/dictionary-api/models"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/db"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/message"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/payment"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/pdf"
+	plan "github.com/AkifhanIlgaz/dictionary-api/utils/plans"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultInvoiceNumberPrefix string = "INV"
+	invoiceCounter             string = "invoice"
+	invoiceDateLayout          string = "2006-01-02"
+)
+
+var ErrInvoiceNotFound = errors.New(message.InvoiceNotFound)
+
+// InvoiceService issues an invoice for every closed period of a subscription.
+// Invoices are numbered sequentially over all users and never change once issued.
+type InvoiceService struct {
+	ctx               context.Context
+	collection        *mongo.Collection
+	itemCollection    *mongo.Collection
+	counterCollection *mongo.Collection
+	overageCollection *mongo.Collection
+	authService       AuthService
+	usageService      UsageService
+	plans             *plan.Registry
+	numberPrefix      string
+	taxName           string
+	taxRate           float64
+	seller            models.InvoiceParty
+}
+
+// NewInvoiceService creates a new InvoiceService instance and initializes the indexes of the
+// invoices and invoice items collections
+func NewInvoiceService(ctx context.Context, mongoDatabase *mongo.Database, authService AuthService, usageService UsageService, plans *plan.Registry, config config.Config) (InvoiceService, error) {
+	collection := mongoDatabase.Collection(db.InvoicesCollection)
+	itemCollection := mongoDatabase.Collection(db.InvoiceItemsCollection)
+
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "number", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			// A period is invoiced once, even if its closing event is redelivered
+			Keys:    bson.D{{Key: "uid", Value: 1}, {Key: "periodStart", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "uid", Value: 1}, {Key: "issuedAt", Value: -1}},
+		},
+	})
+	if err != nil {
+		return InvoiceService{}, fmt.Errorf("initialize invoices collection: %w", err)
+	}
+
+	_, err = itemCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "uid", Value: 1}, {Key: "invoiceId", Value: 1}},
+	})
+	if err != nil {
+		return InvoiceService{}, fmt.Errorf("initialize invoice items collection: %w", err)
+	}
+
+	numberPrefix := config.InvoiceNumberPrefix
+	if numberPrefix == "" {
+		numberPrefix = defaultInvoiceNumberPrefix
+	}
+	taxName := config.InvoiceTaxName
+	if taxName == "" {
+		taxName = "Tax"
+	}
+
+	return InvoiceService{
+		ctx:               ctx,
+		collection:        collection,
+		itemCollection:    itemCollection,
+		counterCollection: mongoDatabase.Collection(db.CountersCollection),
+		overageCollection: mongoDatabase.Collection(db.OverageLedgerCollection),
+		authService:       authService,
+		usageService:      usageService,
+		plans:             plans,
+		numberPrefix:      numberPrefix,
+		taxName:           taxName,
+		taxRate:           config.InvoiceTaxRate,
+		seller: models.InvoiceParty{
+			Name:    config.InvoiceSellerName,
+			Address: config.InvoiceSellerAddress,
+			TaxId:   config.InvoiceSellerTaxId,
+		},
+	}, nil
+}
+
+// AddItem adds a charge, or a credit if the amount is negative, to the next invoice of the user
+func (service InvoiceService) AddItem(uid string, description string, amount int64, at time.Time) error {
+	_, err := service.itemCollection.InsertOne(service.ctx, models.InvoiceItem{
+		Uid:         uid,
+		Description: description,
+		Amount:      amount,
+		CreatedAt:   at,
+	})
+	if err != nil {
+		return fmt.Errorf("add invoice item: %w", err)
+	}
+
+	return nil
+}
+
+// Issue invoices the current period of a subscription when it closes. The invoice has the price of the plan the
+// period started with, which is free for trials, the items added until the end of the period, the overage of the
+// ledger until the end of the period and the charged usage of the period. Periods without anything to bill are
+// not invoiced. Issuing a period again returns its invoice.
+func (service InvoiceService) Issue(subscription models.Subscription, now time.Time) (*models.Invoice, error) {
+	existing, err := service.getByPeriod(subscription.Uid, subscription.CurrentPeriodStart)
+	if err == nil || !errors.Is(err, ErrInvoiceNotFound) {
+		return existing, err
+	}
+
+	user, err := service.authService.GetById(subscription.Uid)
+	if err != nil {
+		return nil, fmt.Errorf("issue invoice: %w", err)
+	}
+
+	usage, err := service.usageService.GetChargedUsage(subscription.Uid, subscription.CurrentPeriodStart, subscription.CurrentPeriodEnd)
+	if err != nil {
+		return nil, fmt.Errorf("issue invoice: %w", err)
+	}
+
+	invoice := models.Invoice{
+		Id:          primitive.NewObjectID(),
+		Uid:         subscription.Uid,
+		Plan:        subscription.PeriodPlan,
+		Seller:      service.seller,
+		Customer:    models.InvoiceParty{Email: user.Email},
+		PeriodStart: subscription.CurrentPeriodStart,
+		PeriodEnd:   subscription.CurrentPeriodEnd,
+		Currency:    subscription.Currency,
+		TaxName:     service.taxName,
+		TaxRate:     service.taxRate,
+		IssuedAt:    now,
+	}
+	if invoice.Plan == "" {
+		// Subscriptions synced before the plan of their period was stored
+		invoice.Plan = subscription.Plan
+	}
+	if invoice.Currency == "" {
+		invoice.Currency = payment.Currency
+	}
+
+	if amount := subscription.PeriodCharge(); amount != 0 {
+		invoice.Lines = append(invoice.Lines, models.InvoiceLine{
+			Description: fmt.Sprintf("%s plan, %s to %s", service.plans.Resolve(string(invoice.Plan)).Name,
+				invoice.PeriodStart.UTC().Format(invoiceDateLayout), invoice.PeriodEnd.UTC().Format(invoiceDateLayout)),
+			Quantity:   1,
+			UnitAmount: amount,
+			Amount:     amount,
+		})
+	}
+
+	// Items and overage are claimed for the invoice before it is inserted, so that they cannot be billed twice
+	_, err = service.itemCollection.UpdateMany(service.ctx,
+		bson.M{"uid": subscription.Uid, "invoiceId": bson.M{"$exists": false}, "createdAt": bson.M{"$lt": subscription.CurrentPeriodEnd}},
+		bson.M{"$set": bson.M{"invoiceId": invoice.Id}})
+	if err != nil {
+		return nil, fmt.Errorf("issue invoice: %w", err)
+	}
+
+	_, err = service.overageCollection.UpdateMany(service.ctx,
+		bson.M{"uid": subscription.Uid, "invoiceId": bson.M{"$exists": false}, "hour": bson.M{"$lt": subscription.CurrentPeriodEnd}},
+		bson.M{"$set": bson.M{"invoiceId": invoice.Id}})
+	if err != nil {
+		service.release(invoice.Id)
+		return nil, fmt.Errorf("issue invoice: %w", err)
+	}
+
+	items, err := service.claimedItems(invoice.Id)
+	if err != nil {
+		service.release(invoice.Id)
+		return nil, fmt.Errorf("issue invoice: %w", err)
+	}
+	for _, item := range items {
+		invoice.Lines = append(invoice.Lines, models.InvoiceLine{
+			Description: item.Description,
+			Quantity:    1,
+			UnitAmount:  item.Amount,
+			Amount:      item.Amount,
+		})
+	}
+
+	invoice.Overage, err = service.overageTotals(bson.M{"invoiceId": invoice.Id})
+	if err != nil {
+		service.release(invoice.Id)
+		return nil, fmt.Errorf("issue invoice: %w", err)
+	}
+	for _, total := range invoice.Overage {
+		overagePlan := service.plans.Resolve(string(total.Plan))
+		line := models.InvoiceLine{
+			Description: fmt.Sprintf("%d requests over the %s quota within the soft cap", total.Requests, overagePlan.Name),
+			Quantity:    total.Requests,
+		}
+		if total.Mode == models.BillOverage {
+			line = models.InvoiceLine{
+				Description: fmt.Sprintf("%d requests over the %s quota, per started 1,000", total.Requests, overagePlan.Name),
+				Quantity:    (total.Requests + 999) / 1000,
+				UnitAmount:  overagePlan.OverageAmount(),
+				Amount:      total.Amount,
+			}
+		}
+		invoice.Lines = append(invoice.Lines, line)
+		usage -= total.Requests
+	}
+
+	if len(invoice.Lines) == 0 {
+		return nil, nil
+	}
+
+	invoice.Lines = append(invoice.Lines, models.InvoiceLine{
+		Description: "API requests included in the plan",
+		Quantity:    max(usage, 0),
+	})
+
+	for _, line := range invoice.Lines {
+		invoice.Subtotal += line.Amount
+	}
+	invoice.Tax = int64(math.Round(float64(invoice.Subtotal) * invoice.TaxRate / 100))
+	invoice.Total = invoice.Subtotal + invoice.Tax
+
+	// A number is only lost if inserting the invoice fails
+	number, err := service.nextNumber()
+	if err != nil {
+		service.release(invoice.Id)
+		return nil, fmt.Errorf("issue invoice: %w", err)
+	}
+	invoice.Number = number
+
+	if _, err := service.collection.InsertOne(service.ctx, invoice); err != nil {
+		service.release(invoice.Id)
+		if mongo.IsDuplicateKeyError(err) {
+			return service.getByPeriod(subscription.Uid, subscription.CurrentPeriodStart)
+		}
+		return nil, fmt.Errorf("issue invoice: %w", err)
+	}
+
+	return &invoice, nil
+}
+
+// ListInvoices returns the invoices of a user from the newest to the oldest
+func (service InvoiceService) ListInvoices(uid string) ([]models.Invoice, error) {
+	cursor, err := service.collection.Find(service.ctx, bson.M{"uid": uid}, options.Find().SetSort(bson.D{{Key: "issuedAt", Value: -1}}))
+	if err != nil {
+		return nil, fmt.Errorf("list invoices: %w", err)
+	}
+
+	invoices := []models.Invoice{}
+	if err := cursor.All(service.ctx, &invoices); err != nil {
+		return nil, fmt.Errorf("list invoices: %w", err)
+	}
+
+	return invoices, nil
+}
+
+// GetInvoice returns an invoice of a user.
+// Returns ErrInvoiceNotFound if the ID is invalid or the invoice belongs to another user.
+func (service InvoiceService) GetInvoice(uid string, id string) (*models.Invoice, error) {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrInvoiceNotFound
+	}
+
+	return service.findOne(bson.M{"_id": objectId, "uid": uid})
+}
+
+// RenderPDF renders an invoice as a PDF document
+func (service InvoiceService) RenderPDF(invoice models.Invoice) []byte {
+	const (
+		left     float64 = 50
+		right    float64 = pdf.PageWidth - 50
+		bottom   float64 = pdf.PageHeight - 60
+		quantity float64 = 370
+		unit     float64 = 460
+	)
+
+	document := pdf.New()
+	y := 60.0
+
+	document.Text(left, y, pdf.Bold, 20, "Invoice")
+	document.TextRight(right, y, pdf.Bold, 12, invoice.Number)
+	y += 20
+	document.TextRight(right, y, pdf.Regular, 10, "Issued "+invoice.IssuedAt.UTC().Format(invoiceDateLayout))
+	y += 14
+	document.TextRight(right, y, pdf.Regular, 10, "Period "+invoice.PeriodStart.UTC().Format(invoiceDateLayout)+" to "+invoice.PeriodEnd.UTC().Format(invoiceDateLayout))
+	y += 30
+
+	partyTop := y
+	for i, party := range []models.InvoiceParty{invoice.Seller, invoice.Customer} {
+		x, label := left, "From"
+		if i == 1 {
+			x, label = pdf.PageWidth/2, "Bill to"
+		}
+
+		y = partyTop
+		document.Text(x, y, pdf.Bold, 10, label)
+		for _, line := range partyLines(party) {
+			y += 14
+			document.Text(x, y, pdf.Regular, 10, line)
+		}
+	}
+	y = partyTop + 6*14 + 20
+
+	header := func() {
+		document.Text(left, y, pdf.Bold, 10, "Description")
+		document.TextRight(quantity, y, pdf.Bold, 10, "Quantity")
+		document.TextRight(unit, y, pdf.Bold, 10, "Unit price")
+		document.TextRight(right, y, pdf.Bold, 10, "Amount")
+		y += 6
+		document.Line(left, y, right, y)
+		y += 16
+	}
+	header()
+
+	for _, line := range invoice.Lines {
+		if y > bottom {
+			document.AddPage()
+			y = 60
+			header()
+		}
+
+		document.TextRight(quantity, y, pdf.Regular, 10, strconv.FormatInt(line.Quantity, 10))
+		document.TextRight(unit, y, pdf.Regular, 10, payment.FormatAmount(line.UnitAmount, invoice.Currency))
+		document.TextRight(right, y, pdf.Regular, 10, payment.FormatAmount(line.Amount, invoice.Currency))
+		for _, text := range wrapText(line.Description, quantity-60-left, 10) {
+			document.Text(left, y, pdf.Regular, 10, text)
+			y += 12
+		}
+		y += 6
+	}
+
+	if y > bottom-60 {
+		document.AddPage()
+		y = 60
+	}
+	document.Line(unit-80, y-8, right, y-8)
+	y += 8
+
+	totals := []struct {
+		label  string
+		amount int64
+		font   pdf.Font
+	}{
+		{"Subtotal", invoice.Subtotal, pdf.Regular},
+		{fmt.Sprintf("%s (%s%%)", invoice.TaxName, strconv.FormatFloat(invoice.TaxRate, 'f', -1, 64)), invoice.Tax, pdf.Regular},
+		{"Total", invoice.Total, pdf.Bold},
+	}
+	for _, total := range totals {
+		document.TextRight(unit, y, total.font, 10, total.label)
+		document.TextRight(right, y, total.font, 10, payment.FormatAmount(total.amount, invoice.Currency))
+		y += 16
+	}
+
+	y += 20
+	document.Text(left, y, pdf.Regular, 9, "Paid with your subscription. Thank you for your business.")
+
+	return document.Bytes()
+}
+
+// partyLines returns the lines printed for the seller or the customer of an invoice
+func partyLines(party models.InvoiceParty) []string {
+	var lines []string
+	if party.Name != "" {
+		lines = append(lines, party.Name)
+	}
+	for _, part := range strings.Split(party.Address, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			lines = append(lines, part)
+		}
+	}
+	if party.Email != "" {
+		lines = append(lines, party.Email)
+	}
+	if party.TaxId != "" {
+		lines = append(lines, "Tax ID: "+party.TaxId)
+	}
+
+	return lines
+}
+
+// wrapText splits text into lines of at most width at the given font size, breaking between words
+func wrapText(text string, width float64, size float64) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(text) {
+		if line != "" && pdf.TextWidth(line+" "+word, size) > width {
+			lines = append(lines, line)
+			line = word
+			continue
+		}
+		if line != "" {
+			line += " "
+		}
+		line += word
+	}
+
+	return append(lines, line)
+}
+
+// nextNumber allocates the next invoice number
+func (service InvoiceService) nextNumber() (string, error) {
+	var counter struct {
+		Seq int64 `bson:"seq"`
+	}
+
+	err := service.counterCollection.FindOneAndUpdate(service.ctx,
+		bson.M{"_id": invoiceCounter},
+		bson.M{"$inc": bson.M{"seq": 1}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&counter)
+	if err != nil {
+		return "", fmt.Errorf("next invoice number: %w", err)
+	}
+
+	return fmt.Sprintf("%s-%06d", service.numberPrefix, counter.Seq), nil
+}
+
+func (service InvoiceService) claimedItems(invoiceId primitive.ObjectID) ([]models.InvoiceItem, error) {
+	cursor, err := service.itemCollection.Find(service.ctx, bson.M{"invoiceId": invoiceId}, options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	var items []models.InvoiceItem
+	if err := cursor.All(service.ctx, &items); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// release unclaims the items and overage of an invoice that was not issued, so that the next invoice bills them
+func (service InvoiceService) release(invoiceId primitive.ObjectID) {
+	for _, collection := range []*mongo.Collection{service.itemCollection, service.overageCollection} {
+		if _, err := collection.UpdateMany(service.ctx, bson.M{"invoiceId": invoiceId}, bson.M{"$unset": bson.M{"invoiceId": ""}}); err != nil {
+			log.Println(err.Error())
+		}
+	}
+}
+
+// GetUnbilledOverage returns the overage of a user in the ledger that has not been invoiced yet,
+// with what it will be billed on the next invoice
+func (service InvoiceService) GetUnbilledOverage(uid string) ([]models.OverageTotal, error) {
+	totals, err := service.overageTotals(bson.M{"uid": uid, "invoiceId": bson.M{"$exists": false}})
+	if err != nil {
+		return nil, fmt.Errorf("get unbilled overage: %w", err)
+	}
+
+	return totals, nil
+}
+
+// overageTotals sums the overage ledger entries matching the filter per plan and overage mode.
+// Overage within a soft cap is free.
+func (service InvoiceService) overageTotals(filter bson.M) ([]models.OverageTotal, error) {
+	cursor, err := service.overageCollection.Aggregate(service.ctx, mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$group", Value: bson.M{
+			"_id":      bson.M{"plan": "$plan", "mode": "$mode"},
+			"requests": bson.M{"$sum": "$requests"},
+		}}},
+		{{Key: "$project", Value: bson.M{"_id": 0, "plan": "$_id.plan", "mode": "$_id.mode", "requests": 1}}},
+		{{Key: "$sort", Value: bson.D{{Key: "plan", Value: 1}, {Key: "mode", Value: 1}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	totals := []models.OverageTotal{}
+	if err := cursor.All(service.ctx, &totals); err != nil {
+		return nil, err
+	}
+
+	for i, total := range totals {
+		if total.Mode == models.BillOverage {
+			totals[i].Amount = service.plans.Resolve(string(total.Plan)).OverageCharge(total.Requests)
+		}
+	}
+
+	return totals, nil
+}
+
+func (service InvoiceService) getByPeriod(uid string, periodStart time.Time) (*models.Invoice, error) {
+	return service.findOne(bson.M{"uid": uid, "periodStart": periodStart})
+}
+
+func (service InvoiceService) findOne(filter bson.M) (*models.Invoice, error) {
+	var invoice models.Invoice
+	if err := service.collection.FindOne(service.ctx, filter).Decode(&invoice); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrInvoiceNotFound
+		}
+		return nil, fmt.Errorf("get invoice: %w", err)
+	}
+
+	return &invoice, nil
+}
@@ -0,0 +1,200 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/AkifhanIlgaz/dictionary-api/models"
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	loginFailuresPrefix string = "login_failures:"
+	loginLockPrefix     string = "login_lock:"
+	loginLockoutsPrefix string = "login_lockouts:"
+
+	accountScope string = "account"
+	ipScope      string = "ip"
+	// mfaScope counts wrong MFA codes per user. It is separate from the account scope, whose counter a correct
+	// password resets, so that knowing the password does not allow guessing codes without limit.
+	mfaScope string = "mfa"
+
+	maxAccountFailures int64 = 5
+	maxIPFailures      int64 = 20
+	maxMFAFailures     int64 = 5
+
+	// failureWindow is how long failed attempts are remembered before the counter resets
+	failureWindow time.Duration = 15 * time.Minute
+	// lockoutMemory is how long previous lockouts keep doubling the next lockout duration
+	lockoutMemory time.Duration = 24 * time.Hour
+	baseLockout   time.Duration = time.Minute
+	maxLockout    time.Duration = time.Hour
+)
+
+// LockoutNotifier is called whenever an account or IP address gets locked out
+type LockoutNotifier interface {
+	NotifyLockout(event models.LockoutEvent) error
+}
+
+// LogLockoutNotifier is the default LockoutNotifier which writes lockouts to the standard logger
+type LogLockoutNotifier struct{}
+
+func (LogLockoutNotifier) NotifyLockout(event models.LockoutEvent) error {
+	log.Printf("login lockout: %s %s locked for %s after %d failed attempts", event.Scope, event.Subject, event.Duration, event.Failures)
+	return nil
+}
+
+// LoginAttemptService keeps Redis-backed failed login counters per account and per IP address
+// and locks them out with an exponentially growing duration.
+type LoginAttemptService struct {
+	client   *redis.Client
+	notifier LockoutNotifier
+}
+
+// NewLoginAttemptService creates a new LoginAttemptService instance.
+// The notifier is called every time a lockout is triggered.
+func NewLoginAttemptService(client *redis.Client, notifier LockoutNotifier) LoginAttemptService {
+	return LoginAttemptService{
+		client:   client,
+		notifier: notifier,
+	}
+}
+
+// LockedFor returns the remaining lockout duration for the email or IP address,
+// whichever is longer. Returns zero if neither is locked.
+func (service LoginAttemptService) LockedFor(email string, ip string) (time.Duration, error) {
+	ctx := service.client.Context()
+
+	var remaining time.Duration
+	for _, key := range []string{lockKey(accountScope, normalizeEmail(email)), lockKey(ipScope, ip)} {
+		ttl, err := service.client.TTL(ctx, key).Result()
+		if err != nil {
+			return 0, fmt.Errorf("check login lock: %w", err)
+		}
+
+		if ttl > remaining {
+			remaining = ttl
+		}
+	}
+
+	return remaining, nil
+}
+
+// RegisterFailure counts a failed login for both the email and the IP address
+// and triggers a lockout for whichever crossed its threshold.
+func (service LoginAttemptService) RegisterFailure(email string, ip string) error {
+	if err := service.registerFailure(accountScope, normalizeEmail(email), maxAccountFailures); err != nil {
+		return err
+	}
+
+	return service.registerFailure(ipScope, ip, maxIPFailures)
+}
+
+// RegisterSuccess clears the failed login counter of the account.
+// The IP counter is kept so a single valid account cannot be used to reset guessing from that address.
+func (service LoginAttemptService) RegisterSuccess(email string) error {
+	subject := normalizeEmail(email)
+
+	err := service.client.Del(service.client.Context(), failuresKey(accountScope, subject), loginLockoutsPrefix+accountScope+":"+subject).Err()
+	if err != nil {
+		return fmt.Errorf("reset login failures: %w", err)
+	}
+
+	return nil
+}
+
+// MFALockedFor returns the remaining lockout duration of the MFA step for the user ID, or zero if it is not locked
+func (service LoginAttemptService) MFALockedFor(uid string) (time.Duration, error) {
+	ttl, err := service.client.TTL(service.client.Context(), lockKey(mfaScope, uid)).Result()
+	if err != nil {
+		return 0, fmt.Errorf("check mfa lock: %w", err)
+	}
+
+	return max(ttl, 0), nil
+}
+
+// RegisterMFAFailure counts a wrong MFA code for the user ID and locks the MFA step out once it crosses the threshold.
+// The counter survives new MFA tokens, so it is only reset by RegisterMFASuccess.
+func (service LoginAttemptService) RegisterMFAFailure(uid string) error {
+	return service.registerFailure(mfaScope, uid, maxMFAFailures)
+}
+
+// RegisterMFASuccess clears the wrong MFA code counter of the user ID
+func (service LoginAttemptService) RegisterMFASuccess(uid string) error {
+	err := service.client.Del(service.client.Context(), failuresKey(mfaScope, uid), loginLockoutsPrefix+mfaScope+":"+uid).Err()
+	if err != nil {
+		return fmt.Errorf("reset mfa failures: %w", err)
+	}
+
+	return nil
+}
+
+func (service LoginAttemptService) registerFailure(scope string, subject string, threshold int64) error {
+	ctx := service.client.Context()
+	key := failuresKey(scope, subject)
+
+	failures, err := service.client.Incr(ctx, key).Result()
+	if err != nil {
+		return fmt.Errorf("count login failure: %w", err)
+	}
+	if failures == 1 {
+		service.client.Expire(ctx, key, failureWindow)
+	}
+
+	if failures < threshold {
+		return nil
+	}
+
+	lockoutsKey := loginLockoutsPrefix + scope + ":" + subject
+	lockouts, err := service.client.Incr(ctx, lockoutsKey).Result()
+	if err != nil {
+		return fmt.Errorf("count lockouts: %w", err)
+	}
+	service.client.Expire(ctx, lockoutsKey, lockoutMemory)
+
+	duration := lockoutDuration(lockouts)
+
+	pipe := service.client.TxPipeline()
+	pipe.Set(ctx, lockKey(scope, subject), failures, duration)
+	pipe.Del(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("lock out %s: %w", scope, err)
+	}
+
+	err = service.notifier.NotifyLockout(models.LockoutEvent{
+		Scope:    scope,
+		Subject:  subject,
+		Failures: failures,
+		Duration: duration,
+		LockedAt: time.Now(),
+	})
+	if err != nil {
+		log.Println(fmt.Errorf("notify lockout: %w", err))
+	}
+
+	return nil
+}
+
+// lockoutDuration doubles the base lockout for every previous lockout, up to maxLockout
+func lockoutDuration(lockouts int64) time.Duration {
+	duration := baseLockout
+	for i := int64(1); i < lockouts && duration < maxLockout; i++ {
+		duration *= 2
+	}
+
+	return min(duration, maxLockout)
+}
+
+func failuresKey(scope string, subject string) string {
+	return loginFailuresPrefix + scope + ":" + subject
+}
+
+func lockKey(scope string, subject string) string {
+	return loginLockPrefix + scope + ":" + subject
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AkifhanIlgaz/dictionary-api/models"
+)
+
+// recordingNotifier collects the lockouts it is notified of
+type recordingNotifier struct {
+	events []models.LockoutEvent
+}
+
+func (notifier *recordingNotifier) NotifyLockout(event models.LockoutEvent) error {
+	notifier.events = append(notifier.events, event)
+	return nil
+}
+
+func TestLockoutDuration(t *testing.T) {
+	tests := map[int64]time.Duration{
+		1:  time.Minute,
+		2:  2 * time.Minute,
+		3:  4 * time.Minute,
+		6:  32 * time.Minute,
+		7:  time.Hour,
+		50: time.Hour,
+	}
+
+	for lockouts, want := range tests {
+		if got := lockoutDuration(lockouts); got != want {
+			t.Errorf("lockoutDuration(%d) = %s, want %s", lockouts, got, want)
+		}
+	}
+}
+
+func TestLoginAttemptAccountLockout(t *testing.T) {
+	notifier := &recordingNotifier{}
+	service := NewLoginAttemptService(newRedisClient(t), notifier)
+	email := randomSubject() + "@example.com"
+	ip := randomSubject()
+
+	for range maxAccountFailures - 1 {
+		if err := service.RegisterFailure(email, ip); err != nil {
+			t.Fatalf("register failure: %v", err)
+		}
+	}
+	if lockedFor, err := service.LockedFor(email, ip); err != nil || lockedFor != 0 {
+		t.Fatalf("locked for %s (%v) below the threshold, want 0", lockedFor, err)
+	}
+
+	if err := service.RegisterFailure(email, ip); err != nil {
+		t.Fatalf("register failure: %v", err)
+	}
+	lockedFor, err := service.LockedFor(" "+email+" ", ip)
+	if err != nil {
+		t.Fatalf("locked for: %v", err)
+	}
+	if lockedFor <= 0 || lockedFor > baseLockout {
+		t.Errorf("locked for %s at the threshold, want up to %s", lockedFor, baseLockout)
+	}
+	if len(notifier.events) != 1 || notifier.events[0].Scope != accountScope {
+		t.Errorf("notified of %+v, want one account lockout", notifier.events)
+	}
+}
+
+func TestLoginAttemptMFALockout(t *testing.T) {
+	service := NewLoginAttemptService(newRedisClient(t), &recordingNotifier{})
+	uid := randomSubject()
+	email := uid + "@example.com"
+
+	for range maxMFAFailures - 1 {
+		if err := service.RegisterMFAFailure(uid); err != nil {
+			t.Fatalf("register mfa failure: %v", err)
+		}
+	}
+
+	// A correct password does not reset the count of wrong codes
+	if err := service.RegisterSuccess(email); err != nil {
+		t.Fatalf("register success: %v", err)
+	}
+	if err := service.RegisterMFAFailure(uid); err != nil {
+		t.Fatalf("register mfa failure: %v", err)
+	}
+
+	lockedFor, err := service.MFALockedFor(uid)
+	if err != nil {
+		t.Fatalf("mfa locked for: %v", err)
+	}
+	if lockedFor <= 0 {
+		t.Errorf("mfa locked for %s after %d wrong codes, want a lockout", lockedFor, maxMFAFailures)
+	}
+
+	// The MFA lockout does not lock the password step
+	if lockedFor, err := service.LockedFor(email, randomSubject()); err != nil || lockedFor != 0 {
+		t.Errorf("password step locked for %s (%v), want 0", lockedFor, err)
+	}
+}
+
+func TestLoginAttemptMFASuccessResets(t *testing.T) {
+	service := NewLoginAttemptService(newRedisClient(t), &recordingNotifier{})
+	uid := randomSubject()
+
+	for range maxMFAFailures - 1 {
+		if err := service.RegisterMFAFailure(uid); err != nil {
+			t.Fatalf("register mfa failure: %v", err)
+		}
+	}
+	if err := service.RegisterMFASuccess(uid); err != nil {
+		t.Fatalf("register mfa success: %v", err)
+	}
+	if err := service.RegisterMFAFailure(uid); err != nil {
+		t.Fatalf("register mfa failure: %v", err)
+	}
+
+	if lockedFor, err := service.MFALockedFor(uid); err != nil || lockedFor != 0 {
+		t.Errorf("mfa locked for %s (%v) after a success, want 0", lockedFor, err)
+	}
+}
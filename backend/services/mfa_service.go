@@ -0,0 +1,300 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/AkifhanIlgaz/dictionary-api/models"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/crypto"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/db"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/message"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/totp"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrMFAAlreadyEnabled      = errors.New(message.MFAAlreadyEnabled)
+	ErrMFANotEnabled          = errors.New(message.MFANotEnabled)
+	ErrMFANoPendingEnrollment = errors.New(message.MFANoPendingEnrollment)
+	ErrInvalidMFACode         = errors.New(message.InvalidMFACode)
+)
+
+// MFAService handles TOTP enrollment and verification for users
+type MFAService struct {
+	ctx        context.Context
+	collection *mongo.Collection
+}
+
+// NewMFAService creates a new MFAService instance backed by the users collection
+func NewMFAService(ctx context.Context, mongoDatabase *mongo.Database) MFAService {
+	return MFAService{
+		ctx:        ctx,
+		collection: mongoDatabase.Collection(db.UsersCollection),
+	}
+}
+
+// BeginEnrollment generates a new TOTP secret for the user and stores it as pending.
+// The secret only becomes active once ConfirmEnrollment succeeds with a valid code.
+// Returns the secret and the provisioning URI to be rendered as a QR code.
+func (service MFAService) BeginEnrollment(uid string) (models.MFAEnrollment, error) {
+	user, err := service.getUser(uid)
+	if err != nil {
+		return models.MFAEnrollment{}, fmt.Errorf("begin mfa enrollment: %w", err)
+	}
+
+	if user.MFA.Enabled {
+		return models.MFAEnrollment{}, ErrMFAAlreadyEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return models.MFAEnrollment{}, fmt.Errorf("begin mfa enrollment: %w", err)
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"mfa.pendingSecret": secret,
+		},
+	}
+
+	_, err = service.collection.UpdateByID(service.ctx, user.Id, update)
+	if err != nil {
+		return models.MFAEnrollment{}, fmt.Errorf("begin mfa enrollment: %w", err)
+	}
+
+	return models.MFAEnrollment{
+		Secret:          secret,
+		ProvisioningURI: totp.ProvisioningURI(user.Email, secret),
+	}, nil
+}
+
+// ConfirmEnrollment verifies the first code against the pending secret and enables MFA.
+// Returns the plain recovery codes, which are stored hashed and cannot be retrieved again.
+func (service MFAService) ConfirmEnrollment(uid string, code string) ([]string, error) {
+	user, err := service.getUser(uid)
+	if err != nil {
+		return nil, fmt.Errorf("confirm mfa enrollment: %w", err)
+	}
+
+	if user.MFA.Enabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if user.MFA.PendingSecret == "" {
+		return nil, ErrMFANoPendingEnrollment
+	}
+
+	step, err := totp.Validate(user.MFA.PendingSecret, code, time.Now())
+	if err != nil {
+		return nil, ErrInvalidMFACode
+	}
+
+	recoveryCodes, hashedCodes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, fmt.Errorf("confirm mfa enrollment: %w", err)
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"mfa": models.MFASettings{
+				Enabled:       true,
+				Secret:        user.MFA.PendingSecret,
+				RecoveryCodes: hashedCodes,
+				LastUsedStep:  step,
+				EnabledAt:     time.Now(),
+			},
+		},
+	}
+
+	_, err = service.collection.UpdateByID(service.ctx, user.Id, update)
+	if err != nil {
+		return nil, fmt.Errorf("confirm mfa enrollment: %w", err)
+	}
+
+	return recoveryCodes, nil
+}
+
+// Disable turns off MFA for the user after verifying a TOTP or recovery code
+func (service MFAService) Disable(uid string, code string) error {
+	if err := service.Verify(uid, code); err != nil {
+		return err
+	}
+
+	objectId, err := primitive.ObjectIDFromHex(uid)
+	if err != nil {
+		return fmt.Errorf("invalid user id: %w", err)
+	}
+
+	update := bson.M{
+		"$unset": bson.M{
+			"mfa": "",
+		},
+	}
+
+	_, err = service.collection.UpdateByID(service.ctx, objectId, update)
+	if err != nil {
+		return fmt.Errorf("disable mfa: %w", err)
+	}
+
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes after verifying a TOTP or recovery code.
+// Returns the new plain recovery codes.
+func (service MFAService) RegenerateRecoveryCodes(uid string, code string) ([]string, error) {
+	if err := service.Verify(uid, code); err != nil {
+		return nil, err
+	}
+
+	objectId, err := primitive.ObjectIDFromHex(uid)
+	if err != nil {
+		return nil, fmt.Errorf("invalid user id: %w", err)
+	}
+
+	recoveryCodes, hashedCodes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, fmt.Errorf("regenerate recovery codes: %w", err)
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"mfa.recoveryCodes": hashedCodes,
+		},
+	}
+
+	_, err = service.collection.UpdateByID(service.ctx, objectId, update)
+	if err != nil {
+		return nil, fmt.Errorf("regenerate recovery codes: %w", err)
+	}
+
+	return recoveryCodes, nil
+}
+
+// IsEnabled reports whether the user has completed MFA enrollment
+func (service MFAService) IsEnabled(uid string) (bool, error) {
+	user, err := service.getUser(uid)
+	if err != nil {
+		return false, fmt.Errorf("is mfa enabled: %w", err)
+	}
+
+	return user.MFA.Enabled, nil
+}
+
+// Verify checks a TOTP code, or a recovery code if the code has the format of one.
+// A TOTP time step can only be used once and a recovery code is consumed on success.
+// Recovery codes are hashed with bcrypt, so wrong TOTP codes are not compared against them.
+func (service MFAService) Verify(uid string, code string) error {
+	user, err := service.getUser(uid)
+	if err != nil {
+		return fmt.Errorf("verify mfa: %w", err)
+	}
+
+	if !user.MFA.Enabled {
+		return ErrMFANotEnabled
+	}
+
+	if step, err := totp.Validate(user.MFA.Secret, code, time.Now()); err == nil {
+		return service.useStep(user.Id, step)
+	}
+
+	recoveryCode := strings.ToLower(strings.TrimSpace(code))
+	if !totp.IsRecoveryCode(recoveryCode) {
+		return ErrInvalidMFACode
+	}
+
+	for _, hashedCode := range user.MFA.RecoveryCodes {
+		if crypto.VerifyPassword(hashedCode, recoveryCode) == nil {
+			return service.useRecoveryCode(user.Id, hashedCode)
+		}
+	}
+
+	return ErrInvalidMFACode
+}
+
+// useStep records the matched time step, rejecting it if the same or a later step was already used
+func (service MFAService) useStep(id primitive.ObjectID, step int64) error {
+	filter := bson.M{
+		"_id":              id,
+		"mfa.lastUsedStep": bson.M{"$not": bson.M{"$gte": step}},
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"mfa.lastUsedStep": step,
+		},
+	}
+
+	result, err := service.collection.UpdateOne(service.ctx, filter, update)
+	if err != nil {
+		return fmt.Errorf("use totp step: %w", err)
+	}
+
+	if result.ModifiedCount == 0 {
+		return ErrInvalidMFACode
+	}
+
+	return nil
+}
+
+// useRecoveryCode removes a recovery code so it cannot be used again
+func (service MFAService) useRecoveryCode(id primitive.ObjectID, hashedCode string) error {
+	filter := bson.M{
+		"_id":               id,
+		"mfa.recoveryCodes": hashedCode,
+	}
+
+	update := bson.M{
+		"$pull": bson.M{
+			"mfa.recoveryCodes": hashedCode,
+		},
+	}
+
+	result, err := service.collection.UpdateOne(service.ctx, filter, update)
+	if err != nil {
+		return fmt.Errorf("use recovery code: %w", err)
+	}
+
+	if result.ModifiedCount == 0 {
+		return ErrInvalidMFACode
+	}
+
+	return nil
+}
+
+func (service MFAService) getUser(uid string) (models.User, error) {
+	objectId, err := primitive.ObjectIDFromHex(uid)
+	if err != nil {
+		return models.User{}, fmt.Errorf("invalid user id: %w", err)
+	}
+
+	var user models.User
+	err = service.collection.FindOne(service.ctx, bson.M{"_id": objectId}).Decode(&user)
+	if err != nil {
+		return models.User{}, fmt.Errorf("get user: %w", err)
+	}
+
+	return user, nil
+}
+
+// generateRecoveryCodes returns the plain recovery codes together with their hashes
+func generateRecoveryCodes() ([]string, []string, error) {
+	recoveryCodes, err := totp.GenerateRecoveryCodes()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hashedCodes := make([]string, 0, len(recoveryCodes))
+	for _, code := range recoveryCodes {
+		hashedCode, err := crypto.HashPassword(code)
+		if err != nil {
+			return nil, nil, err
+		}
+		hashedCodes = append(hashedCodes, hashedCode)
+	}
+
+	return recoveryCodes, hashedCodes, nil
+}
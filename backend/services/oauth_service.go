@@ -0,0 +1,332 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/AkifhanIlgaz/dictionary-api/config"
+	"github.com/AkifhanIlgaz/dictionary-api/models"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/db"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/message"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/oidc"
+	"github.com/go-redis/redis/v8"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	oauthStatePrefix string        = "oauth_state:"
+	oauthStateExpiry time.Duration = 10 * time.Minute
+)
+
+var (
+	ErrUnknownProvider       = errors.New(message.UnknownProvider)
+	ErrInvalidOAuthState     = errors.New(message.InvalidOAuthState)
+	ErrIdentityAlreadyLinked = errors.New(message.IdentityAlreadyLinked)
+	ErrEmailNotVerified      = errors.New(message.EmailNotVerified)
+	ErrLinkRequired          = errors.New(message.LinkRequired)
+	ErrIdentityNotFound      = errors.New(message.IdentityNotFound)
+	ErrLastLoginMethod       = errors.New(message.LastLoginMethod)
+)
+
+// OAuthService handles logging in with and linking external OAuth2 / OpenID Connect identities
+type OAuthService struct {
+	ctx         context.Context
+	collection  *mongo.Collection
+	client      *redis.Client
+	authService AuthService
+	providers   map[string]*oidc.Provider
+}
+
+// NewOAuthService creates a new OAuthService instance with the providers that have a client ID configured.
+// OpenID Connect providers are discovered on creation, so their issuer must be reachable.
+// It returns an error if discovery or the index creation fails.
+func NewOAuthService(ctx context.Context, mongoDatabase *mongo.Database, client *redis.Client, authService AuthService, config config.Config) (OAuthService, error) {
+	collection := mongoDatabase.Collection(db.IdentitiesCollection)
+
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "provider", Value: 1}, {Key: "subject", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "uid", Value: 1}, {Key: "provider", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	})
+	if err != nil {
+		return OAuthService{}, fmt.Errorf("initialize oauth service: %w", err)
+	}
+
+	providers := map[string]*oidc.Provider{}
+
+	if config.GoogleClientID != "" {
+		provider, err := oidc.NewGoogleProvider(ctx, config.GoogleClientID, config.GoogleClientSecret, redirectURL(config, oidc.Google))
+		if err != nil {
+			return OAuthService{}, fmt.Errorf("initialize oauth service: %w", err)
+		}
+		providers[oidc.Google] = provider
+	}
+
+	if config.GitHubClientID != "" {
+		providers[oidc.GitHub] = oidc.NewGitHubProvider(config.GitHubClientID, config.GitHubClientSecret, redirectURL(config, oidc.GitHub))
+	}
+
+	if config.OIDCClientID != "" {
+		provider, err := oidc.NewGenericProvider(ctx, oidc.Generic, config.OIDCIssuer, config.OIDCClientID, config.OIDCClientSecret, redirectURL(config, oidc.Generic))
+		if err != nil {
+			return OAuthService{}, fmt.Errorf("initialize oauth service: %w", err)
+		}
+		providers[oidc.Generic] = provider
+	}
+
+	return OAuthService{
+		ctx:         ctx,
+		collection:  collection,
+		client:      client,
+		authService: authService,
+		providers:   providers,
+	}, nil
+}
+
+// Authorize starts the authorization code flow with PKCE to log in with the given provider.
+// Returns the provider URL the user should be redirected to, and the binding that the browser which started
+// the flow must present with the callback, so that nobody can log a victim into the attacker's account.
+func (service OAuthService) Authorize(providerName string) (string, string, error) {
+	binding, err := oidc.GenerateRandomString()
+	if err != nil {
+		return "", "", fmt.Errorf("authorize: %w", err)
+	}
+
+	authorizationURL, err := service.authorize(providerName, models.OAuthState{Binding: binding})
+	if err != nil {
+		return "", "", err
+	}
+
+	return authorizationURL, binding, nil
+}
+
+// AuthorizeLink starts the authorization code flow with PKCE to link an identity at the given provider to the user.
+// Returns the provider URL the user should be redirected to.
+func (service OAuthService) AuthorizeLink(providerName string, uid string) (string, error) {
+	return service.authorize(providerName, models.OAuthState{LinkUid: uid})
+}
+
+func (service OAuthService) authorize(providerName string, oauthState models.OAuthState) (string, error) {
+	provider, ok := service.providers[providerName]
+	if !ok {
+		return "", ErrUnknownProvider
+	}
+
+	state, err := oidc.GenerateRandomString()
+	if err != nil {
+		return "", fmt.Errorf("authorize: %w", err)
+	}
+	nonce, err := oidc.GenerateRandomString()
+	if err != nil {
+		return "", fmt.Errorf("authorize: %w", err)
+	}
+	codeVerifier, err := oidc.GenerateRandomString()
+	if err != nil {
+		return "", fmt.Errorf("authorize: %w", err)
+	}
+
+	oauthState.Provider = providerName
+	oauthState.CodeVerifier = codeVerifier
+	oauthState.Nonce = nonce
+
+	value, err := json.Marshal(oauthState)
+	if err != nil {
+		return "", fmt.Errorf("authorize: %w", err)
+	}
+
+	err = service.client.Set(service.client.Context(), oauthStatePrefix+state, value, oauthStateExpiry).Err()
+	if err != nil {
+		return "", fmt.Errorf("store oauth state on redis: %w", err)
+	}
+
+	return provider.AuthCodeURL(state, nonce, codeVerifier), nil
+}
+
+// HandleCallback completes a login started by Authorize in the browser that presents its binding.
+// The identity is resolved to an existing link, to an existing user with the same email if both emails are verified,
+// or to a newly created user. Returns the ID of the user, or ErrInvalidOAuthState if the state is unknown, belongs to
+// a link flow or was started by another browser.
+func (service OAuthService) HandleCallback(providerName string, code string, state string, binding string) (string, error) {
+	provider, oauthState, err := service.consumeState(providerName, state)
+	if err != nil {
+		return "", err
+	}
+	if oauthState.LinkUid != "" || oauthState.Binding == "" || subtle.ConstantTimeCompare([]byte(oauthState.Binding), []byte(binding)) != 1 {
+		return "", ErrInvalidOAuthState
+	}
+
+	identity, err := provider.Authenticate(service.ctx, code, oauthState.CodeVerifier, oauthState.Nonce)
+	if err != nil {
+		return "", fmt.Errorf("handle callback: %w", err)
+	}
+
+	return service.resolveUser(providerName, identity)
+}
+
+// HandleLinkCallback completes a link started by AuthorizeLink and attaches the identity to the user.
+// The user completing the flow must be the one who started it, otherwise anyone could be lured into finishing
+// the flow of an attacker and link their identity to the attacker's account.
+// Returns ErrInvalidOAuthState if the state is unknown or was not started by the user to link.
+func (service OAuthService) HandleLinkCallback(providerName string, code string, state string, uid string) error {
+	provider, oauthState, err := service.consumeState(providerName, state)
+	if err != nil {
+		return err
+	}
+	if oauthState.LinkUid == "" || oauthState.LinkUid != uid {
+		return ErrInvalidOAuthState
+	}
+
+	identity, err := provider.Authenticate(service.ctx, code, oauthState.CodeVerifier, oauthState.Nonce)
+	if err != nil {
+		return fmt.Errorf("handle link callback: %w", err)
+	}
+
+	return service.link(uid, providerName, identity)
+}
+
+// consumeState removes the state of a flow, so that it can only be completed once, and returns it with its provider.
+// Returns ErrInvalidOAuthState if the state is unknown, expired or was started for another provider.
+func (service OAuthService) consumeState(providerName string, state string) (*oidc.Provider, models.OAuthState, error) {
+	provider, ok := service.providers[providerName]
+	if !ok {
+		return nil, models.OAuthState{}, ErrUnknownProvider
+	}
+
+	value, err := service.client.GetDel(service.client.Context(), oauthStatePrefix+state).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, models.OAuthState{}, ErrInvalidOAuthState
+		}
+		return nil, models.OAuthState{}, fmt.Errorf("get oauth state from redis: %w", err)
+	}
+
+	var oauthState models.OAuthState
+	if err := json.Unmarshal([]byte(value), &oauthState); err != nil {
+		return nil, models.OAuthState{}, fmt.Errorf("decode oauth state: %w", err)
+	}
+	if oauthState.Provider != providerName {
+		return nil, models.OAuthState{}, ErrInvalidOAuthState
+	}
+
+	return provider, oauthState, nil
+}
+
+// ListIdentities returns the external identities linked to the user
+func (service OAuthService) ListIdentities(uid string) ([]models.Identity, error) {
+	cursor, err := service.collection.Find(service.ctx, bson.M{"uid": uid})
+	if err != nil {
+		return nil, fmt.Errorf("list identities: %w", err)
+	}
+
+	identities := []models.Identity{}
+	if err := cursor.All(service.ctx, &identities); err != nil {
+		return nil, fmt.Errorf("list identities: %w", err)
+	}
+
+	return identities, nil
+}
+
+// Unlink removes the user's identity at the given provider.
+// It refuses to remove the last way to log in for users without a password.
+func (service OAuthService) Unlink(uid string, providerName string) error {
+	user, err := service.authService.GetById(uid)
+	if err != nil {
+		return fmt.Errorf("unlink identity: %w", err)
+	}
+
+	if user.PasswordHash == "" {
+		count, err := service.collection.CountDocuments(service.ctx, bson.M{"uid": uid})
+		if err != nil {
+			return fmt.Errorf("unlink identity: %w", err)
+		}
+		if count <= 1 {
+			return ErrLastLoginMethod
+		}
+	}
+
+	result, err := service.collection.DeleteOne(service.ctx, bson.M{"uid": uid, "provider": providerName})
+	if err != nil {
+		return fmt.Errorf("unlink identity: %w", err)
+	}
+
+	if result.DeletedCount == 0 {
+		return ErrIdentityNotFound
+	}
+
+	return nil
+}
+
+func (service OAuthService) resolveUser(providerName string, identity oidc.Identity) (string, error) {
+	var existing models.Identity
+	err := service.collection.FindOne(service.ctx, bson.M{"provider": providerName, "subject": identity.Subject}).Decode(&existing)
+	if err == nil {
+		return existing.Uid, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return "", fmt.Errorf("find identity: %w", err)
+	}
+
+	// Only a verified email proves ownership of the matching account
+	if identity.Email == "" || !identity.EmailVerified {
+		return "", ErrEmailNotVerified
+	}
+
+	var uid string
+	user, err := service.authService.GetByEmail(identity.Email)
+	switch {
+	case err == nil:
+		// Anyone can register an email with a password, so an unverified account may have been registered by
+		// someone else to take over the identity. Its owner has to prove the password by linking instead.
+		if !user.EmailVerified {
+			return "", ErrLinkRequired
+		}
+		uid = user.Id.Hex()
+	case errors.Is(err, mongo.ErrNoDocuments):
+		id, err := service.authService.CreateExternal(identity.Email)
+		if err != nil {
+			return "", err
+		}
+		uid = id.Hex()
+	default:
+		return "", err
+	}
+
+	if err := service.link(uid, providerName, identity); err != nil {
+		return "", err
+	}
+
+	return uid, nil
+}
+
+func (service OAuthService) link(uid string, providerName string, identity oidc.Identity) error {
+	_, err := service.collection.InsertOne(service.ctx, models.Identity{
+		Uid:      uid,
+		Provider: providerName,
+		Subject:  identity.Subject,
+		Email:    identity.Email,
+		LinkedAt: time.Now(),
+	})
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrIdentityAlreadyLinked
+		}
+		return fmt.Errorf("link identity: %w", err)
+	}
+
+	return nil
+}
+
+func redirectURL(config config.Config, providerName string) string {
+	return config.OAuthRedirectURL + "/" + providerName
+}
@@ -0,0 +1,270 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/AkifhanIlgaz/dictionary-api/config"
+	"github.com/AkifhanIlgaz/dictionary-api/models"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/oidc"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/oidc/oidctest"
+	plan "github.com/AkifhanIlgaz/dictionary-api/utils/plans"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// newTestOAuthService returns an OAuthService with the mock issuer as its generic OpenID Connect provider.
+// Without a database, only tests that fail before the user is resolved can run.
+func newTestOAuthService(t *testing.T, database *mongo.Database) (OAuthService, *oidctest.Issuer) {
+	t.Helper()
+
+	issuer := oidctest.NewIssuer(t)
+	provider, err := oidc.NewGenericProvider(context.Background(), oidc.Generic, issuer.URL, oidctest.ClientID, oidctest.ClientSecret, "http://localhost:3000/oauth/callback/"+oidc.Generic)
+	if err != nil {
+		t.Fatalf("NewGenericProvider: %v", err)
+	}
+
+	service := OAuthService{
+		ctx:       context.Background(),
+		client:    newRedisClient(t),
+		providers: map[string]*oidc.Provider{oidc.Generic: provider},
+	}
+
+	if database != nil {
+		plans, err := plan.LoadRegistry("")
+		if err != nil {
+			t.Fatalf("LoadRegistry: %v", err)
+		}
+		authService, _ := NewAuthService(context.Background(), database, plans)
+		service, err = NewOAuthService(context.Background(), database, service.client, authService, config.Config{
+			OAuthRedirectURL: "http://localhost:3000/oauth/callback",
+			OIDCIssuer:       issuer.URL,
+			OIDCClientID:     oidctest.ClientID,
+			OIDCClientSecret: oidctest.ClientSecret,
+		})
+		if err != nil {
+			t.Fatalf("NewOAuthService: %v", err)
+		}
+	}
+
+	return service, issuer
+}
+
+// authorizeLogin starts a login and lets the mock issuer approve it, returning the code and state of the callback
+// and the binding of the browser that started it
+func authorizeLogin(t *testing.T, service OAuthService, issuer *oidctest.Issuer) (string, string, string) {
+	t.Helper()
+
+	authURL, binding, err := service.Authorize(oidc.Generic)
+	if err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+
+	code, state, err := issuer.Authorize(authURL)
+	if err != nil {
+		t.Fatalf("approve authorization: %v", err)
+	}
+
+	return code, state, binding
+}
+
+// authorizeLink starts linking an identity to the user and lets the mock issuer approve it, returning the code
+// and state of the callback
+func authorizeLink(t *testing.T, service OAuthService, issuer *oidctest.Issuer, uid string) (string, string) {
+	t.Helper()
+
+	authURL, err := service.AuthorizeLink(oidc.Generic, uid)
+	if err != nil {
+		t.Fatalf("AuthorizeLink: %v", err)
+	}
+
+	code, state, err := issuer.Authorize(authURL)
+	if err != nil {
+		t.Fatalf("approve authorization: %v", err)
+	}
+
+	return code, state
+}
+
+func TestAuthorizeUnknownProvider(t *testing.T) {
+	service, _ := newTestOAuthService(t, nil)
+
+	if _, _, err := service.Authorize("unknown"); !errors.Is(err, ErrUnknownProvider) {
+		t.Errorf("Authorize() error = %v, want %v", err, ErrUnknownProvider)
+	}
+	if _, err := service.AuthorizeLink("unknown", randomSubject()); !errors.Is(err, ErrUnknownProvider) {
+		t.Errorf("AuthorizeLink() error = %v, want %v", err, ErrUnknownProvider)
+	}
+}
+
+func TestAuthorizeStoresState(t *testing.T) {
+	service, _ := newTestOAuthService(t, nil)
+
+	authURL, binding, err := service.Authorize(oidc.Generic)
+	if err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+	if binding == "" {
+		t.Error("Authorize() returned no binding")
+	}
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("parse auth URL: %v", err)
+	}
+	query := parsed.Query()
+
+	if query.Get("state") == "" || query.Get("nonce") == "" || query.Get("code_challenge_method") != "S256" {
+		t.Fatalf("auth URL %s lacks state, nonce or an S256 code challenge", authURL)
+	}
+
+	ttl := service.client.TTL(context.Background(), oauthStatePrefix+query.Get("state")).Val()
+	if ttl <= 0 || ttl > oauthStateExpiry {
+		t.Errorf("state TTL = %v, want at most %v", ttl, oauthStateExpiry)
+	}
+}
+
+func TestHandleCallbackUnknownState(t *testing.T) {
+	service, issuer := newTestOAuthService(t, nil)
+	code, _, binding := authorizeLogin(t, service, issuer)
+
+	if _, err := service.HandleCallback(oidc.Generic, code, "forged-state", binding); !errors.Is(err, ErrInvalidOAuthState) {
+		t.Errorf("HandleCallback() error = %v, want %v", err, ErrInvalidOAuthState)
+	}
+}
+
+func TestHandleCallbackProviderMismatch(t *testing.T) {
+	service, issuer := newTestOAuthService(t, nil)
+	service.providers["other"] = service.providers[oidc.Generic]
+	code, state, binding := authorizeLogin(t, service, issuer)
+
+	// A state started for one provider cannot complete the flow of another
+	if _, err := service.HandleCallback("other", code, state, binding); !errors.Is(err, ErrInvalidOAuthState) {
+		t.Errorf("HandleCallback() error = %v, want %v", err, ErrInvalidOAuthState)
+	}
+}
+
+func TestHandleCallbackStateIsSingleUse(t *testing.T) {
+	service, issuer := newTestOAuthService(t, nil)
+	_, state, binding := authorizeLogin(t, service, issuer)
+
+	// The failed exchange consumes the state as well
+	if _, err := service.HandleCallback(oidc.Generic, "invalid-code", state, binding); err == nil || errors.Is(err, ErrInvalidOAuthState) {
+		t.Fatalf("HandleCallback() error = %v, want a failed code exchange", err)
+	}
+	if _, err := service.HandleCallback(oidc.Generic, "invalid-code", state, binding); !errors.Is(err, ErrInvalidOAuthState) {
+		t.Errorf("HandleCallback() with a used state error = %v, want %v", err, ErrInvalidOAuthState)
+	}
+}
+
+func TestHandleCallbackRequiresBinding(t *testing.T) {
+	for name, binding := range map[string]string{"missing": "", "of another browser": "another-binding"} {
+		t.Run(name, func(t *testing.T) {
+			service, issuer := newTestOAuthService(t, nil)
+			// The victim is sent the code and state of a login the attacker started
+			code, state, _ := authorizeLogin(t, service, issuer)
+
+			if _, err := service.HandleCallback(oidc.Generic, code, state, binding); !errors.Is(err, ErrInvalidOAuthState) {
+				t.Errorf("HandleCallback() error = %v, want %v", err, ErrInvalidOAuthState)
+			}
+		})
+	}
+}
+
+func TestHandleCallbackRejectsLinkState(t *testing.T) {
+	service, issuer := newTestOAuthService(t, nil)
+	code, state := authorizeLink(t, service, issuer, randomSubject())
+
+	if _, err := service.HandleCallback(oidc.Generic, code, state, ""); !errors.Is(err, ErrInvalidOAuthState) {
+		t.Errorf("HandleCallback() with the state of a link error = %v, want %v", err, ErrInvalidOAuthState)
+	}
+}
+
+func TestHandleLinkCallbackRequiresSameUser(t *testing.T) {
+	service, issuer := newTestOAuthService(t, nil)
+
+	// The attacker starts linking on their account and lures the victim into completing it
+	code, state := authorizeLink(t, service, issuer, randomSubject())
+	if err := service.HandleLinkCallback(oidc.Generic, code, state, randomSubject()); !errors.Is(err, ErrInvalidOAuthState) {
+		t.Errorf("HandleLinkCallback() by another user error = %v, want %v", err, ErrInvalidOAuthState)
+	}
+
+	code, state, _ = authorizeLogin(t, service, issuer)
+	if err := service.HandleLinkCallback(oidc.Generic, code, state, randomSubject()); !errors.Is(err, ErrInvalidOAuthState) {
+		t.Errorf("HandleLinkCallback() with the state of a login error = %v, want %v", err, ErrInvalidOAuthState)
+	}
+}
+
+func TestHandleLinkCallback(t *testing.T) {
+	service, issuer := newTestOAuthService(t, newTestDatabase(t))
+	uid, err := service.authService.Create(models.AuthRequest{Email: "owner@example.com", Password: "password"})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	code, state := authorizeLink(t, service, issuer, uid.Hex())
+	if err := service.HandleLinkCallback(oidc.Generic, code, state, uid.Hex()); err != nil {
+		t.Fatalf("HandleLinkCallback: %v", err)
+	}
+
+	// Logging in with the linked identity logs in to the user who linked it
+	code, state, binding := authorizeLogin(t, service, issuer)
+	loggedIn, err := service.HandleCallback(oidc.Generic, code, state, binding)
+	if err != nil {
+		t.Fatalf("HandleCallback: %v", err)
+	}
+	if loggedIn != uid.Hex() {
+		t.Errorf("login resolved to %s, want %s", loggedIn, uid.Hex())
+	}
+}
+
+func TestHandleCallbackUnverifiedEmail(t *testing.T) {
+	service, issuer := newTestOAuthService(t, newTestDatabase(t))
+	issuer.EmailVerified = false
+	code, state, binding := authorizeLogin(t, service, issuer)
+
+	if _, err := service.HandleCallback(oidc.Generic, code, state, binding); !errors.Is(err, ErrEmailNotVerified) {
+		t.Errorf("HandleCallback() error = %v, want %v", err, ErrEmailNotVerified)
+	}
+}
+
+func TestHandleCallbackLinksOnlyVerifiedAccounts(t *testing.T) {
+	service, issuer := newTestOAuthService(t, newTestDatabase(t))
+
+	// An attacker registers the victim's email with a password before the victim logs in with the provider
+	if _, err := service.authService.Create(models.AuthRequest{Email: issuer.Email, Password: "attacker-password"}); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	code, state, binding := authorizeLogin(t, service, issuer)
+	if _, err := service.HandleCallback(oidc.Generic, code, state, binding); !errors.Is(err, ErrLinkRequired) {
+		t.Errorf("HandleCallback() error = %v, want %v", err, ErrLinkRequired)
+	}
+}
+
+func TestHandleCallbackCreatesAndReusesUser(t *testing.T) {
+	service, issuer := newTestOAuthService(t, newTestDatabase(t))
+
+	code, state, binding := authorizeLogin(t, service, issuer)
+	first, err := service.HandleCallback(oidc.Generic, code, state, binding)
+	if err != nil {
+		t.Fatalf("HandleCallback: %v", err)
+	}
+
+	user, err := service.authService.GetById(first)
+	if err != nil {
+		t.Fatalf("GetById: %v", err)
+	}
+	if !user.EmailVerified || user.PasswordHash != "" {
+		t.Errorf("created user %+v, want a verified email and no password", user)
+	}
+
+	code, state, binding = authorizeLogin(t, service, issuer)
+	second, err := service.HandleCallback(oidc.Generic, code, state, binding)
+	if err != nil {
+		t.Fatalf("HandleCallback: %v", err)
+	}
+	if second != first {
+		t.Errorf("second login resolved to %s, want %s", second, first)
+	}
+}
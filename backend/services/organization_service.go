@@ -0,0 +1,589 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/AkifhanIlgaz/dictionary-api/config"
+	"github.com/AkifhanIlgaz/dictionary-api/models"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/db"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/mail"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/message"
+	plan "github.com/AkifhanIlgaz/dictionary-api/utils/plans"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrOrganizationNotFound  = errors.New(message.OrganizationNotFound)
+	ErrOrgInvitationNotFound = errors.New(message.OrgInvitationNotFound)
+	ErrOrgInvitationEmail    = errors.New(message.OrgInvitationWrongEmail)
+	ErrAlreadyOrgMember      = errors.New(message.AlreadyOrgMember)
+	ErrInvalidOrgRole        = errors.New(message.InvalidOrgRole)
+	ErrOrgMemberNotFound     = errors.New(message.OrgMemberNotFound)
+	ErrLastOrgOwner          = errors.New(message.LastOrgOwner)
+	ErrTooManyOrgMembers     = errors.New(message.TooManyOrgMembers)
+)
+
+// OrganizationService manages organizations, their members and invitations.
+// The keys of an organization share the quota of its plan, and are managed through UserService.
+type OrganizationService struct {
+	ctx                  context.Context
+	orgCollection        *mongo.Collection
+	memberCollection     *mongo.Collection
+	invitationCollection *mongo.Collection
+	apiKeyCollection     *mongo.Collection
+	plans                *plan.Registry
+	mailer               mail.Mailer
+	// invitationURL is the frontend page invitations are accepted on, the token is sent on its own if empty
+	invitationURL string
+}
+
+// NewOrganizationService creates a new OrganizationService instance and initializes the indexes of the
+// organizations, members and invitations collections
+func NewOrganizationService(ctx context.Context, mongoDatabase *mongo.Database, plans *plan.Registry, mailer mail.Mailer, config config.Config) (OrganizationService, error) {
+	memberCollection := mongoDatabase.Collection(db.OrgMembersCollection)
+	invitationCollection := mongoDatabase.Collection(db.OrgInvitationsCollection)
+
+	_, err := memberCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "orgId", Value: 1}, {Key: "uid", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "uid", Value: 1}},
+		},
+	})
+	if err != nil {
+		return OrganizationService{}, fmt.Errorf("initialize organization members collection: %w", err)
+	}
+
+	_, err = invitationCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "orgId", Value: 1}, {Key: "email", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "tokenHash", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+	if err != nil {
+		return OrganizationService{}, fmt.Errorf("initialize organization invitations collection: %w", err)
+	}
+
+	return OrganizationService{
+		ctx:                  ctx,
+		orgCollection:        mongoDatabase.Collection(db.OrganizationsCollection),
+		memberCollection:     memberCollection,
+		invitationCollection: invitationCollection,
+		apiKeyCollection:     mongoDatabase.Collection(db.ApiKeysCollection),
+		plans:                plans,
+		mailer:               mailer,
+		invitationURL:        config.OrgInvitationURL,
+	}, nil
+}
+
+// Create creates an organization on the default plan, with the given user as its owner
+func (service OrganizationService) Create(uid string, email string, req models.CreateOrganizationRequest) (*models.Organization, error) {
+	now := time.Now()
+	org := models.Organization{
+		Name:      strings.TrimSpace(req.Name),
+		Plan:      string(service.plans.Default().Type),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	result, err := service.orgCollection.InsertOne(service.ctx, org)
+	if err != nil {
+		return nil, fmt.Errorf("create organization: %w", err)
+	}
+	org.Id = result.InsertedID.(primitive.ObjectID)
+
+	_, err = service.memberCollection.InsertOne(service.ctx, models.OrgMembership{
+		OrgId:    org.Id,
+		Uid:      uid,
+		Email:    email,
+		Role:     models.OrgOwnerRole,
+		JoinedAt: now,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create organization: %w", err)
+	}
+
+	return &org, nil
+}
+
+// ListForUser retrieves the organizations the given user ID is a member of, with their role in each
+func (service OrganizationService) ListForUser(uid string) ([]models.UserOrganization, error) {
+	cursor, err := service.memberCollection.Find(service.ctx, bson.M{"uid": uid})
+	if err != nil {
+		return nil, fmt.Errorf("list organizations: %w", err)
+	}
+
+	var memberships []models.OrgMembership
+	if err := cursor.All(service.ctx, &memberships); err != nil {
+		return nil, fmt.Errorf("list organizations: %w", err)
+	}
+
+	roles := map[primitive.ObjectID]models.OrgRole{}
+	orgIds := bson.A{}
+	for _, membership := range memberships {
+		roles[membership.OrgId] = membership.Role
+		orgIds = append(orgIds, membership.OrgId)
+	}
+
+	organizations := []models.UserOrganization{}
+	if len(orgIds) == 0 {
+		return organizations, nil
+	}
+
+	cursor, err = service.orgCollection.Find(service.ctx, bson.M{"_id": bson.M{"$in": orgIds}},
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("list organizations: %w", err)
+	}
+
+	var orgs []models.Organization
+	if err := cursor.All(service.ctx, &orgs); err != nil {
+		return nil, fmt.Errorf("list organizations: %w", err)
+	}
+
+	for _, org := range orgs {
+		organizations = append(organizations, models.UserOrganization{Organization: org, Role: roles[org.Id]})
+	}
+
+	return organizations, nil
+}
+
+// Get retrieves the organization with the given ID.
+// Returns ErrOrganizationNotFound if there is no such organization.
+func (service OrganizationService) Get(orgId string) (*models.Organization, error) {
+	objectId, err := primitive.ObjectIDFromHex(orgId)
+	if err != nil {
+		return nil, ErrOrganizationNotFound
+	}
+
+	var org models.Organization
+	if err := service.orgCollection.FindOne(service.ctx, bson.M{"_id": objectId}).Decode(&org); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrOrganizationNotFound
+		}
+		return nil, fmt.Errorf("get organization: %w", err)
+	}
+
+	return &org, nil
+}
+
+// GetMembership retrieves the membership of the given user ID in an organization.
+// Returns ErrOrganizationNotFound if the user is not a member, so that other organizations are not revealed.
+func (service OrganizationService) GetMembership(orgId string, uid string) (*models.OrgMembership, error) {
+	objectId, err := primitive.ObjectIDFromHex(orgId)
+	if err != nil {
+		return nil, ErrOrganizationNotFound
+	}
+
+	var membership models.OrgMembership
+	if err := service.memberCollection.FindOne(service.ctx, bson.M{"orgId": objectId, "uid": uid}).Decode(&membership); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrOrganizationNotFound
+		}
+		return nil, fmt.Errorf("get organization membership: %w", err)
+	}
+
+	return &membership, nil
+}
+
+// GetMember retrieves a member of an organization like GetMembership, for requests about other members.
+// Returns ErrOrgMemberNotFound if the user is not a member.
+func (service OrganizationService) GetMember(orgId string, uid string) (*models.OrgMembership, error) {
+	membership, err := service.GetMembership(orgId, uid)
+	if errors.Is(err, ErrOrganizationNotFound) {
+		return nil, ErrOrgMemberNotFound
+	}
+
+	return membership, err
+}
+
+// Rename changes the name of an organization and returns it
+func (service OrganizationService) Rename(orgId string, req models.UpdateOrganizationRequest) (*models.Organization, error) {
+	return service.update(orgId, bson.M{"name": strings.TrimSpace(req.Name)})
+}
+
+// SetPlan moves an organization to the plan with the given ID and returns the plan it was on.
+// Returns ErrUnknownPlan if there is no such plan.
+func (service OrganizationService) SetPlan(orgId string, planType plan.PlanType) (*models.Organization, plan.PlanType, error) {
+	target, ok := service.plans.Get(planType)
+	if !ok {
+		return nil, "", ErrUnknownPlan
+	}
+
+	org, err := service.Get(orgId)
+	if err != nil {
+		return nil, "", err
+	}
+	previous := service.plans.Resolve(org.Plan).Type
+
+	org, err = service.update(orgId, bson.M{"plan": target.Type})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return org, previous, nil
+}
+
+func (service OrganizationService) update(orgId string, set bson.M) (*models.Organization, error) {
+	objectId, err := primitive.ObjectIDFromHex(orgId)
+	if err != nil {
+		return nil, ErrOrganizationNotFound
+	}
+
+	set["updatedAt"] = time.Now()
+
+	var org models.Organization
+	err = service.orgCollection.FindOneAndUpdate(service.ctx, bson.M{"_id": objectId}, bson.M{"$set": set},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&org)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrOrganizationNotFound
+		}
+		return nil, fmt.Errorf("update organization: %w", err)
+	}
+
+	return &org, nil
+}
+
+// Delete removes an organization with its members, invitations and API keys
+func (service OrganizationService) Delete(orgId string) error {
+	objectId, err := primitive.ObjectIDFromHex(orgId)
+	if err != nil {
+		return ErrOrganizationNotFound
+	}
+
+	// Keys are revoked first, so that a failure never leaves keys of a deleted organization working
+	if _, err := service.apiKeyCollection.DeleteMany(service.ctx, bson.M{"orgId": orgId}); err != nil {
+		return fmt.Errorf("delete organization api keys: %w", err)
+	}
+
+	result, err := service.orgCollection.DeleteOne(service.ctx, bson.M{"_id": objectId})
+	if err != nil {
+		return fmt.Errorf("delete organization: %w", err)
+	}
+	if result.DeletedCount == 0 {
+		return ErrOrganizationNotFound
+	}
+
+	if _, err := service.memberCollection.DeleteMany(service.ctx, bson.M{"orgId": objectId}); err != nil {
+		return fmt.Errorf("delete organization members: %w", err)
+	}
+
+	if _, err := service.invitationCollection.DeleteMany(service.ctx, bson.M{"orgId": objectId}); err != nil {
+		return fmt.Errorf("delete organization invitations: %w", err)
+	}
+
+	return nil
+}
+
+// GetQuota returns the plan of an organization as the quota its keys share.
+// Quota windows of organizations start in UTC, and requests over the quota are always blocked.
+func (service OrganizationService) GetQuota(orgId string) (models.UserQuota, error) {
+	org, err := service.Get(orgId)
+	if err != nil {
+		return models.UserQuota{}, fmt.Errorf("get organization quota: %w", err)
+	}
+
+	return models.UserQuota{
+		Plan:     service.plans.Resolve(org.Plan),
+		Location: time.UTC,
+	}, nil
+}
+
+// ListMembers retrieves the members of an organization, in the order they joined
+func (service OrganizationService) ListMembers(orgId string) ([]models.OrgMembership, error) {
+	objectId, err := primitive.ObjectIDFromHex(orgId)
+	if err != nil {
+		return nil, ErrOrganizationNotFound
+	}
+
+	cursor, err := service.memberCollection.Find(service.ctx, bson.M{"orgId": objectId},
+		options.Find().SetSort(bson.D{{Key: "joinedAt", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("list organization members: %w", err)
+	}
+
+	members := []models.OrgMembership{}
+	if err := cursor.All(service.ctx, &members); err != nil {
+		return nil, fmt.Errorf("list organization members: %w", err)
+	}
+
+	return members, nil
+}
+
+// SetRole changes the role of a member of an organization and returns the membership.
+// Returns ErrLastOrgOwner if it would leave the organization without an owner.
+func (service OrganizationService) SetRole(orgId string, uid string, role models.OrgRole) (*models.OrgMembership, error) {
+	if !role.IsValid() {
+		return nil, ErrInvalidOrgRole
+	}
+
+	membership, err := service.GetMember(orgId, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	if membership.Role == models.OrgOwnerRole && role != models.OrgOwnerRole {
+		if err := service.checkOtherOwner(membership.OrgId); err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = service.memberCollection.UpdateByID(service.ctx, membership.Id, bson.M{"$set": bson.M{"role": role}})
+	if err != nil {
+		return nil, fmt.Errorf("set organization role: %w", err)
+	}
+	membership.Role = role
+
+	return membership, nil
+}
+
+// RemoveMember removes a member from an organization and revokes the keys of the organization they created.
+// Returns ErrLastOrgOwner if it would leave the organization without an owner.
+func (service OrganizationService) RemoveMember(orgId string, uid string) error {
+	membership, err := service.GetMember(orgId, uid)
+	if err != nil {
+		return err
+	}
+
+	if membership.Role == models.OrgOwnerRole {
+		if err := service.checkOtherOwner(membership.OrgId); err != nil {
+			return err
+		}
+	}
+
+	if _, err := service.memberCollection.DeleteOne(service.ctx, bson.M{"_id": membership.Id}); err != nil {
+		return fmt.Errorf("remove organization member: %w", err)
+	}
+
+	if _, err := service.apiKeyCollection.DeleteMany(service.ctx, bson.M{"orgId": orgId, "uid": uid}); err != nil {
+		return fmt.Errorf("remove organization member api keys: %w", err)
+	}
+
+	return nil
+}
+
+// checkOtherOwner returns ErrLastOrgOwner unless the organization has more than one owner
+func (service OrganizationService) checkOtherOwner(orgId primitive.ObjectID) error {
+	owners, err := service.memberCollection.CountDocuments(service.ctx, bson.M{"orgId": orgId, "role": models.OrgOwnerRole})
+	if err != nil {
+		return fmt.Errorf("count organization owners: %w", err)
+	}
+	if owners <= 1 {
+		return ErrLastOrgOwner
+	}
+
+	return nil
+}
+
+// Invite invites an email address to an organization with the given role, members if empty, and emails it the
+// invitation token. Inviting an address again replaces its invitation with a new token and expiry.
+func (service OrganizationService) Invite(orgId string, invitedBy string, req models.InviteOrgMemberRequest) (*models.OrgInvitation, error) {
+	role := req.Role
+	if role == "" {
+		role = models.OrgMemberRole
+	}
+	if !role.IsValid() {
+		return nil, ErrInvalidOrgRole
+	}
+
+	org, err := service.Get(orgId)
+	if err != nil {
+		return nil, err
+	}
+
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+
+	members, err := service.ListMembers(orgId)
+	if err != nil {
+		return nil, err
+	}
+	for _, member := range members {
+		if strings.EqualFold(member.Email, email) {
+			return nil, ErrAlreadyOrgMember
+		}
+	}
+
+	invitations, err := service.invitationCollection.CountDocuments(service.ctx, bson.M{"orgId": org.Id, "email": bson.M{"$ne": email}})
+	if err != nil {
+		return nil, fmt.Errorf("invite organization member: %w", err)
+	}
+	if len(members)+int(invitations) >= models.MaxOrgMembers {
+		return nil, ErrTooManyOrgMembers
+	}
+
+	token, err := generateInvitationToken()
+	if err != nil {
+		return nil, fmt.Errorf("invite organization member: %w", err)
+	}
+
+	now := time.Now()
+	invitation := models.OrgInvitation{
+		OrgId:     org.Id,
+		Email:     email,
+		Role:      role,
+		TokenHash: hashInvitationToken(token),
+		InvitedBy: invitedBy,
+		CreatedAt: now,
+		ExpiresAt: now.Add(models.OrgInvitationExpiry),
+	}
+
+	err = service.invitationCollection.FindOneAndReplace(service.ctx, bson.M{"orgId": org.Id, "email": email}, invitation,
+		options.FindOneAndReplace().SetUpsert(true).SetReturnDocument(options.After)).Decode(&invitation)
+	if err != nil {
+		return nil, fmt.Errorf("invite organization member: %w", err)
+	}
+
+	if err := service.mailer.Send(service.invitationMail(invitation, org.Name, token)); err != nil {
+		// The token is only in the email, so an invitation that was not sent cannot be accepted
+		if _, deleteErr := service.invitationCollection.DeleteOne(service.ctx, bson.M{"_id": invitation.Id}); deleteErr != nil {
+			log.Println(deleteErr.Error())
+		}
+		return nil, fmt.Errorf("send organization invitation: %w", err)
+	}
+
+	return &invitation, nil
+}
+
+// ListInvitations retrieves the pending invitations of an organization, newest first
+func (service OrganizationService) ListInvitations(orgId string) ([]models.OrgInvitation, error) {
+	objectId, err := primitive.ObjectIDFromHex(orgId)
+	if err != nil {
+		return nil, ErrOrganizationNotFound
+	}
+
+	cursor, err := service.invitationCollection.Find(service.ctx,
+		bson.M{"orgId": objectId, "expiresAt": bson.M{"$gt": time.Now()}},
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}))
+	if err != nil {
+		return nil, fmt.Errorf("list organization invitations: %w", err)
+	}
+
+	invitations := []models.OrgInvitation{}
+	if err := cursor.All(service.ctx, &invitations); err != nil {
+		return nil, fmt.Errorf("list organization invitations: %w", err)
+	}
+
+	return invitations, nil
+}
+
+// RevokeInvitation deletes a pending invitation of an organization.
+// Returns ErrOrgInvitationNotFound if there is no such invitation.
+func (service OrganizationService) RevokeInvitation(orgId string, id string) error {
+	orgObjectId, err := primitive.ObjectIDFromHex(orgId)
+	if err != nil {
+		return ErrOrgInvitationNotFound
+	}
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrOrgInvitationNotFound
+	}
+
+	result, err := service.invitationCollection.DeleteOne(service.ctx, bson.M{"_id": objectId, "orgId": orgObjectId})
+	if err != nil {
+		return fmt.Errorf("revoke organization invitation: %w", err)
+	}
+	if result.DeletedCount == 0 {
+		return ErrOrgInvitationNotFound
+	}
+
+	return nil
+}
+
+// AcceptInvitation makes the given user a member of the organization the token invites them to.
+// The invitation must have been sent to the email address of the user.
+// Returns ErrOrgInvitationNotFound for unknown or expired tokens.
+func (service OrganizationService) AcceptInvitation(uid string, email string, token string) (*models.OrgMembership, error) {
+	var invitation models.OrgInvitation
+	err := service.invitationCollection.FindOne(service.ctx, bson.M{
+		"tokenHash": hashInvitationToken(token),
+		"expiresAt": bson.M{"$gt": time.Now()},
+	}).Decode(&invitation)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrOrgInvitationNotFound
+		}
+		return nil, fmt.Errorf("accept organization invitation: %w", err)
+	}
+
+	if !strings.EqualFold(invitation.Email, strings.TrimSpace(email)) {
+		return nil, ErrOrgInvitationEmail
+	}
+
+	membership := models.OrgMembership{
+		OrgId:    invitation.OrgId,
+		Uid:      uid,
+		Email:    email,
+		Role:     invitation.Role,
+		JoinedAt: time.Now(),
+	}
+
+	result, err := service.memberCollection.InsertOne(service.ctx, membership)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrAlreadyOrgMember
+		}
+		return nil, fmt.Errorf("accept organization invitation: %w", err)
+	}
+	membership.Id = result.InsertedID.(primitive.ObjectID)
+
+	if _, err := service.invitationCollection.DeleteOne(service.ctx, bson.M{"_id": invitation.Id}); err != nil {
+		log.Println(fmt.Errorf("accept organization invitation: %w", err).Error())
+	}
+
+	return &membership, nil
+}
+
+func (service OrganizationService) invitationMail(invitation models.OrgInvitation, orgName string, token string) mail.Message {
+	accept := fmt.Sprintf("Accept it by signing in with this email address and sending the invitation token to POST /api/orgs/invitations/accept:\n\n%s", token)
+	if service.invitationURL != "" {
+		accept = fmt.Sprintf("Accept it by signing in with this email address at:\n\n%s?token=%s", service.invitationURL, url.QueryEscape(token))
+	}
+
+	body := fmt.Sprintf("You have been invited to join the %s organization as %s.\n\n%s\n\nThe invitation expires at %s.\n",
+		orgName, invitation.Role, accept, invitation.ExpiresAt.UTC().Format("2006-01-02 15:04 MST"))
+
+	return mail.Message{
+		To:      invitation.Email,
+		Subject: fmt.Sprintf("You are invited to join %s", orgName),
+		Body:    body,
+	}
+}
+
+// generateInvitationToken returns a random invitation token
+func generateInvitationToken() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+
+	return "inv_" + hex.EncodeToString(bytes), nil
+}
+
+// hashInvitationToken returns the hash invitations are looked up by
+func hashInvitationToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
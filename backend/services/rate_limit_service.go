@@ -0,0 +1,324 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/AkifhanIlgaz/dictionary-api/models"
+	plan "github.com/AkifhanIlgaz/dictionary-api/utils/plans"
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	rateLimitPrefix    string = "rate_limit:"
+	quotaUsagePrefix   string = "quota_usage:"
+	overageAlertPrefix string = "overage_alert:"
+	quotaAlertPrefix   string = "quota_alert:"
+
+	// quotaUsageExpiry keeps quota counters a while after their window ends
+	quotaUsageExpiry time.Duration = 48 * time.Hour
+)
+
+// rateLimitScript counts a request against the quota and the GCRA (generic cell rate algorithm)
+// limit of a user in one round trip, and only counts it if both allow it. Requests over the quota
+// are allowed up to the quota limit of the overage policy.
+//
+// The GCRA key stores the theoretical arrival time (TAT) in milliseconds: the time at which the user would be
+// back to a full burst. Every request moves it forward by the emission interval, and a request is allowed as
+// long as the TAT stays within burst emission intervals from now.
+//
+// KEYS: rate limit key, quota usage key
+// ARGV: now in ms, emission interval in ms (0 = unlimited), burst, quota (0 = unlimited),
+// quota limit including overage (0 = unlimited), quota expiry in s
+// Returns: allowed (0/1), exceeded (0 = none, 1 = quota, 2 = burst), quota usage, retry after in ms, TAT - now in ms
+var rateLimitScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local emission = tonumber(ARGV[2])
+local burst = tonumber(ARGV[3])
+local quota = tonumber(ARGV[4])
+local limit = tonumber(ARGV[5])
+
+local used = tonumber(redis.call("GET", KEYS[2]) or "0")
+local tat = math.max(tonumber(redis.call("GET", KEYS[1]) or "0"), now)
+
+if quota > 0 and limit > 0 and used >= limit then
+	return {0, 1, used, 0, tat - now}
+end
+
+local new_tat = tat
+if emission > 0 then
+	new_tat = tat + emission
+	local allow_at = new_tat - emission * burst
+	if allow_at > now then
+		return {0, 2, used, allow_at - now, tat - now}
+	end
+	redis.call("SET", KEYS[1], string.format("%.0f", new_tat), "PX", new_tat - now)
+end
+
+used = redis.call("INCR", KEYS[2])
+if used == 1 then
+	redis.call("EXPIRE", KEYS[2], ARGV[6])
+end
+
+return {1, 0, used, 0, new_tat - now}
+`)
+
+// refundScript gives back a request reserved by rateLimitScript: it decrements the quota usage and moves
+// the TAT back by one emission interval, but never before now, so that refunds cannot build up extra burst.
+//
+// KEYS: rate limit key, quota usage key
+// ARGV: now in ms, emission interval in ms (0 = unlimited)
+var refundScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local emission = tonumber(ARGV[2])
+
+local used = tonumber(redis.call("GET", KEYS[2]) or "0")
+if used > 0 then
+	redis.call("DECR", KEYS[2])
+end
+
+local tat = tonumber(redis.call("GET", KEYS[1]) or "0")
+if emission > 0 and tat > now then
+	local new_tat = math.max(tat - emission, now)
+	if new_tat > now then
+		redis.call("SET", KEYS[1], string.format("%.0f", new_tat), "PX", new_tat - now)
+	else
+		redis.call("DEL", KEYS[1])
+	end
+end
+
+return 1
+`)
+
+// QuotaNotifier is called when the requests of a user first reach one of their alert thresholds
+// or go over the quota in a quota window
+type QuotaNotifier interface {
+	NotifyThreshold(event models.QuotaAlertEvent) error
+	NotifyOverage(event models.OverageEvent) error
+}
+
+// LogQuotaNotifier is the default QuotaNotifier which writes alerts and overage to the standard logger
+type LogQuotaNotifier struct{}
+
+func (LogQuotaNotifier) NotifyThreshold(event models.QuotaAlertEvent) error {
+	log.Printf("quota alert: %s reached %d%% of the %s quota of %d until %s", event.Uid, event.Threshold, event.Plan, event.Quota, event.WindowEnd.Format(time.RFC3339))
+	return nil
+}
+
+func (LogQuotaNotifier) NotifyOverage(event models.OverageEvent) error {
+	log.Printf("overage: %s went over the %s quota of %d with %s overage until %s", event.Uid, event.Plan, event.Quota, event.Mode, event.WindowEnd.Format(time.RFC3339))
+	return nil
+}
+
+// RateLimitService enforces the daily or monthly quota and the burst / per-minute limits of plans with
+// Redis counters, so that every server instance shares the same limits.
+type RateLimitService struct {
+	client   *redis.Client
+	notifier QuotaNotifier
+}
+
+// NewRateLimitService creates a new RateLimitService instance.
+// The notifier is called once per quota window when a user's requests reach an alert threshold or go over the quota.
+func NewRateLimitService(client *redis.Client, notifier QuotaNotifier) RateLimitService {
+	return RateLimitService{
+		client:   client,
+		notifier: notifier,
+	}
+}
+
+// Reserve counts a request of the user against the limits of the plan if they allow it.
+// The check and the count are atomic, so concurrent requests cannot exceed the limits.
+// Requests over the quota are allowed as far as the overage policy of the user allows them.
+// A reserved request that should not be charged must be given back with Refund.
+// The quota window of the plan starts at midnight in the billing timezone of the user.
+func (service RateLimitService) Reserve(uid string, userQuota models.UserQuota, now time.Time) (models.RateLimitResult, error) {
+	userPlan := userQuota.Plan
+	emission := emissionInterval(userPlan)
+	burst := max(userPlan.Burst, 1)
+	quota := userPlan.Quota()
+
+	start, end := userPlan.QuotaWindow.Bounds(now, userQuota.Location)
+
+	keys := rateLimitKeys(uid, userPlan.QuotaWindow, start)
+	args := []interface{}{
+		now.UnixMilli(),
+		emission.Milliseconds(),
+		burst,
+		quota,
+		userQuota.Overage.Limit(quota),
+		int((end.Sub(now) + quotaUsageExpiry).Seconds()),
+	}
+
+	values, err := rateLimitScript.Run(service.client.Context(), service.client, keys, args...).Int64Slice()
+	if err != nil {
+		return models.RateLimitResult{}, fmt.Errorf("check rate limit: %w", err)
+	}
+	if len(values) != 5 {
+		return models.RateLimitResult{}, fmt.Errorf("check rate limit: unexpected result %v", values)
+	}
+
+	allowed, exceeded, used, retryAfter, tatOffset := values[0] == 1, values[1], int(values[2]), values[3], values[4]
+
+	result := models.RateLimitResult{
+		Allowed:        allowed,
+		RetryAfter:     time.Duration(retryAfter) * time.Millisecond,
+		BurstLimit:     burst,
+		BurstRemaining: burst,
+		QuotaLimit:     quota,
+		QuotaRemaining: max(quota-used, 0),
+		QuotaReset:     end.Sub(now),
+		QuotaPeriod:    end.Sub(start),
+	}
+
+	if emission > 0 {
+		backlog := time.Duration(tatOffset) * time.Millisecond
+		result.BurstRemaining = max(int((time.Duration(burst)*emission-backlog)/emission), 0)
+		result.BurstReset = backlog
+		result.BurstPeriod = time.Duration(burst) * emission
+	}
+
+	switch exceeded {
+	case 1:
+		result.Exceeded = models.QuotaWindow
+		result.RetryAfter = result.QuotaReset
+	case 2:
+		result.Exceeded = models.BurstWindow
+	}
+
+	if allowed && quota > 0 {
+		for _, threshold := range userQuota.AlertThresholds {
+			if used == thresholdUsage(quota, threshold) {
+				service.notifyThreshold(models.QuotaAlertEvent{
+					Uid:         uid,
+					Plan:        userPlan.Type,
+					Window:      userPlan.QuotaWindow,
+					WindowStart: start,
+					WindowEnd:   end,
+					Threshold:   threshold,
+					Quota:       quota,
+					Used:        used,
+				})
+			}
+		}
+	}
+
+	if allowed && quota > 0 && used > quota {
+		result.Overage = userQuota.Overage.Mode
+		if used == quota+1 {
+			service.notifyOverage(models.OverageEvent{
+				Uid:         uid,
+				Plan:        userPlan.Type,
+				Mode:        userQuota.Overage.Mode,
+				Window:      userPlan.QuotaWindow,
+				WindowStart: start,
+				WindowEnd:   end,
+				Quota:       quota,
+				Limit:       userQuota.Overage.Limit(quota),
+			})
+		}
+	}
+
+	return result, nil
+}
+
+// notifyOverage calls the notifier once per user and quota window, refunds can make the first request over the
+// quota happen again. Failing to notify does not change the response.
+func (service RateLimitService) notifyOverage(event models.OverageEvent) {
+	key := overageAlertPrefix + event.Uid + ":" + strconv.FormatInt(event.WindowStart.Unix(), 10)
+	first, err := service.client.SetNX(service.client.Context(), key, 1, event.WindowEnd.Sub(event.WindowStart)+quotaUsageExpiry).Result()
+	if err != nil {
+		log.Println(err.Error())
+		return
+	}
+	if !first {
+		return
+	}
+
+	if err := service.notifier.NotifyOverage(event); err != nil {
+		log.Println(err.Error())
+	}
+}
+
+// notifyThreshold calls the notifier once per user, quota window and threshold, like notifyOverage
+func (service RateLimitService) notifyThreshold(event models.QuotaAlertEvent) {
+	key := quotaAlertPrefix + event.Uid + ":" + strconv.FormatInt(event.WindowStart.Unix(), 10) + ":" + strconv.Itoa(event.Threshold)
+	first, err := service.client.SetNX(service.client.Context(), key, 1, event.WindowEnd.Sub(event.WindowStart)+quotaUsageExpiry).Result()
+	if err != nil {
+		log.Println(err.Error())
+		return
+	}
+	if !first {
+		return
+	}
+
+	if err := service.notifier.NotifyThreshold(event); err != nil {
+		log.Println(err.Error())
+	}
+}
+
+// thresholdUsage is the number of requests that reaches a threshold in percent of the quota, rounded up
+func thresholdUsage(quota int, threshold int) int {
+	return (quota*threshold + 99) / 100
+}
+
+// Refund gives back a request reserved at reservedAt, e.g. because it failed.
+// It is counted towards the quota window the request was reserved in.
+func (service RateLimitService) Refund(uid string, userQuota models.UserQuota, reservedAt time.Time) error {
+	userPlan := userQuota.Plan
+	start, _ := userPlan.QuotaWindow.Bounds(reservedAt, userQuota.Location)
+
+	args := []interface{}{
+		time.Now().UnixMilli(),
+		emissionInterval(userPlan).Milliseconds(),
+	}
+
+	err := refundScript.Run(service.client.Context(), service.client, rateLimitKeys(uid, userPlan.QuotaWindow, start), args...).Err()
+	if err != nil {
+		return fmt.Errorf("refund rate limit: %w", err)
+	}
+
+	return nil
+}
+
+// GetQuotaUsage returns how much of the quota of the plan the user has used in the current window
+func (service RateLimitService) GetQuotaUsage(uid string, userQuota models.UserQuota, now time.Time) (*models.QuotaUsage, error) {
+	userPlan, loc := userQuota.Plan, userQuota.Location
+	start, end := userPlan.QuotaWindow.Bounds(now, loc)
+
+	used, err := service.client.Get(service.client.Context(), rateLimitKeys(uid, userPlan.QuotaWindow, start)[1]).Int()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("get quota usage: %w", err)
+	}
+
+	return &models.QuotaUsage{
+		Window:    userPlan.QuotaWindow,
+		Timezone:  loc.String(),
+		Start:     start,
+		ResetsAt:  end,
+		Limit:     userPlan.Quota(),
+		Used:      used,
+		Remaining: max(userPlan.Quota()-used, 0),
+		Overage:   userQuota.Overage,
+	}, nil
+}
+
+// emissionInterval is the time between two requests at the per-minute rate of the plan, 0 if unlimited
+func emissionInterval(userPlan plan.Plan) time.Duration {
+	if userPlan.RequestsPerMinute <= 0 {
+		return 0
+	}
+
+	return time.Minute / time.Duration(userPlan.RequestsPerMinute)
+}
+
+// rateLimitKeys returns the GCRA key of the user and the usage key of the user's quota window starting at start.
+// Windows are keyed by their start instant, so windows of different timezones never share a counter.
+func rateLimitKeys(uid string, window plan.Window, start time.Time) []string {
+	return []string{
+		rateLimitPrefix + uid,
+		quotaUsagePrefix + uid + ":" + string(window) + ":" + strconv.FormatInt(start.Unix(), 10),
+	}
+}
@@ -0,0 +1,191 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AkifhanIlgaz/dictionary-api/models"
+	plan "github.com/AkifhanIlgaz/dictionary-api/utils/plans"
+)
+
+// recordingQuotaNotifier records the alerts of a RateLimitService
+type recordingQuotaNotifier struct {
+	thresholds []models.QuotaAlertEvent
+	overages   []models.OverageEvent
+}
+
+func (notifier *recordingQuotaNotifier) NotifyThreshold(event models.QuotaAlertEvent) error {
+	notifier.thresholds = append(notifier.thresholds, event)
+	return nil
+}
+
+func (notifier *recordingQuotaNotifier) NotifyOverage(event models.OverageEvent) error {
+	notifier.overages = append(notifier.overages, event)
+	return nil
+}
+
+func newTestRateLimitService(t *testing.T) (RateLimitService, *recordingQuotaNotifier) {
+	t.Helper()
+
+	notifier := &recordingQuotaNotifier{}
+	return NewRateLimitService(newRedisClient(t), notifier), notifier
+}
+
+func testQuota(p plan.Plan) models.UserQuota {
+	return models.UserQuota{
+		Plan:     p,
+		Location: time.UTC,
+		Overage:  models.OveragePolicy{Mode: models.BlockOverage},
+	}
+}
+
+// reserve reserves a request and fails the test on errors
+func reserve(t *testing.T, service RateLimitService, uid string, userQuota models.UserQuota, now time.Time) models.RateLimitResult {
+	t.Helper()
+
+	result, err := service.Reserve(uid, userQuota, now)
+	if err != nil {
+		t.Fatalf("Reserve: %v", err)
+	}
+
+	return result
+}
+
+func TestReserveBurst(t *testing.T) {
+	service, _ := newTestRateLimitService(t)
+	uid := randomSubject()
+	// One request per second with bursts of 3
+	userQuota := testQuota(plan.Plan{RequestsPerMinute: 60, Burst: 3})
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 3; i++ {
+		result := reserve(t, service, uid, userQuota, now)
+		if !result.Allowed || result.BurstRemaining != 2-i {
+			t.Fatalf("request %d: %+v, want allowed with %d remaining", i+1, result, 2-i)
+		}
+	}
+
+	result := reserve(t, service, uid, userQuota, now)
+	if result.Allowed || result.Exceeded != models.BurstWindow || result.RetryAfter != time.Second {
+		t.Fatalf("request over the burst: %+v, want rejected for a second", result)
+	}
+
+	// Cells are emitted one interval at a time
+	if result := reserve(t, service, uid, userQuota, now.Add(999*time.Millisecond)); result.Allowed {
+		t.Errorf("request before the next emission allowed: %+v", result)
+	}
+	if result := reserve(t, service, uid, userQuota, now.Add(time.Second)); !result.Allowed {
+		t.Errorf("request at the next emission rejected: %+v", result)
+	}
+
+	// A full burst is available again after burst intervals without requests
+	later := now.Add(time.Minute)
+	for i := 0; i < 3; i++ {
+		if result := reserve(t, service, uid, userQuota, later); !result.Allowed {
+			t.Fatalf("request %d after a pause rejected: %+v", i+1, result)
+		}
+	}
+}
+
+func TestReserveQuota(t *testing.T) {
+	service, notifier := newTestRateLimitService(t)
+	uid := randomSubject()
+	userQuota := testQuota(plan.Plan{Type: plan.FreePlan, RequestsPerDay: 4})
+	userQuota.AlertThresholds = []int{50, 100}
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 4; i++ {
+		result := reserve(t, service, uid, userQuota, now)
+		if !result.Allowed || result.QuotaRemaining != 3-i {
+			t.Fatalf("request %d: %+v, want allowed with %d remaining", i+1, result, 3-i)
+		}
+	}
+
+	result := reserve(t, service, uid, userQuota, now)
+	if result.Allowed || result.Exceeded != models.QuotaWindow || result.RetryAfter != 12*time.Hour {
+		t.Errorf("request over the quota: %+v, want rejected until midnight", result)
+	}
+
+	if len(notifier.thresholds) != 2 || notifier.thresholds[0].Threshold != 50 || notifier.thresholds[1].Threshold != 100 {
+		t.Errorf("threshold alerts %+v, want 50 and 100", notifier.thresholds)
+	}
+	if len(notifier.overages) != 0 {
+		t.Errorf("overage alerts %+v without overage", notifier.overages)
+	}
+
+	// The quota window starts again at midnight
+	if result := reserve(t, service, uid, userQuota, now.Add(12*time.Hour)); !result.Allowed || result.QuotaRemaining != 3 {
+		t.Errorf("first request of the next day: %+v, want allowed with 3 remaining", result)
+	}
+}
+
+func TestReserveSoftCap(t *testing.T) {
+	service, notifier := newTestRateLimitService(t)
+	uid := randomSubject()
+	userQuota := testQuota(plan.Plan{RequestsPerDay: 10})
+	userQuota.Overage = models.OveragePolicy{Mode: models.SoftCapOverage, SoftCapPercent: 20}
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 12; i++ {
+		result := reserve(t, service, uid, userQuota, now)
+		if !result.Allowed {
+			t.Fatalf("request %d within the soft cap rejected: %+v", i+1, result)
+		}
+		if i >= 10 && result.Overage != models.SoftCapOverage {
+			t.Errorf("request %d over the quota has overage %q", i+1, result.Overage)
+		}
+	}
+
+	if result := reserve(t, service, uid, userQuota, now); result.Allowed || result.Exceeded != models.QuotaWindow {
+		t.Errorf("request over the soft cap: %+v, want rejected", result)
+	}
+	if len(notifier.overages) != 1 {
+		t.Errorf("overage alerts %+v, want one", notifier.overages)
+	}
+}
+
+func TestRefund(t *testing.T) {
+	service, _ := newTestRateLimitService(t)
+	uid := randomSubject()
+	userQuota := testQuota(plan.Plan{RequestsPerDay: 1, RequestsPerMinute: 60, Burst: 1})
+	now := time.Now()
+
+	if result := reserve(t, service, uid, userQuota, now); !result.Allowed {
+		t.Fatalf("first request rejected: %+v", result)
+	}
+	if err := service.Refund(uid, userQuota, now); err != nil {
+		t.Fatalf("Refund: %v", err)
+	}
+
+	usage, err := service.GetQuotaUsage(uid, userQuota, now)
+	if err != nil {
+		t.Fatalf("GetQuotaUsage: %v", err)
+	}
+	if usage.Used != 0 {
+		t.Errorf("quota usage after a refund = %d, want 0", usage.Used)
+	}
+
+	// The refunded request does not count towards the quota or the burst
+	if result := reserve(t, service, uid, userQuota, time.Now()); !result.Allowed {
+		t.Errorf("request after a refund rejected: %+v", result)
+	}
+}
+
+func TestReserveQuotaWindowTimezone(t *testing.T) {
+	service, _ := newTestRateLimitService(t)
+	uid := randomSubject()
+	istanbul, err := time.LoadLocation("Europe/Istanbul")
+	if err != nil {
+		t.Skipf("timezone database unavailable: %v", err)
+	}
+	userQuota := testQuota(plan.Plan{RequestsPerDay: 1})
+	userQuota.Location = istanbul
+
+	// 22:30 UTC is already the next day in Istanbul
+	if result := reserve(t, service, uid, userQuota, time.Date(2026, 3, 1, 20, 30, 0, 0, time.UTC)); !result.Allowed {
+		t.Fatalf("first request rejected: %+v", result)
+	}
+	if result := reserve(t, service, uid, userQuota, time.Date(2026, 3, 1, 22, 30, 0, 0, time.UTC)); !result.Allowed {
+		t.Errorf("first request of the next day in the billing timezone rejected: %+v", result)
+	}
+}
@@ -0,0 +1,62 @@
+package services
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// newTestRedis starts an in-memory Redis server for a test and returns a client of it, with the server
+// to fast-forward the expiry of keys
+func newTestRedis(t *testing.T) (*redis.Client, *miniredis.Miniredis) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	return client, server
+}
+
+// randomSubject returns a unique ID for the keys of a test
+func randomSubject() string {
+	return primitive.NewObjectID().Hex()
+}
+
+// newRedisClient returns a client of an in-memory Redis server for a test
+func newRedisClient(t *testing.T) *redis.Client {
+	t.Helper()
+
+	client, _ := newTestRedis(t)
+	return client
+}
+
+// newTestDatabase returns a fresh database on the MongoDB server at TEST_MONGO_URI, which is dropped after the test.
+// Tests that need MongoDB are skipped if TEST_MONGO_URI is not set.
+func newTestDatabase(t *testing.T) *mongo.Database {
+	t.Helper()
+
+	uri := os.Getenv("TEST_MONGO_URI")
+	if uri == "" {
+		t.Skip("TEST_MONGO_URI is not set")
+	}
+
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("connect to mongodb: %v", err)
+	}
+
+	database := client.Database("test_" + randomSubject())
+	t.Cleanup(func() {
+		database.Drop(context.Background())
+		client.Disconnect(context.Background())
+	})
+
+	return database
+}
@@ -0,0 +1,229 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/AkifhanIlgaz/dictionary-api/config"
+	"github.com/AkifhanIlgaz/dictionary-api/models"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/db"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/keyring"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// keyActivationDelay is how long a new key is published in the JWKS before it signs tokens,
+	// so that verifiers caching the JWKS pick it up first
+	keyActivationDelay time.Duration = time.Hour
+	keyReloadInterval  time.Duration = time.Minute
+)
+
+// SigningKeyService owns the access token keyring.
+// The configured key pair is always loaded. With rotation enabled, a new key is generated every
+// rotation period and stored in MongoDB, encrypted with the key-encryption key of the config,
+// so that every server instance signs with the same keys.
+// A key retires once its successor has been active for longer than the access token lifetime.
+type SigningKeyService struct {
+	ctx        context.Context
+	collection *mongo.Collection
+	keyring    *keyring.Keyring
+	configKey  keyring.Key
+	// encryptionKey encrypts the stored keys, it is only set with rotation
+	encryptionKey []byte
+	rotation      time.Duration
+	tokenExpiry   time.Duration
+}
+
+// NewSigningKeyService parses the configured access token keys once and loads any rotated keys.
+// It returns an error if the configured keys are invalid, rotation is enabled without a valid key-encryption key,
+// or the index creation fails.
+func NewSigningKeyService(ctx context.Context, mongoDatabase *mongo.Database, config config.Config) (SigningKeyService, error) {
+	configKey, err := keyring.ParseKey(config.AccessTokenPrivateKey, config.AccessTokenPublicKey)
+	if err != nil {
+		return SigningKeyService{}, fmt.Errorf("initialize signing key service: %w", err)
+	}
+
+	var encryptionKey []byte
+	if config.AccessTokenRotation > 0 {
+		if config.SigningKeyEncryptionKey == "" {
+			return SigningKeyService{}, fmt.Errorf("initialize signing key service: SIGNING_KEY_ENCRYPTION_KEY is required with rotation")
+		}
+
+		encryptionKey, err = keyring.ParseEncryptionKey(config.SigningKeyEncryptionKey)
+		if err != nil {
+			return SigningKeyService{}, fmt.Errorf("initialize signing key service: %w", err)
+		}
+	}
+
+	collection := mongoDatabase.Collection(db.SigningKeysCollection)
+
+	_, err = collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "period", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return SigningKeyService{}, fmt.Errorf("initialize signing key service: %w", err)
+	}
+
+	service := SigningKeyService{
+		ctx:           ctx,
+		collection:    collection,
+		keyring:       keyring.New(configKey),
+		configKey:     configKey,
+		encryptionKey: encryptionKey,
+		rotation:      time.Duration(config.AccessTokenRotation) * time.Hour,
+		tokenExpiry:   time.Duration(config.AccessTokenExpiry) * time.Hour,
+	}
+
+	if err := service.reload(time.Now()); err != nil {
+		return SigningKeyService{}, fmt.Errorf("initialize signing key service: %w", err)
+	}
+
+	return service, nil
+}
+
+// Keyring returns the access token keyring, which is kept up to date while rotation runs
+func (service SigningKeyService) Keyring() *keyring.Keyring {
+	return service.keyring
+}
+
+// StartRotation generates a key whenever a new rotation period begins and reloads keys
+// generated by other instances. It does nothing if rotation is disabled and stops when ctx is done.
+func (service SigningKeyService) StartRotation(ctx context.Context) {
+	if service.rotation <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(keyReloadInterval)
+		defer ticker.Stop()
+
+		for {
+			now := time.Now()
+			if err := service.rotate(now); err != nil {
+				log.Println(err.Error())
+			}
+			if err := service.reload(now); err != nil {
+				log.Println(err.Error())
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// rotate generates the key of the current rotation period unless one exists already
+func (service SigningKeyService) rotate(now time.Time) error {
+	period := now.Truncate(service.rotation)
+
+	count, err := service.collection.CountDocuments(service.ctx, bson.M{"period": period})
+	if err != nil {
+		return fmt.Errorf("rotate signing key: %w", err)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	key, err := keyring.Generate()
+	if err != nil {
+		return fmt.Errorf("rotate signing key: %w", err)
+	}
+
+	encryptedPrivateKey, err := keyring.EncryptPrivateKey(key, service.encryptionKey)
+	if err != nil {
+		return fmt.Errorf("rotate signing key: %w", err)
+	}
+
+	_, err = service.collection.InsertOne(service.ctx, models.SigningKey{
+		Id:                  key.Id,
+		Period:              period,
+		EncryptedPrivateKey: encryptedPrivateKey,
+		ActivatesAt:         now.Add(min(keyActivationDelay, service.rotation/2)),
+		CreatedAt:           now,
+	})
+	if err != nil {
+		// Another instance generated the key for this period first
+		if mongo.IsDuplicateKeyError(err) {
+			return nil
+		}
+		return fmt.Errorf("rotate signing key: %w", err)
+	}
+
+	return nil
+}
+
+// reload replaces the keyring with the configured key and the stored keys,
+// computes when each key retires and deletes stored keys that have retired or are not encrypted.
+// Stored keys are only loaded with rotation, which provides the key to decrypt them.
+func (service SigningKeyService) reload(now time.Time) error {
+	if service.encryptionKey == nil {
+		_, err := service.collection.DeleteMany(service.ctx, bson.M{"encryptedPrivateKey": bson.M{"$exists": false}})
+		if err != nil {
+			return fmt.Errorf("delete unencrypted signing keys: %w", err)
+		}
+		return nil
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "activatesAt", Value: 1}})
+
+	cursor, err := service.collection.Find(service.ctx, bson.M{}, opts)
+	if err != nil {
+		return fmt.Errorf("reload signing keys: %w", err)
+	}
+
+	var documents []models.SigningKey
+	if err := cursor.All(service.ctx, &documents); err != nil {
+		return fmt.Errorf("reload signing keys: %w", err)
+	}
+
+	var active []keyring.Key
+	var retired []string
+
+	keys := []keyring.Key{service.configKey}
+	for _, document := range documents {
+		if document.EncryptedPrivateKey == "" {
+			log.Printf("reload signing keys: deleting unencrypted key %s", document.Id)
+			retired = append(retired, document.Id)
+			continue
+		}
+
+		key, err := keyring.DecryptPrivateKey(document.EncryptedPrivateKey, document.Id, service.encryptionKey)
+		if err != nil {
+			return fmt.Errorf("reload signing keys: %s: %w", document.Id, err)
+		}
+
+		key.ActivatesAt = document.ActivatesAt
+		keys = append(keys, key)
+	}
+
+	for i := range keys {
+		if i+1 < len(keys) && !keys[i+1].ActivatesAt.After(now) {
+			keys[i].RetiresAt = keys[i+1].ActivatesAt.Add(service.tokenExpiry)
+		}
+
+		if !keys[i].RetiresAt.IsZero() && now.After(keys[i].RetiresAt) {
+			retired = append(retired, keys[i].Id)
+			continue
+		}
+		active = append(active, keys[i])
+	}
+
+	service.keyring.Replace(active)
+
+	if len(retired) > 0 {
+		_, err := service.collection.DeleteMany(service.ctx, bson.M{"_id": bson.M{"$in": retired}})
+		if err != nil {
+			return fmt.Errorf("delete retired signing keys: %w", err)
+		}
+	}
+
+	return nil
+}
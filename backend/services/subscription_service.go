@@ -0,0 +1,459 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/AkifhanIlgaz/dictionary-api/config"
+	"github.com/AkifhanIlgaz/dictionary-api/models"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/db"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/message"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/payment"
+	plan "github.com/AkifhanIlgaz/dictionary-api/utils/plans"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// billingEventRetention is how long the IDs of processed webhook events are kept to ignore redeliveries
+const billingEventRetention time.Duration = 30 * 24 * time.Hour
+
+var (
+	ErrUnknownPlan          = errors.New(message.UnknownPlan)
+	ErrInvalidPlanChange    = errors.New(message.InvalidPlanChange)
+	ErrSubscriptionExists   = errors.New(message.SubscriptionExists)
+	ErrSubscriptionNotFound = errors.New(message.SubscriptionNotFound)
+	ErrInvalidWebhook       = errors.New(message.InvalidWebhook)
+	ErrCheckoutNotFound     = errors.New(message.FakeCheckoutNotFound)
+)
+
+// SubscriptionService sells plans through a payment provider and keeps the plans of users in sync with their subscriptions.
+// The provider is the source of truth: subscriptions are updated from its signed webhook events, and users
+// get the plan of their subscription while it is trialing, active or past due, and the default plan otherwise.
+type SubscriptionService struct {
+	ctx             context.Context
+	collection      *mongo.Collection
+	eventCollection *mongo.Collection
+	authService     AuthService
+	invoiceService  InvoiceService
+	webhookService  WebhookService
+	auditService    AuditService
+	plans           *plan.Registry
+	provider        payment.Provider
+	successURL      string
+	cancelURL       string
+}
+
+// NewSubscriptionService creates a new SubscriptionService instance and initializes the indexes of the
+// subscriptions and billing events collections
+func NewSubscriptionService(ctx context.Context, mongoDatabase *mongo.Database, authService AuthService, invoiceService InvoiceService, webhookService WebhookService, auditService AuditService, plans *plan.Registry, provider payment.Provider, config config.Config) (SubscriptionService, error) {
+	collection := mongoDatabase.Collection(db.SubscriptionsCollection)
+	eventCollection := mongoDatabase.Collection(db.BillingEventsCollection)
+
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "uid", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "provider", Value: 1}, {Key: "subscriptionId", Value: 1}},
+		},
+	})
+	if err != nil {
+		return SubscriptionService{}, fmt.Errorf("initialize subscriptions collection: %w", err)
+	}
+
+	_, err = eventCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "receivedAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(billingEventRetention.Seconds())),
+	})
+	if err != nil {
+		return SubscriptionService{}, fmt.Errorf("initialize billing events collection: %w", err)
+	}
+
+	return SubscriptionService{
+		ctx:             ctx,
+		collection:      collection,
+		eventCollection: eventCollection,
+		authService:     authService,
+		invoiceService:  invoiceService,
+		webhookService:  webhookService,
+		auditService:    auditService,
+		plans:           plans,
+		provider:        provider,
+		successURL:      config.BillingSuccessURL,
+		cancelURL:       config.BillingCancelURL,
+	}, nil
+}
+
+// IsFake reports whether payments go to the local fake provider
+func (service SubscriptionService) IsFake() bool {
+	_, ok := service.provider.(*payment.FakeProvider)
+	return ok
+}
+
+// GetSubscription returns the subscription of a user.
+// Returns ErrSubscriptionNotFound if the user never subscribed.
+func (service SubscriptionService) GetSubscription(uid string) (*models.Subscription, error) {
+	var subscription models.Subscription
+	err := service.collection.FindOne(service.ctx, bson.M{"uid": uid}).Decode(&subscription)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrSubscriptionNotFound
+		}
+		return nil, fmt.Errorf("get subscription: %w", err)
+	}
+
+	return &subscription, nil
+}
+
+// Checkout creates a checkout session for subscribing to a paid plan, which must be an upgrade of the current plan.
+// Users subscribing for the first time get the trial of the plan.
+// The user gets the plan when the provider reports the completed checkout.
+func (service SubscriptionService) Checkout(uid string, planType plan.PlanType) (*models.CheckoutSessionResponse, error) {
+	target, ok := service.plans.Get(planType)
+	if !ok {
+		return nil, ErrUnknownPlan
+	}
+	if target.Amount() == 0 {
+		return nil, ErrInvalidPlanChange
+	}
+
+	user, err := service.authService.GetById(uid)
+	if err != nil {
+		return nil, fmt.Errorf("checkout: %w", err)
+	}
+
+	existing, err := service.GetSubscription(uid)
+	if err != nil && !errors.Is(err, ErrSubscriptionNotFound) {
+		return nil, fmt.Errorf("checkout: %w", err)
+	}
+	if existing != nil && existing.Status.HasPlan() {
+		return nil, ErrSubscriptionExists
+	}
+
+	if _, err := service.plans.UpgradePlan(service.plans.Resolve(user.Plan).Type, target.Type); err != nil {
+		return nil, ErrInvalidPlanChange
+	}
+
+	params := payment.CheckoutParams{
+		Uid:        uid,
+		Email:      user.Email,
+		Plan:       target.Type,
+		Amount:     target.Amount(),
+		SuccessURL: service.successURL,
+		CancelURL:  service.cancelURL,
+	}
+	if existing == nil {
+		params.TrialDays = target.TrialDays
+	} else {
+		params.CustomerId = existing.CustomerId
+	}
+
+	session, err := service.provider.CreateCheckoutSession(service.ctx, params)
+	if err != nil {
+		return nil, fmt.Errorf("checkout: %w", err)
+	}
+
+	return &models.CheckoutSessionResponse{
+		SessionId: session.Id,
+		URL:       session.URL,
+		ExpiresAt: session.ExpiresAt,
+	}, nil
+}
+
+// ChangePlan moves a trialing or active subscription to another paid plan right away.
+// The change has to be an upgrade or a downgrade in the plan registry. The rest of the current period
+// is prorated: upgrades are charged the difference and downgrades are credited it, on the provider and on the next
+// invoice. Trials are not prorated.
+func (service SubscriptionService) ChangePlan(uid string, planType plan.PlanType, now time.Time) (*models.PlanChangeResponse, error) {
+	target, ok := service.plans.Get(planType)
+	if !ok {
+		return nil, ErrUnknownPlan
+	}
+
+	subscription, err := service.GetSubscription(uid)
+	if err != nil {
+		return nil, fmt.Errorf("change plan: %w", err)
+	}
+	if subscription.Status != payment.TrialingStatus && subscription.Status != payment.ActiveStatus {
+		return nil, ErrSubscriptionNotFound
+	}
+
+	// Moving to a free plan is a cancellation
+	if target.Amount() == 0 {
+		return nil, ErrInvalidPlanChange
+	}
+
+	_, upgradeErr := service.plans.UpgradePlan(subscription.Plan, target.Type)
+	_, downgradeErr := service.plans.DowngradePlan(subscription.Plan, target.Type)
+	if upgradeErr != nil && downgradeErr != nil {
+		return nil, ErrInvalidPlanChange
+	}
+
+	var proration int64
+	if subscription.Status == payment.ActiveStatus {
+		proration = payment.Prorate(subscription.Amount, target.Amount(), subscription.CurrentPeriodStart, subscription.CurrentPeriodEnd, now)
+	}
+
+	changed, err := service.provider.ChangePlan(service.ctx, subscription.SubscriptionId, payment.ChangeParams{
+		Plan:            target.Type,
+		Amount:          target.Amount(),
+		ProrationAmount: proration,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("change plan: %w", err)
+	}
+
+	if proration != 0 {
+		description := fmt.Sprintf("Change from %s to %s plan, prorated until %s", service.plans.Resolve(string(subscription.Plan)).Name,
+			target.Name, subscription.CurrentPeriodEnd.UTC().Format(invoiceDateLayout))
+		if err := service.invoiceService.AddItem(uid, description, proration, now); err != nil {
+			return nil, fmt.Errorf("change plan: %w", err)
+		}
+	}
+
+	updated, err := service.sync(*changed, now)
+	if err != nil {
+		return nil, fmt.Errorf("change plan: %w", err)
+	}
+
+	return &models.PlanChangeResponse{
+		Subscription:    updated,
+		ProrationAmount: proration,
+		Currency:        payment.Currency,
+	}, nil
+}
+
+// Cancel cancels the subscription of a user at the end of the current period.
+// The user keeps the plan until the provider reports the subscription as deleted.
+func (service SubscriptionService) Cancel(uid string, now time.Time) (*models.Subscription, error) {
+	subscription, err := service.GetSubscription(uid)
+	if err != nil {
+		return nil, fmt.Errorf("cancel subscription: %w", err)
+	}
+	if !subscription.Status.HasPlan() {
+		return nil, ErrSubscriptionNotFound
+	}
+
+	canceled, err := service.provider.CancelSubscription(service.ctx, subscription.SubscriptionId)
+	if err != nil {
+		return nil, fmt.Errorf("cancel subscription: %w", err)
+	}
+
+	updated, err := service.sync(*canceled, now)
+	if err != nil {
+		return nil, fmt.Errorf("cancel subscription: %w", err)
+	}
+
+	return updated, nil
+}
+
+// HandleWebhook verifies and applies a webhook request of the payment provider.
+// Every event is applied once, redelivered events are ignored. Returns ErrInvalidWebhook if the
+// signature or payload is invalid. If applying the event fails, it can be retried by redelivering it.
+func (service SubscriptionService) HandleWebhook(payload []byte, header http.Header) error {
+	event, err := service.provider.ParseWebhook(payload, header)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidWebhook, err)
+	}
+	if event.Id == "" || event.Subscription.Uid == "" {
+		return ErrInvalidWebhook
+	}
+
+	_, err = service.eventCollection.InsertOne(service.ctx, bson.M{
+		"_id":        service.provider.Name() + ":" + event.Id,
+		"type":       event.Type,
+		"receivedAt": time.Now(),
+	})
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil
+		}
+		return fmt.Errorf("handle webhook: %w", err)
+	}
+
+	if _, err := service.sync(event.Subscription, event.CreatedAt); err != nil {
+		if _, deleteErr := service.eventCollection.DeleteOne(service.ctx, bson.M{"_id": service.provider.Name() + ":" + event.Id}); deleteErr != nil {
+			log.Println(deleteErr.Error())
+		}
+		return fmt.Errorf("handle webhook %s: %w", event.Type, err)
+	}
+
+	return nil
+}
+
+// CompleteFakeCheckout completes a checkout session of the fake provider and applies its webhook,
+// as if the user paid on the checkout page. Returns ErrCheckoutNotFound for unknown sessions or other providers.
+func (service SubscriptionService) CompleteFakeCheckout(sessionId string) error {
+	fake, ok := service.provider.(*payment.FakeProvider)
+	if !ok {
+		return ErrCheckoutNotFound
+	}
+
+	payload, header, err := fake.CompleteCheckout(sessionId)
+	if err != nil {
+		if errors.Is(err, payment.ErrFakeNotFound) {
+			return ErrCheckoutNotFound
+		}
+		return fmt.Errorf("complete fake checkout: %w", err)
+	}
+
+	return service.HandleWebhook(payload, header)
+}
+
+// EndFakePeriod ends the current period of the user's subscription at the fake provider and applies its webhook.
+// Subscriptions canceled at the period end are deleted, others renew if paid and become past due otherwise.
+func (service SubscriptionService) EndFakePeriod(uid string, paid bool) error {
+	fake, ok := service.provider.(*payment.FakeProvider)
+	if !ok {
+		return ErrSubscriptionNotFound
+	}
+
+	subscription, err := service.GetSubscription(uid)
+	if err != nil {
+		return fmt.Errorf("end fake period: %w", err)
+	}
+
+	payload, header, err := fake.EndPeriod(subscription.SubscriptionId, paid)
+	if err != nil {
+		if errors.Is(err, payment.ErrFakeNotFound) {
+			return ErrSubscriptionNotFound
+		}
+		return fmt.Errorf("end fake period: %w", err)
+	}
+
+	return service.HandleWebhook(payload, header)
+}
+
+// sync stores the state of a subscription at the provider as of the given time and moves the user to its plan.
+// States older than the stored one are ignored, so that events delivered out of order cannot roll it back.
+// When the state closes the stored period, by starting the next one or by ending the subscription, the stored
+// period is invoiced first, so that a failure leaves it stored for the retry.
+func (service SubscriptionService) sync(state payment.Subscription, asOf time.Time) (*models.Subscription, error) {
+	now := time.Now()
+
+	stored, err := service.GetSubscription(state.Uid)
+	if err != nil && !errors.Is(err, ErrSubscriptionNotFound) {
+		return nil, fmt.Errorf("sync subscription: %w", err)
+	}
+
+	if stored != nil && stored.Status.HasPlan() && !asOf.Before(stored.LastEventAt) &&
+		(state.Status == payment.CanceledStatus || state.CurrentPeriodStart.After(stored.CurrentPeriodStart)) {
+		if _, err := service.invoiceService.Issue(*stored, now); err != nil {
+			return nil, fmt.Errorf("sync subscription: %w", err)
+		}
+	}
+
+	filter := bson.M{
+		"uid": state.Uid,
+		"$or": bson.A{
+			bson.M{"lastEventAt": bson.M{"$exists": false}},
+			bson.M{"lastEventAt": bson.M{"$lte": asOf}},
+		},
+	}
+	set := bson.M{
+		"provider":           service.provider.Name(),
+		"customerId":         state.CustomerId,
+		"subscriptionId":     state.Id,
+		"plan":               state.Plan,
+		"amount":             state.Amount,
+		"currency":           payment.Currency,
+		"status":             state.Status,
+		"currentPeriodStart": state.CurrentPeriodStart,
+		"currentPeriodEnd":   state.CurrentPeriodEnd,
+		"trialEnd":           state.TrialEnd,
+		"cancelAtPeriodEnd":  state.CancelAtPeriodEnd,
+		"updatedAt":          now,
+		"lastEventAt":        asOf,
+	}
+
+	// A new period is billed the plan it starts with, trials are free
+	if stored == nil || !stored.Status.HasPlan() || !stored.CurrentPeriodStart.Equal(state.CurrentPeriodStart) {
+		set["periodPlan"] = state.Plan
+		set["periodAmount"] = state.Amount
+		if state.Status == payment.TrialingStatus {
+			set["periodAmount"] = 0
+		}
+	}
+
+	update := bson.M{
+		"$set": set,
+		"$setOnInsert": bson.M{
+			"createdAt": now,
+		},
+	}
+
+	var subscription models.Subscription
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err = service.collection.FindOneAndUpdate(service.ctx, filter, update, opts).Decode(&subscription)
+	if err != nil {
+		// The stored subscription is newer, so the upsert conflicts with it on the uid
+		if mongo.IsDuplicateKeyError(err) {
+			return service.GetSubscription(state.Uid)
+		}
+		return nil, fmt.Errorf("sync subscription: %w", err)
+	}
+
+	if err := service.applyPlan(subscription); err != nil {
+		return nil, err
+	}
+
+	return &subscription, nil
+}
+
+// applyPlan moves the user to the plan of the subscription while it has one, or to the default plan.
+// The change goes through the upgrade and downgrade validation of the plan registry, and is published
+// as a plan.changed webhook event and recorded in the audit log as an action of the system.
+func (service SubscriptionService) applyPlan(subscription models.Subscription) error {
+	target := service.plans.Default().Type
+	if subscription.Status.HasPlan() {
+		target = subscription.Plan
+	}
+
+	current, err := service.authService.GetUserPlan(subscription.Uid)
+	if err != nil {
+		return fmt.Errorf("apply subscription plan: %w", err)
+	}
+	if current.Type == service.plans.Resolve(string(target)).Type {
+		return nil
+	}
+
+	if _, err := service.plans.UpgradePlan(current.Type, target); err == nil {
+		err = service.authService.UpgradePlan(subscription.Uid, target)
+	} else {
+		err = service.authService.DowngradePlan(subscription.Uid, target)
+	}
+	if err != nil {
+		return err
+	}
+
+	planType := service.plans.Resolve(string(target)).Type
+
+	err = service.webhookService.Publish(subscription.Uid, models.PlanChangedEvent, models.PlanChangedEventData{
+		PreviousPlan: current.Type,
+		Plan:         planType,
+	})
+	if err != nil {
+		log.Println(err.Error())
+	}
+
+	err = service.auditService.Record(models.AuditEvent{
+		Action: models.PlanChangedAction,
+		Uid:    subscription.Uid,
+		Details: map[string]string{
+			"previousPlan": string(current.Type),
+			"plan":         string(planType),
+			"source":       "subscription",
+		},
+	})
+	if err != nil {
+		log.Println(err.Error())
+	}
+
+	return nil
+}
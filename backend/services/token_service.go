@@ -1,40 +1,66 @@
 package services
 
 import (
+	"crypto/rand"
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/AkifhanIlgaz/dictionary-api/config"
 	"github.com/AkifhanIlgaz/dictionary-api/models"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/keyring"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/rbac"
 	"github.com/go-redis/redis/v8"
 	"github.com/golang-jwt/jwt/v4"
 )
 
+const (
+	mfaTokenPrefix      string        = "mfa_pending:"
+	mfaAttemptsPrefix   string        = "mfa_attempts:"
+	mfaTokenExpiry      time.Duration = 5 * time.Minute
+	mfaTokenMaxAttempts int64         = 5
+	// tokensRevokedPrefix keys the time before which the access tokens of a user were revoked
+	tokensRevokedPrefix string = "tokens_revoked:"
+	// impersonationTokenExpiry is how long support staff and admins can act as a user, without a refresh token
+	impersonationTokenExpiry time.Duration = 15 * time.Minute
+)
+
 // TokenService handles JWT token generation and parsing operations
 type TokenService struct {
-	client *redis.Client
-	config config.Config
+	client      *redis.Client
+	config      config.Config
+	accessKeys  *keyring.Keyring
+	refreshKeys *keyring.Keyring
 }
 
-// NewTokenService creates a new TokenService instance with the provided configuration
-func NewTokenService(config config.Config, client *redis.Client) TokenService {
-	return TokenService{
-		config: config,
-		client: client,
+// NewTokenService creates a new TokenService instance with the provided configuration.
+// Access tokens are signed with the given keyring. The refresh token keys are parsed once from the config.
+// Returns an error if the refresh token keys are invalid.
+func NewTokenService(config config.Config, client *redis.Client, accessKeys *keyring.Keyring) (TokenService, error) {
+	refreshKey, err := keyring.ParseKey(config.RefreshTokenPrivateKey, config.RefreshTokenPublicKey)
+	if err != nil {
+		return TokenService{}, fmt.Errorf("initialize token service: %w", err)
 	}
+
+	return TokenService{
+		config:      config,
+		client:      client,
+		accessKeys:  accessKeys,
+		refreshKeys: keyring.New(refreshKey),
+	}, nil
 }
 
-// CreateTokens generates both access and refresh tokens for a given user ID
+// CreateTokens generates both access and refresh tokens for a given user ID and role
 // Returns a models.Tokens struct containing both tokens, or an error if token generation fails
-func (service TokenService) CreateTokens(uid string) (models.Tokens, error) {
-	accessToken, err := service.generateToken("access", uid)
+func (service TokenService) CreateTokens(uid string, role rbac.Role) (models.Tokens, error) {
+	accessToken, err := service.generateToken("access", uid, role)
 	if err != nil {
 		return models.Tokens{}, fmt.Errorf("create tokens: %w", err)
 	}
 
-	refreshToken, err := service.generateToken("refresh", uid)
+	refreshToken, err := service.generateToken("refresh", uid, role)
 	if err != nil {
 		return models.Tokens{}, fmt.Errorf("create tokens: %w", err)
 	}
@@ -64,18 +90,137 @@ func (service TokenService) IsRefreshTokenExpired(token string) (bool, error) {
 	return true, nil
 }
 
+// CreateImpersonationToken issues a short-lived access token of the given user ID and role to the impersonator,
+// without a refresh token. The token carries the impersonator, so that it can be limited to reading.
+func (service TokenService) CreateImpersonationToken(uid string, role rbac.Role, impersonator string) (models.ImpersonationToken, error) {
+	now := time.Now()
+	expiresAt := now.Add(impersonationTokenExpiry)
+
+	token, err := signToken(service.accessKeys, models.TokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   uid,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+		Role:         string(role),
+		Impersonator: impersonator,
+	})
+	if err != nil {
+		return models.ImpersonationToken{}, fmt.Errorf("create impersonation token: %w", err)
+	}
+
+	return models.ImpersonationToken{
+		AccessToken: token,
+		ExpiresAt:   expiresAt,
+	}, nil
+}
+
+// RevokeAccessTokens rejects the access tokens issued to the given user ID until now, e.g. when the user is suspended
+// or their role changes.
+// The revocation is kept until the last of them expires.
+func (service TokenService) RevokeAccessTokens(uid string, now time.Time) error {
+	expiry := time.Duration(service.config.AccessTokenExpiry) * time.Hour
+	err := service.client.Set(service.client.Context(), tokensRevokedPrefix+uid, now.Unix(), expiry).Err()
+	if err != nil {
+		return fmt.Errorf("revoke access tokens: %w", err)
+	}
+
+	return nil
+}
+
+// IsRevoked reports whether the access tokens of the user were revoked since the token was issued.
+// Tokens revoked in the second they were issued in are revoked too.
+func (service TokenService) IsRevoked(claims models.TokenClaims) (bool, error) {
+	value, err := service.client.Get(service.client.Context(), tokensRevokedPrefix+claims.Subject).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return false, nil
+		}
+		return false, fmt.Errorf("check revoked tokens in redis: %w", err)
+	}
+
+	revokedAt, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return false, fmt.Errorf("check revoked tokens in redis: %w", err)
+	}
+
+	// Tokens issued before tokens carried their issue time have none
+	return claims.IssuedAt == nil || claims.IssuedAt.Unix() <= revokedAt, nil
+}
+
+// CreateMFAToken issues a short-lived opaque token proving that the password step of a login succeeded.
+// The token is stored on Redis and must be exchanged together with a valid MFA code for the usual tokens.
+func (service TokenService) CreateMFAToken(uid string) (string, error) {
+	randomBytes := make([]byte, 32)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", fmt.Errorf("create mfa token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(randomBytes)
+
+	err := service.client.Set(service.client.Context(), mfaTokenPrefix+token, uid, mfaTokenExpiry).Err()
+	if err != nil {
+		return "", fmt.Errorf("store mfa token on redis: %w", err)
+	}
+
+	return token, nil
+}
+
+// ParseMFAToken returns the user ID the MFA token was issued for.
+// Every call counts as an attempt and the token is revoked once mfaTokenMaxAttempts is exceeded.
+func (service TokenService) ParseMFAToken(token string) (string, error) {
+	ctx := service.client.Context()
+
+	uid, err := service.client.Get(ctx, mfaTokenPrefix+token).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return "", errors.New("mfa token not found")
+		}
+		return "", fmt.Errorf("check mfa token in redis: %w", err)
+	}
+
+	attempts, err := service.client.Incr(ctx, mfaAttemptsPrefix+token).Result()
+	if err != nil {
+		return "", fmt.Errorf("count mfa attempts: %w", err)
+	}
+	if attempts == 1 {
+		if err := service.client.Expire(ctx, mfaAttemptsPrefix+token, mfaTokenExpiry).Err(); err != nil {
+			return "", fmt.Errorf("expire mfa attempts: %w", err)
+		}
+	}
+
+	if attempts > mfaTokenMaxAttempts {
+		if err := service.RevokeMFAToken(token); err != nil {
+			return "", fmt.Errorf("too many mfa attempts: %w", err)
+		}
+		return "", errors.New("too many mfa attempts")
+	}
+
+	return uid, nil
+}
+
+// RevokeMFAToken deletes the MFA token so it cannot be exchanged again
+func (service TokenService) RevokeMFAToken(token string) error {
+	err := service.client.Del(service.client.Context(), mfaTokenPrefix+token, mfaAttemptsPrefix+token).Err()
+	if err != nil {
+		return fmt.Errorf("revoke mfa token: %w", err)
+	}
+
+	return nil
+}
+
 // generateToken creates a specific type of JWT token (access or refresh) for a given user ID
 // Parameters:
 //   - tokenType: "access" or "refresh"
 //   - uid: user identifier
+//   - role: user role carried as a claim
 //
 // Returns the generated token as a string or an error if generation fails
-func (service TokenService) generateToken(tokenType string, uid string) (string, error) {
+func (service TokenService) generateToken(tokenType string, uid string, role rbac.Role) (string, error) {
 	switch tokenType {
 	case "access":
-		return generateToken(service.config.AccessTokenPrivateKey, uid, service.config.AccessTokenExpiry)
+		return generateToken(service.accessKeys, uid, role, service.config.AccessTokenExpiry)
 	case "refresh":
-		return generateToken(service.config.RefreshTokenPrivateKey, uid, service.config.RefreshTokenExpiry)
+		return generateToken(service.refreshKeys, uid, role, service.config.RefreshTokenExpiry)
 	default:
 		return "", errors.New("unsupported token type")
 	}
@@ -88,74 +233,85 @@ func (service TokenService) generateToken(tokenType string, uid string) (string,
 //
 // Returns the user ID (uid) from the token claims or an error if parsing fails
 func (service TokenService) ParseToken(tokenType string, token string) (string, error) {
+	var claims models.TokenClaims
+	var err error
+
 	switch tokenType {
 	case "access":
-		return parseToken(service.config.AccessTokenPublicKey, token)
+		claims, err = parseToken(service.accessKeys, token)
 	case "refresh":
-		return parseToken(service.config.RefreshTokenPublicKey, token)
+		claims, err = parseToken(service.refreshKeys, token)
 	default:
 		return "", errors.New("unsupported token type")
 	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return claims.Subject, nil
+}
+
+// ParseAccessToken validates an access token and returns all of its claims, including the role
+func (service TokenService) ParseAccessToken(token string) (models.TokenClaims, error) {
+	return parseToken(service.accessKeys, token)
 }
 
-// generateToken creates a JWT token using RSA private key encryption
+// generateToken creates a JWT token signed with the active key of the keyring
 // Parameters:
-//   - privKey: base64 encoded RSA private key
+//   - keys: keyring holding the signing key
 //   - uid: user identifier to be included in token claims
+//   - role: user role to be included in token claims
 //   - expiryHour: token expiration time in hours
 //
-// Returns the signed JWT token as a string or an error if generation fails
-func generateToken(privKey string, uid string, expiryHour int) (string, error) {
-	decodedPrivateKey, err := base64.StdEncoding.DecodeString(privKey)
-	if err != nil {
-		return "", fmt.Errorf("generate token: %w", err)
-	}
+// Returns the signed JWT token with the key's kid header as a string or an error if generation fails
+func generateToken(keys *keyring.Keyring, uid string, role rbac.Role, expiryHour int) (string, error) {
+	now := time.Now()
+
+	return signToken(keys, models.TokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   uid,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Duration(expiryHour) * time.Hour)),
+		},
+		Role: string(role),
+	})
+}
 
-	privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(decodedPrivateKey)
+// signToken signs the claims with the active key of the keyring and sets the key's kid header
+func signToken(keys *keyring.Keyring, claims models.TokenClaims) (string, error) {
+	signingKey, err := keys.SigningKey(time.Now())
 	if err != nil {
 		return "", fmt.Errorf("generate token: %w", err)
 	}
 
-	claims := jwt.RegisteredClaims{
-		Subject:   uid,
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Duration(expiryHour) * time.Hour)),
-	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = signingKey.Id
 
-	token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(privateKey)
+	signedToken, err := token.SignedString(signingKey.PrivateKey)
 	if err != nil {
 		return "", fmt.Errorf("generate token: %w", err)
 	}
 
-	return token, nil
+	return signedToken, nil
 }
 
-// parseToken validates and decodes a JWT token using RSA public key
+// parseToken validates and decodes a JWT token using the keyring key matching its kid header
 // Parameters:
-//   - publicKey: base64 encoded RSA public key
+//   - keys: keyring holding the verification keys
 //   - token: JWT token string to parse
 //
-// Returns the user ID from the token claims or an error if validation fails
-func parseToken(publicKey string, token string) (string, error) {
-	decodedPublicKey, err := base64.StdEncoding.DecodeString(publicKey)
-	if err != nil {
-		return "", fmt.Errorf("extract uid from access token: %w", err)
-	}
-
-	parsedToken, err := jwt.ParseWithClaims(token, &jwt.RegisteredClaims{}, func(t *jwt.Token) (interface{}, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodRSA); !ok {
-			return jwt.RegisteredClaims{}, fmt.Errorf("unexpected method: %s", t.Header["alg"])
-		}
-		return jwt.ParseRSAPublicKeyFromPEM(decodedPublicKey)
-	})
-
+// Returns the token claims or an error if validation fails
+func parseToken(keys *keyring.Keyring, token string) (models.TokenClaims, error) {
+	parsedToken, err := jwt.ParseWithClaims(token, &models.TokenClaims{}, keys.Keyfunc())
 	if err != nil {
-		return "", fmt.Errorf("parse token: %w", err)
+		return models.TokenClaims{}, fmt.Errorf("parse token: %w", err)
 	}
 
-	claims, ok := parsedToken.Claims.(*jwt.RegisteredClaims)
+	claims, ok := parsedToken.Claims.(*models.TokenClaims)
 	if !ok || !parsedToken.Valid {
-		return "", fmt.Errorf("validate: invalid token")
+		return models.TokenClaims{}, fmt.Errorf("validate: invalid token")
 	}
 
-	return claims.Subject, nil
+	return *claims, nil
 }
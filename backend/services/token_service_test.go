@@ -0,0 +1,85 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AkifhanIlgaz/dictionary-api/config"
+	"github.com/AkifhanIlgaz/dictionary-api/models"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestRevokeAccessTokens(t *testing.T) {
+	client, server := newTestRedis(t)
+	service := TokenService{client: client, config: config.Config{AccessTokenExpiry: 1}}
+	uid := randomSubject()
+	now := time.Now()
+
+	claims := func(issuedAt time.Time) models.TokenClaims {
+		return models.TokenClaims{RegisteredClaims: jwt.RegisteredClaims{Subject: uid, IssuedAt: jwt.NewNumericDate(issuedAt)}}
+	}
+
+	if revoked, err := service.IsRevoked(claims(now.Add(-time.Minute))); err != nil || revoked {
+		t.Fatalf("IsRevoked() before revocation = %v, %v, want false", revoked, err)
+	}
+
+	if err := service.RevokeAccessTokens(uid, now); err != nil {
+		t.Fatalf("RevokeAccessTokens: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		claims models.TokenClaims
+		want   bool
+	}{
+		{"issued before", claims(now.Add(-time.Minute)), true},
+		{"issued in the same second", claims(now), true},
+		{"issued after", claims(now.Add(time.Second)), false},
+		{"without issue time", models.TokenClaims{RegisteredClaims: jwt.RegisteredClaims{Subject: uid}}, true},
+		{"of another user", models.TokenClaims{RegisteredClaims: jwt.RegisteredClaims{Subject: randomSubject(), IssuedAt: jwt.NewNumericDate(now.Add(-time.Minute))}}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			revoked, err := service.IsRevoked(test.claims)
+			if err != nil {
+				t.Fatalf("IsRevoked: %v", err)
+			}
+			if revoked != test.want {
+				t.Errorf("IsRevoked() = %v, want %v", revoked, test.want)
+			}
+		})
+	}
+
+	// The revocation is dropped once the revoked tokens have expired
+	server.FastForward(time.Hour + time.Second)
+	if revoked, err := service.IsRevoked(claims(now.Add(-time.Minute))); err != nil || revoked {
+		t.Errorf("IsRevoked() after expiry = %v, %v, want false", revoked, err)
+	}
+}
+
+func TestParseMFATokenAttempts(t *testing.T) {
+	client := newRedisClient(t)
+	service := TokenService{client: client}
+	uid := randomSubject()
+
+	token, err := service.CreateMFAToken(uid)
+	if err != nil {
+		t.Fatalf("CreateMFAToken: %v", err)
+	}
+
+	for attempt := int64(1); attempt <= mfaTokenMaxAttempts; attempt++ {
+		if got, err := service.ParseMFAToken(token); err != nil || got != uid {
+			t.Fatalf("ParseMFAToken() attempt %d = %q, %v, want %q", attempt, got, err, uid)
+		}
+	}
+
+	if _, err := service.ParseMFAToken(token); err == nil {
+		t.Fatal("ParseMFAToken() over the attempt limit succeeded")
+	}
+
+	// The token is revoked once the attempts are exceeded
+	if n, _ := client.Exists(client.Context(), mfaTokenPrefix+token, mfaAttemptsPrefix+token).Result(); n != 0 {
+		t.Errorf("%d keys of the revoked mfa token are left, want 0", n)
+	}
+}
@@ -0,0 +1,949 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AkifhanIlgaz/dictionary-api/models"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/db"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/message"
+	plan "github.com/AkifhanIlgaz/dictionary-api/utils/plans"
+	"github.com/go-redis/redis/v8"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// pendingUsageKey is a Redis hash of usage not rolled up into MongoDB yet, with fields
+	// "<uid>|<api key id>|<hour>|<endpoint>|<status class>|<charged>|<plan>|<overage mode>|<org id>" and request
+	// counts as values. Plan and overage mode are only set for charged requests over the quota, org ID for keys of organizations.
+	pendingUsageKey string = "usage_pending"
+	// rollupUsagePrefix is the prefix of pending usage hashes claimed by a rollup, "usage_rollup:<rollup id>:<claim id>".
+	// A rollup keeps its ID when it is retried, the claim ID tells when it was last claimed.
+	rollupUsagePrefix string = "usage_rollup:"
+	// failedRollupPrefix is the prefix of claimed usage hashes that could not be rolled up within the applied
+	// rollup retention, which are set aside for an operator to look into
+	failedRollupPrefix string = "usage_rollup_failed:"
+	// appliedRollupRetention is how long the IDs of rollups are kept on the usage documents they were written to.
+	// Rollups are retried until they are this old, retrying them later could count their usage twice.
+	appliedRollupRetention time.Duration = 6 * time.Hour
+
+	usageRollupInterval time.Duration = 10 * time.Second
+	usageFieldSeparator string        = "|"
+
+	// hourlyUsageRetention is how long hourly usage buckets are kept, daily usage is kept forever
+	hourlyUsageRetention time.Duration = 90 * 24 * time.Hour
+
+	defaultDailyHistory  time.Duration = 30 * 24 * time.Hour
+	maxDailyHistory      time.Duration = 366 * 24 * time.Hour
+	defaultHourlyHistory time.Duration = 24 * time.Hour
+	maxHourlyHistory     time.Duration = 31 * 24 * time.Hour
+)
+
+var (
+	ErrInvalidGranularity = errors.New(message.InvalidGranularity)
+	ErrInvalidUsageRange  = errors.New(message.InvalidUsageRange)
+	ErrInvalidGroupBy     = errors.New(message.InvalidGroupBy)
+)
+
+// chargedCount is the charged requests of a usage document.
+// Usage recorded before uncharged requests were tracked only counted charged requests.
+var chargedCount = bson.M{"$ifNull": bson.A{"$charged", "$count"}}
+
+// UsageService records API key usage in Redis and rolls it up into the daily usage, hourly usage, api keys
+// and overage ledger collections in the background, so that metering does not write to MongoDB on every request.
+// Usage in MongoDB lags behind by up to the rollup interval.
+type UsageService struct {
+	ctx                   context.Context
+	client                *redis.Client
+	usageCollection       *mongo.Collection
+	hourlyUsageCollection *mongo.Collection
+	apiKeyCollection      *mongo.Collection
+	overageCollection     *mongo.Collection
+}
+
+// NewUsageService creates a new UsageService instance and initializes the indexes of the daily usage,
+// hourly usage and overage ledger collections
+func NewUsageService(ctx context.Context, mongoDatabase *mongo.Database, client *redis.Client) (UsageService, error) {
+	usageCollection := mongoDatabase.Collection(db.DailyUsageCollection)
+	hourlyUsageCollection := mongoDatabase.Collection(db.HourlyUsageCollection)
+	overageCollection := mongoDatabase.Collection(db.OverageLedgerCollection)
+
+	// Drop the index of earlier versions, which had a single usage document per key and day
+	if _, err := usageCollection.Indexes().DropOne(ctx, "key_1_date_1"); err != nil && !isIndexNotFound(err) {
+		return UsageService{}, fmt.Errorf("initialize usage service: %w", err)
+	}
+
+	// key holds the API key ID
+	_, err := usageCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{
+				{Key: "key", Value: 1},
+				{Key: "date", Value: 1},
+				{Key: "endpoint", Value: 1},
+				{Key: "statusClass", Value: 1},
+			},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "uid", Value: 1}, {Key: "date", Value: 1}},
+		},
+		{
+			Keys:    bson.D{{Key: "orgId", Value: 1}, {Key: "date", Value: 1}},
+			Options: options.Index().SetPartialFilterExpression(bson.M{"orgId": bson.M{"$exists": true}}),
+		},
+	})
+	if err != nil {
+		return UsageService{}, fmt.Errorf("initialize usage collection: %w", err)
+	}
+
+	_, err = hourlyUsageCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{
+				{Key: "key", Value: 1},
+				{Key: "hour", Value: 1},
+				{Key: "endpoint", Value: 1},
+				{Key: "statusClass", Value: 1},
+			},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "uid", Value: 1}, {Key: "hour", Value: 1}},
+		},
+		{
+			Keys:    bson.D{{Key: "orgId", Value: 1}, {Key: "hour", Value: 1}},
+			Options: options.Index().SetPartialFilterExpression(bson.M{"orgId": bson.M{"$exists": true}}),
+		},
+		{
+			Keys:    bson.D{{Key: "hour", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(hourlyUsageRetention.Seconds())),
+		},
+	})
+	if err != nil {
+		return UsageService{}, fmt.Errorf("initialize hourly usage collection: %w", err)
+	}
+
+	_, err = overageCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "uid", Value: 1}, {Key: "invoiceId", Value: 1}, {Key: "hour", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "rollups", Value: 1}},
+		},
+	})
+	if err != nil {
+		return UsageService{}, fmt.Errorf("initialize overage ledger collection: %w", err)
+	}
+
+	return UsageService{
+		ctx:                   ctx,
+		client:                client,
+		usageCollection:       usageCollection,
+		hourlyUsageCollection: hourlyUsageCollection,
+		apiKeyCollection:      mongoDatabase.Collection(db.ApiKeysCollection),
+		overageCollection:     overageCollection,
+	}, nil
+}
+
+// Record counts a request that reached its handler in the UTC hour it was made
+func (service UsageService) Record(record models.UsageRecord) error {
+	charged, planType, overage := "0", "", ""
+	if record.Charged {
+		charged = "1"
+		if record.Overage != "" {
+			planType, overage = string(record.Plan), string(record.Overage)
+		}
+	}
+
+	field := strings.Join([]string{
+		record.Uid,
+		record.KeyId,
+		record.Time.UTC().Truncate(time.Hour).Format(time.RFC3339),
+		record.Endpoint,
+		statusClass(record.Status),
+		charged,
+		planType,
+		overage,
+		record.OrgId,
+	}, usageFieldSeparator)
+
+	if err := service.client.HIncrBy(service.client.Context(), pendingUsageKey, field, 1).Err(); err != nil {
+		return fmt.Errorf("record usage: %w", err)
+	}
+
+	return nil
+}
+
+// StartRollup rolls up the pending usage every rollup interval, including usage claimed by
+// rollups of instances that stopped before finishing. It rolls up once more when ctx is done.
+func (service UsageService) StartRollup(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(usageRollupInterval)
+		defer ticker.Stop()
+
+		if err := service.recoverRollups(time.Now()); err != nil {
+			log.Println(err.Error())
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				if err := service.Rollup(); err != nil {
+					log.Println(err.Error())
+				}
+				return
+			case <-ticker.C:
+				if err := service.Rollup(); err != nil {
+					log.Println(err.Error())
+				}
+			}
+		}
+	}()
+}
+
+// Rollup moves the pending usage into MongoDB, and retries the rollups that failed or were left claimed by
+// instances that stopped. The pending hash is claimed with an atomic rename, so concurrent rollups never claim
+// the same usage.
+func (service UsageService) Rollup() error {
+	rollupId := primitive.NewObjectID()
+	claimed := rollupUsagePrefix + rollupId.Hex() + ":" + rollupId.Hex()
+
+	err := service.client.Rename(service.client.Context(), pendingUsageKey, claimed).Err()
+	switch {
+	// Nothing was recorded since the last rollup
+	case err != nil && strings.Contains(err.Error(), "no such key"):
+	case err != nil:
+		return fmt.Errorf("claim pending usage: %w", err)
+	default:
+		if err := service.rollupClaimed(claimed, rollupId, time.Now()); err != nil {
+			log.Println(err.Error())
+		}
+	}
+
+	return service.recoverRollups(time.Now().Add(-usageRollupInterval))
+}
+
+// recoverRollups retries the rollups that were claimed before the given time. Each rollup is retried with its
+// rollup ID, so that the usage it already wrote is not counted again. Rollups older than the applied rollup
+// retention are set aside instead. Failed rollups are logged and retried on the next call.
+func (service UsageService) recoverRollups(claimedBefore time.Time) error {
+	ctx := service.client.Context()
+
+	iter := service.client.Scan(ctx, 0, rollupUsagePrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		rollupId, claimedAt, err := parseRollupKey(iter.Val())
+		if err != nil {
+			log.Printf("roll up usage: skipping malformed rollup %q\n", iter.Val())
+			continue
+		}
+		if !claimedAt.Before(claimedBefore) {
+			continue
+		}
+
+		if time.Since(rollupId.Timestamp()) > appliedRollupRetention {
+			failed := failedRollupPrefix + rollupId.Hex()
+			if err := service.client.Rename(ctx, iter.Val(), failed).Err(); err == nil {
+				log.Printf("roll up usage: rollup %s could not be written in %s, set aside as %q\n",
+					rollupId.Hex(), appliedRollupRetention, failed)
+			}
+			continue
+		}
+
+		claimed := rollupUsagePrefix + rollupId.Hex() + ":" + primitive.NewObjectID().Hex()
+
+		// Another instance may be recovering the same rollup
+		if err := service.client.Rename(ctx, iter.Val(), claimed).Err(); err != nil {
+			continue
+		}
+
+		if err := service.rollupClaimed(claimed, rollupId, time.Now()); err != nil {
+			log.Println(err.Error())
+		}
+	}
+
+	if err := iter.Err(); err != nil {
+		return fmt.Errorf("recover usage rollups: %w", err)
+	}
+
+	return nil
+}
+
+// parseRollupKey returns the rollup ID of a claimed usage hash and when it was last claimed.
+// Hashes claimed by earlier versions, "usage_rollup:<rollup id>", were claimed when the rollup started.
+func parseRollupKey(key string) (primitive.ObjectID, time.Time, error) {
+	ids := strings.Split(strings.TrimPrefix(key, rollupUsagePrefix), ":")
+
+	rollupId, err := primitive.ObjectIDFromHex(ids[0])
+	if err != nil || len(ids) > 2 {
+		return primitive.NilObjectID, time.Time{}, fmt.Errorf("parse rollup key %q: invalid rollup id", key)
+	}
+
+	claimId, err := primitive.ObjectIDFromHex(ids[len(ids)-1])
+	if err != nil {
+		return primitive.NilObjectID, time.Time{}, fmt.Errorf("parse rollup key %q: invalid claim id", key)
+	}
+
+	return rollupId, claimId.Timestamp(), nil
+}
+
+// rollupClaimed writes a claimed usage hash to MongoDB and deletes it.
+// Every write records the rollup ID on the documents it changes and skips the documents that already have it,
+// so a rollup that failed part way or whose hash could not be deleted is retried without counting usage or
+// billing overage twice.
+func (service UsageService) rollupClaimed(claimed string, rollupId primitive.ObjectID, now time.Time) error {
+	ctx := service.client.Context()
+
+	counts, err := service.client.HGetAll(ctx, claimed).Result()
+	if err != nil {
+		return fmt.Errorf("read claimed usage: %w", err)
+	}
+
+	entries := parseUsageEntries(counts)
+
+	if err := service.writeDailyUsage(entries, rollupId, now); err != nil {
+		return fmt.Errorf("roll up usage %s: %w", rollupId.Hex(), err)
+	}
+
+	if err := service.writeHourlyUsage(entries, rollupId, now); err != nil {
+		return fmt.Errorf("roll up usage %s: %w", rollupId.Hex(), err)
+	}
+
+	if err := service.writeTotalUsage(entries, rollupId, now); err != nil {
+		return fmt.Errorf("roll up usage %s: %w", rollupId.Hex(), err)
+	}
+
+	if err := service.writeOverage(entries, rollupId, now); err != nil {
+		return fmt.Errorf("roll up usage %s: %w", rollupId.Hex(), err)
+	}
+
+	if err := service.client.Del(ctx, claimed).Err(); err != nil {
+		return fmt.Errorf("delete claimed usage: %w", err)
+	}
+
+	return nil
+}
+
+// rollupUpdate returns an update pipeline that adds inc to the fields of a document and sets the fields of set,
+// unless the document already has the rollup ID. The rollup ID is recorded on the document, and IDs older than
+// the applied rollup retention are dropped so that they do not pile up.
+func rollupUpdate(rollupId primitive.ObjectID, now time.Time, inc bson.M, set bson.M) bson.A {
+	rollups := bson.M{"$ifNull": bson.A{"$rollups", bson.A{}}}
+	applied := bson.M{"$in": bson.A{rollupId, rollups}}
+	retained := primitive.NewObjectIDFromTimestamp(now.Add(-appliedRollupRetention))
+
+	update := bson.M{
+		"rollups": bson.M{"$cond": bson.A{applied, rollups, bson.M{"$concatArrays": bson.A{
+			bson.M{"$filter": bson.M{"input": rollups, "cond": bson.M{"$gte": bson.A{"$$this", retained}}}},
+			bson.A{rollupId},
+		}}}},
+	}
+	for field, n := range inc {
+		update[field] = bson.M{"$cond": bson.A{
+			applied,
+			"$" + field,
+			bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$" + field, 0}}, n}},
+		}}
+	}
+	for field, value := range set {
+		update[field] = bson.M{"$cond": bson.A{applied, "$" + field, value}}
+	}
+
+	return bson.A{bson.M{"$set": update}}
+}
+
+// usageEntry is the usage of an API key on an endpoint with a status class in an hour
+type usageEntry struct {
+	uid         string
+	keyId       string
+	hour        time.Time
+	endpoint    string
+	statusClass string
+	count       int64
+	charged     int64
+	// plan and overage are only set for charged requests over the quota
+	plan    plan.PlanType
+	overage models.OverageMode
+	// orgId is only set for keys of organizations
+	orgId string
+}
+
+// usageBucket is a usage document of an API key on an endpoint with a status class in a period
+type usageBucket struct {
+	uid         string
+	keyId       string
+	period      time.Time
+	endpoint    string
+	statusClass string
+	orgId       string
+}
+
+// filter returns the usage document filter of the bucket with the period in the given field
+func (bucket usageBucket) filter(periodField string) bson.M {
+	filter := bson.M{
+		"key":         bucket.keyId,
+		"uid":         bucket.uid,
+		periodField:   bucket.period,
+		"endpoint":    bucket.endpoint,
+		"statusClass": bucket.statusClass,
+	}
+	if bucket.orgId != "" {
+		filter["orgId"] = bucket.orgId
+	}
+
+	return filter
+}
+
+// usageCounts are the requests and charged requests of a usage bucket
+type usageCounts struct {
+	count   int64
+	charged int64
+}
+
+// sumUsage sums the entries per usage bucket of the period returned by period. Entries of the same bucket are
+// written at once, since a usage document only takes one write per rollup.
+func sumUsage(entries []usageEntry, period func(usageEntry) time.Time) map[usageBucket]usageCounts {
+	sums := map[usageBucket]usageCounts{}
+	for _, entry := range entries {
+		bucket := usageBucket{
+			uid:         entry.uid,
+			keyId:       entry.keyId,
+			period:      period(entry),
+			endpoint:    entry.endpoint,
+			statusClass: entry.statusClass,
+			orgId:       entry.orgId,
+		}
+
+		sum := sums[bucket]
+		sum.count += entry.count
+		sum.charged += entry.charged
+		sums[bucket] = sum
+	}
+
+	return sums
+}
+
+// date is the UTC day of the entry
+func (entry usageEntry) date() time.Time {
+	return utcDate(entry.hour)
+}
+
+// parseUsageEntries parses the fields of a pending usage hash, skipping malformed ones.
+// Fields of earlier versions, "<uid>|<api key id>|<date>", only counted charged requests,
+// fields without plan and overage mode had no overage, and fields without org ID were of personal keys.
+func parseUsageEntries(counts map[string]string) []usageEntry {
+	var entries []usageEntry
+
+	for field, value := range counts {
+		parts := strings.Split(field, usageFieldSeparator)
+		count, err := strconv.ParseInt(value, 10, 64)
+		if (len(parts) != 3 && len(parts) != 6 && len(parts) != 8 && len(parts) != 9) || err != nil {
+			log.Printf("roll up usage: skipping malformed entry %q: %q\n", field, value)
+			continue
+		}
+
+		entry := usageEntry{uid: parts[0], keyId: parts[1], count: count, charged: count}
+
+		if len(parts) == 3 {
+			entry.hour, err = time.Parse(time.DateOnly, parts[2])
+		} else {
+			entry.hour, err = time.Parse(time.RFC3339, parts[2])
+			entry.endpoint = parts[3]
+			entry.statusClass = parts[4]
+			if parts[5] != "1" {
+				entry.charged = 0
+			}
+		}
+		if len(parts) >= 8 {
+			entry.plan = plan.PlanType(parts[6])
+			entry.overage = models.OverageMode(parts[7])
+		}
+		if len(parts) == 9 {
+			entry.orgId = parts[8]
+		}
+		if err != nil {
+			log.Printf("roll up usage: skipping malformed time %q\n", field)
+			continue
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+// writeDailyUsage adds the entries to the daily usage of their keys, once per rollup
+func (service UsageService) writeDailyUsage(entries []usageEntry, rollupId primitive.ObjectID, now time.Time) error {
+	var writes []mongo.WriteModel
+	for bucket, sum := range sumUsage(entries, usageEntry.date) {
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bucket.filter("date")).
+			SetUpdate(rollupUpdate(rollupId, now, bson.M{"count": sum.count, "charged": sum.charged}, nil)).
+			SetUpsert(true))
+	}
+
+	if len(writes) == 0 {
+		return nil
+	}
+
+	if _, err := service.usageCollection.BulkWrite(service.ctx, writes, options.BulkWrite().SetOrdered(false)); err != nil {
+		return fmt.Errorf("write daily usage: %w", err)
+	}
+
+	return nil
+}
+
+// writeHourlyUsage adds the entries to the hourly usage of their keys, once per rollup.
+// Entries of earlier versions have no hour and are only part of the daily usage.
+func (service UsageService) writeHourlyUsage(entries []usageEntry, rollupId primitive.ObjectID, now time.Time) error {
+	var hourly []usageEntry
+	for _, entry := range entries {
+		if entry.statusClass != "" {
+			hourly = append(hourly, entry)
+		}
+	}
+
+	var writes []mongo.WriteModel
+	for bucket, sum := range sumUsage(hourly, func(entry usageEntry) time.Time { return entry.hour }) {
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bucket.filter("hour")).
+			SetUpdate(rollupUpdate(rollupId, now, bson.M{"count": sum.count, "charged": sum.charged}, nil)).
+			SetUpsert(true))
+	}
+
+	if len(writes) == 0 {
+		return nil
+	}
+
+	if _, err := service.hourlyUsageCollection.BulkWrite(service.ctx, writes, options.BulkWrite().SetOrdered(false)); err != nil {
+		return fmt.Errorf("write hourly usage: %w", err)
+	}
+
+	return nil
+}
+
+// writeTotalUsage adds the charged entries to the total usage of their keys and updates when they were last used,
+// once per rollup
+func (service UsageService) writeTotalUsage(entries []usageEntry, rollupId primitive.ObjectID, now time.Time) error {
+	totals := map[primitive.ObjectID]int64{}
+	for _, entry := range entries {
+		if objectId, err := primitive.ObjectIDFromHex(entry.keyId); err == nil {
+			totals[objectId] += entry.charged
+		}
+	}
+
+	var writes []mongo.WriteModel
+	for objectId, count := range totals {
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": objectId}).
+			SetUpdate(rollupUpdate(rollupId, now,
+				bson.M{"totalUsage": count},
+				bson.M{"lastUsedAt": bson.M{"$max": bson.A{"$lastUsedAt", now}}})))
+	}
+
+	if len(writes) == 0 {
+		return nil
+	}
+
+	if _, err := service.apiKeyCollection.BulkWrite(service.ctx, writes, options.BulkWrite().SetOrdered(false)); err != nil {
+		return fmt.Errorf("write total usage: %w", err)
+	}
+
+	return nil
+}
+
+// overageBucket is an overage ledger entry of a user in an hour on a plan with an overage mode
+type overageBucket struct {
+	uid  string
+	hour time.Time
+	plan plan.PlanType
+	mode models.OverageMode
+}
+
+// writeOverage adds the charged entries over the quota to the overage ledger, in the uninvoiced entry of their hour,
+// once per rollup. Entries that already have the rollup may have been invoiced since, so their hours are skipped.
+func (service UsageService) writeOverage(entries []usageEntry, rollupId primitive.ObjectID, now time.Time) error {
+	sums := map[overageBucket]int64{}
+	for _, entry := range entries {
+		if entry.overage == "" || entry.charged == 0 {
+			continue
+		}
+
+		sums[overageBucket{uid: entry.uid, hour: entry.hour.UTC(), plan: entry.plan, mode: entry.overage}] += entry.charged
+	}
+
+	if len(sums) == 0 {
+		return nil
+	}
+
+	cursor, err := service.overageCollection.Find(service.ctx, bson.M{"rollups": rollupId})
+	if err != nil {
+		return fmt.Errorf("write overage: %w", err)
+	}
+
+	var applied []models.OverageLedgerEntry
+	if err := cursor.All(service.ctx, &applied); err != nil {
+		return fmt.Errorf("write overage: %w", err)
+	}
+	for _, entry := range applied {
+		delete(sums, overageBucket{uid: entry.Uid, hour: entry.Hour.UTC(), plan: entry.Plan, mode: entry.Mode})
+	}
+
+	var writes []mongo.WriteModel
+	for bucket, requests := range sums {
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{
+				"uid":       bucket.uid,
+				"hour":      bucket.hour,
+				"plan":      bucket.plan,
+				"mode":      bucket.mode,
+				"invoiceId": bson.M{"$exists": false},
+			}).
+			SetUpdate(rollupUpdate(rollupId, now, bson.M{"requests": requests}, nil)).
+			SetUpsert(true))
+	}
+
+	if len(writes) == 0 {
+		return nil
+	}
+
+	if _, err := service.overageCollection.BulkWrite(service.ctx, writes, options.BulkWrite().SetOrdered(false)); err != nil {
+		return fmt.Errorf("write overage: %w", err)
+	}
+
+	return nil
+}
+
+// MigrateDailyUsageToUTC moves daily usage recorded by earlier versions, which started days at midnight in loc,
+// the timezone of the servers, to the UTC day with the same date. Usage of a key that was already recorded on
+// that UTC day is merged into it. It is safe to run more than once. Returns the number of migrated documents.
+func (service UsageService) MigrateDailyUsageToUTC(loc *time.Location) (int, error) {
+	notMidnight := bson.M{"$expr": bson.M{"$or": bson.A{
+		bson.M{"$ne": bson.A{bson.M{"$hour": "$date"}, 0}},
+		bson.M{"$ne": bson.A{bson.M{"$minute": "$date"}, 0}},
+	}}}
+
+	cursor, err := service.usageCollection.Find(service.ctx, notMidnight)
+	if err != nil {
+		return 0, fmt.Errorf("migrate daily usage: %w", err)
+	}
+
+	var docs []struct {
+		Id          primitive.ObjectID `bson:"_id"`
+		Key         string             `bson:"key"`
+		Uid         string             `bson:"uid"`
+		Date        time.Time          `bson:"date"`
+		Endpoint    *string            `bson:"endpoint"`
+		StatusClass *string            `bson:"statusClass"`
+		Count       int64              `bson:"count"`
+		Charged     *int64             `bson:"charged"`
+	}
+	if err := cursor.All(service.ctx, &docs); err != nil {
+		return 0, fmt.Errorf("migrate daily usage: %w", err)
+	}
+
+	for _, doc := range docs {
+		local := doc.Date.In(loc)
+		date := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.UTC)
+
+		_, err := service.usageCollection.UpdateByID(service.ctx, doc.Id, bson.M{"$set": bson.M{"date": date}})
+		if err == nil {
+			continue
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return 0, fmt.Errorf("migrate daily usage %s: %w", doc.Id.Hex(), err)
+		}
+
+		charged := doc.Count
+		if doc.Charged != nil {
+			charged = *doc.Charged
+		}
+
+		// The UTC day already has usage of the key, e.g. recorded after upgrading
+		_, err = service.usageCollection.UpdateOne(service.ctx, bson.M{
+			"key":         doc.Key,
+			"date":        date,
+			"endpoint":    doc.Endpoint,
+			"statusClass": doc.StatusClass,
+		}, bson.M{"$inc": bson.M{"count": doc.Count, "charged": charged}})
+		if err != nil {
+			return 0, fmt.Errorf("merge daily usage %s: %w", doc.Id.Hex(), err)
+		}
+
+		if _, err := service.usageCollection.DeleteOne(service.ctx, bson.M{"_id": doc.Id}); err != nil {
+			return 0, fmt.Errorf("merge daily usage %s: %w", doc.Id.Hex(), err)
+		}
+	}
+
+	return len(docs), nil
+}
+
+// personalUsage matches the usage of the personal API keys of a user, without the keys they created for organizations
+func personalUsage(uid string) bson.M {
+	return bson.M{"uid": uid, "orgId": bson.M{"$exists": false}}
+}
+
+// GetHistory returns the usage of the user's personal API keys between from and to, in UTC daily or hourly buckets,
+// broken down by the requested dimensions. Daily usage recorded before endpoints and statuses were tracked
+// has no endpoint or status class. Hourly usage is only kept for the hourly usage retention.
+func (service UsageService) GetHistory(uid string, req models.UsageHistoryRequest, now time.Time) (*models.UsageHistoryResponse, error) {
+	return service.history(personalUsage(uid), bson.M{"uid": uid}, models.UsageDimensions, req, now)
+}
+
+// GetOrgHistory returns the usage of the API keys of an organization like GetHistory,
+// which can also be broken down by the members who created the keys
+func (service UsageService) GetOrgHistory(orgId string, req models.UsageHistoryRequest, now time.Time) (*models.UsageHistoryResponse, error) {
+	return service.history(bson.M{"orgId": orgId}, bson.M{"orgId": orgId}, models.OrgUsageDimensions, req, now)
+}
+
+// history aggregates the usage documents matching match. keyFilter selects the API keys whose names are set on the rows,
+// and dimensions are the dimensions the usage can be broken down by, all of them by default.
+func (service UsageService) history(match bson.M, keyFilter bson.M, dimensions []string, req models.UsageHistoryRequest, now time.Time) (*models.UsageHistoryResponse, error) {
+	granularity, collection, periodField := models.DayGranularity, service.usageCollection, "date"
+	switch models.Granularity(req.Granularity) {
+	case "", models.DayGranularity:
+	case models.HourGranularity:
+		granularity, collection, periodField = models.HourGranularity, service.hourlyUsageCollection, "hour"
+	default:
+		return nil, ErrInvalidGranularity
+	}
+
+	from, to, err := parseUsageRange(req.From, req.To, granularity, now)
+	if err != nil {
+		return nil, err
+	}
+
+	groupBy, err := parseGroupBy(req.GroupBy, dimensions)
+	if err != nil {
+		return nil, err
+	}
+
+	group := bson.D{{Key: "period", Value: "$" + periodField}}
+	sort := bson.D{{Key: "period", Value: 1}}
+	project := bson.D{{Key: "_id", Value: 0}, {Key: "period", Value: "$_id.period"}}
+	for _, dimension := range groupBy {
+		field := usageDimensionFields[dimension]
+		group = append(group, bson.E{Key: field, Value: "$" + field})
+		sort = append(sort, bson.E{Key: field, Value: 1})
+		project = append(project, bson.E{Key: field, Value: "$_id." + field})
+	}
+	project = append(project, bson.E{Key: "requests", Value: 1}, bson.E{Key: "charged", Value: 1})
+
+	periodMatch := bson.M{periodField: bson.M{"$gte": from, "$lt": to}}
+	for field, value := range match {
+		periodMatch[field] = value
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: periodMatch}},
+		{{Key: "$group", Value: bson.M{
+			"_id":      group,
+			"requests": bson.M{"$sum": "$count"},
+			"charged":  bson.M{"$sum": chargedCount},
+		}}},
+		{{Key: "$project", Value: project}},
+		{{Key: "$sort", Value: sort}},
+	}
+
+	cursor, err := collection.Aggregate(service.ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("get usage history: %w", err)
+	}
+
+	rows := []models.UsageHistoryRow{}
+	if err := cursor.All(service.ctx, &rows); err != nil {
+		return nil, fmt.Errorf("get usage history: %w", err)
+	}
+
+	if err := service.setKeyNames(keyFilter, rows); err != nil {
+		return nil, fmt.Errorf("get usage history: %w", err)
+	}
+
+	history := models.UsageHistoryResponse{
+		From:        from,
+		To:          to,
+		Granularity: granularity,
+		GroupBy:     groupBy,
+		Rows:        rows,
+	}
+	for _, row := range rows {
+		history.Requests += row.Requests
+		history.Charged += row.Charged
+	}
+
+	return &history, nil
+}
+
+// GetChargedUsage returns the charged usage of the user's personal API keys in the hours starting between from and to.
+// Hourly usage is only kept for the hourly usage retention.
+func (service UsageService) GetChargedUsage(uid string, from time.Time, to time.Time) (int64, error) {
+	match := personalUsage(uid)
+	match["hour"] = bson.M{"$gte": from, "$lt": to}
+
+	cursor, err := service.hourlyUsageCollection.Aggregate(service.ctx, mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "charged": bson.M{"$sum": chargedCount}}}},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("get charged usage: %w", err)
+	}
+
+	var results []struct {
+		Charged int64 `bson:"charged"`
+	}
+	if err := cursor.All(service.ctx, &results); err != nil {
+		return 0, fmt.Errorf("get charged usage: %w", err)
+	}
+	if len(results) == 0 {
+		return 0, nil
+	}
+
+	return results[0].Charged, nil
+}
+
+// setKeyNames sets the name and prefix of the keys of the rows among the keys matching filter.
+// Deleted keys only have their ID.
+func (service UsageService) setKeyNames(filter bson.M, rows []models.UsageHistoryRow) error {
+	cursor, err := service.apiKeyCollection.Find(service.ctx, filter,
+		options.Find().SetProjection(bson.M{"name": 1, "prefix": 1}))
+	if err != nil {
+		return err
+	}
+
+	var apiKeys []models.APIKey
+	if err := cursor.All(service.ctx, &apiKeys); err != nil {
+		return err
+	}
+
+	keys := map[string]models.APIKey{}
+	for _, apiKey := range apiKeys {
+		keys[apiKey.Id.Hex()] = apiKey
+	}
+
+	for i := range rows {
+		if apiKey, ok := keys[rows[i].KeyId]; ok {
+			rows[i].KeyName = apiKey.Name
+			rows[i].KeyPrefix = apiKey.Prefix
+		}
+	}
+
+	return nil
+}
+
+// usageDimensionFields maps the dimensions of a usage history to the fields of usage documents
+var usageDimensionFields = map[string]string{
+	models.KeyDimension:      "key",
+	models.EndpointDimension: "endpoint",
+	models.StatusDimension:   "statusClass",
+	models.MemberDimension:   "uid",
+}
+
+// parseGroupBy parses a comma separated list of the given dimensions, all of them if empty
+func parseGroupBy(spec string, dimensions []string) ([]string, error) {
+	if strings.TrimSpace(spec) == "" {
+		return dimensions, nil
+	}
+
+	var groupBy []string
+	seen := map[string]bool{}
+	for _, dimension := range strings.Split(spec, ",") {
+		dimension = strings.TrimSpace(dimension)
+		if !slices.Contains(dimensions, dimension) {
+			return nil, ErrInvalidGroupBy
+		}
+		if !seen[dimension] {
+			seen[dimension] = true
+			groupBy = append(groupBy, dimension)
+		}
+	}
+
+	return groupBy, nil
+}
+
+// parseUsageRange parses the range of a usage history into [from, to), aligned to the buckets of the granularity.
+// Bounds are dates (2006-01-02) or RFC 3339 times, and a date as the upper bound includes the whole day.
+// Without bounds, the range ends now and spans the default history of the granularity.
+func parseUsageRange(fromValue string, toValue string, granularity models.Granularity, now time.Time) (time.Time, time.Time, error) {
+	defaultHistory, maxHistory := defaultDailyHistory, maxDailyHistory
+	if granularity == models.HourGranularity {
+		defaultHistory, maxHistory = defaultHourlyHistory, maxHourlyHistory
+	}
+
+	to := now
+	if toValue != "" {
+		date, isDate, err := parseUsageTime(toValue)
+		if err != nil {
+			return time.Time{}, time.Time{}, ErrInvalidUsageRange
+		}
+		to = date
+		if isDate {
+			to = date.AddDate(0, 0, 1)
+		}
+	}
+
+	from := to.Add(-defaultHistory)
+	if fromValue != "" {
+		date, _, err := parseUsageTime(fromValue)
+		if err != nil {
+			return time.Time{}, time.Time{}, ErrInvalidUsageRange
+		}
+		from = date
+	}
+
+	from, to = bucketStart(from, granularity), bucketEnd(to, granularity)
+	if !from.Before(to) || to.Sub(from) > maxHistory {
+		return time.Time{}, time.Time{}, ErrInvalidUsageRange
+	}
+
+	return from, to, nil
+}
+
+// parseUsageTime parses a UTC date or an RFC 3339 time and reports whether it was a date
+func parseUsageTime(value string) (time.Time, bool, error) {
+	if date, err := time.Parse(time.DateOnly, value); err == nil {
+		return date, true, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	return t, false, err
+}
+
+// bucketStart returns the start of the bucket containing t
+func bucketStart(t time.Time, granularity models.Granularity) time.Time {
+	if granularity == models.HourGranularity {
+		return t.Truncate(time.Hour)
+	}
+
+	return utcDate(t)
+}
+
+// bucketEnd returns the end of the bucket containing t, or t if it is the start of a bucket
+func bucketEnd(t time.Time, granularity models.Granularity) time.Time {
+	start := bucketStart(t, granularity)
+	if start.Equal(t) {
+		return t
+	}
+
+	if granularity == models.HourGranularity {
+		return start.Add(time.Hour)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// utcDate returns the start of the UTC day containing t
+func utcDate(t time.Time) time.Time {
+	utc := t.UTC()
+	return time.Date(utc.Year(), utc.Month(), utc.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// statusClass returns the class of an HTTP status code, e.g. "2xx"
+func statusClass(status int) string {
+	return strconv.Itoa(status/100) + "xx"
+}
@@ -0,0 +1,178 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/AkifhanIlgaz/dictionary-api/models"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestParseRollupKey(t *testing.T) {
+	rollupId := primitive.NewObjectIDFromTimestamp(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	claimId := primitive.NewObjectIDFromTimestamp(time.Date(2026, 3, 1, 12, 5, 0, 0, time.UTC))
+
+	tests := []struct {
+		name        string
+		key         string
+		wantClaimed time.Time
+		wantErr     bool
+	}{
+		{"claimed", rollupUsagePrefix + rollupId.Hex() + ":" + claimId.Hex(), claimId.Timestamp(), false},
+		{"earlier version", rollupUsagePrefix + rollupId.Hex(), rollupId.Timestamp(), false},
+		{"invalid rollup id", rollupUsagePrefix + "rollup:" + claimId.Hex(), time.Time{}, true},
+		{"invalid claim id", rollupUsagePrefix + rollupId.Hex() + ":claim", time.Time{}, true},
+		{"too many ids", rollupUsagePrefix + rollupId.Hex() + ":" + claimId.Hex() + ":" + claimId.Hex(), time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotId, gotClaimed, err := parseRollupKey(tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRollupKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if gotId != rollupId || !gotClaimed.Equal(tt.wantClaimed) {
+				t.Errorf("parseRollupKey() = %s, %v, want %s, %v", gotId.Hex(), gotClaimed, rollupId.Hex(), tt.wantClaimed)
+			}
+		})
+	}
+}
+
+func TestRecoverRollupsSkipsRecentClaims(t *testing.T) {
+	client := newRedisClient(t)
+	service := UsageService{ctx: context.Background(), client: client}
+
+	rollupId := primitive.NewObjectID()
+	claimed := rollupUsagePrefix + rollupId.Hex() + ":" + rollupId.Hex()
+	client.HSet(client.Context(), claimed, "field", 1)
+
+	// The rollup was claimed after the cutoff, so it is still being written by the instance that claimed it
+	if err := service.recoverRollups(time.Now().Add(-usageRollupInterval)); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, _ := client.Exists(client.Context(), claimed).Result(); n != 1 {
+		t.Error("recoverRollups() claimed a rollup that is still being written")
+	}
+}
+
+func TestRecoverRollupsSetsAsideExpiredRollups(t *testing.T) {
+	client := newRedisClient(t)
+	service := UsageService{ctx: context.Background(), client: client}
+
+	rollupId := primitive.NewObjectIDFromTimestamp(time.Now().Add(-appliedRollupRetention - time.Minute))
+	claimed := rollupUsagePrefix + rollupId.Hex()
+	client.HSet(client.Context(), claimed, "field", 1)
+
+	if err := service.recoverRollups(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, _ := client.Exists(client.Context(), claimed).Result(); n != 0 {
+		t.Error("recoverRollups() kept an expired rollup")
+	}
+	if n, _ := client.Exists(client.Context(), failedRollupPrefix+rollupId.Hex()).Result(); n != 1 {
+		t.Error("recoverRollups() did not set aside an expired rollup")
+	}
+}
+
+func TestRollupRetriesCountUsageAndOverageOnce(t *testing.T) {
+	database := newTestDatabase(t)
+	client := newRedisClient(t)
+	ctx := context.Background()
+
+	service, err := NewUsageService(ctx, database, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyId := primitive.NewObjectID()
+	if _, err := database.Collection(db.ApiKeysCollection).InsertOne(ctx, bson.M{"_id": keyId}); err != nil {
+		t.Fatal(err)
+	}
+
+	day := time.Date(2026, 3, 1, 10, 30, 0, 0, time.UTC)
+	for _, at := range []time.Time{day, day, day.Add(time.Hour)} {
+		record := models.UsageRecord{Uid: "uid", KeyId: keyId.Hex(), Endpoint: "/words", Status: 200, Charged: true, Time: at}
+		if err := service.Record(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	overage := models.UsageRecord{
+		Uid: "uid", KeyId: keyId.Hex(), Endpoint: "/words", Status: 200, Charged: true,
+		Plan: "pro", Overage: models.BillOverage, Time: day.Add(time.Hour),
+	}
+	if err := service.Record(overage); err != nil {
+		t.Fatal(err)
+	}
+	counts := client.HGetAll(ctx, pendingUsageKey).Val()
+
+	rollupId := primitive.NewObjectID()
+	claimed := rollupUsagePrefix + rollupId.Hex() + ":" + rollupId.Hex()
+	client.Rename(ctx, pendingUsageKey, claimed)
+	if err := service.rollupClaimed(claimed, rollupId, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The overage is invoiced before the retry, which must not add it to a new ledger entry
+	ledger := database.Collection(db.OverageLedgerCollection)
+	if _, err := ledger.UpdateMany(ctx, bson.M{}, bson.M{"$set": bson.M{"invoiceId": primitive.NewObjectID()}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The claimed hash is left behind as if deleting it had failed, so it is retried with the same rollup ID
+	stale := rollupUsagePrefix + rollupId.Hex() + ":" + primitive.NewObjectIDFromTimestamp(time.Now().Add(-time.Minute)).Hex()
+	client.HSet(ctx, stale, counts)
+	if err := service.recoverRollups(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := client.Exists(ctx, stale).Result(); n != 0 {
+		t.Error("recoverRollups() did not roll up the claimed usage")
+	}
+
+	var daily struct {
+		Count   int64 `bson:"count"`
+		Charged int64 `bson:"charged"`
+	}
+	if err := database.Collection(db.DailyUsageCollection).FindOne(ctx, bson.M{"key": keyId.Hex()}).Decode(&daily); err != nil {
+		t.Fatal(err)
+	}
+	if daily.Count != 4 || daily.Charged != 4 {
+		t.Errorf("daily usage = %d, %d charged, want 4, 4", daily.Count, daily.Charged)
+	}
+
+	hours, err := database.Collection(db.HourlyUsageCollection).CountDocuments(ctx, bson.M{"key": keyId.Hex()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hours != 2 {
+		t.Errorf("hourly usage has %d documents, want 2", hours)
+	}
+
+	var key struct {
+		TotalUsage int64 `bson:"totalUsage"`
+	}
+	if err := database.Collection(db.ApiKeysCollection).FindOne(ctx, bson.M{"_id": keyId}).Decode(&key); err != nil {
+		t.Fatal(err)
+	}
+	if key.TotalUsage != 4 {
+		t.Errorf("total usage = %d, want 4", key.TotalUsage)
+	}
+
+	var entries []models.OverageLedgerEntry
+	cursor, err := ledger.Find(ctx, bson.M{"uid": "uid"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cursor.All(ctx, &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Requests != 1 {
+		t.Errorf("overage ledger = %+v, want a single entry of 1 request", entries)
+	}
+}
@@ -2,28 +2,60 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
+	"github.com/AkifhanIlgaz/dictionary-api/config"
 	"github.com/AkifhanIlgaz/dictionary-api/models"
 	"github.com/AkifhanIlgaz/dictionary-api/utils/apikey"
 	"github.com/AkifhanIlgaz/dictionary-api/utils/db"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/message"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+const (
+	defaultApiKeyName string = "default"
+	// apiKeyEventRetention is how long rejected API key requests are kept in the security log
+	apiKeyEventRetention    time.Duration = 90 * 24 * time.Hour
+	defaultApiKeyEventLimit int64         = 50
+	maxApiKeyEventLimit     int64         = 500
+)
+
+var (
+	ErrInvalidScope    = errors.New(message.InvalidScope)
+	ErrInvalidExpiry   = errors.New(message.InvalidExpiry)
+	ErrInvalidCIDR     = errors.New(message.InvalidCIDR)
+	ErrInvalidReferrer = errors.New(message.InvalidReferrer)
+)
+
 type UserService struct {
 	ctx              context.Context
 	userCollection   *mongo.Collection
 	apiKeyCollection *mongo.Collection
 	usageCollection  *mongo.Collection
+	// hourlyUsageCollection holds the usage buckets that today's usage is summed from
+	hourlyUsageCollection *mongo.Collection
+	eventCollection       *mongo.Collection
+	// apiKeySecret is the HMAC secret for hashing API keys, plain SHA-256 is used if empty
+	apiKeySecret string
+	// rotationGrace is how long a rotated API key keeps working by default
+	rotationGrace time.Duration
+	// legacyKeys looks up keys stored in clear text by earlier versions if no hashed key matches
+	legacyKeys bool
 }
 
-func NewUserService(ctx context.Context, mongoDatabase *mongo.Database) (UserService, error) {
+// NewUserService creates a new UserService instance and initializes the indexes of the
+// users, api keys and api key events collections. The usage indexes are initialized by NewUsageService.
+// API keys are stored as a hash, keyed with the configured secret if it is not empty.
+func NewUserService(ctx context.Context, mongoDatabase *mongo.Database, config config.Config) (UserService, error) {
 	userCollection := mongoDatabase.Collection(db.UsersCollection)
 	apiKeyCollection := mongoDatabase.Collection(db.ApiKeysCollection)
 	usageCollection := mongoDatabase.Collection(db.DailyUsageCollection)
+	eventCollection := mongoDatabase.Collection(db.ApiKeyEventsCollection)
 
 	_, err := userCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
 		Keys:    bson.D{{Key: "email", Value: 1}},
@@ -33,83 +65,236 @@ func NewUserService(ctx context.Context, mongoDatabase *mongo.Database) (UserSer
 		return UserService{}, fmt.Errorf("initialize user service: %w", err)
 	}
 
-	// Create index for api keys collection
-	_, err = apiKeyCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys:    bson.D{{Key: "uid", Value: 1}},
-		Options: options.Index().SetUnique(true),
+	// Drop indexes of earlier versions: users can have many api keys, and keys are no longer stored in clear text
+	for _, index := range []string{"uid_1", "key_1"} {
+		if _, err := apiKeyCollection.Indexes().DropOne(ctx, index); err != nil && !isIndexNotFound(err) {
+			return UserService{}, fmt.Errorf("initialize user service: %w", err)
+		}
+	}
+
+	// Create indexes for api keys collection
+	_, err = apiKeyCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "uid", Value: 1}, {Key: "createdAt", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "prefix", Value: 1}},
+		},
+		{
+			Keys:    bson.D{{Key: "orgId", Value: 1}, {Key: "createdAt", Value: 1}},
+			Options: options.Index().SetPartialFilterExpression(bson.M{"orgId": bson.M{"$exists": true}}),
+		},
+		{
+			Keys: bson.D{{Key: "previous.prefix", Value: 1}},
+			Options: options.Index().SetPartialFilterExpression(bson.M{
+				"previous": bson.M{"$exists": true},
+			}),
+		},
+		{
+			// Partial, so that keys not migrated yet without a hash do not collide
+			Keys: bson.D{{Key: "hash", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{
+				"hash": bson.M{"$exists": true},
+			}),
+		},
 	})
 	if err != nil {
 		return UserService{}, fmt.Errorf("initialize user service: %w", err)
 	}
 
-	// Create compound index for usage collection
-	_, err = usageCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys: bson.D{
-			{Key: "key", Value: 1},
-			{Key: "date", Value: 1},
+	_, err = eventCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "keyId", Value: 1}, {Key: "createdAt", Value: -1}},
+		},
+		{
+			Keys:    bson.D{{Key: "createdAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(apiKeyEventRetention.Seconds())),
 		},
-		Options: options.Index().SetUnique(true),
 	})
 	if err != nil {
-		return UserService{}, fmt.Errorf("initialize usage collection: %w", err)
+		return UserService{}, fmt.Errorf("initialize api key events collection: %w", err)
 	}
 
 	return UserService{
-		ctx:              ctx,
-		userCollection:   userCollection,
-		apiKeyCollection: apiKeyCollection,
-		usageCollection:  usageCollection,
+		ctx:                   ctx,
+		userCollection:        userCollection,
+		apiKeyCollection:      apiKeyCollection,
+		usageCollection:       usageCollection,
+		hourlyUsageCollection: mongoDatabase.Collection(db.HourlyUsageCollection),
+		eventCollection:       eventCollection,
+		apiKeySecret:          config.APIKeySecret,
+		rotationGrace:         time.Duration(config.APIKeyRotationGrace) * time.Hour,
+		legacyKeys:            config.LegacyAPIKeys,
 	}, nil
 }
 
-// CreateApiKey generates and stores a new API key for the given user ID
-func (s *UserService) CreateApiKey(uid string) (*models.APIKey, error) {
+// CreateApiKey generates and stores a new personal API key for the given user ID.
+// Keys without a name or scopes get a default name and DefaultScopes.
+// Only the prefix and hash of the key are stored, so the returned key is the only time it is visible.
+func (s *UserService) CreateApiKey(uid string, req models.CreateAPIKeyRequest) (*models.APIKey, error) {
+	return s.createApiKey(uid, "", req)
+}
+
+// CreateOrgApiKey generates and stores a new API key of an organization, created by the member with the given user ID.
+// Requests with the key count towards the quota of the organization.
+func (s *UserService) CreateOrgApiKey(orgId string, uid string, req models.CreateAPIKeyRequest) (*models.APIKey, error) {
+	return s.createApiKey(uid, orgId, req)
+}
+
+func (s *UserService) createApiKey(uid string, orgId string, req models.CreateAPIKeyRequest) (*models.APIKey, error) {
+	if err := validateApiKeyFields(req.Scopes, req.ExpiresAt, req.AllowedCIDRs, req.AllowedReferrers); err != nil {
+		return nil, err
+	}
+
 	apiKey, err := apikey.GenerateAPIKey()
 	if err != nil {
 		return nil, fmt.Errorf("generate api key: %w", err)
 	}
 
+	name := req.Name
+	if name == "" {
+		name = defaultApiKeyName
+	}
+
+	scopes := req.Scopes
+	if len(scopes) == 0 {
+		for _, scope := range apikey.DefaultScopes {
+			scopes = append(scopes, string(scope))
+		}
+	}
+
 	apiKeyDoc := models.APIKey{
-		Uid:        uid,
-		Key:        apiKey,
-		TotalUsage: 0,
-		CreatedAt:  time.Now(),
+		Name:             name,
+		Uid:              uid,
+		OrgId:            orgId,
+		Prefix:           apikey.Prefix(apiKey),
+		Hash:             apikey.Hash(apiKey, s.apiKeySecret),
+		Scopes:           scopes,
+		AllowedCIDRs:     req.AllowedCIDRs,
+		AllowedReferrers: req.AllowedReferrers,
+		TotalUsage:       0,
+		CreatedAt:        time.Now(),
+		ExpiresAt:        req.ExpiresAt,
 	}
 
-	_, err = s.apiKeyCollection.InsertOne(s.ctx, apiKeyDoc)
+	result, err := s.apiKeyCollection.InsertOne(s.ctx, apiKeyDoc)
 	if err != nil {
 		return nil, fmt.Errorf("create api key: %w", err)
 	}
+	apiKeyDoc.Id = result.InsertedID.(primitive.ObjectID)
+	apiKeyDoc.Key = apiKey
 
 	return &apiKeyDoc, nil
 }
 
-// DeleteApiKey removes the API key associated with the given user ID
-func (s *UserService) DeleteApiKey(uid string) error {
-	filter := bson.M{
-		"uid": uid,
+// ListApiKeys retrieves all personal API keys of the given user ID, oldest first
+func (s *UserService) ListApiKeys(uid string) ([]models.APIKey, error) {
+	apiKeys, err := s.listApiKeys(bson.M{"uid": uid, "orgId": bson.M{"$exists": false}})
+	if err != nil {
+		return nil, fmt.Errorf("list api keys: %w", err)
 	}
-	result, err := s.apiKeyCollection.DeleteOne(s.ctx, filter)
+
+	return apiKeys, nil
+}
+
+// ListOrgApiKeys retrieves all API keys of the organization with the given ID, oldest first
+func (s *UserService) ListOrgApiKeys(orgId string) ([]models.APIKey, error) {
+	apiKeys, err := s.listApiKeys(bson.M{"orgId": orgId})
 	if err != nil {
-		return fmt.Errorf("delete api key: %w", err)
+		return nil, fmt.Errorf("list organization api keys: %w", err)
 	}
 
-	if result.DeletedCount == 0 {
-		return fmt.Errorf("no api key found for user: %s", uid)
+	return apiKeys, nil
+}
+
+// DeleteOrgApiKey removes the API key with the given ID of an organization and returns it.
+// Unless uid is empty, only a key created by that member is removed.
+// Returns mongo.ErrNoDocuments wrapped in the error if there is no such key.
+func (s *UserService) DeleteOrgApiKey(orgId string, id string, uid string) (*models.APIKey, error) {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("delete organization api key: %w", mongo.ErrNoDocuments)
 	}
 
-	return nil
+	filter := bson.M{"_id": objectId, "orgId": orgId}
+	if uid != "" {
+		filter["uid"] = uid
+	}
+
+	var apiKey models.APIKey
+	if err := s.apiKeyCollection.FindOneAndDelete(s.ctx, filter).Decode(&apiKey); err != nil {
+		return nil, fmt.Errorf("delete organization api key: %w", err)
+	}
+
+	return &apiKey, nil
 }
 
-// GetApiKey retrieves the API key associated with the given user ID
-func (s *UserService) GetApiKey(uid string) (*models.APIKey, error) {
+// ListUserApiKeys retrieves all API keys created by the given user ID, personal keys and keys of organizations, oldest first
+func (s *UserService) ListUserApiKeys(uid string) ([]models.APIKey, error) {
+	apiKeys, err := s.listApiKeys(bson.M{"uid": uid})
+	if err != nil {
+		return nil, fmt.Errorf("list user api keys: %w", err)
+	}
+
+	return apiKeys, nil
+}
+
+// DeleteUserApiKey removes the API key with the given ID created by the given user ID, personal or of an organization,
+// and returns it. Returns mongo.ErrNoDocuments wrapped in the error if there is no such key.
+func (s *UserService) DeleteUserApiKey(uid string, id string) (*models.APIKey, error) {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("delete user api key: %w", mongo.ErrNoDocuments)
+	}
+
 	var apiKey models.APIKey
+	if err := s.apiKeyCollection.FindOneAndDelete(s.ctx, bson.M{"_id": objectId, "uid": uid}).Decode(&apiKey); err != nil {
+		return nil, fmt.Errorf("delete user api key: %w", err)
+	}
 
-	filter := bson.M{
-		"uid": uid,
+	return &apiKey, nil
+}
+
+// SetApiKeysSuspended suspends or reinstates all API keys created by the given user ID
+func (s *UserService) SetApiKeysSuspended(uid string, suspended bool) error {
+	update := bson.M{"$set": bson.M{"suspended": true}}
+	if !suspended {
+		update = bson.M{"$unset": bson.M{"suspended": ""}}
+	}
+
+	if _, err := s.apiKeyCollection.UpdateMany(s.ctx, bson.M{"uid": uid}, update); err != nil {
+		return fmt.Errorf("set api keys suspended: %w", err)
+	}
+
+	return nil
+}
+
+func (s *UserService) listApiKeys(filter bson.M) ([]models.APIKey, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+
+	cursor, err := s.apiKeyCollection.Find(s.ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	apiKeys := []models.APIKey{}
+	if err := cursor.All(s.ctx, &apiKeys); err != nil {
+		return nil, err
+	}
+
+	return apiKeys, nil
+}
+
+// GetApiKey retrieves the API key with the given ID if it belongs to the given user ID.
+// Returns mongo.ErrNoDocuments wrapped in the error if there is no such key.
+func (s *UserService) GetApiKey(uid string, id string) (*models.APIKey, error) {
+	filter, err := apiKeyFilter(uid, id)
+	if err != nil {
+		return nil, fmt.Errorf("get api key: %w", err)
 	}
 
-	err := s.apiKeyCollection.FindOne(s.ctx, filter).Decode(&apiKey)
+	var apiKey models.APIKey
+	err = s.apiKeyCollection.FindOne(s.ctx, filter).Decode(&apiKey)
 	if err != nil {
 		return nil, fmt.Errorf("get api key: %w", err)
 	}
@@ -117,107 +302,362 @@ func (s *UserService) GetApiKey(uid string) (*models.APIKey, error) {
 	return &apiKey, nil
 }
 
-func (s *UserService) GetByKey(key string) (*models.APIKey, error) {
-	filter := bson.M{
-		"key": key,
+// UpdateApiKey changes the name, scopes, expiry or allowlists of the API key with the given ID.
+// Returns the updated key, or mongo.ErrNoDocuments wrapped in the error if there is no such key.
+func (s *UserService) UpdateApiKey(uid string, id string, req models.UpdateAPIKeyRequest) (*models.APIKey, error) {
+	if err := validateApiKeyFields(req.Scopes, req.ExpiresAt, req.AllowedCIDRs, req.AllowedReferrers); err != nil {
+		return nil, err
+	}
+
+	filter, err := apiKeyFilter(uid, id)
+	if err != nil {
+		return nil, fmt.Errorf("update api key: %w", err)
+	}
+
+	set := bson.M{}
+	if req.Name != nil && *req.Name != "" {
+		set["name"] = *req.Name
+	}
+	if req.Scopes != nil {
+		set["scopes"] = req.Scopes
 	}
+	if req.ExpiresAt != nil {
+		set["expiresAt"] = req.ExpiresAt
+	}
+	if req.AllowedCIDRs != nil {
+		set["allowedCidrs"] = req.AllowedCIDRs
+	}
+	if req.AllowedReferrers != nil {
+		set["allowedReferrers"] = req.AllowedReferrers
+	}
+
+	if len(set) == 0 {
+		return s.GetApiKey(uid, id)
+	}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
 
 	var apiKey models.APIKey
-	err := s.apiKeyCollection.FindOne(s.ctx, filter).Decode(&apiKey)
+	err = s.apiKeyCollection.FindOneAndUpdate(s.ctx, filter, bson.M{"$set": set}, opts).Decode(&apiKey)
 	if err != nil {
-		return nil, fmt.Errorf("get by api key: %w", err)
+		return nil, fmt.Errorf("update api key: %w", err)
 	}
 
 	return &apiKey, nil
 }
 
-func (s *UserService) IncrementUsage(uid, key string) (int, error) {
-	dailyUsage, err := s.incrementDailyUsage(uid, key)
+// DeleteApiKey removes the API key with the given ID if it belongs to the given user ID and returns it.
+// Returns mongo.ErrNoDocuments wrapped in the error if there is no such key.
+func (s *UserService) DeleteApiKey(uid string, id string) (*models.APIKey, error) {
+	filter, err := apiKeyFilter(uid, id)
 	if err != nil {
-		return 0, fmt.Errorf("increment daily usage: %w", err)
+		return nil, fmt.Errorf("delete api key: %w", err)
 	}
 
-	err = s.incrementTotalUsage(key)
-	if err != nil {
-		return 0, fmt.Errorf("increment total usage: %w", err)
+	var apiKey models.APIKey
+	if err := s.apiKeyCollection.FindOneAndDelete(s.ctx, filter).Decode(&apiKey); err != nil {
+		return nil, fmt.Errorf("delete api key: %w", err)
 	}
 
-	return dailyUsage, nil
+	return &apiKey, nil
 }
 
-func (s *UserService) incrementDailyUsage(uid, 	key string) (int, error) {
-	// Get today's date at midnight (00:00:00)
-	now := time.Now()
-	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+// RotateApiKey replaces the key of the API key with the given ID with a new one.
+// The replaced key keeps working for the grace period, the configured one if gracePeriod is nil,
+// and both keys count towards the same API key. Rotating again during the grace period revokes
+// the key replaced before immediately.
+// Returns the updated key with the new key set, or mongo.ErrNoDocuments wrapped in the error if there is no such key.
+func (s *UserService) RotateApiKey(uid string, id string, gracePeriod *time.Duration) (*models.APIKey, error) {
+	filter, err := apiKeyFilter(uid, id)
+	if err != nil {
+		return nil, fmt.Errorf("rotate api key: %w", err)
+	}
 
-	filter := bson.M{
-		"key":  key,
-		"uid":  uid,
-		"date": today,
+	current, err := s.GetApiKey(uid, id)
+	if err != nil {
+		return nil, fmt.Errorf("rotate api key: %w", err)
+	}
+
+	apiKey, err := apikey.GenerateAPIKey()
+	if err != nil {
+		return nil, fmt.Errorf("generate api key: %w", err)
+	}
+
+	grace := s.rotationGrace
+	if gracePeriod != nil {
+		grace = *gracePeriod
 	}
 
-	update := bson.M{
-		"$inc": bson.M{"count": 1},
+	set := bson.M{
+		"prefix": apikey.Prefix(apiKey),
+		"hash":   apikey.Hash(apiKey, s.apiKeySecret),
+	}
+	unset := bson.M{}
+
+	// Keys stored in clear text by earlier versions have no hash to keep, so they are replaced without grace
+	if grace > 0 && current.Hash != "" {
+		set["previous"] = models.RotatedAPIKey{
+			Prefix:    current.Prefix,
+			Hash:      current.Hash,
+			ExpiresAt: time.Now().Add(grace),
+		}
+	} else {
+		unset["previous"] = ""
+	}
+	if current.Hash == "" {
+		unset["key"] = ""
+	}
+
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	// Matching the current hash makes a concurrent rotation fail instead of silently dropping a key
+	filter["hash"] = bson.M{"$exists": false}
+	if current.Hash != "" {
+		filter["hash"] = current.Hash
 	}
 
-	opts := options.FindOneAndUpdate().
-		SetUpsert(true).
-		SetReturnDocument(options.After)
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
 
-	var result models.DailyUsageEntry
-	err := s.usageCollection.FindOneAndUpdate(s.ctx, filter, update, opts).Decode(&result)
+	var rotated models.APIKey
+	err = s.apiKeyCollection.FindOneAndUpdate(s.ctx, filter, update, opts).Decode(&rotated)
 	if err != nil {
-		return 0, fmt.Errorf("increment daily usage: %w", err)
+		return nil, fmt.Errorf("rotate api key: %w", err)
 	}
+	rotated.Key = apiKey
 
-	return result.Usage, nil
+	return &rotated, nil
 }
 
-func (s *UserService) incrementTotalUsage(key string) error {
-	filter := bson.M{
-		"key": key,
+// RecordSecurityEvent stores a rejected attempt to use an API key in its security log
+func (s *UserService) RecordSecurityEvent(event models.APIKeySecurityEvent) error {
+	event.CreatedAt = time.Now()
+
+	if _, err := s.eventCollection.InsertOne(s.ctx, event); err != nil {
+		return fmt.Errorf("record api key security event: %w", err)
+	}
+
+	return nil
+}
+
+// ListSecurityEvents returns the most recent rejected attempts to use the API key with the given ID, newest first.
+// Returns mongo.ErrNoDocuments wrapped in the error if the key does not belong to the given user ID.
+func (s *UserService) ListSecurityEvents(uid string, id string, limit int64) ([]models.APIKeySecurityEvent, error) {
+	apiKey, err := s.GetApiKey(uid, id)
+	if err != nil {
+		return nil, fmt.Errorf("list api key security events: %w", err)
 	}
 
-	update := bson.M{
-		"$inc": bson.M{"totalUsage": 1},
+	if limit <= 0 {
+		limit = defaultApiKeyEventLimit
 	}
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}).SetLimit(min(limit, maxApiKeyEventLimit))
 
-	_, err := s.apiKeyCollection.UpdateOne(s.ctx, filter, update)
+	cursor, err := s.eventCollection.Find(s.ctx, bson.M{"keyId": apiKey.Id}, opts)
 	if err != nil {
-		return fmt.Errorf("increment total usage: %w", err)
+		return nil, fmt.Errorf("list api key security events: %w", err)
 	}
 
-	return nil
+	events := []models.APIKeySecurityEvent{}
+	if err := cursor.All(s.ctx, &events); err != nil {
+		return nil, fmt.Errorf("list api key security events: %w", err)
+	}
+
+	return events, nil
 }
 
-func (s *UserService) GetTodayUsage(uid string) (int, error) {
+// GetByKey finds the API key document for a full key, which is either its current key or
+// the previous key during the grace period of a rotation, or a clear text key of an earlier version if legacy keys are allowed.
+// Candidates are looked up by the public prefix and the hash is compared in constant time.
+// Returns mongo.ErrNoDocuments wrapped in the error if no key matches.
+func (s *UserService) GetByKey(key string) (*models.APIKey, error) {
+	prefix := apikey.Prefix(key)
+	filter := bson.M{
+		"$or": bson.A{
+			bson.M{"prefix": prefix},
+			bson.M{"previous.prefix": prefix},
+		},
+	}
+
+	cursor, err := s.apiKeyCollection.Find(s.ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("get by api key: %w", err)
+	}
+
+	var candidates []models.APIKey
+	if err := cursor.All(s.ctx, &candidates); err != nil {
+		return nil, fmt.Errorf("get by api key: %w", err)
+	}
+
 	now := time.Now()
-	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	for _, candidate := range candidates {
+		if apikey.Verify(key, candidate.Hash, s.apiKeySecret) {
+			return &candidate, nil
+		}
+
+		previous := candidate.Previous
+		if previous != nil && previous.IsActive(now) && apikey.Verify(key, previous.Hash, s.apiKeySecret) {
+			return &candidate, nil
+		}
+	}
 
-	filter := bson.M{
-		"uid":  uid,
-		"date": today,
+	// Keys of earlier versions are only looked up in clear text if allowed until MigrateApiKeysToHashes runs.
+	// The lookup scans the collection, since the clear text keys are no longer indexed.
+	if !s.legacyKeys {
+		return nil, fmt.Errorf("get by api key: %w", mongo.ErrNoDocuments)
+	}
+
+	var legacyKey models.APIKey
+	if err := s.apiKeyCollection.FindOne(s.ctx, bson.M{"key": key}).Decode(&legacyKey); err != nil {
+		return nil, fmt.Errorf("get by api key: %w", err)
+	}
+
+	return &legacyKey, nil
+}
+
+// MigrateApiKeysToHashes replaces API keys stored in clear text by earlier versions with their
+// prefix and hash, and moves their daily usage from the clear text key to the key ID.
+// It is safe to run more than once. Returns the number of migrated keys.
+func (s *UserService) MigrateApiKeysToHashes() (int, error) {
+	cursor, err := s.apiKeyCollection.Find(s.ctx, bson.M{"key": bson.M{"$exists": true}})
+	if err != nil {
+		return 0, fmt.Errorf("migrate api keys: %w", err)
+	}
+
+	var legacyKeys []models.LegacyAPIKey
+	if err := cursor.All(s.ctx, &legacyKeys); err != nil {
+		return 0, fmt.Errorf("migrate api keys: %w", err)
+	}
+
+	for _, legacyKey := range legacyKeys {
+		_, err := s.usageCollection.UpdateMany(s.ctx, bson.M{"key": legacyKey.Key}, bson.M{
+			"$set": bson.M{"key": legacyKey.Id.Hex()},
+		})
+		if err != nil {
+			return 0, fmt.Errorf("migrate daily usage of %s: %w", legacyKey.Id.Hex(), err)
+		}
+
+		_, err = s.apiKeyCollection.UpdateByID(s.ctx, legacyKey.Id, bson.M{
+			"$set": bson.M{
+				"prefix": apikey.Prefix(legacyKey.Key),
+				"hash":   apikey.Hash(legacyKey.Key, s.apiKeySecret),
+			},
+			"$unset": bson.M{"key": ""},
+		})
+		if err != nil {
+			return 0, fmt.Errorf("migrate api key %s: %w", legacyKey.Id.Hex(), err)
+		}
+	}
+
+	return len(legacyKeys), nil
+}
+
+// GetTodayUsage returns today's charged usage summed over all personal API keys of the given user ID.
+// Today starts at midnight in loc, the billing timezone of the user, so it is summed from the hourly usage.
+// Hourly buckets are UTC hours, so in timezones whose offset is not a whole hour, e.g. Asia/Kolkata, today
+// starts at the UTC hour that midnight falls in and includes up to 45 minutes of yesterday.
+// Usage is rolled up by UsageService, so the most recent requests may be missing.
+func (s *UserService) GetTodayUsage(uid string, loc *time.Location) (int, error) {
+	now := time.Now().In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+
+	match := personalUsage(uid)
+	match["hour"] = bson.M{"$gte": today.Truncate(time.Hour), "$lt": today.AddDate(0, 0, 1)}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "usage": bson.M{"$sum": chargedCount}}}},
 	}
 
-	var result models.DailyUsageEntry
-	err := s.usageCollection.FindOne(s.ctx, filter).Decode(&result)
+	usage, err := s.sumUsage(s.hourlyUsageCollection, pipeline)
 	if err != nil {
 		return 0, fmt.Errorf("get today usage: %w", err)
 	}
 
-	return result.Usage, nil
+	return usage, nil
 }
 
+// GetTotalUsage returns the total charged usage summed over all personal API keys of the given user ID
 func (s *UserService) GetTotalUsage(uid string) (int, error) {
-	filter := bson.M{
-		"uid": uid,
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"uid": uid, "orgId": bson.M{"$exists": false}}}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "usage": bson.M{"$sum": "$totalUsage"}}}},
 	}
 
-	var result models.APIKey
-	err := s.apiKeyCollection.FindOne(s.ctx, filter).Decode(&result)
+	usage, err := s.sumUsage(s.apiKeyCollection, pipeline)
 	if err != nil {
 		return 0, fmt.Errorf("get total usage: %w", err)
 	}
 
-	return result.TotalUsage, nil
+	return usage, nil
+}
+
+// sumUsage runs a pipeline that groups into a single document with a usage field
+func (s *UserService) sumUsage(collection *mongo.Collection, pipeline mongo.Pipeline) (int, error) {
+	cursor, err := collection.Aggregate(s.ctx, pipeline)
+	if err != nil {
+		return 0, err
+	}
+
+	var results []struct {
+		Usage int `bson:"usage"`
+	}
+	if err := cursor.All(s.ctx, &results); err != nil {
+		return 0, err
+	}
+
+	if len(results) == 0 {
+		return 0, nil
+	}
+
+	return results[0].Usage, nil
+}
+
+// validateApiKeyFields checks that all scopes are known, the expiry is in the future and the allowlist entries are valid
+func validateApiKeyFields(scopes []string, expiresAt *time.Time, allowedCIDRs []string, allowedReferrers []string) error {
+	for _, scope := range scopes {
+		if !apikey.IsValidScope(scope) {
+			return ErrInvalidScope
+		}
+	}
+
+	for _, cidr := range allowedCIDRs {
+		if !apikey.IsValidCIDR(cidr) {
+			return ErrInvalidCIDR
+		}
+	}
+
+	for _, referrer := range allowedReferrers {
+		if !apikey.IsValidReferrer(referrer) {
+			return ErrInvalidReferrer
+		}
+	}
+
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return ErrInvalidExpiry
+	}
+
+	return nil
+}
+
+// apiKeyFilter matches the personal API key with the given ID of the given user ID.
+// Keys of organizations are managed through their organization.
+func apiKeyFilter(uid string, id string) (bson.M, error) {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	return bson.M{
+		"_id":   objectId,
+		"uid":   uid,
+		"orgId": bson.M{"$exists": false},
+	}, nil
+}
+
+func isIndexNotFound(err error) bool {
+	var commandErr mongo.CommandError
+	return errors.As(err, &commandErr) && (commandErr.Code == 27 || commandErr.Name == "IndexNotFound" || commandErr.Name == "NamespaceNotFound")
 }
@@ -0,0 +1,635 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/AkifhanIlgaz/dictionary-api/config"
+	"github.com/AkifhanIlgaz/dictionary-api/models"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/db"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/message"
+	"github.com/AkifhanIlgaz/dictionary-api/utils/webhook"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// webhookAttempts is how many times a delivery is attempted before it fails
+	webhookAttempts int = 8
+	// webhookBackoff is the wait before the first retry, it doubles with every retry
+	webhookBackoff time.Duration = 30 * time.Second
+	// webhookLease is how long a claimed delivery is hidden from other workers. A delivery whose worker
+	// stopped before finishing it is attempted again once its lease ends.
+	webhookLease time.Duration = 2 * webhook.Timeout
+	// webhookPollInterval is how often the queue is checked for due retries
+	webhookPollInterval time.Duration = 5 * time.Second
+	// webhookWorkers is how many deliveries an instance attempts at the same time
+	webhookWorkers int = 8
+	// webhookDeliveryRetention is how long the delivery log is kept
+	webhookDeliveryRetention time.Duration = 30 * 24 * time.Hour
+	// maxDeliveryErrorLength limits the errors stored in the delivery log
+	maxDeliveryErrorLength int = 512
+
+	defaultWebhookDeliveryLimit int64 = 50
+	maxWebhookDeliveryLimit     int64 = 500
+)
+
+var (
+	ErrWebhookEndpointNotFound = errors.New(message.WebhookEndpointNotFound)
+	ErrWebhookDeliveryNotFound = errors.New(message.WebhookDeliveryNotFound)
+	ErrInvalidWebhookURL       = errors.New(message.InvalidWebhookURL)
+	ErrInvalidEventType        = errors.New(message.InvalidEventType)
+	ErrTooManyWebhookEndpoints = errors.New(message.TooManyWebhookEndpoints)
+)
+
+// WebhookService manages the webhook endpoints of users and delivers events to them.
+// Events are queued as deliveries in MongoDB, so that they survive restarts and are shared by all instances,
+// and attempted until the endpoint acknowledges them or they run out of attempts, with exponential backoff.
+type WebhookService struct {
+	ctx                context.Context
+	endpointCollection *mongo.Collection
+	deliveryCollection *mongo.Collection
+	userCollection     *mongo.Collection
+	client             *http.Client
+	// wake starts delivering right away instead of at the next poll
+	wake chan struct{}
+}
+
+// NewWebhookService creates a new WebhookService instance and initializes the indexes of the
+// webhook endpoints and deliveries collections
+func NewWebhookService(ctx context.Context, mongoDatabase *mongo.Database, config config.Config) (WebhookService, error) {
+	endpointCollection := mongoDatabase.Collection(db.WebhookEndpointsCollection)
+	deliveryCollection := mongoDatabase.Collection(db.WebhookDeliveriesCollection)
+
+	_, err := endpointCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "uid", Value: 1}, {Key: "createdAt", Value: 1}},
+	})
+	if err != nil {
+		return WebhookService{}, fmt.Errorf("initialize webhook endpoints collection: %w", err)
+	}
+
+	_, err = deliveryCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			// Pending deliveries are the queue
+			Keys: bson.D{{Key: "status", Value: 1}, {Key: "nextAttemptAt", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "uid", Value: 1}, {Key: "endpointId", Value: 1}, {Key: "createdAt", Value: -1}},
+		},
+		{
+			Keys:    bson.D{{Key: "createdAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(webhookDeliveryRetention.Seconds())),
+		},
+	})
+	if err != nil {
+		return WebhookService{}, fmt.Errorf("initialize webhook deliveries collection: %w", err)
+	}
+
+	return WebhookService{
+		ctx:                ctx,
+		endpointCollection: endpointCollection,
+		deliveryCollection: deliveryCollection,
+		userCollection:     mongoDatabase.Collection(db.UsersCollection),
+		client:             webhook.NewClient(config.WebhookAllowPrivateURLs),
+		wake:               make(chan struct{}, 1),
+	}, nil
+}
+
+// CreateEndpoint registers a webhook endpoint for the given user ID with a new signing secret.
+// An endpoint without events is sent all event types.
+func (service WebhookService) CreateEndpoint(uid string, req models.CreateWebhookEndpointRequest) (*models.WebhookEndpoint, error) {
+	if err := webhook.ValidateURL(req.URL); err != nil {
+		return nil, ErrInvalidWebhookURL
+	}
+
+	events, err := parseEventTypes(req.Events)
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := service.endpointCollection.CountDocuments(service.ctx, bson.M{"uid": uid})
+	if err != nil {
+		return nil, fmt.Errorf("create webhook endpoint: %w", err)
+	}
+	if count >= int64(models.MaxWebhookEndpoints) {
+		return nil, ErrTooManyWebhookEndpoints
+	}
+
+	now := time.Now()
+	endpoint := models.WebhookEndpoint{
+		Uid:         uid,
+		URL:         req.URL,
+		Description: req.Description,
+		Events:      events,
+		Enabled:     true,
+		Secret:      webhook.GenerateSecret(),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	result, err := service.endpointCollection.InsertOne(service.ctx, endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("create webhook endpoint: %w", err)
+	}
+	endpoint.Id = result.InsertedID.(primitive.ObjectID)
+
+	return &endpoint, nil
+}
+
+// ListEndpoints retrieves the webhook endpoints of the given user ID, oldest first
+func (service WebhookService) ListEndpoints(uid string) ([]models.WebhookEndpoint, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+
+	cursor, err := service.endpointCollection.Find(service.ctx, bson.M{"uid": uid}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("list webhook endpoints: %w", err)
+	}
+
+	endpoints := []models.WebhookEndpoint{}
+	if err := cursor.All(service.ctx, &endpoints); err != nil {
+		return nil, fmt.Errorf("list webhook endpoints: %w", err)
+	}
+
+	return endpoints, nil
+}
+
+// GetEndpoint retrieves the webhook endpoint with the given ID if it belongs to the given user ID
+func (service WebhookService) GetEndpoint(uid string, id string) (*models.WebhookEndpoint, error) {
+	filter, err := webhookFilter(uid, id, ErrWebhookEndpointNotFound)
+	if err != nil {
+		return nil, err
+	}
+
+	var endpoint models.WebhookEndpoint
+	err = service.endpointCollection.FindOne(service.ctx, filter).Decode(&endpoint)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrWebhookEndpointNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get webhook endpoint: %w", err)
+	}
+
+	return &endpoint, nil
+}
+
+// UpdateEndpoint changes the fields of a webhook endpoint that are set in the request.
+// Pending deliveries are sent with the new URL and secret.
+func (service WebhookService) UpdateEndpoint(uid string, id string, req models.UpdateWebhookEndpointRequest) (*models.WebhookEndpoint, error) {
+	filter, err := webhookFilter(uid, id, ErrWebhookEndpointNotFound)
+	if err != nil {
+		return nil, err
+	}
+
+	set := bson.M{"updatedAt": time.Now()}
+	if req.URL != nil {
+		if err := webhook.ValidateURL(*req.URL); err != nil {
+			return nil, ErrInvalidWebhookURL
+		}
+		set["url"] = *req.URL
+	}
+	if req.Description != nil {
+		set["description"] = *req.Description
+	}
+	if req.Events != nil {
+		events, err := parseEventTypes(req.Events)
+		if err != nil {
+			return nil, err
+		}
+		set["events"] = events
+	}
+	if req.Enabled != nil {
+		set["enabled"] = *req.Enabled
+	}
+	if req.RotateSecret {
+		set["secret"] = webhook.GenerateSecret()
+	}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var endpoint models.WebhookEndpoint
+	err = service.endpointCollection.FindOneAndUpdate(service.ctx, filter, bson.M{"$set": set}, opts).Decode(&endpoint)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrWebhookEndpointNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("update webhook endpoint: %w", err)
+	}
+
+	return &endpoint, nil
+}
+
+// DeleteEndpoint removes a webhook endpoint. Its pending deliveries fail, its delivery log is kept.
+func (service WebhookService) DeleteEndpoint(uid string, id string) error {
+	filter, err := webhookFilter(uid, id, ErrWebhookEndpointNotFound)
+	if err != nil {
+		return err
+	}
+
+	result, err := service.endpointCollection.DeleteOne(service.ctx, filter)
+	if err != nil {
+		return fmt.Errorf("delete webhook endpoint: %w", err)
+	}
+	if result.DeletedCount == 0 {
+		return ErrWebhookEndpointNotFound
+	}
+
+	return nil
+}
+
+// Publish queues an event for the enabled webhook endpoints of the given user ID that subscribe to its type
+func (service WebhookService) Publish(uid string, eventType models.WebhookEventType, data any) error {
+	return service.publish(bson.M{"uid": uid}, eventType, data)
+}
+
+// Broadcast queues an event for the enabled webhook endpoints of all users that subscribe to its type
+func (service WebhookService) Broadcast(eventType models.WebhookEventType, data any) error {
+	return service.publish(bson.M{}, eventType, data)
+}
+
+func (service WebhookService) publish(filter bson.M, eventType models.WebhookEventType, data any) error {
+	filter["enabled"] = true
+	filter["$or"] = bson.A{
+		bson.M{"events": eventType},
+		bson.M{"events": bson.M{"$size": 0}},
+		bson.M{"events": nil},
+	}
+
+	cursor, err := service.endpointCollection.Find(service.ctx, filter)
+	if err != nil {
+		return fmt.Errorf("publish %s: %w", eventType, err)
+	}
+
+	var endpoints []models.WebhookEndpoint
+	if err := cursor.All(service.ctx, &endpoints); err != nil {
+		return fmt.Errorf("publish %s: %w", eventType, err)
+	}
+	if len(endpoints) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	event := models.WebhookEvent{
+		Id:        "evt_" + primitive.NewObjectID().Hex(),
+		Type:      eventType,
+		CreatedAt: now.UTC(),
+		Data:      data,
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("publish %s: %w", eventType, err)
+	}
+
+	deliveries := make([]interface{}, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		deliveries = append(deliveries, models.WebhookDelivery{
+			Uid:           endpoint.Uid,
+			EndpointId:    endpoint.Id,
+			EventId:       event.Id,
+			EventType:     eventType,
+			Payload:       string(payload),
+			Status:        models.PendingDelivery,
+			Attempts:      []models.WebhookAttempt{},
+			NextAttemptAt: &now,
+			CreatedAt:     now,
+		})
+	}
+
+	if _, err := service.deliveryCollection.InsertMany(service.ctx, deliveries); err != nil {
+		return fmt.Errorf("publish %s: %w", eventType, err)
+	}
+
+	service.notify()
+	return nil
+}
+
+// ListDeliveries retrieves the most recent deliveries of a webhook endpoint, newest first
+func (service WebhookService) ListDeliveries(uid string, endpointId string, limit int64) ([]models.WebhookDelivery, error) {
+	endpoint, err := service.GetEndpoint(uid, endpointId)
+	if err != nil {
+		return nil, err
+	}
+
+	if limit <= 0 {
+		limit = defaultWebhookDeliveryLimit
+	}
+
+	filter := bson.M{"uid": uid, "endpointId": endpoint.Id}
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}).SetLimit(min(limit, maxWebhookDeliveryLimit))
+
+	cursor, err := service.deliveryCollection.Find(service.ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("list webhook deliveries: %w", err)
+	}
+
+	deliveries := []models.WebhookDelivery{}
+	if err := cursor.All(service.ctx, &deliveries); err != nil {
+		return nil, fmt.Errorf("list webhook deliveries: %w", err)
+	}
+
+	return deliveries, nil
+}
+
+// GetDelivery retrieves a delivery of a webhook endpoint of the given user ID
+func (service WebhookService) GetDelivery(uid string, endpointId string, id string) (*models.WebhookDelivery, error) {
+	filter, err := webhookFilter(uid, id, ErrWebhookDeliveryNotFound)
+	if err != nil {
+		return nil, err
+	}
+	filter["endpointId"], err = primitive.ObjectIDFromHex(endpointId)
+	if err != nil {
+		return nil, ErrWebhookDeliveryNotFound
+	}
+
+	var delivery models.WebhookDelivery
+	err = service.deliveryCollection.FindOne(service.ctx, filter).Decode(&delivery)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrWebhookDeliveryNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get webhook delivery: %w", err)
+	}
+
+	return &delivery, nil
+}
+
+// Redeliver queues the event of a delivery again for its endpoint, as a new delivery with a fresh set of attempts.
+// The event keeps its ID, so that endpoints can tell it apart from new events.
+func (service WebhookService) Redeliver(uid string, endpointId string, id string) (*models.WebhookDelivery, error) {
+	delivery, err := service.GetDelivery(uid, endpointId, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := service.GetEndpoint(uid, endpointId); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	redelivery := models.WebhookDelivery{
+		Uid:           delivery.Uid,
+		EndpointId:    delivery.EndpointId,
+		EventId:       delivery.EventId,
+		EventType:     delivery.EventType,
+		Payload:       delivery.Payload,
+		Status:        models.PendingDelivery,
+		Attempts:      []models.WebhookAttempt{},
+		NextAttemptAt: &now,
+		RedeliveryOf:  &delivery.Id,
+		CreatedAt:     now,
+	}
+
+	result, err := service.deliveryCollection.InsertOne(service.ctx, redelivery)
+	if err != nil {
+		return nil, fmt.Errorf("redeliver webhook: %w", err)
+	}
+	redelivery.Id = result.InsertedID.(primitive.ObjectID)
+
+	service.notify()
+	return &redelivery, nil
+}
+
+// StartDelivery attempts the due deliveries of the queue every poll interval, and right away when events are
+// published, until ctx is done
+func (service WebhookService) StartDelivery(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(webhookPollInterval)
+		defer ticker.Stop()
+
+		workers := make(chan struct{}, webhookWorkers)
+		for {
+			service.deliverDue(ctx, workers)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-service.wake:
+			}
+		}
+	}()
+}
+
+// notify wakes the delivery loop up, without blocking if it is already awake
+func (service WebhookService) notify() {
+	select {
+	case service.wake <- struct{}{}:
+	default:
+	}
+}
+
+// deliverDue claims due deliveries until there are none left, and attempts each of them on one of the workers
+func (service WebhookService) deliverDue(ctx context.Context, workers chan struct{}) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case workers <- struct{}{}:
+		}
+
+		delivery, err := service.claim(time.Now())
+		if err != nil {
+			<-workers
+			if !errors.Is(err, mongo.ErrNoDocuments) {
+				log.Println(err.Error())
+			}
+			return
+		}
+
+		go func() {
+			defer func() { <-workers }()
+			if err := service.attempt(ctx, delivery); err != nil {
+				log.Println(err.Error())
+			}
+		}()
+	}
+}
+
+// claim takes the pending delivery that has been due the longest and moves its next attempt past the lease,
+// so that no other worker claims it meanwhile
+func (service WebhookService) claim(now time.Time) (models.WebhookDelivery, error) {
+	filter := bson.M{
+		"status":        models.PendingDelivery,
+		"nextAttemptAt": bson.M{"$lte": now},
+	}
+	update := bson.M{"$set": bson.M{"nextAttemptAt": now.Add(webhookLease)}}
+	opts := options.FindOneAndUpdate().SetSort(bson.D{{Key: "nextAttemptAt", Value: 1}})
+
+	var delivery models.WebhookDelivery
+	err := service.deliveryCollection.FindOneAndUpdate(service.ctx, filter, update, opts).Decode(&delivery)
+	if err != nil {
+		return models.WebhookDelivery{}, fmt.Errorf("claim webhook delivery: %w", err)
+	}
+
+	return delivery, nil
+}
+
+// attempt sends a claimed delivery to its endpoint and records the attempt. Failed attempts are retried after
+// webhookBackoff, doubled with every attempt, until webhookAttempts is reached.
+func (service WebhookService) attempt(ctx context.Context, delivery models.WebhookDelivery) error {
+	var endpoint models.WebhookEndpoint
+	err := service.endpointCollection.FindOne(service.ctx, bson.M{"_id": delivery.EndpointId}).Decode(&endpoint)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return fmt.Errorf("attempt webhook delivery: %w", err)
+	}
+
+	start := time.Now()
+	var statusCode int
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		err = errors.New("endpoint deleted")
+	case !endpoint.Enabled:
+		err = errors.New("endpoint disabled")
+	default:
+		statusCode, err = webhook.Send(ctx, service.client, endpoint.URL, endpoint.Secret, delivery.EventId, []byte(delivery.Payload), start)
+	}
+	end := time.Now()
+
+	record := models.WebhookAttempt{
+		At:         start,
+		StatusCode: statusCode,
+		DurationMs: end.Sub(start).Milliseconds(),
+	}
+
+	set := bson.M{}
+	unset := bson.M{}
+	switch {
+	case err == nil:
+		set["status"] = models.SucceededDelivery
+		set["completedAt"] = end
+		unset["nextAttemptAt"] = ""
+	case statusCode == 0 && (errors.Is(err, context.Canceled) || ctx.Err() != nil):
+		// Stopped by a shutdown, the delivery is attempted again once its lease ends
+		return nil
+	case endpoint.Id.IsZero() || !endpoint.Enabled || delivery.AttemptCount+1 >= webhookAttempts:
+		record.Error = truncate(err.Error(), maxDeliveryErrorLength)
+		set["status"] = models.FailedDelivery
+		set["completedAt"] = end
+		unset["nextAttemptAt"] = ""
+	default:
+		record.Error = truncate(err.Error(), maxDeliveryErrorLength)
+		set["nextAttemptAt"] = end.Add(webhookBackoff << delivery.AttemptCount)
+	}
+
+	update := bson.M{
+		"$set": set,
+		"$inc": bson.M{"attemptCount": 1},
+		"$push": bson.M{"attempts": bson.M{
+			"$each":  bson.A{record},
+			"$slice": -webhookAttempts,
+		}},
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	if _, err := service.deliveryCollection.UpdateByID(service.ctx, delivery.Id, update); err != nil {
+		return fmt.Errorf("record webhook attempt: %w", err)
+	}
+
+	return nil
+}
+
+// MigrateQuotaAlertWebhooks moves the webhook URLs of quota alert settings, from before quota alerts were sent
+// through the webhook subsystem, to webhook endpoints subscribed to the quota events with the same secret.
+// Returns the number of migrated users.
+func (service WebhookService) MigrateQuotaAlertWebhooks() (int, error) {
+	filter := bson.M{"quotaAlerts.webhookUrl": bson.M{"$exists": true}}
+	opts := options.Find().SetProjection(bson.M{"quotaAlerts": 1})
+
+	cursor, err := service.userCollection.Find(service.ctx, filter, opts)
+	if err != nil {
+		return 0, fmt.Errorf("migrate quota alert webhooks: %w", err)
+	}
+	defer cursor.Close(service.ctx)
+
+	migrated := 0
+	for cursor.Next(service.ctx) {
+		var user struct {
+			Id          primitive.ObjectID `bson:"_id"`
+			QuotaAlerts struct {
+				WebhookURL    string `bson:"webhookUrl"`
+				WebhookSecret string `bson:"webhookSecret"`
+			} `bson:"quotaAlerts"`
+		}
+		if err := cursor.Decode(&user); err != nil {
+			return migrated, fmt.Errorf("migrate quota alert webhooks: %w", err)
+		}
+
+		if user.QuotaAlerts.WebhookURL != "" {
+			secret := user.QuotaAlerts.WebhookSecret
+			if secret == "" {
+				secret = webhook.GenerateSecret()
+			}
+
+			now := time.Now()
+			_, err := service.endpointCollection.InsertOne(service.ctx, models.WebhookEndpoint{
+				Uid:         user.Id.Hex(),
+				URL:         user.QuotaAlerts.WebhookURL,
+				Description: "Quota alerts",
+				Events:      []models.WebhookEventType{models.QuotaThresholdEvent, models.QuotaOverageEvent},
+				Enabled:     true,
+				Secret:      secret,
+				CreatedAt:   now,
+				UpdatedAt:   now,
+			})
+			if err != nil {
+				return migrated, fmt.Errorf("migrate quota alert webhooks: %w", err)
+			}
+		}
+
+		update := bson.M{"$unset": bson.M{"quotaAlerts.webhookUrl": "", "quotaAlerts.webhookSecret": ""}}
+		if _, err := service.userCollection.UpdateByID(service.ctx, user.Id, update); err != nil {
+			return migrated, fmt.Errorf("migrate quota alert webhooks: %w", err)
+		}
+		migrated++
+	}
+
+	if err := cursor.Err(); err != nil {
+		return migrated, fmt.Errorf("migrate quota alert webhooks: %w", err)
+	}
+
+	return migrated, nil
+}
+
+// parseEventTypes validates the event types of an endpoint and removes duplicates
+func parseEventTypes(events []string) ([]models.WebhookEventType, error) {
+	parsed := []models.WebhookEventType{}
+	for _, event := range events {
+		eventType := models.WebhookEventType(event)
+		if !eventType.IsValid() {
+			return nil, ErrInvalidEventType
+		}
+		if !slices.Contains(parsed, eventType) {
+			parsed = append(parsed, eventType)
+		}
+	}
+
+	return parsed, nil
+}
+
+// webhookFilter returns the filter of a document of the given user ID, or notFound if the ID is invalid
+func webhookFilter(uid string, id string, notFound error) (bson.M, error) {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, notFound
+	}
+
+	return bson.M{"_id": objectId, "uid": uid}, nil
+}
+
+func truncate(s string, length int) string {
+	if len(s) <= length {
+		return s
+	}
+
+	return s[:length]
+}
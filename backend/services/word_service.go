@@ -9,6 +9,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // WordService handles operations related to word data in MongoDB
@@ -71,3 +72,59 @@ func (service WordService) GetByName(wordName string, partOfSpeech string) (mode
 
 	return word, nil
 }
+
+// GetByNames retrieves every entry of the given words, optionally of a part of speech, ordered by index.
+// Words with several parts of speech have an entry for each of them.
+func (service WordService) GetByNames(wordNames []string, partOfSpeech string) ([]models.WordInfo, error) {
+	filter := bson.M{
+		"word": bson.M{"$in": wordNames},
+	}
+
+	if partOfSpeech != "" {
+		filter["header.partOfSpeech"] = partOfSpeech
+	}
+
+	cursor, err := service.collection.Find(service.ctx, filter, options.Find().SetSort(bson.D{{Key: "index", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("get words by names: %w", err)
+	}
+
+	words := []models.WordInfo{}
+	if err := cursor.All(service.ctx, &words); err != nil {
+		return nil, fmt.Errorf("get words by names: %w", err)
+	}
+
+	return words, nil
+}
+
+// ForEach calls fn with every word of the given CEFR level, or every word if it is empty, ordered by index.
+// It stops at the first error fn returns.
+func (service WordService) ForEach(cefrLevel string, fn func(word models.WordInfo) error) error {
+	filter := bson.M{}
+	if cefrLevel != "" {
+		filter["header.CEFRLevel"] = cefrLevel
+	}
+
+	cursor, err := service.collection.Find(service.ctx, filter, options.Find().SetSort(bson.D{{Key: "index", Value: 1}}))
+	if err != nil {
+		return fmt.Errorf("for each word: %w", err)
+	}
+	defer cursor.Close(service.ctx)
+
+	for cursor.Next(service.ctx) {
+		var word models.WordInfo
+		if err := cursor.Decode(&word); err != nil {
+			return fmt.Errorf("for each word: %w", err)
+		}
+
+		if err := fn(word); err != nil {
+			return err
+		}
+	}
+
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("for each word: %w", err)
+	}
+
+	return nil
+}
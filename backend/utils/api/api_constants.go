@@ -6,22 +6,55 @@ const (
 	UidParam          string = "uid"
 	NameParam         string = "name"
 	ApiKeyParam       string = "apikey"
+	ProviderParam     string = "provider"
+	IdParam           string = "id"
+	LimitParam        string = "limit"
+	DeliveryIdParam   string = "deliveryId"
+	MemberIdParam     string = "memberId"
+	InvitationIdParam string = "invitationId"
+	KeyIdParam        string = "keyId"
+	PaidParam         string = "paid"
 )
 
 const (
-	AuthHeader string = "Authorization"
+	AuthHeader        string = "Authorization"
+	RetryAfterHeader  string = "Retry-After"
+	ApiKeyHeader      string = "X-API-Key"
+	DeprecationHeader string = "Deprecation"
+	WarningHeader     string = "Warning"
+	OriginHeader      string = "Origin"
+	RefererHeader     string = "Referer"
+	UserAgentHeader   string = "User-Agent"
+
+	ContentTypeHeader        string = "Content-Type"
+	ContentDispositionHeader string = "Content-Disposition"
+
+	// Rate limit headers of the IETF draft "RateLimit header fields for HTTP"
+	RateLimitLimitHeader     string = "RateLimit-Limit"
+	RateLimitRemainingHeader string = "RateLimit-Remaining"
+	RateLimitResetHeader     string = "RateLimit-Reset"
+	RateLimitPolicyHeader    string = "RateLimit-Policy"
 )
 
+// OAuthBindingCookie binds a social login to the browser that started it
+const OAuthBindingCookie string = "oauth_binding"
+
 const (
-	ContextUid string = "uid"
+	JSONFormat string = "json"
+	CSVFormat  string = "csv"
+	MIMECSV    string = "text/csv"
+	MIMEPDF    string = "application/pdf"
 )
 
 const (
-	FreePlanUsage int = 10
-	ProPlanUsage  int = 1000
+	BearerScheme string = "Bearer"
+	ApiKeyScheme string = "ApiKey"
 )
 
-var PlanUsageLimits = map[string]int{
-	"free": FreePlanUsage,
-	"pro":  ProPlanUsage,
-}
+const (
+	ContextUid  string = "uid"
+	ContextRole string = "role"
+	ContextPlan string = "plan"
+	// ContextImpersonator is the support staff member or admin behind a read-only impersonation token
+	ContextImpersonator string = "impersonator"
+)
@@ -0,0 +1,129 @@
+package apikey
+
+import (
+	"net/netip"
+	"net/url"
+	"strings"
+)
+
+// IsValidCIDR reports whether the entry is a CIDR range like "203.0.113.0/24" or a single IP address
+func IsValidCIDR(entry string) bool {
+	_, err := parseCIDR(entry)
+	return err == nil
+}
+
+// IsIPAllowed reports whether the IP is inside one of the allowed CIDR ranges.
+// An empty allowlist allows every IP.
+func IsIPAllowed(allowedCIDRs []string, ip string) bool {
+	if len(allowedCIDRs) == 0 {
+		return true
+	}
+
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+
+	for _, entry := range allowedCIDRs {
+		prefix, err := parseCIDR(entry)
+		if err == nil && prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsValidReferrer reports whether the pattern is a host like "example.com", a wildcard host like
+// "*.example.com", optionally with a scheme and port, e.g. "https://*.example.com:8443"
+func IsValidReferrer(pattern string) bool {
+	_, _, ok := parseReferrerPattern(pattern)
+	return ok
+}
+
+// IsReferrerAllowed reports whether the origin, or the referrer if there is no origin, matches one
+// of the allowed patterns. An empty allowlist allows every request, a non-empty one rejects requests
+// without an origin and referrer.
+func IsReferrerAllowed(allowedReferrers []string, origin string, referrer string) bool {
+	if len(allowedReferrers) == 0 {
+		return true
+	}
+
+	source := origin
+	if source == "" || source == "null" {
+		source = referrer
+	}
+
+	u, err := url.Parse(source)
+	if err != nil || u.Host == "" {
+		return false
+	}
+
+	for _, pattern := range allowedReferrers {
+		scheme, host, ok := parseReferrerPattern(pattern)
+		if !ok || (scheme != "" && scheme != u.Scheme) {
+			continue
+		}
+		if matchHost(host, strings.ToLower(u.Hostname()), u.Port()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func parseCIDR(entry string) (netip.Prefix, error) {
+	if !strings.Contains(entry, "/") {
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+
+	prefix, err := netip.ParsePrefix(entry)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+
+	return prefix.Masked(), nil
+}
+
+// parseReferrerPattern splits the pattern into its optional scheme and lower case host with port
+func parseReferrerPattern(pattern string) (string, string, bool) {
+	scheme, host, found := strings.Cut(strings.ToLower(pattern), "://")
+	if !found {
+		scheme, host = "", scheme
+	}
+	host = strings.TrimSuffix(host, "/")
+
+	if host == "" || strings.ContainsAny(host, "/?#@") || strings.Contains(strings.TrimPrefix(host, "*."), "*") {
+		return "", "", false
+	}
+	if _, err := url.Parse("//" + host); err != nil {
+		return "", "", false
+	}
+
+	return scheme, host, true
+}
+
+// matchHost matches the hostname and port against the pattern host, where "*." matches any subdomain
+// but not the domain itself. A pattern without a port matches any port.
+func matchHost(pattern string, hostname string, port string) bool {
+	patternURL, err := url.Parse("//" + pattern)
+	if err != nil {
+		return false
+	}
+
+	if patternURL.Port() != "" && patternURL.Port() != port {
+		return false
+	}
+
+	if domain, found := strings.CutPrefix(patternURL.Hostname(), "*."); found {
+		return strings.HasSuffix(hostname, "."+domain)
+	}
+
+	return hostname == patternURL.Hostname()
+}
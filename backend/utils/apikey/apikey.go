@@ -1,9 +1,19 @@
 package apikey
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
+	"strings"
+)
+
+const (
+	keyPrefix string = "oxf_"
+	// publicPrefixLength is the number of random characters kept in clear text to look keys up by
+	publicPrefixLength int = 8
 )
 
 // GenerateAPIKey creates a new secure random API key
@@ -19,5 +29,34 @@ func GenerateAPIKey() (string, error) {
 	encoded := base64.RawURLEncoding.EncodeToString(randomBytes)
 
 	// Add prefix
-	return fmt.Sprintf("oxf_%s", encoded), nil
+	return fmt.Sprintf("%s%s", keyPrefix, encoded), nil
+}
+
+// Prefix returns the public part of the key, e.g. "oxf_AbCdEfGh", which is stored in clear text
+// to find the key and to let users tell their keys apart
+func Prefix(key string) string {
+	length := len(keyPrefix) + publicPrefixLength
+	if len(key) < length || !strings.HasPrefix(key, keyPrefix) {
+		return key
+	}
+
+	return key[:length]
+}
+
+// Hash returns the hex encoded digest of the key that is stored instead of the key.
+// With a secret it is an HMAC-SHA256, so a database leak alone is not enough to verify guesses.
+func Hash(key string, secret string) string {
+	if secret == "" {
+		sum := sha256.Sum256([]byte(key))
+		return hex.EncodeToString(sum[:])
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(key))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify compares the key against a stored hash in constant time
+func Verify(key string, hash string, secret string) bool {
+	return hmac.Equal([]byte(Hash(key, secret)), []byte(hash))
 }
@@ -0,0 +1,80 @@
+package apikey
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerateAPIKey(t *testing.T) {
+	key, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatalf("GenerateAPIKey: %v", err)
+	}
+
+	// 32 random bytes are 43 characters of unpadded base64
+	if !strings.HasPrefix(key, keyPrefix) || len(key) != len(keyPrefix)+43 {
+		t.Errorf("GenerateAPIKey() = %q, want %s followed by 43 characters", key, keyPrefix)
+	}
+
+	other, _ := GenerateAPIKey()
+	if other == key {
+		t.Error("GenerateAPIKey() returned the same key twice")
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	tests := map[string]string{
+		"oxf_AbCdEfGhIjKlMnOp": "oxf_AbCdEfGh",
+		"oxf_AbCdEfGh":         "oxf_AbCdEfGh",
+		// Too short or foreign keys are returned as they are and match no prefix
+		"oxf_Abc":        "oxf_Abc",
+		"sk_AbCdEfGhIjK": "sk_AbCdEfGhIjK",
+	}
+
+	for key, want := range tests {
+		if got := Prefix(key); got != want {
+			t.Errorf("Prefix(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestHash(t *testing.T) {
+	tests := []struct {
+		name   string
+		key    string
+		secret string
+		want   string
+	}{
+		// FIPS 180-2 example
+		{"sha256 without secret", "abc", "", "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		// RFC 4231 test case 2
+		{"hmac-sha256 with secret", "what do ya want for nothing?", "Jefe", "5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Hash(test.key, test.secret); got != test.want {
+				t.Errorf("Hash() = %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	key, _ := GenerateAPIKey()
+	hash := Hash(key, "secret")
+
+	if !Verify(key, hash, "secret") {
+		t.Error("Verify() = false for the key of the hash")
+	}
+	if Verify(key+"x", hash, "secret") {
+		t.Error("Verify() = true for another key")
+	}
+	// A leaked hash cannot be checked against guesses without the secret
+	if Verify(key, hash, "") || Verify(key, hash, "another-secret") {
+		t.Error("Verify() = true with another secret")
+	}
+	if Verify(key, "", "secret") {
+		t.Error("Verify() = true for an empty hash")
+	}
+}
@@ -0,0 +1,30 @@
+package apikey
+
+import "slices"
+
+type Scope string
+
+const (
+	WordsReadScope Scope = "words:read"
+	ExportScope    Scope = "export"
+)
+
+var Scopes = []Scope{
+	WordsReadScope,
+	ExportScope,
+}
+
+// DefaultScopes are granted to keys created without explicit scopes
+var DefaultScopes = []Scope{
+	WordsReadScope,
+}
+
+// IsValidScope reports whether the scope is one of the known scopes
+func IsValidScope(scope string) bool {
+	return slices.Contains(Scopes, Scope(scope))
+}
+
+// HasScope reports whether the granted scopes include the required scope
+func HasScope(granted []string, required Scope) bool {
+	return slices.Contains(granted, string(required))
+}
@@ -0,0 +1,28 @@
+package apikey
+
+import "testing"
+
+func TestIsValidScope(t *testing.T) {
+	tests := map[string]bool{
+		"words:read":   true,
+		"export":       true,
+		"text:profile": false,
+		"":             false,
+	}
+
+	for scope, want := range tests {
+		if got := IsValidScope(scope); got != want {
+			t.Errorf("IsValidScope(%q) = %v, want %v", scope, got, want)
+		}
+	}
+}
+
+func TestHasScope(t *testing.T) {
+	// Lookup keys do not grant exports
+	if HasScope([]string{string(WordsReadScope)}, ExportScope) {
+		t.Error("the default scopes grant export")
+	}
+	if !HasScope([]string{"words:read", "export"}, ExportScope) {
+		t.Error("a key with the export scope does not grant export")
+	}
+}
@@ -5,8 +5,24 @@ const (
 )
 
 const (
-	UsersCollection      string = "users"
-	WordsCollection      string = "words"
-	ApiKeysCollection    string = "api_keys"
-	DailyUsageCollection string = "daily_usage"
+	UsersCollection             string = "users"
+	WordsCollection             string = "words"
+	ApiKeysCollection           string = "api_keys"
+	DailyUsageCollection        string = "daily_usage"
+	HourlyUsageCollection       string = "hourly_usage"
+	IdentitiesCollection        string = "identities"
+	SigningKeysCollection       string = "signing_keys"
+	ApiKeyEventsCollection      string = "api_key_events"
+	SubscriptionsCollection     string = "subscriptions"
+	BillingEventsCollection     string = "billing_events"
+	InvoicesCollection          string = "invoices"
+	InvoiceItemsCollection      string = "invoice_items"
+	CountersCollection          string = "counters"
+	OverageLedgerCollection     string = "overage_ledger"
+	WebhookEndpointsCollection  string = "webhook_endpoints"
+	WebhookDeliveriesCollection string = "webhook_deliveries"
+	OrganizationsCollection     string = "organizations"
+	OrgMembersCollection        string = "org_members"
+	OrgInvitationsCollection    string = "org_invitations"
+	AuditLogCollection          string = "audit_log"
 )
@@ -0,0 +1,93 @@
+package keyring
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// encryptionKeySize is the size of the AES-256 keys private keys are encrypted with
+const encryptionKeySize int = 32
+
+var ErrDecryptPrivateKey = errors.New("decrypt private key: wrong encryption key or tampered ciphertext")
+
+// ParseEncryptionKey decodes a base64 encoded 32 byte key-encryption key as stored in the config
+func ParseEncryptionKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("decode encryption key: %w", err)
+	}
+	if len(key) != encryptionKeySize {
+		return nil, fmt.Errorf("encryption key has %d bytes, want %d", len(key), encryptionKeySize)
+	}
+
+	return key, nil
+}
+
+// EncryptPrivateKey encrypts the PEM of a private key with AES-256-GCM under the key-encryption key and returns
+// the nonce and ciphertext as base64. The key ID is authenticated with it, so a ciphertext only decrypts for its key.
+func EncryptPrivateKey(key Key, encryptionKey []byte) (string, error) {
+	aead, err := newAEAD(encryptionKey)
+	if err != nil {
+		return "", fmt.Errorf("encrypt private key: %w", err)
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("encrypt private key: %w", err)
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(EncodePrivateKey(key.PrivateKey)), []byte(key.Id))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptPrivateKey decrypts a private key of EncryptPrivateKey with the ID of its key
+func DecryptPrivateKey(encrypted string, id string, encryptionKey []byte) (Key, error) {
+	aead, err := newAEAD(encryptionKey)
+	if err != nil {
+		return Key{}, fmt.Errorf("decrypt private key: %w", err)
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return Key{}, fmt.Errorf("decrypt private key: %w", err)
+	}
+	if len(sealed) < aead.NonceSize() {
+		return Key{}, ErrDecryptPrivateKey
+	}
+
+	privateKeyPEM, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(id))
+	if err != nil {
+		return Key{}, ErrDecryptPrivateKey
+	}
+
+	privateKey, err := DecodePrivateKey(string(privateKeyPEM))
+	if err != nil {
+		return Key{}, fmt.Errorf("decrypt private key: %w", err)
+	}
+	if Thumbprint(&privateKey.PublicKey) != id {
+		return Key{}, ErrDecryptPrivateKey
+	}
+
+	return Key{
+		Id:         id,
+		PrivateKey: privateKey,
+		PublicKey:  &privateKey.PublicKey,
+	}, nil
+}
+
+func newAEAD(encryptionKey []byte) (cipher.AEAD, error) {
+	if len(encryptionKey) != encryptionKeySize {
+		return nil, fmt.Errorf("encryption key has %d bytes, want %d", len(encryptionKey), encryptionKeySize)
+	}
+
+	block, err := aes.NewCipher(encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,107 @@
+package keyring
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func newEncryptionKey(t *testing.T) []byte {
+	t.Helper()
+
+	key := make([]byte, encryptionKeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatalf("generate encryption key: %v", err)
+	}
+
+	return key
+}
+
+func TestParseEncryptionKey(t *testing.T) {
+	key := newEncryptionKey(t)
+
+	parsed, err := ParseEncryptionKey(base64.StdEncoding.EncodeToString(key))
+	if err != nil {
+		t.Fatalf("ParseEncryptionKey: %v", err)
+	}
+	if !bytes.Equal(parsed, key) {
+		t.Error("parsed key differs from the encoded key")
+	}
+
+	for _, encoded := range []string{"", "not base64!", base64.StdEncoding.EncodeToString(key[:16])} {
+		if _, err := ParseEncryptionKey(encoded); err == nil {
+			t.Errorf("ParseEncryptionKey(%q) succeeded, want an error", encoded)
+		}
+	}
+}
+
+func TestEncryptPrivateKey(t *testing.T) {
+	key := generateKey(t)
+	encryptionKey := newEncryptionKey(t)
+
+	encrypted, err := EncryptPrivateKey(key, encryptionKey)
+	if err != nil {
+		t.Fatalf("EncryptPrivateKey: %v", err)
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		t.Fatalf("decode encrypted key: %v", err)
+	}
+	if bytes.Contains(decoded, []byte("PRIVATE KEY")) || strings.Contains(encrypted, "PRIVATE KEY") {
+		t.Fatal("encrypted key contains the PEM in plain text")
+	}
+
+	decrypted, err := DecryptPrivateKey(encrypted, key.Id, encryptionKey)
+	if err != nil {
+		t.Fatalf("DecryptPrivateKey: %v", err)
+	}
+	if decrypted.Id != key.Id || !decrypted.PrivateKey.Equal(key.PrivateKey) || !decrypted.PublicKey.Equal(key.PublicKey) {
+		t.Error("decrypted key differs from the encrypted key")
+	}
+
+	// The same key encrypts differently every time
+	again, err := EncryptPrivateKey(key, encryptionKey)
+	if err != nil {
+		t.Fatalf("EncryptPrivateKey: %v", err)
+	}
+	if again == encrypted {
+		t.Error("encrypting twice gave the same ciphertext, want a random nonce")
+	}
+}
+
+func TestDecryptPrivateKeyRejects(t *testing.T) {
+	key := generateKey(t)
+	encryptionKey := newEncryptionKey(t)
+
+	encrypted, err := EncryptPrivateKey(key, encryptionKey)
+	if err != nil {
+		t.Fatalf("EncryptPrivateKey: %v", err)
+	}
+
+	if _, err := DecryptPrivateKey(encrypted, key.Id, newEncryptionKey(t)); !errors.Is(err, ErrDecryptPrivateKey) {
+		t.Errorf("wrong encryption key: err = %v, want %v", err, ErrDecryptPrivateKey)
+	}
+
+	// A ciphertext copied to the document of another key does not decrypt
+	if _, err := DecryptPrivateKey(encrypted, generateKey(t).Id, encryptionKey); !errors.Is(err, ErrDecryptPrivateKey) {
+		t.Errorf("wrong key ID: err = %v, want %v", err, ErrDecryptPrivateKey)
+	}
+
+	sealed, _ := base64.StdEncoding.DecodeString(encrypted)
+	sealed[len(sealed)-1] ^= 1
+	if _, err := DecryptPrivateKey(base64.StdEncoding.EncodeToString(sealed), key.Id, encryptionKey); !errors.Is(err, ErrDecryptPrivateKey) {
+		t.Errorf("tampered ciphertext: err = %v, want %v", err, ErrDecryptPrivateKey)
+	}
+
+	if _, err := DecryptPrivateKey(base64.StdEncoding.EncodeToString([]byte("short")), key.Id, encryptionKey); !errors.Is(err, ErrDecryptPrivateKey) {
+		t.Errorf("short ciphertext: err = %v, want %v", err, ErrDecryptPrivateKey)
+	}
+
+	if _, err := EncryptPrivateKey(key, encryptionKey[:16]); err == nil {
+		t.Error("EncryptPrivateKey with a 16 byte key succeeded, want an error")
+	}
+}
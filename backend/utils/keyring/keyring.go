@@ -0,0 +1,224 @@
+package keyring
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const keySize int = 2048
+
+// Key is an RSA signing key identified by its kid.
+// A key signs tokens from ActivatesAt on and is no longer accepted after RetiresAt, if set.
+type Key struct {
+	Id          string
+	PrivateKey  *rsa.PrivateKey
+	PublicKey   *rsa.PublicKey
+	ActivatesAt time.Time
+	RetiresAt   time.Time
+}
+
+// JSONWebKey is the public part of a Key in RFC 7517 format
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// Keyring holds the keys used to sign and verify tokens.
+// It is safe for concurrent use and its keys can be replaced while the server is running.
+type Keyring struct {
+	mu   sync.RWMutex
+	keys []Key
+}
+
+// New creates a keyring with the given keys
+func New(keys ...Key) *Keyring {
+	keyring := &Keyring{}
+	keyring.Replace(keys)
+	return keyring
+}
+
+// Replace swaps all keys of the keyring, e.g. after reloading them from storage
+func (keyring *Keyring) Replace(keys []Key) {
+	sorted := append([]Key(nil), keys...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].ActivatesAt.Before(sorted[j].ActivatesAt)
+	})
+
+	keyring.mu.Lock()
+	defer keyring.mu.Unlock()
+	keyring.keys = sorted
+}
+
+// SigningKey returns the most recently activated key that has a private key and is not retired
+func (keyring *Keyring) SigningKey(now time.Time) (Key, error) {
+	keyring.mu.RLock()
+	defer keyring.mu.RUnlock()
+
+	for i := len(keyring.keys) - 1; i >= 0; i-- {
+		key := keyring.keys[i]
+		if key.PrivateKey != nil && !key.ActivatesAt.After(now) && !key.isRetired(now) {
+			return key, nil
+		}
+	}
+
+	return Key{}, errors.New("no active signing key")
+}
+
+// VerificationKey returns the public key for the kid of a token.
+// Tokens without a kid were issued before key rotation and are checked against the oldest key.
+func (keyring *Keyring) VerificationKey(kid string, now time.Time) (*rsa.PublicKey, error) {
+	keyring.mu.RLock()
+	defer keyring.mu.RUnlock()
+
+	for _, key := range keyring.keys {
+		if (kid == "" || key.Id == kid) && !key.isRetired(now) {
+			return key.PublicKey, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown key id: %s", kid)
+}
+
+// Keyfunc returns a jwt.Keyfunc that resolves the verification key from the kid header
+func (keyring *Keyring) Keyfunc() jwt.Keyfunc {
+	return func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected method: %s", t.Header["alg"])
+		}
+
+		kid, _ := t.Header["kid"].(string)
+		return keyring.VerificationKey(kid, time.Now())
+	}
+}
+
+// JWKS returns every key that is not retired, including keys that are published but not active yet,
+// so that verifiers can cache them before the first token is signed with them.
+func (keyring *Keyring) JWKS(now time.Time) JSONWebKeySet {
+	keyring.mu.RLock()
+	defer keyring.mu.RUnlock()
+
+	keySet := JSONWebKeySet{Keys: []JSONWebKey{}}
+	for _, key := range keyring.keys {
+		if key.isRetired(now) {
+			continue
+		}
+
+		keySet.Keys = append(keySet.Keys, JSONWebKey{
+			Kty: "RSA",
+			Use: "sig",
+			Alg: jwt.SigningMethodRS256.Alg(),
+			Kid: key.Id,
+			N:   base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+		})
+	}
+
+	return keySet
+}
+
+func (key Key) isRetired(now time.Time) bool {
+	return !key.RetiresAt.IsZero() && now.After(key.RetiresAt)
+}
+
+// ParseKey builds a key from base64 encoded PEM keys as stored in the config.
+// The private key may be empty for verify-only keys, the public key is derived from the private key if empty.
+func ParseKey(privateKey string, publicKey string) (Key, error) {
+	var key Key
+
+	if privateKey != "" {
+		decodedPrivateKey, err := base64.StdEncoding.DecodeString(privateKey)
+		if err != nil {
+			return Key{}, fmt.Errorf("decode private key: %w", err)
+		}
+
+		key.PrivateKey, err = jwt.ParseRSAPrivateKeyFromPEM(decodedPrivateKey)
+		if err != nil {
+			return Key{}, fmt.Errorf("parse private key: %w", err)
+		}
+		key.PublicKey = &key.PrivateKey.PublicKey
+	}
+
+	if publicKey != "" {
+		decodedPublicKey, err := base64.StdEncoding.DecodeString(publicKey)
+		if err != nil {
+			return Key{}, fmt.Errorf("decode public key: %w", err)
+		}
+
+		key.PublicKey, err = jwt.ParseRSAPublicKeyFromPEM(decodedPublicKey)
+		if err != nil {
+			return Key{}, fmt.Errorf("parse public key: %w", err)
+		}
+	}
+
+	if key.PublicKey == nil {
+		return Key{}, errors.New("parse key: no key configured")
+	}
+
+	key.Id = Thumbprint(key.PublicKey)
+	return key, nil
+}
+
+// Generate creates a new RSA key
+func Generate() (Key, error) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, keySize)
+	if err != nil {
+		return Key{}, fmt.Errorf("generate key: %w", err)
+	}
+
+	return Key{
+		Id:         Thumbprint(&privateKey.PublicKey),
+		PrivateKey: privateKey,
+		PublicKey:  &privateKey.PublicKey,
+	}, nil
+}
+
+// EncodePrivateKey returns the private key as PKCS#1 PEM
+func EncodePrivateKey(privateKey *rsa.PrivateKey) string {
+	return string(pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
+	}))
+}
+
+// DecodePrivateKey parses a PKCS#1 or PKCS#8 PEM private key
+func DecodePrivateKey(privateKeyPEM string) (*rsa.PrivateKey, error) {
+	return jwt.ParseRSAPrivateKeyFromPEM([]byte(privateKeyPEM))
+}
+
+// Thumbprint returns the RFC 7638 JWK thumbprint of the public key, used as kid
+func Thumbprint(publicKey *rsa.PublicKey) string {
+	// Members in lexicographic order as required by RFC 7638
+	canonical, _ := json.Marshal(struct {
+		E   string `json:"e"`
+		Kty string `json:"kty"`
+		N   string `json:"n"`
+	}{
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+		Kty: "RSA",
+		N:   base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+	})
+
+	sum := sha256.Sum256(canonical)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
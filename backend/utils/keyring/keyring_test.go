@@ -0,0 +1,125 @@
+package keyring
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func generateKey(t *testing.T) Key {
+	t.Helper()
+
+	key, err := Generate()
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	return key
+}
+
+func TestParseKey(t *testing.T) {
+	key := generateKey(t)
+	encoded := base64.StdEncoding.EncodeToString([]byte(EncodePrivateKey(key.PrivateKey)))
+
+	parsed, err := ParseKey(encoded, "")
+	if err != nil {
+		t.Fatalf("ParseKey: %v", err)
+	}
+	if parsed.Id != key.Id || !parsed.PublicKey.Equal(key.PublicKey) {
+		t.Errorf("parsed key %s, want %s", parsed.Id, key.Id)
+	}
+
+	if _, err := ParseKey("", ""); err == nil {
+		t.Error("ParseKey without keys succeeded, want an error")
+	}
+	if _, err := ParseKey("not base64!", ""); err == nil {
+		t.Error("ParseKey of invalid base64 succeeded, want an error")
+	}
+}
+
+func TestThumbprintRFC7638(t *testing.T) {
+	// The example key of RFC 7638 section 3.1
+	n, _ := base64.RawURLEncoding.DecodeString("0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw")
+	publicKey := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: 65537}
+
+	if got, want := Thumbprint(publicKey), "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"; got != want {
+		t.Errorf("Thumbprint() = %s, want %s", got, want)
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	old := generateKey(t)
+	old.RetiresAt = now.Add(time.Hour)
+	current := generateKey(t)
+	current.ActivatesAt = now.Add(-time.Minute)
+	next := generateKey(t)
+	next.ActivatesAt = now.Add(time.Hour)
+
+	keyring := New(next, old, current)
+
+	signingKey, err := keyring.SigningKey(now)
+	if err != nil {
+		t.Fatalf("SigningKey: %v", err)
+	}
+	if signingKey.Id != current.Id {
+		t.Errorf("signing with %s, want the most recently activated key %s", signingKey.Id, current.Id)
+	}
+
+	// Published keys that are not active yet are in the JWKS but do not sign
+	jwks := keyring.JWKS(now)
+	if len(jwks.Keys) != 3 {
+		t.Errorf("JWKS has %d keys, want 3", len(jwks.Keys))
+	}
+
+	later := now.Add(2 * time.Hour)
+	if signingKey, _ := keyring.SigningKey(later); signingKey.Id != next.Id {
+		t.Errorf("signing with %s after activation, want %s", signingKey.Id, next.Id)
+	}
+
+	// Retired keys neither verify nor are published
+	if _, err := keyring.VerificationKey(old.Id, later); err == nil {
+		t.Error("retired key verifies tokens, want an error")
+	}
+	for _, jwk := range keyring.JWKS(later).Keys {
+		if jwk.Kid == old.Id {
+			t.Error("retired key is in the JWKS")
+		}
+	}
+
+	if _, err := keyring.VerificationKey("unknown", now); err == nil {
+		t.Error("unknown kid verifies tokens, want an error")
+	}
+}
+
+func TestKeyfunc(t *testing.T) {
+	key := generateKey(t)
+	keyring := New(key)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.RegisteredClaims{Subject: "user-1"})
+	token.Header["kid"] = key.Id
+	signed, err := token.SignedString(key.PrivateKey)
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+
+	if _, err := jwt.Parse(signed, keyring.Keyfunc()); err != nil {
+		t.Errorf("parse token signed with the keyring: %v", err)
+	}
+
+	// Tokens signed with HMAC, using the public key as secret, are rejected
+	hmacToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{Subject: "user-1"})
+	hmacToken.Header["kid"] = key.Id
+	signed, err = hmacToken.SignedString([]byte(EncodePrivateKey(key.PrivateKey)))
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	if _, err := jwt.Parse(signed, keyring.Keyfunc()); err == nil {
+		t.Error("parsed an HS256 token, want an error")
+	}
+}
@@ -0,0 +1,78 @@
+package mail
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends emails
+type Mailer interface {
+	Send(message Message) error
+}
+
+// LogMailer is the default Mailer which writes emails to the standard logger instead of sending them
+type LogMailer struct{}
+
+func (LogMailer) Send(message Message) error {
+	log.Printf("mail to %s: %s\n%s", message.To, message.Subject, message.Body)
+	return nil
+}
+
+// SMTPMailer sends emails through an SMTP server, with STARTTLS if the server supports it
+type SMTPMailer struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+// NewSMTPMailer creates a mailer for the SMTP server at host:port.
+// It authenticates with PLAIN auth if a username is given.
+func NewSMTPMailer(host string, port string, username string, password string, from string) SMTPMailer {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return SMTPMailer{
+		addr: net.JoinHostPort(host, port),
+		auth: auth,
+		from: from,
+	}
+}
+
+func (mailer SMTPMailer) Send(message Message) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", mailer.from)
+	fmt.Fprintf(&b, "To: %s\r\n", message.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", message.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(message.Body, "\n", "\r\n"))
+
+	if err := smtp.SendMail(mailer.addr, mailer.auth, mailer.from, []string{message.To}, []byte(b.String())); err != nil {
+		return fmt.Errorf("send mail: %w", err)
+	}
+
+	return nil
+}
+
+// New returns the SMTP mailer of the server at host:port, or the LogMailer if host is empty
+func New(host string, port string, username string, password string, from string) Mailer {
+	if host == "" {
+		return LogMailer{}
+	}
+
+	return NewSMTPMailer(host, port, username, password, from)
+}
@@ -0,0 +1,19 @@
+package message
+
+const (
+	UsersRetrieved         string = "Users retrieved successfully!"
+	UserRetrieved          string = "User retrieved successfully!"
+	EmailQueryRequired     string = "An email to search for is required!"
+	UserPlanUpdated        string = "Plan updated successfully!"
+	UserHasSubscription    string = "The plan of this user follows their subscription, change the subscription instead!"
+	UserSuspended          string = "User suspended successfully!"
+	UserUnsuspended        string = "User unsuspended successfully!"
+	CannotSuspendSelf      string = "You cannot suspend your own account!"
+	ImpersonationStarted   string = "Impersonation token created successfully!"
+	CannotImpersonateStaff string = "Support and admin accounts cannot be impersonated!"
+	ImpersonationReadOnly  string = "Impersonation sessions are read-only!"
+	AuditLogRetrieved      string = "Audit log retrieved successfully!"
+	InvalidAuditQuery      string = "Invalid audit log query: from and to must be dates or RFC 3339 times and before an event ID!"
+	AuditChainVerified     string = "Audit log hash chain verified!"
+	AuditError             string = "Error processing audit log!"
+)
@@ -1,16 +1,61 @@
 package message
 
 const (
-	MissingField  string = "missing field"
-	WrongPassword string = "wrong password"
+	MissingField       string = "missing field"
+	InvalidCredentials string = "invalid email or password"
+	TooManyAttempts    string = "too many failed login attempts, try again later"
+	LoginError         string = "error processing login"
 
 	// API Key related messages
-	ApiKeyRetrieved string = "api key retrieved"
-	ApiKeyCreated   string = "api key created"
-	ApiKeyDeleted   string = "api key deleted"
-	ApiKeyError     string = "error processing api key"
-	ApiKeyRequired  string = "api key is required"
+	ApiKeyRetrieved      string = "api key retrieved"
+	ApiKeyCreated        string = "api key created"
+	ApiKeyDeleted        string = "api key deleted"
+	ApiKeyError          string = "error processing api key"
+	ApiKeyRequired       string = "api key is required"
+	ApiKeyUpdated        string = "api key updated"
+	ApiKeyNotFound       string = "api key not found"
+	ApiKeyExpired        string = "api key expired"
+	ApiKeyRotated        string = "api key rotated"
+	InvalidScope         string = "invalid api key scope"
+	InvalidExpiry        string = "api key expiry must be in the future"
+	MissingScope         string = "api key does not have the required scope"
+	InvalidCIDR          string = "allowed cidrs must be ip addresses or cidr ranges"
+	InvalidReferrer      string = "allowed referrers must be hosts like example.com or *.example.com"
+	IPNotAllowed         string = "api key is not allowed from this ip address"
+	OriginNotAllowed     string = "api key is not allowed from this origin"
+	SecurityLogRetrieved string = "api key security log retrieved"
+	// ApiKeyQueryDeprecated is sent as a Warning header, so it follows the RFC 7234 warning format
+	ApiKeyQueryDeprecated string = `299 - "the apikey query parameter is deprecated, use the X-API-Key header"`
 
 	InvalidApiKey string = "invalid api key"
 	InvalidToken  string = "invalid or expired token"
+	// AccountSuspended is returned for logins and API key requests of suspended users
+	AccountSuspended string = "account is suspended, contact support"
+
+	// MFA related messages
+	MFARequired            string = "mfa code required"
+	MFAEnrollmentStarted   string = "mfa enrollment started"
+	MFAEnabled             string = "mfa enabled"
+	MFADisabled            string = "mfa disabled"
+	MFAAlreadyEnabled      string = "mfa is already enabled"
+	MFANotEnabled          string = "mfa is not enabled"
+	MFANoPendingEnrollment string = "no pending mfa enrollment"
+	MFAError               string = "error processing mfa"
+	InvalidMFACode         string = "invalid mfa code"
+	InvalidMFAToken        string = "invalid or expired mfa token"
+	RecoveryCodesCreated   string = "recovery codes created"
+
+	// OAuth related messages
+	AuthorizationStarted  string = "authorization started"
+	IdentityLinked        string = "identity linked"
+	IdentityUnlinked      string = "identity unlinked"
+	IdentitiesRetrieved   string = "identities retrieved"
+	UnknownProvider       string = "unknown identity provider"
+	InvalidOAuthState     string = "invalid or expired oauth state"
+	IdentityAlreadyLinked string = "identity is already linked to an account"
+	EmailNotVerified      string = "identity provider did not return a verified email, log in and link the identity instead"
+	LinkRequired          string = "an account with this email already exists, log in with its password and link the identity instead"
+	IdentityNotFound      string = "identity not found"
+	LastLoginMethod       string = "cannot unlink the only login method, set a password first"
+	OAuthError            string = "error processing oauth login"
 )
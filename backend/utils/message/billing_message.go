@@ -0,0 +1,28 @@
+package message
+
+const (
+	PlansRetrieved         string = "Plans retrieved successfully!"
+	CheckoutCreated        string = "Checkout session created successfully!"
+	SubscriptionRetrieved  string = "Subscription retrieved successfully!"
+	SubscriptionUpdated    string = "Subscription updated successfully!"
+	SubscriptionCanceled   string = "Subscription will be canceled at the end of the current period!"
+	WebhookProcessed       string = "Webhook processed successfully!"
+	UnknownPlan            string = "Unknown plan!"
+	InvalidPlanChange      string = "Plan change is not possible, subscribe to upgrade from the free plan and cancel to return to it!"
+	SubscriptionExists     string = "You already have a subscription, change its plan instead!"
+	SubscriptionNotFound   string = "No active subscription found!"
+	InvalidWebhook         string = "Invalid webhook signature or payload!"
+	FakeCheckoutNotFound   string = "Checkout session not found!"
+	BillingError           string = "Error processing billing!"
+	InvoicesRetrieved      string = "Invoices retrieved successfully!"
+	InvoiceRetrieved       string = "Invoice retrieved successfully!"
+	InvoiceNotFound        string = "Invoice not found!"
+	OverageRetrieved       string = "Overage retrieved successfully!"
+	OveragePolicyUpdated   string = "Overage policy updated successfully!"
+	InvalidOverageMode     string = "Overage mode must be block, bill or soft_cap!"
+	InvalidSoftCap         string = "Soft cap must be between 1 and 100 percent over the quota!"
+	OverageNotAvailable    string = "Your plan does not allow requests over the quota!"
+	QuotaAlertsRetrieved   string = "Quota alerts retrieved successfully!"
+	QuotaAlertsUpdated     string = "Quota alerts updated successfully!"
+	InvalidAlertThresholds string = "Alert thresholds must be at most 10 percentages between 1 and 1000!"
+)
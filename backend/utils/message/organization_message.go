@@ -0,0 +1,27 @@
+package message
+
+const (
+	OrganizationCreated     string = "Organization created successfully!"
+	OrganizationRetrieved   string = "Organization retrieved successfully!"
+	OrganizationsRetrieved  string = "Organizations retrieved successfully!"
+	OrganizationUpdated     string = "Organization updated successfully!"
+	OrganizationDeleted     string = "Organization deleted successfully!"
+	OrgMembersRetrieved     string = "Organization members retrieved successfully!"
+	OrgMemberUpdated        string = "Organization member updated successfully!"
+	OrgMemberRemoved        string = "Organization member removed successfully!"
+	OrgInvitationSent       string = "Invitation sent successfully!"
+	OrgInvitationsRetrieved string = "Invitations retrieved successfully!"
+	OrgInvitationRevoked    string = "Invitation revoked successfully!"
+	OrgInvitationAccepted   string = "Invitation accepted successfully!"
+	OrgPlanUpdated          string = "Organization plan updated successfully!"
+	OrganizationNotFound    string = "Organization not found!"
+	OrgMemberNotFound       string = "Organization member not found!"
+	OrgInvitationNotFound   string = "Invitation not found or expired!"
+	OrgInvitationWrongEmail string = "The invitation was sent to another email address!"
+	AlreadyOrgMember        string = "The user is already a member of the organization!"
+	InvalidOrgRole          string = "Role must be owner, admin or member!"
+	OrgPermissionDenied     string = "Your role in the organization does not allow this!"
+	LastOrgOwner            string = "The organization must keep at least one owner!"
+	TooManyOrgMembers       string = "An organization can have at most 100 members and pending invitations!"
+	OrganizationError       string = "Error processing organization!"
+)
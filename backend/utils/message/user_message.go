@@ -1,9 +1,20 @@
 package message
 
 const (
-	UserNotFound      string = "User not found!"
-	UnableParseUser   string = "Unable to parse user!"
-	UsageLimitReached string = "Usage limit reached!"
-	UsageRetrieved    string = "Usage retrieved successfully!"
-	
+	UserNotFound        string = "User not found!"
+	UnableParseUser     string = "Unable to parse user!"
+	UsageLimitReached   string = "Usage limit reached!"
+	RateLimitExceeded   string = "Too many requests, slow down!"
+	UsageRetrieved      string = "Usage retrieved successfully!"
+	Forbidden           string = "You do not have permission to perform this action!"
+	InvalidRole         string = "Invalid role!"
+	CannotChangeOwnRole string = "You cannot change your own role!"
+	RoleUpdated         string = "Role updated successfully!"
+	UserError           string = "Error processing user!"
+	InvalidGranularity  string = "Granularity must be day or hour!"
+	InvalidUsageRange   string = "Invalid usage range: from must be before to, at most 366 days apart for daily and 31 days for hourly usage!"
+	InvalidGroupBy      string = "groupBy must be a comma separated list of key, endpoint and status!"
+	InvalidUsageFormat  string = "Format must be json or csv!"
+	InvalidTimezone     string = "Timezone must be an IANA time zone like Europe/Istanbul!"
+	TimezoneUpdated     string = "Billing timezone updated successfully!"
 )
@@ -0,0 +1,20 @@
+package message
+
+const (
+	WebhookEventTypesRetrieved string = "Webhook event types retrieved successfully!"
+	WebhookEndpointsRetrieved  string = "Webhook endpoints retrieved successfully!"
+	WebhookEndpointRetrieved   string = "Webhook endpoint retrieved successfully!"
+	WebhookEndpointCreated     string = "Webhook endpoint created successfully!"
+	WebhookEndpointUpdated     string = "Webhook endpoint updated successfully!"
+	WebhookEndpointDeleted     string = "Webhook endpoint deleted successfully!"
+	WebhookDeliveriesRetrieved string = "Webhook deliveries retrieved successfully!"
+	WebhookDeliveryRetrieved   string = "Webhook delivery retrieved successfully!"
+	WebhookRedelivered         string = "Webhook queued for redelivery!"
+	WebhookEndpointNotFound    string = "Webhook endpoint not found!"
+	WebhookDeliveryNotFound    string = "Webhook delivery not found!"
+	InvalidWebhookURL          string = "Webhook URL must be an absolute http or https URL!"
+	InvalidEventType           string = "Unknown webhook event type!"
+	TooManyWebhookEndpoints    string = "You can register at most 10 webhook endpoints!"
+	WebhookError               string = "Error processing webhook endpoint!"
+	DatasetUpdatePublished     string = "Dataset update published successfully!"
+)
@@ -1,7 +1,22 @@
 package message
 
 const (
-	WordFound string = "Word found!"
+	WordFound      string = "Word found!"
+	WordsFound     string = "Words found!"
+	WordNotFound   string = "Word not found!"
+	WordError      string = "Could not get words!"
+	WordsExported  string = "Words exported!"
+	InvalidFormat  string = "Format must be json or csv!"
+	InvalidCEFR    string = "Unsupported CEFR level!"
+	TooManyWords   string = "Too many words, at most %d can be looked up at once!"
+	NoWordsToFetch string = "At least one word is required!"
+)
+
+const (
+	// FeatureRequiresPlan is formatted with the feature and the name of the lowest plan entitled to it
+	FeatureRequiresPlan string = "The %s feature requires the %s plan or higher!"
+	// FeatureUnavailable is formatted with the feature no plan is entitled to
+	FeatureUnavailable string = "The %s feature is not available on any plan!"
 )
 
 const (
@@ -0,0 +1,67 @@
+package metering
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultChargedStatuses charges every successful response
+const DefaultChargedStatuses string = "200-299"
+
+// StatusSet is a set of HTTP status codes that are charged against the quota
+type StatusSet struct {
+	ranges [][2]int
+}
+
+// ParseStatusSet parses a comma separated list of status codes and inclusive ranges, e.g. "200-299,304".
+// An empty spec is DefaultChargedStatuses.
+func ParseStatusSet(spec string) (StatusSet, error) {
+	if strings.TrimSpace(spec) == "" {
+		spec = DefaultChargedStatuses
+	}
+
+	var set StatusSet
+	for _, entry := range strings.Split(spec, ",") {
+		from, to, isRange := strings.Cut(strings.TrimSpace(entry), "-")
+		if !isRange {
+			to = from
+		}
+
+		low, err := parseStatus(from)
+		if err != nil {
+			return StatusSet{}, fmt.Errorf("parse status set %q: %w", spec, err)
+		}
+		high, err := parseStatus(to)
+		if err != nil {
+			return StatusSet{}, fmt.Errorf("parse status set %q: %w", spec, err)
+		}
+		if low > high {
+			return StatusSet{}, fmt.Errorf("parse status set %q: empty range %s", spec, entry)
+		}
+
+		set.ranges = append(set.ranges, [2]int{low, high})
+	}
+
+	return set, nil
+}
+
+// Contains reports whether the status code is in the set
+func (set StatusSet) Contains(status int) bool {
+	for _, r := range set.ranges {
+		if status >= r[0] && status <= r[1] {
+			return true
+		}
+	}
+
+	return false
+}
+
+func parseStatus(value string) (int, error) {
+	status, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || status < 100 || status > 599 {
+		return 0, fmt.Errorf("invalid status code %q", value)
+	}
+
+	return status, nil
+}
@@ -0,0 +1,317 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// Provider is an OAuth2 / OpenID Connect identity provider used with the authorization code flow and PKCE
+type Provider struct {
+	Name         string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+
+	Issuer      string
+	AuthURL     string
+	TokenURL    string
+	UserInfoURL string
+	JWKSURL     string
+}
+
+// Identity is the external account returned by a provider after a successful login
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	IDToken     string `json:"id_token"`
+	TokenType   string `json:"token_type"`
+	Error       string `json:"error"`
+}
+
+type discoveryDocument struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserInfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type idTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce         string `json:"nonce"`
+	Email         string `json:"email"`
+	EmailVerified any    `json:"email_verified"`
+}
+
+type jsonWebKeySet struct {
+	Keys []struct {
+		Kid string `json:"kid"`
+		Kty string `json:"kty"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+	} `json:"keys"`
+}
+
+// Discover fills the provider endpoints from the issuer's /.well-known/openid-configuration document
+func (provider *Provider) Discover(ctx context.Context) error {
+	discoveryURL := strings.TrimSuffix(provider.Issuer, "/") + "/.well-known/openid-configuration"
+
+	var document discoveryDocument
+	if err := getJSON(ctx, discoveryURL, "", &document); err != nil {
+		return fmt.Errorf("discover %s: %w", provider.Name, err)
+	}
+
+	if document.Issuer != provider.Issuer {
+		return fmt.Errorf("discover %s: issuer mismatch: %s", provider.Name, document.Issuer)
+	}
+
+	provider.AuthURL = document.AuthorizationEndpoint
+	provider.TokenURL = document.TokenEndpoint
+	provider.UserInfoURL = document.UserInfoEndpoint
+	provider.JWKSURL = document.JWKSURI
+
+	return nil
+}
+
+// AuthCodeURL returns the URL the user is redirected to in order to sign in at the provider
+func (provider *Provider) AuthCodeURL(state string, nonce string, codeVerifier string) string {
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", provider.ClientID)
+	query.Set("redirect_uri", provider.RedirectURL)
+	query.Set("scope", strings.Join(provider.Scopes, " "))
+	query.Set("state", state)
+	query.Set("code_challenge", CodeChallenge(codeVerifier))
+	query.Set("code_challenge_method", "S256")
+	if provider.JWKSURL != "" {
+		query.Set("nonce", nonce)
+	}
+
+	separator := "?"
+	if strings.Contains(provider.AuthURL, "?") {
+		separator = "&"
+	}
+
+	return provider.AuthURL + separator + query.Encode()
+}
+
+// Authenticate exchanges the authorization code for tokens and resolves the external identity.
+// If the provider issues an ID token it is verified against the provider's keys and nonce,
+// otherwise the identity is read from the userinfo endpoint.
+func (provider *Provider) Authenticate(ctx context.Context, code string, codeVerifier string, nonce string) (Identity, error) {
+	tokens, err := provider.exchange(ctx, code, codeVerifier)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	if tokens.IDToken != "" && provider.JWKSURL != "" {
+		return provider.verifyIDToken(ctx, tokens.IDToken, nonce)
+	}
+
+	if provider.Name == GitHub {
+		return fetchGitHubIdentity(ctx, provider.UserInfoURL, tokens.AccessToken)
+	}
+
+	return provider.fetchUserInfo(ctx, tokens.AccessToken)
+}
+
+func (provider *Provider) exchange(ctx context.Context, code string, codeVerifier string) (tokenResponse, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", provider.RedirectURL)
+	form.Set("client_id", provider.ClientID)
+	form.Set("client_secret", provider.ClientSecret)
+	form.Set("code_verifier", codeVerifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, provider.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return tokenResponse{}, fmt.Errorf("exchange code: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	var tokens tokenResponse
+	if err := doJSON(req, &tokens); err != nil {
+		return tokenResponse{}, fmt.Errorf("exchange code: %w", err)
+	}
+
+	if tokens.Error != "" {
+		return tokenResponse{}, fmt.Errorf("exchange code: %s", tokens.Error)
+	}
+	if tokens.AccessToken == "" {
+		return tokenResponse{}, errors.New("exchange code: missing access token")
+	}
+
+	return tokens, nil
+}
+
+func (provider *Provider) verifyIDToken(ctx context.Context, rawIDToken string, nonce string) (Identity, error) {
+	var keySet jsonWebKeySet
+	if err := getJSON(ctx, provider.JWKSURL, "", &keySet); err != nil {
+		return Identity{}, fmt.Errorf("fetch jwks: %w", err)
+	}
+
+	var claims idTokenClaims
+	_, err := jwt.ParseWithClaims(rawIDToken, &claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected method: %s", t.Header["alg"])
+		}
+
+		kid, _ := t.Header["kid"].(string)
+		for _, key := range keySet.Keys {
+			if key.Kty == "RSA" && (kid == "" || key.Kid == kid) {
+				return rsaPublicKey(key.N, key.E)
+			}
+		}
+
+		return nil, fmt.Errorf("unknown key id: %s", kid)
+	})
+	if err != nil {
+		return Identity{}, fmt.Errorf("verify id token: %w", err)
+	}
+
+	if !claims.VerifyIssuer(provider.Issuer, true) {
+		return Identity{}, errors.New("verify id token: issuer mismatch")
+	}
+	if !claims.VerifyAudience(provider.ClientID, true) {
+		return Identity{}, errors.New("verify id token: audience mismatch")
+	}
+	if claims.Nonce != nonce {
+		return Identity{}, errors.New("verify id token: nonce mismatch")
+	}
+	if claims.Subject == "" {
+		return Identity{}, errors.New("verify id token: missing subject")
+	}
+	// The expiry is only checked while parsing if it is set, and ID tokens must have one
+	if claims.ExpiresAt == nil {
+		return Identity{}, errors.New("verify id token: missing expiry")
+	}
+
+	return Identity{
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: isTrue(claims.EmailVerified),
+	}, nil
+}
+
+func (provider *Provider) fetchUserInfo(ctx context.Context, accessToken string) (Identity, error) {
+	var userInfo struct {
+		Subject       string `json:"sub"`
+		Email         string `json:"email"`
+		EmailVerified any    `json:"email_verified"`
+	}
+
+	if err := getJSON(ctx, provider.UserInfoURL, accessToken, &userInfo); err != nil {
+		return Identity{}, fmt.Errorf("fetch userinfo: %w", err)
+	}
+
+	if userInfo.Subject == "" {
+		return Identity{}, errors.New("fetch userinfo: missing subject")
+	}
+
+	return Identity{
+		Subject:       userInfo.Subject,
+		Email:         userInfo.Email,
+		EmailVerified: isTrue(userInfo.EmailVerified),
+	}, nil
+}
+
+// GenerateRandomString returns a URL-safe random string used for state, nonce and PKCE verifiers
+func GenerateRandomString() (string, error) {
+	randomBytes := make([]byte, 32)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", fmt.Errorf("generate random bytes: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(randomBytes), nil
+}
+
+// CodeChallenge derives the S256 PKCE code challenge from a code verifier
+func CodeChallenge(codeVerifier string) string {
+	sum := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func rsaPublicKey(n string, e string) (*rsa.PublicKey, error) {
+	modulus, err := base64.RawURLEncoding.DecodeString(n)
+	if err != nil {
+		return nil, fmt.Errorf("decode modulus: %w", err)
+	}
+
+	exponent, err := base64.RawURLEncoding.DecodeString(e)
+	if err != nil {
+		return nil, fmt.Errorf("decode exponent: %w", err)
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(modulus),
+		E: int(new(big.Int).SetBytes(exponent).Int64()),
+	}, nil
+}
+
+func getJSON(ctx context.Context, endpoint string, accessToken string, target any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+
+	return doJSON(req, target)
+}
+
+func doJSON(req *http.Request, target any) error {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("unexpected status %d from %s", resp.StatusCode, req.URL.Host)
+	}
+
+	return json.Unmarshal(body, target)
+}
+
+// isTrue accepts both boolean and string "true" values, since providers differ in how they encode email_verified
+func isTrue(value any) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	default:
+		return false
+	}
+}
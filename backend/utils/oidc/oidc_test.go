@@ -0,0 +1,227 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AkifhanIlgaz/dictionary-api/utils/oidc/oidctest"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const testRedirectURL string = "http://localhost:3000/oauth/callback/oidc"
+
+func newTestProvider(t *testing.T) (*Provider, *oidctest.Issuer) {
+	t.Helper()
+
+	issuer := oidctest.NewIssuer(t)
+	provider, err := NewGenericProvider(context.Background(), Generic, issuer.URL, oidctest.ClientID, oidctest.ClientSecret, testRedirectURL)
+	if err != nil {
+		t.Fatalf("NewGenericProvider: %v", err)
+	}
+
+	return provider, issuer
+}
+
+// login runs the authorization code flow against the mock issuer with a fresh state, nonce and code verifier
+func login(t *testing.T, provider *Provider, issuer *oidctest.Issuer) (Identity, error) {
+	t.Helper()
+
+	nonce, _ := GenerateRandomString()
+	codeVerifier, _ := GenerateRandomString()
+
+	code, _, err := issuer.Authorize(provider.AuthCodeURL("state", nonce, codeVerifier))
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+
+	return provider.Authenticate(context.Background(), code, codeVerifier, nonce)
+}
+
+func TestDiscover(t *testing.T) {
+	provider, issuer := newTestProvider(t)
+
+	if provider.AuthURL != issuer.URL+"/authorize" || provider.TokenURL != issuer.URL+"/token" ||
+		provider.UserInfoURL != issuer.URL+"/userinfo" || provider.JWKSURL != issuer.URL+"/jwks" {
+		t.Errorf("discovered endpoints %+v, want those of %s", provider, issuer.URL)
+	}
+
+	// The issuer of the discovery document must be the configured one
+	if _, err := NewGenericProvider(context.Background(), Generic, issuer.URL+"/", oidctest.ClientID, oidctest.ClientSecret, testRedirectURL); err == nil {
+		t.Error("discovery with a different issuer succeeded, want an error")
+	}
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	provider, _ := newTestProvider(t)
+
+	authURL, err := url.Parse(provider.AuthCodeURL("the-state", "the-nonce", "the-verifier"))
+	if err != nil {
+		t.Fatalf("parse auth URL: %v", err)
+	}
+	query := authURL.Query()
+
+	want := map[string]string{
+		"response_type":         "code",
+		"client_id":             oidctest.ClientID,
+		"redirect_uri":          testRedirectURL,
+		"state":                 "the-state",
+		"nonce":                 "the-nonce",
+		"code_challenge":        CodeChallenge("the-verifier"),
+		"code_challenge_method": "S256",
+	}
+	for name, value := range want {
+		if query.Get(name) != value {
+			t.Errorf("%s = %q, want %q", name, query.Get(name), value)
+		}
+	}
+	if !strings.Contains(query.Get("scope"), "openid") {
+		t.Errorf("scope %q does not request openid", query.Get("scope"))
+	}
+}
+
+func TestCodeChallengeRFC7636(t *testing.T) {
+	// The example of RFC 7636 appendix B
+	if got, want := CodeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"), "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"; got != want {
+		t.Errorf("CodeChallenge() = %s, want %s", got, want)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	provider, issuer := newTestProvider(t)
+
+	identity, err := login(t, provider, issuer)
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+
+	if identity.Subject != issuer.Subject || identity.Email != issuer.Email || !identity.EmailVerified {
+		t.Errorf("identity = %+v, want %s with verified %s", identity, issuer.Subject, issuer.Email)
+	}
+}
+
+func TestAuthenticateUnverifiedEmail(t *testing.T) {
+	provider, issuer := newTestProvider(t)
+	issuer.EmailVerified = false
+
+	identity, err := login(t, provider, issuer)
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if identity.EmailVerified {
+		t.Error("EmailVerified = true for an unverified email")
+	}
+}
+
+func TestAuthenticatePKCE(t *testing.T) {
+	provider, issuer := newTestProvider(t)
+
+	code, _, err := issuer.Authorize(provider.AuthCodeURL("state", "nonce", "the-verifier"))
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+
+	// A stolen code is useless without the code verifier
+	if _, err := provider.Authenticate(context.Background(), code, "another-verifier", "nonce"); err == nil {
+		t.Error("Authenticate with the wrong code verifier succeeded, want an error")
+	}
+
+	// Codes are single use, also after a failed exchange
+	if _, err := provider.Authenticate(context.Background(), code, "the-verifier", "nonce"); err == nil {
+		t.Error("Authenticate with a used code succeeded, want an error")
+	}
+}
+
+func TestAuthenticateNonce(t *testing.T) {
+	provider, issuer := newTestProvider(t)
+
+	code, _, err := issuer.Authorize(provider.AuthCodeURL("state", "the-nonce", "verifier"))
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+
+	// An ID token issued for another login is rejected
+	if _, err := provider.Authenticate(context.Background(), code, "verifier", "another-nonce"); err == nil {
+		t.Error("Authenticate with the wrong nonce succeeded, want an error")
+	}
+}
+
+func TestAuthenticateRejectsIDTokens(t *testing.T) {
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		tamper func(issuer *oidctest.Issuer)
+	}{
+		{"issuer", func(issuer *oidctest.Issuer) {
+			issuer.IDTokenClaims = func(claims jwt.MapClaims) { claims["iss"] = "https://attacker.example.com" }
+		}},
+		{"audience", func(issuer *oidctest.Issuer) {
+			issuer.IDTokenClaims = func(claims jwt.MapClaims) { claims["aud"] = "another-client" }
+		}},
+		{"expired", func(issuer *oidctest.Issuer) {
+			issuer.IDTokenClaims = func(claims jwt.MapClaims) { claims["exp"] = time.Now().Add(-time.Minute).Unix() }
+		}},
+		{"no expiry", func(issuer *oidctest.Issuer) {
+			issuer.IDTokenClaims = func(claims jwt.MapClaims) { delete(claims, "exp") }
+		}},
+		{"no subject", func(issuer *oidctest.Issuer) {
+			issuer.IDTokenClaims = func(claims jwt.MapClaims) { delete(claims, "sub") }
+		}},
+		{"signed with another key", func(issuer *oidctest.Issuer) {
+			issuer.SigningKey = otherKey
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			provider, issuer := newTestProvider(t)
+			test.tamper(issuer)
+
+			if identity, err := login(t, provider, issuer); err == nil {
+				t.Errorf("Authenticate succeeded with %+v, want an error", identity)
+			}
+		})
+	}
+}
+
+func TestAuthenticateUserInfo(t *testing.T) {
+	issuer := oidctest.NewIssuer(t)
+	issuer.OmitIDToken = true
+
+	// A plain OAuth2 provider without ID tokens reads the identity from userinfo
+	provider := &Provider{
+		Name:         "plain",
+		ClientID:     oidctest.ClientID,
+		ClientSecret: oidctest.ClientSecret,
+		RedirectURL:  testRedirectURL,
+		AuthURL:      issuer.URL + "/authorize",
+		TokenURL:     issuer.URL + "/token",
+		UserInfoURL:  issuer.URL + "/userinfo",
+	}
+
+	identity, err := login(t, provider, issuer)
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if identity.Subject != issuer.Subject || identity.Email != issuer.Email || !identity.EmailVerified {
+		t.Errorf("identity = %+v, want %s with verified %s", identity, issuer.Subject, issuer.Email)
+	}
+}
+
+func TestIsTrue(t *testing.T) {
+	tests := map[any]bool{true: true, "true": true, false: false, "false": false, nil: false, 1: false}
+
+	for value, want := range tests {
+		if got := isTrue(value); got != want {
+			t.Errorf("isTrue(%v) = %v, want %v", value, got, want)
+		}
+	}
+}
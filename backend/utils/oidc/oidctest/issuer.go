@@ -0,0 +1,256 @@
+// Package oidctest provides a local mock OpenID Connect provider for tests of the login flows
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	ClientID     string = "test-client"
+	ClientSecret string = "test-secret"
+
+	keyId string = "test-key"
+)
+
+// Issuer is a mock OpenID Connect provider served by an httptest server. It implements discovery, the
+// authorization code flow with PKCE, signed ID tokens, userinfo and the JWKS, and checks every request
+// like a real provider would.
+type Issuer struct {
+	URL string
+
+	// Subject, Email and EmailVerified are the identity of the user who signs in
+	Subject       string
+	Email         string
+	EmailVerified bool
+	// IDTokenClaims, if set, changes the claims of ID tokens before they are signed
+	IDTokenClaims func(claims jwt.MapClaims)
+	// SigningKey signs the ID tokens, the key published in the JWKS unless replaced
+	SigningKey *rsa.PrivateKey
+	// OmitIDToken makes the token endpoint return only an access token, like plain OAuth2 providers
+	OmitIDToken bool
+
+	key   *rsa.PrivateKey
+	mu    sync.Mutex
+	codes map[string]authorization
+}
+
+// authorization is a pending authorization code with the parameters of the request that created it
+type authorization struct {
+	clientId      string
+	redirectURI   string
+	codeChallenge string
+	nonce         string
+}
+
+// NewIssuer starts a mock provider that is stopped at the end of the test
+func NewIssuer(t *testing.T) *Issuer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate issuer key: %v", err)
+	}
+
+	issuer := &Issuer{
+		Subject:       "mock-subject",
+		Email:         "user@example.com",
+		EmailVerified: true,
+		SigningKey:    key,
+		key:           key,
+		codes:         map[string]authorization{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", issuer.discovery)
+	mux.HandleFunc("/authorize", issuer.authorize)
+	mux.HandleFunc("/token", issuer.token)
+	mux.HandleFunc("/userinfo", issuer.userInfo)
+	mux.HandleFunc("/jwks", issuer.jwks)
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	issuer.URL = server.URL
+
+	return issuer
+}
+
+// Authorize signs the user in at an authorization URL, as the browser would, and returns the code and state
+// of the redirect back to the app
+func (issuer *Issuer) Authorize(authURL string) (string, string, error) {
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, err := client.Get(authURL)
+	if err != nil {
+		return "", "", fmt.Errorf("authorize: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusFound {
+		return "", "", fmt.Errorf("authorize: status %d", resp.StatusCode)
+	}
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		return "", "", fmt.Errorf("authorize: %w", err)
+	}
+
+	return location.Query().Get("code"), location.Query().Get("state"), nil
+}
+
+func (issuer *Issuer) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"issuer":                 issuer.URL,
+		"authorization_endpoint": issuer.URL + "/authorize",
+		"token_endpoint":         issuer.URL + "/token",
+		"userinfo_endpoint":      issuer.URL + "/userinfo",
+		"jwks_uri":               issuer.URL + "/jwks",
+	})
+}
+
+func (issuer *Issuer) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	if query.Get("response_type") != "code" || query.Get("client_id") != ClientID ||
+		query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" ||
+		query.Get("state") == "" || query.Get("redirect_uri") == "" {
+		http.Error(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+
+	code := randomString()
+	issuer.mu.Lock()
+	issuer.codes[code] = authorization{
+		clientId:      query.Get("client_id"),
+		redirectURI:   query.Get("redirect_uri"),
+		codeChallenge: query.Get("code_challenge"),
+		nonce:         query.Get("nonce"),
+	}
+	issuer.mu.Unlock()
+
+	redirect := url.Values{}
+	redirect.Set("code", code)
+	redirect.Set("state", query.Get("state"))
+	http.Redirect(w, r, query.Get("redirect_uri")+"?"+redirect.Encode(), http.StatusFound)
+}
+
+func (issuer *Issuer) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.Method != http.MethodPost {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	if r.PostForm.Get("grant_type") != "authorization_code" ||
+		r.PostForm.Get("client_id") != ClientID || r.PostForm.Get("client_secret") != ClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	// Codes are single use
+	issuer.mu.Lock()
+	auth, ok := issuer.codes[r.PostForm.Get("code")]
+	delete(issuer.codes, r.PostForm.Get("code"))
+	issuer.mu.Unlock()
+
+	if !ok || auth.redirectURI != r.PostForm.Get("redirect_uri") || auth.clientId != r.PostForm.Get("client_id") {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != auth.codeChallenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	response := map[string]string{
+		"access_token": "access-" + randomString(),
+		"token_type":   "Bearer",
+	}
+	if !issuer.OmitIDToken {
+		idToken, err := issuer.idToken(auth.nonce)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+			return
+		}
+		response["id_token"] = idToken
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (issuer *Issuer) idToken(nonce string) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":            issuer.URL,
+		"sub":            issuer.Subject,
+		"aud":            ClientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+		"nonce":          nonce,
+		"email":          issuer.Email,
+		"email_verified": issuer.EmailVerified,
+	}
+	if issuer.IDTokenClaims != nil {
+		issuer.IDTokenClaims(claims)
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = keyId
+	return token.SignedString(issuer.SigningKey)
+}
+
+func (issuer *Issuer) userInfo(w http.ResponseWriter, r *http.Request) {
+	if len(r.Header.Get("Authorization")) <= len("Bearer access-") {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_token"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"sub":            issuer.Subject,
+		"email":          issuer.Email,
+		"email_verified": issuer.EmailVerified,
+	})
+}
+
+func (issuer *Issuer) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": keyId,
+			"n":   base64.RawURLEncoding.EncodeToString(issuer.key.PublicKey.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(issuer.key.PublicKey.E)).Bytes()),
+		}},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,16 @@
+package payment
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FormatAmount formats an amount in the smallest currency unit with two decimals and the currency code, e.g. "-9.99 USD"
+func FormatAmount(amount int64, currency string) string {
+	sign := ""
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+
+	return fmt.Sprintf("%s%d.%02d %s", sign, amount/100, amount%100, strings.ToUpper(currency))
+}
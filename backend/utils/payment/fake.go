@@ -0,0 +1,199 @@
+package payment
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const fakeCheckoutExpiry time.Duration = 24 * time.Hour
+
+var ErrFakeNotFound = errors.New("fake payment provider: not found")
+
+// FakeProvider is an in-memory payment provider for local development and tests.
+// Checkouts are completed and billing periods are ended by calling CompleteCheckout and EndPeriod,
+// which return the signed webhook request the provider would send.
+type FakeProvider struct {
+	mu            sync.Mutex
+	webhookSecret string
+	checkoutURL   string
+	sessions      map[string]CheckoutParams
+	subscriptions map[string]*Subscription
+	// Now returns the current time, it can be replaced to simulate the passing of billing periods
+	Now func() time.Time
+}
+
+// NewFakeProvider creates a fake provider that signs its webhooks with the secret, a random one if empty.
+// Checkout URLs are the checkout URL followed by the session ID.
+func NewFakeProvider(webhookSecret string, checkoutURL string) *FakeProvider {
+	if webhookSecret == "" {
+		webhookSecret = randomId("whsec_")
+	}
+
+	return &FakeProvider{
+		webhookSecret: webhookSecret,
+		checkoutURL:   checkoutURL,
+		sessions:      map[string]CheckoutParams{},
+		subscriptions: map[string]*Subscription{},
+		Now:           time.Now,
+	}
+}
+
+func (provider *FakeProvider) Name() string {
+	return Fake
+}
+
+func (provider *FakeProvider) CreateCheckoutSession(ctx context.Context, params CheckoutParams) (*CheckoutSession, error) {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+
+	if params.CustomerId == "" {
+		params.CustomerId = randomId("cus_fake_")
+	}
+
+	id := randomId("cs_fake_")
+	provider.sessions[id] = params
+
+	return &CheckoutSession{
+		Id:        id,
+		URL:       provider.checkoutURL + "/" + id,
+		ExpiresAt: provider.Now().Add(fakeCheckoutExpiry),
+	}, nil
+}
+
+func (provider *FakeProvider) ChangePlan(ctx context.Context, subscriptionId string, params ChangeParams) (*Subscription, error) {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+
+	subscription, ok := provider.subscriptions[subscriptionId]
+	if !ok || subscription.Status == CanceledStatus {
+		return nil, fmt.Errorf("change plan of %s: %w", subscriptionId, ErrFakeNotFound)
+	}
+
+	subscription.Plan = params.Plan
+	subscription.Amount = params.Amount
+
+	result := *subscription
+	return &result, nil
+}
+
+func (provider *FakeProvider) CancelSubscription(ctx context.Context, subscriptionId string) (*Subscription, error) {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+
+	subscription, ok := provider.subscriptions[subscriptionId]
+	if !ok || subscription.Status == CanceledStatus {
+		return nil, fmt.Errorf("cancel subscription %s: %w", subscriptionId, ErrFakeNotFound)
+	}
+
+	subscription.CancelAtPeriodEnd = true
+
+	result := *subscription
+	return &result, nil
+}
+
+func (provider *FakeProvider) ParseWebhook(payload []byte, header http.Header) (*Event, error) {
+	if err := VerifySignature(payload, header.Get(SignatureHeader), provider.webhookSecret, provider.Now()); err != nil {
+		return nil, err
+	}
+
+	var event Event
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, fmt.Errorf("parse webhook: %w", err)
+	}
+
+	return &event, nil
+}
+
+// CompleteCheckout pays for a checkout session as if the user finished the checkout page.
+// The subscription starts with its trial if the session has one.
+func (provider *FakeProvider) CompleteCheckout(sessionId string) ([]byte, http.Header, error) {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+
+	params, ok := provider.sessions[sessionId]
+	if !ok {
+		return nil, nil, fmt.Errorf("complete checkout %s: %w", sessionId, ErrFakeNotFound)
+	}
+	delete(provider.sessions, sessionId)
+
+	now := provider.Now()
+	subscription := &Subscription{
+		Id:                 randomId("sub_fake_"),
+		CustomerId:         params.CustomerId,
+		Uid:                params.Uid,
+		Plan:               params.Plan,
+		Amount:             params.Amount,
+		Status:             ActiveStatus,
+		CurrentPeriodStart: now,
+		CurrentPeriodEnd:   now.AddDate(0, 1, 0),
+	}
+	if params.TrialDays > 0 {
+		trialEnd := now.AddDate(0, 0, params.TrialDays)
+		subscription.Status = TrialingStatus
+		subscription.CurrentPeriodEnd = trialEnd
+		subscription.TrialEnd = &trialEnd
+	}
+	provider.subscriptions[subscription.Id] = subscription
+
+	return provider.event(CheckoutCompletedEvent, subscription)
+}
+
+// EndPeriod ends the current period of a subscription, as if it reached its period end.
+// Subscriptions canceled at the period end are deleted, others renew if paid is true and become past due otherwise.
+func (provider *FakeProvider) EndPeriod(subscriptionId string, paid bool) ([]byte, http.Header, error) {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+
+	subscription, ok := provider.subscriptions[subscriptionId]
+	if !ok || subscription.Status == CanceledStatus {
+		return nil, nil, fmt.Errorf("end period of %s: %w", subscriptionId, ErrFakeNotFound)
+	}
+
+	if subscription.CancelAtPeriodEnd {
+		subscription.Status = CanceledStatus
+		return provider.event(SubscriptionDeletedEvent, subscription)
+	}
+
+	if !paid {
+		subscription.Status = PastDueStatus
+		return provider.event(InvoicePaymentFailedEvent, subscription)
+	}
+
+	subscription.Status = ActiveStatus
+	subscription.CurrentPeriodStart = subscription.CurrentPeriodEnd
+	subscription.CurrentPeriodEnd = subscription.CurrentPeriodEnd.AddDate(0, 1, 0)
+	return provider.event(InvoicePaidEvent, subscription)
+}
+
+// event returns the signed webhook request of an event with the current state of the subscription
+func (provider *FakeProvider) event(eventType EventType, subscription *Subscription) ([]byte, http.Header, error) {
+	now := provider.Now()
+
+	payload, err := json.Marshal(Event{
+		Id:           randomId("evt_fake_"),
+		Type:         eventType,
+		CreatedAt:    now,
+		Subscription: *subscription,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("fake webhook: %w", err)
+	}
+
+	header := http.Header{}
+	header.Set(SignatureHeader, Sign(payload, provider.webhookSecret, now))
+
+	return payload, header, nil
+}
+
+func randomId(prefix string) string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return prefix + hex.EncodeToString(b)
+}
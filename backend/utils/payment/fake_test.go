@@ -0,0 +1,235 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeClock is a settable clock for the Now of the fake provider
+type fakeClock struct {
+	now time.Time
+}
+
+func (clock *fakeClock) Now() time.Time {
+	return clock.now
+}
+
+func newTestProvider(t *testing.T) (*FakeProvider, *fakeClock) {
+	t.Helper()
+
+	clock := &fakeClock{now: time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC)}
+	provider := NewFakeProvider("whsec_test", "/api/billing/fake/checkout")
+	provider.Now = clock.Now
+
+	return provider, clock
+}
+
+// checkout creates a checkout session and completes it, returning the parsed checkout.completed event
+func checkout(t *testing.T, provider *FakeProvider, params CheckoutParams) *Event {
+	t.Helper()
+
+	session, err := provider.CreateCheckoutSession(context.Background(), params)
+	if err != nil {
+		t.Fatalf("create checkout session: %v", err)
+	}
+
+	payload, header, err := provider.CompleteCheckout(session.Id)
+	if err != nil {
+		t.Fatalf("complete checkout: %v", err)
+	}
+
+	event, err := provider.ParseWebhook(payload, header)
+	if err != nil {
+		t.Fatalf("parse webhook: %v", err)
+	}
+
+	return event
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New("", "secret", "/checkout", true); err == nil {
+		t.Error("New with an empty provider succeeded, want an error")
+	}
+	if _, err := New("stripe", "secret", "/checkout", true); err == nil {
+		t.Error("New with an unknown provider succeeded, want an error")
+	}
+	if _, err := New(Fake, "secret", "/checkout", false); err == nil {
+		t.Error("New with the fake provider outside dev mode succeeded, want an error")
+	}
+
+	provider, err := New(Fake, "secret", "/checkout", true)
+	if err != nil {
+		t.Fatalf("New with the fake provider in dev mode: %v", err)
+	}
+	if provider.Name() != Fake {
+		t.Errorf("Name() = %q, want %q", provider.Name(), Fake)
+	}
+}
+
+func TestFakeProviderCheckout(t *testing.T) {
+	provider, clock := newTestProvider(t)
+
+	session, err := provider.CreateCheckoutSession(context.Background(), CheckoutParams{Uid: "user-1", Plan: "pro", Amount: 1999})
+	if err != nil {
+		t.Fatalf("create checkout session: %v", err)
+	}
+	if want := "/api/billing/fake/checkout/" + session.Id; session.URL != want {
+		t.Errorf("URL = %q, want %q", session.URL, want)
+	}
+	if want := clock.now.Add(fakeCheckoutExpiry); !session.ExpiresAt.Equal(want) {
+		t.Errorf("ExpiresAt = %v, want %v", session.ExpiresAt, want)
+	}
+
+	payload, header, err := provider.CompleteCheckout(session.Id)
+	if err != nil {
+		t.Fatalf("complete checkout: %v", err)
+	}
+	event, err := provider.ParseWebhook(payload, header)
+	if err != nil {
+		t.Fatalf("parse webhook: %v", err)
+	}
+
+	if event.Type != CheckoutCompletedEvent {
+		t.Errorf("Type = %q, want %q", event.Type, CheckoutCompletedEvent)
+	}
+	subscription := event.Subscription
+	if subscription.Uid != "user-1" || subscription.Plan != "pro" || subscription.Amount != 1999 {
+		t.Errorf("subscription = %+v, want user-1 on pro for 1999", subscription)
+	}
+	if subscription.Status != ActiveStatus {
+		t.Errorf("Status = %q, want %q", subscription.Status, ActiveStatus)
+	}
+	if !subscription.CurrentPeriodStart.Equal(clock.now) || !subscription.CurrentPeriodEnd.Equal(clock.now.AddDate(0, 1, 0)) {
+		t.Errorf("period = %v - %v, want a month from %v", subscription.CurrentPeriodStart, subscription.CurrentPeriodEnd, clock.now)
+	}
+	if subscription.CustomerId == "" {
+		t.Error("CustomerId is empty, want a new customer")
+	}
+
+	// A session can only be paid once
+	if _, _, err := provider.CompleteCheckout(session.Id); !errors.Is(err, ErrFakeNotFound) {
+		t.Errorf("completing a session twice: err = %v, want %v", err, ErrFakeNotFound)
+	}
+}
+
+func TestFakeProviderTrial(t *testing.T) {
+	provider, clock := newTestProvider(t)
+
+	subscription := checkout(t, provider, CheckoutParams{Uid: "user-1", Plan: "pro", Amount: 1999, TrialDays: 14}).Subscription
+
+	trialEnd := clock.now.AddDate(0, 0, 14)
+	if subscription.Status != TrialingStatus {
+		t.Errorf("Status = %q, want %q", subscription.Status, TrialingStatus)
+	}
+	if subscription.TrialEnd == nil || !subscription.TrialEnd.Equal(trialEnd) || !subscription.CurrentPeriodEnd.Equal(trialEnd) {
+		t.Errorf("trial ends %v and period %v, want both %v", subscription.TrialEnd, subscription.CurrentPeriodEnd, trialEnd)
+	}
+}
+
+func TestFakeProviderEndPeriod(t *testing.T) {
+	provider, clock := newTestProvider(t)
+	subscription := checkout(t, provider, CheckoutParams{Uid: "user-1", Plan: "pro", Amount: 1999}).Subscription
+
+	// Renewal at the end of the period
+	clock.now = subscription.CurrentPeriodEnd
+	payload, header, err := provider.EndPeriod(subscription.Id, true)
+	if err != nil {
+		t.Fatalf("end period: %v", err)
+	}
+	event, err := provider.ParseWebhook(payload, header)
+	if err != nil {
+		t.Fatalf("parse webhook: %v", err)
+	}
+	if event.Type != InvoicePaidEvent || event.Subscription.Status != ActiveStatus {
+		t.Errorf("renewal: %s with %s, want %s with %s", event.Type, event.Subscription.Status, InvoicePaidEvent, ActiveStatus)
+	}
+	if !event.Subscription.CurrentPeriodStart.Equal(subscription.CurrentPeriodEnd) {
+		t.Errorf("renewed period starts %v, want %v", event.Subscription.CurrentPeriodStart, subscription.CurrentPeriodEnd)
+	}
+	if !event.CreatedAt.Equal(clock.now) {
+		t.Errorf("CreatedAt = %v, want the fake clock %v", event.CreatedAt, clock.now)
+	}
+
+	// Failed renewal
+	clock.now = event.Subscription.CurrentPeriodEnd
+	payload, header, err = provider.EndPeriod(subscription.Id, false)
+	if err != nil {
+		t.Fatalf("end period: %v", err)
+	}
+	event, err = provider.ParseWebhook(payload, header)
+	if err != nil {
+		t.Fatalf("parse webhook: %v", err)
+	}
+	if event.Type != InvoicePaymentFailedEvent || event.Subscription.Status != PastDueStatus {
+		t.Errorf("failed renewal: %s with %s, want %s with %s", event.Type, event.Subscription.Status, InvoicePaymentFailedEvent, PastDueStatus)
+	}
+
+	// Cancellation at the end of the period
+	if _, err := provider.CancelSubscription(context.Background(), subscription.Id); err != nil {
+		t.Fatalf("cancel subscription: %v", err)
+	}
+	payload, header, err = provider.EndPeriod(subscription.Id, true)
+	if err != nil {
+		t.Fatalf("end period: %v", err)
+	}
+	event, err = provider.ParseWebhook(payload, header)
+	if err != nil {
+		t.Fatalf("parse webhook: %v", err)
+	}
+	if event.Type != SubscriptionDeletedEvent || event.Subscription.Status != CanceledStatus {
+		t.Errorf("cancellation: %s with %s, want %s with %s", event.Type, event.Subscription.Status, SubscriptionDeletedEvent, CanceledStatus)
+	}
+
+	if _, _, err := provider.EndPeriod(subscription.Id, true); !errors.Is(err, ErrFakeNotFound) {
+		t.Errorf("ending the period of a canceled subscription: err = %v, want %v", err, ErrFakeNotFound)
+	}
+	if _, err := provider.ChangePlan(context.Background(), subscription.Id, ChangeParams{Plan: "standard"}); !errors.Is(err, ErrFakeNotFound) {
+		t.Errorf("changing the plan of a canceled subscription: err = %v, want %v", err, ErrFakeNotFound)
+	}
+}
+
+func TestFakeProviderChangePlan(t *testing.T) {
+	provider, _ := newTestProvider(t)
+	subscription := checkout(t, provider, CheckoutParams{Uid: "user-1", Plan: "pro", Amount: 1999}).Subscription
+
+	changed, err := provider.ChangePlan(context.Background(), subscription.Id, ChangeParams{Plan: "standard", Amount: 999})
+	if err != nil {
+		t.Fatalf("change plan: %v", err)
+	}
+	if changed.Plan != "standard" || changed.Amount != 999 {
+		t.Errorf("changed subscription = %s for %d, want standard for 999", changed.Plan, changed.Amount)
+	}
+}
+
+func TestFakeProviderWebhookSignature(t *testing.T) {
+	provider, clock := newTestProvider(t)
+
+	session, err := provider.CreateCheckoutSession(context.Background(), CheckoutParams{Uid: "user-1", Plan: "pro"})
+	if err != nil {
+		t.Fatalf("create checkout session: %v", err)
+	}
+	payload, header, err := provider.CompleteCheckout(session.Id)
+	if err != nil {
+		t.Fatalf("complete checkout: %v", err)
+	}
+
+	tampered := append([]byte{}, payload...)
+	tampered[len(tampered)-2] ^= 1
+	if _, err := provider.ParseWebhook(tampered, header); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("tampered payload: err = %v, want %v", err, ErrInvalidSignature)
+	}
+
+	other := NewFakeProvider("whsec_other", "")
+	other.Now = clock.Now
+	if _, err := other.ParseWebhook(payload, header); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("other secret: err = %v, want %v", err, ErrInvalidSignature)
+	}
+
+	// Replayed after the tolerance
+	clock.now = clock.now.Add(SignatureTolerance + time.Second)
+	if _, err := provider.ParseWebhook(payload, header); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("replayed payload: err = %v, want %v", err, ErrInvalidSignature)
+	}
+}
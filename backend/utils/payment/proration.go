@@ -0,0 +1,21 @@
+package payment
+
+import (
+	"math"
+	"time"
+)
+
+// Prorate returns the amount owed for switching from oldAmount to newAmount per period at now,
+// for the part of the period that is left. A negative amount is a credit.
+// Amounts are in the smallest currency unit and rounded to the nearest one.
+func Prorate(oldAmount int64, newAmount int64, periodStart time.Time, periodEnd time.Time, now time.Time) int64 {
+	if !periodEnd.After(periodStart) || !now.Before(periodEnd) {
+		return 0
+	}
+	if now.Before(periodStart) {
+		now = periodStart
+	}
+
+	remaining := float64(periodEnd.Sub(now)) / float64(periodEnd.Sub(periodStart))
+	return int64(math.Round(float64(newAmount-oldAmount) * remaining))
+}
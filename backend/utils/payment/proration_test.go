@@ -0,0 +1,53 @@
+package payment
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProrate(t *testing.T) {
+	start := time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		old, new int64
+		now      time.Time
+		want     int64
+	}{
+		{"upgrade halfway", 1000, 3000, start.Add(end.Sub(start) / 2), 1000},
+		{"downgrade halfway is a credit", 3000, 1000, start.Add(end.Sub(start) / 2), -1000},
+		{"before the period is the whole period", 1000, 3000, start.AddDate(0, 0, -1), 2000},
+		{"at the period end", 1000, 3000, end, 0},
+		{"after the period end", 1000, 3000, end.Add(time.Hour), 0},
+		{"rounded to the nearest cent", 0, 1000, start.Add(end.Sub(start) * 2 / 3), 333},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Prorate(test.old, test.new, start, end, test.now); got != test.want {
+				t.Errorf("Prorate() = %d, want %d", got, test.want)
+			}
+		})
+	}
+
+	if got := Prorate(1000, 3000, end, start, start); got != 0 {
+		t.Errorf("Prorate() of an empty period = %d, want 0", got)
+	}
+}
+
+func TestFormatAmount(t *testing.T) {
+	tests := map[int64]string{
+		0:     "0.00 USD",
+		5:     "0.05 USD",
+		1999:  "19.99 USD",
+		-999:  "-9.99 USD",
+		12345: "123.45 USD",
+	}
+
+	for amount, want := range tests {
+		if got := FormatAmount(amount, Currency); got != want {
+			t.Errorf("FormatAmount(%d) = %q, want %q", amount, got, want)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package payment
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	plan "github.com/AkifhanIlgaz/dictionary-api/utils/plans"
+)
+
+const (
+	// Fake is the local provider for development and tests, it never charges anyone
+	Fake string = "fake"
+)
+
+// Currency of all plan prices
+const Currency string = "usd"
+
+// SubscriptionStatus is the lifecycle state of a subscription
+type SubscriptionStatus string
+
+const (
+	// TrialingStatus subscriptions have the plan for free until the trial ends
+	TrialingStatus SubscriptionStatus = "trialing"
+	ActiveStatus   SubscriptionStatus = "active"
+	// PastDueStatus subscriptions failed to renew and keep the plan while the provider retries the payment
+	PastDueStatus SubscriptionStatus = "past_due"
+	// CanceledStatus subscriptions ended, their users are back on the default plan
+	CanceledStatus SubscriptionStatus = "canceled"
+)
+
+// HasPlan reports whether users with a subscription in this state get its plan
+func (status SubscriptionStatus) HasPlan() bool {
+	return status == TrialingStatus || status == ActiveStatus || status == PastDueStatus
+}
+
+// EventType is the type of a webhook event
+type EventType string
+
+const (
+	CheckoutCompletedEvent    EventType = "checkout.completed"
+	SubscriptionUpdatedEvent  EventType = "subscription.updated"
+	SubscriptionDeletedEvent  EventType = "subscription.deleted"
+	InvoicePaidEvent          EventType = "invoice.paid"
+	InvoicePaymentFailedEvent EventType = "invoice.payment_failed"
+)
+
+// CheckoutParams describe the subscription a checkout session sells.
+// Amounts are in the smallest currency unit, e.g. cents.
+type CheckoutParams struct {
+	// Uid is passed back in the events of the subscription
+	Uid string
+	// CustomerId is the customer of an earlier subscription, a new customer is created if empty
+	CustomerId string
+	Email      string
+	Plan       plan.PlanType
+	Amount     int64
+	TrialDays  int
+	SuccessURL string
+	CancelURL  string
+}
+
+// CheckoutSession is a hosted payment page the user is redirected to
+type CheckoutSession struct {
+	Id        string
+	URL       string
+	ExpiresAt time.Time
+}
+
+// ChangeParams move a subscription to another plan right away.
+// ProrationAmount is charged, or credited if negative, for the rest of the current period.
+type ChangeParams struct {
+	Plan            plan.PlanType
+	Amount          int64
+	ProrationAmount int64
+}
+
+// Subscription is the state of a subscription at the provider
+type Subscription struct {
+	Id                 string             `json:"id"`
+	CustomerId         string             `json:"customerId"`
+	Uid                string             `json:"uid"`
+	Plan               plan.PlanType      `json:"plan"`
+	Amount             int64              `json:"amount"`
+	Status             SubscriptionStatus `json:"status"`
+	CurrentPeriodStart time.Time          `json:"currentPeriodStart"`
+	CurrentPeriodEnd   time.Time          `json:"currentPeriodEnd"`
+	TrialEnd           *time.Time         `json:"trialEnd,omitempty"`
+	CancelAtPeriodEnd  bool               `json:"cancelAtPeriodEnd"`
+}
+
+// Event is a verified webhook event. It carries the state of the subscription after the event,
+// so that events can be applied by syncing that state.
+type Event struct {
+	Id           string       `json:"id"`
+	Type         EventType    `json:"type"`
+	CreatedAt    time.Time    `json:"createdAt"`
+	Subscription Subscription `json:"subscription"`
+}
+
+// Provider is a payment provider that hosts checkout pages, bills subscriptions and reports changes with signed webhooks
+type Provider interface {
+	Name() string
+	CreateCheckoutSession(ctx context.Context, params CheckoutParams) (*CheckoutSession, error)
+	// ChangePlan moves the subscription to another plan and bills the proration
+	ChangePlan(ctx context.Context, subscriptionId string, params ChangeParams) (*Subscription, error)
+	// CancelSubscription cancels the subscription at the end of the current period
+	CancelSubscription(ctx context.Context, subscriptionId string) (*Subscription, error)
+	// ParseWebhook verifies the signature of a webhook request and parses its event
+	ParseWebhook(payload []byte, header http.Header) (*Event, error)
+}
+
+// New creates the provider with the given name. The fake provider lets anyone complete checkouts,
+// so it is refused unless allowFake is set, which is only meant for development.
+func New(name string, webhookSecret string, checkoutURL string, allowFake bool) (Provider, error) {
+	switch name {
+	case "":
+		return nil, fmt.Errorf("no payment provider configured")
+	case Fake:
+		if !allowFake {
+			return nil, fmt.Errorf("the fake payment provider is only available in dev mode")
+		}
+		return NewFakeProvider(webhookSecret, checkoutURL), nil
+	default:
+		return nil, fmt.Errorf("unknown payment provider %q", name)
+	}
+}
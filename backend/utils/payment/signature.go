@@ -0,0 +1,27 @@
+package payment
+
+import (
+	"time"
+
+	"github.com/AkifhanIlgaz/dictionary-api/utils/webhook"
+)
+
+// SignatureHeader carries the signature of webhook requests in the format "t=<unix time>,v1=<hex HMAC-SHA256>"
+const SignatureHeader string = "Payment-Signature"
+
+// SignatureTolerance is how old a signature may be, so that captured requests cannot be replayed later
+const SignatureTolerance time.Duration = webhook.SignatureTolerance
+
+var ErrInvalidSignature = webhook.ErrInvalidSignature
+
+// Sign returns the signature header of a payload signed at the given time.
+// Payment webhooks are signed like outbound webhooks.
+func Sign(payload []byte, secret string, signedAt time.Time) string {
+	return webhook.Sign(payload, secret, signedAt)
+}
+
+// VerifySignature checks that the signature header was created with the secret for the payload
+// within the signature tolerance of now
+func VerifySignature(payload []byte, header string, secret string, now time.Time) error {
+	return webhook.VerifySignature(payload, header, secret, now)
+}
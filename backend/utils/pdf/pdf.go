@@ -0,0 +1,144 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Font is one of the standard Helvetica fonts, which PDF readers provide so that nothing has to be embedded
+type Font string
+
+const (
+	Regular Font = "F1"
+	Bold    Font = "F2"
+)
+
+// A4 page size in points
+const (
+	PageWidth  float64 = 595.28
+	PageHeight float64 = 841.89
+)
+
+// helveticaWidths are the widths of the printable ASCII characters in Helvetica, in thousandths of the font size.
+// Helvetica-Bold is slightly wider, but its digits, punctuation and most capitals have the same widths.
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+// Document is a minimal writer of text documents with A4 pages. Coordinates are in points
+// from the top left corner of the page. Text is encoded as WinAnsi, characters outside of Latin-1 are replaced.
+type Document struct {
+	pages []*bytes.Buffer
+}
+
+// New creates a document with one empty page
+func New() *Document {
+	document := &Document{}
+	document.AddPage()
+	return document
+}
+
+// AddPage starts a new page, the following drawing goes to it
+func (document *Document) AddPage() {
+	document.pages = append(document.pages, &bytes.Buffer{})
+}
+
+// Text draws text with its baseline starting at x, y
+func (document *Document) Text(x float64, y float64, font Font, size float64, text string) {
+	page := document.pages[len(document.pages)-1]
+	fmt.Fprintf(page, "BT /%s %s Tf %s %s Td (%s) Tj ET\n", font, number(size), number(x), number(PageHeight-y), escape(text))
+}
+
+// TextRight draws text with its baseline ending at right, y
+func (document *Document) TextRight(right float64, y float64, font Font, size float64, text string) {
+	document.Text(right-TextWidth(text, size), y, font, size, text)
+}
+
+// Line draws a thin line from x1, y1 to x2, y2
+func (document *Document) Line(x1 float64, y1 float64, x2 float64, y2 float64) {
+	page := document.pages[len(document.pages)-1]
+	fmt.Fprintf(page, "0.5 w %s %s m %s %s l S\n", number(x1), number(PageHeight-y1), number(x2), number(PageHeight-y2))
+}
+
+// TextWidth returns the width of text in Helvetica at the given size
+func TextWidth(text string, size float64) float64 {
+	width := 0
+	for _, r := range text {
+		if r >= ' ' && r <= '~' {
+			width += helveticaWidths[r-' ']
+		} else {
+			width += 556
+		}
+	}
+
+	return float64(width) * size / 1000
+}
+
+// Bytes returns the PDF file of the document
+func (document *Document) Bytes() []byte {
+	var out bytes.Buffer
+	var offsets []int
+
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	out.WriteString("%PDF-1.4\n")
+
+	// Objects 1 to 4 are the catalog, the page tree and the fonts, followed by a page and its content per page
+	kids := make([]string, len(document.pages))
+	for i := range document.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+2*i)
+	}
+
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(document.pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+
+	for i, page := range document.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			number(PageWidth), number(PageHeight), 6+2*i))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", page.Len(), page.String()))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	return out.Bytes()
+}
+
+// escape encodes text as a PDF string in WinAnsi
+func escape(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= ' ' && r <= '~':
+			b.WriteRune(r)
+		case r >= 0xA0 && r <= 0xFF:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+
+	return b.String()
+}
+
+func number(f float64) string {
+	return strings.TrimRight(strings.TrimRight(fmt.Sprintf("%.2f", f), "0"), ".")
+}
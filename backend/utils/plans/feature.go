@@ -0,0 +1,32 @@
+package plan
+
+import "slices"
+
+// Feature is an entitlement of a plan, enforced on every request
+type Feature string
+
+const (
+	// AudioFeature includes the UK and US pronunciations in word responses
+	AudioFeature Feature = "audio"
+	// ExamplesFeature includes the example sentences of definitions in word responses
+	ExamplesFeature Feature = "examples"
+	// IdiomsFeature includes the idioms of words in word responses
+	IdiomsFeature Feature = "idioms"
+	// ExportFeature allows exporting the word list
+	ExportFeature Feature = "export"
+	// BatchFeature allows looking up several words in one request
+	BatchFeature Feature = "batch"
+)
+
+// Features are all known features
+var Features = []Feature{AudioFeature, ExamplesFeature, IdiomsFeature, ExportFeature, BatchFeature}
+
+// IsValid reports whether the feature is known
+func (feature Feature) IsValid() bool {
+	return slices.Contains(Features, feature)
+}
+
+// HasFeature reports whether the plan is entitled to the feature
+func (p Plan) HasFeature(feature Feature) bool {
+	return slices.Contains(p.Features, feature)
+}
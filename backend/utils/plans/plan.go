@@ -1,84 +1,106 @@
 package plan
 
 import (
-	"fmt"
+	"math"
+	"strings"
 )
 
+// PlanType is the ID of a plan as stored on users
 type PlanType string
 
 const (
-	FreePlan     PlanType = "Free"
-	StandardPlan PlanType = "Standard"
-	ProPlan      PlanType = "Pro"
+	FreePlan     PlanType = "free"
+	StandardPlan PlanType = "standard"
+	ProPlan      PlanType = "pro"
 )
 
+// Plan describes the limits, features and price of a subscription plan.
+// A limit of 0 means unlimited.
 type Plan struct {
-	Type           PlanType
-	RequestsPerDay int
-	Features       []string
-	Price          float64
+	Type PlanType `json:"id"`
+	Name string   `json:"name"`
+	// QuotaWindow decides whether RequestsPerDay or RequestsPerMonth is the quota of the plan, daily if empty
+	QuotaWindow       Window `json:"quotaWindow"`
+	RequestsPerDay    int    `json:"requestsPerDay,omitempty"`
+	RequestsPerMonth  int    `json:"requestsPerMonth,omitempty"`
+	RequestsPerMinute int    `json:"requestsPerMinute"`
+	// Burst is how many requests may be sent at once before the per-minute rate applies
+	Burst int `json:"burst"`
+	// Features are the entitlements of the plan, requests for other features are rejected or left out of responses
+	Features []Feature `json:"features"`
+	// Price is charged every month, in dollars
+	Price float64 `json:"price"`
+	// OveragePrice is charged per started 1,000 requests over the quota, in dollars.
+	// Users can only allow requests over the quota on plans with an overage price.
+	OveragePrice float64 `json:"overagePrice,omitempty"`
+	// TrialDays is the length of the free trial of users subscribing for the first time, none if 0
+	TrialDays int `json:"trialDays,omitempty"`
 }
 
-func GetPlan(planType PlanType) Plan {
-	switch planType {
-	case FreePlan:
-		return Plan{
-			Type:           FreePlan,
-			RequestsPerDay: 100,
-			Features:       []string{"Basic word lookups", "Definition search"},
-			Price:          0,
-		}
-	case StandardPlan:
-		return Plan{
-			Type:           StandardPlan,
-			RequestsPerDay: 1000,
-			Features:       []string{"Basic word lookups", "Definition search", "Examples", "Synonyms"},
-			Price:          9.99,
-		}
-	case ProPlan:
-		return Plan{
-			Type:           ProPlan,
-			RequestsPerDay: 10000,
-			Features:       []string{"Basic word lookups", "Definition search", "Examples", "Synonyms", "Etymology", "Advanced API features"},
-			Price:          19.99,
-		}
-	default:
-		return Plan{}
-	}
+// Amount returns the price in cents
+func (p Plan) Amount() int64 {
+	return int64(math.Round(p.Price * 100))
 }
 
-func UpgradePlan(currentPlan, targetPlan PlanType) (Plan, error) {
-	if !isValidUpgrade(currentPlan, targetPlan) {
-		return Plan{}, fmt.Errorf("invalid upgrade path from %s to %s", currentPlan, targetPlan)
-	}
-	return GetPlan(targetPlan), nil
+// OverageAmount returns the overage price in cents
+func (p Plan) OverageAmount() int64 {
+	return int64(math.Round(p.OveragePrice * 100))
 }
 
-func DowngradePlan(currentPlan, targetPlan PlanType) (Plan, error) {
-	if !isValidDowngrade(currentPlan, targetPlan) {
-		return Plan{}, fmt.Errorf("invalid downgrade path from %s to %s", currentPlan, targetPlan)
-	}
-	return GetPlan(targetPlan), nil
+// OverageCharge returns what the requests over the quota cost, in cents.
+// They are billed per started 1,000 requests.
+func (p Plan) OverageCharge(requests int64) int64 {
+	return (requests + 999) / 1000 * p.OverageAmount()
 }
 
-func isValidUpgrade(current, target PlanType) bool {
-	switch current {
-	case FreePlan:
-		return target == StandardPlan || target == ProPlan
-	case StandardPlan:
-		return target == ProPlan
-	default:
-		return false
+// Quota returns the number of requests allowed in each quota window of the plan
+func (p Plan) Quota() int {
+	if p.QuotaWindow == MonthlyWindow {
+		return p.RequestsPerMonth
 	}
+
+	return p.RequestsPerDay
 }
 
-func isValidDowngrade(current, target PlanType) bool {
-	switch current {
-	case ProPlan:
-		return target == StandardPlan || target == FreePlan
-	case StandardPlan:
-		return target == FreePlan
-	default:
-		return false
-	}
+// DefaultPlans are used when no plans file is configured, ordered from the lowest to the highest tier
+var DefaultPlans = []Plan{
+	{
+		Type:              FreePlan,
+		Name:              "Free",
+		QuotaWindow:       DailyWindow,
+		RequestsPerDay:    100,
+		RequestsPerMinute: 20,
+		Burst:             5,
+		Features:          []Feature{AudioFeature},
+		Price:             0,
+	},
+	{
+		Type:              StandardPlan,
+		Name:              "Standard",
+		QuotaWindow:       DailyWindow,
+		RequestsPerDay:    1000,
+		RequestsPerMinute: 120,
+		Burst:             20,
+		Features:          []Feature{AudioFeature, ExamplesFeature, IdiomsFeature},
+		Price:             9.99,
+		OveragePrice:      0.5,
+		TrialDays:         14,
+	},
+	{
+		Type:              ProPlan,
+		Name:              "Pro",
+		QuotaWindow:       DailyWindow,
+		RequestsPerDay:    10000,
+		RequestsPerMinute: 600,
+		Burst:             50,
+		Features:          []Feature{AudioFeature, ExamplesFeature, IdiomsFeature, ExportFeature, BatchFeature},
+		Price:             19.99,
+		OveragePrice:      0.25,
+		TrialDays:         14,
+	},
+}
+
+// normalizeType returns the canonical form of a stored or configured plan ID, e.g. "Free" becomes "free"
+func normalizeType(planType string) PlanType {
+	return PlanType(strings.ToLower(strings.TrimSpace(planType)))
 }
@@ -0,0 +1,179 @@
+package plan
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Registry is the single source of the available plans.
+// Plans are ordered by tier, which decides whether a change is an upgrade or a downgrade.
+type Registry struct {
+	plans       []Plan
+	defaultPlan PlanType
+}
+
+// registryFile is the format of a plans file
+type registryFile struct {
+	Default PlanType `json:"default"`
+	Plans   []Plan   `json:"plans"`
+}
+
+// NewRegistry creates a registry of the given plans, ordered from the lowest to the highest tier.
+// Users without a known plan are on defaultPlan, or on the first plan if it is empty.
+func NewRegistry(plans []Plan, defaultPlan PlanType) (*Registry, error) {
+	if len(plans) == 0 {
+		return nil, errors.New("plan registry: no plans")
+	}
+
+	registry := &Registry{}
+	for _, p := range plans {
+		p.Type = normalizeType(string(p.Type))
+		if p.Type == "" {
+			return nil, errors.New("plan registry: plan without id")
+		}
+		if _, ok := registry.Get(p.Type); ok {
+			return nil, fmt.Errorf("plan registry: duplicate plan %s", p.Type)
+		}
+		if p.QuotaWindow == "" {
+			p.QuotaWindow = DailyWindow
+		}
+		if !p.QuotaWindow.IsValid() {
+			return nil, fmt.Errorf("plan registry: unknown quota window %q for %s", p.QuotaWindow, p.Type)
+		}
+		if p.RequestsPerDay < 0 || p.RequestsPerMonth < 0 || p.RequestsPerMinute < 0 || p.Burst < 0 || p.Price < 0 || p.OveragePrice < 0 || p.TrialDays < 0 {
+			return nil, fmt.Errorf("plan registry: negative limit or price for %s", p.Type)
+		}
+		for _, feature := range p.Features {
+			if !feature.IsValid() {
+				return nil, fmt.Errorf("plan registry: unknown feature %q for %s", feature, p.Type)
+			}
+		}
+		registry.plans = append(registry.plans, p)
+	}
+
+	registry.defaultPlan = registry.plans[0].Type
+	if defaultPlan != "" {
+		registry.defaultPlan = normalizeType(string(defaultPlan))
+		if _, ok := registry.Get(registry.defaultPlan); !ok {
+			return nil, fmt.Errorf("plan registry: unknown default plan %s", defaultPlan)
+		}
+	}
+
+	return registry, nil
+}
+
+// LoadRegistry creates a registry from a JSON plans file, or of DefaultPlans if path is empty
+func LoadRegistry(path string) (*Registry, error) {
+	if path == "" {
+		return NewRegistry(DefaultPlans, FreePlan)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("load plans: %w", err)
+	}
+
+	var file registryFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("load plans: %w", err)
+	}
+
+	return NewRegistry(file.Plans, file.Default)
+}
+
+// Plans returns all plans ordered from the lowest to the highest tier
+func (registry *Registry) Plans() []Plan {
+	return append([]Plan(nil), registry.plans...)
+}
+
+// Get returns the plan with the given ID, which is matched case-insensitively
+func (registry *Registry) Get(planType PlanType) (Plan, bool) {
+	planType = normalizeType(string(planType))
+
+	for _, p := range registry.plans {
+		if p.Type == planType {
+			return p, true
+		}
+	}
+
+	return Plan{}, false
+}
+
+// LowestWith returns the lowest tier plan entitled to the feature, or false if no plan is
+func (registry *Registry) LowestWith(feature Feature) (Plan, bool) {
+	for _, p := range registry.plans {
+		if p.HasFeature(feature) {
+			return p, true
+		}
+	}
+
+	return Plan{}, false
+}
+
+// Default returns the plan of new users
+func (registry *Registry) Default() Plan {
+	p, _ := registry.Get(registry.defaultPlan)
+	return p
+}
+
+// Resolve returns the plan for a plan value stored on a user.
+// Values are matched case-insensitively, and missing or unknown values resolve to the default plan.
+func (registry *Registry) Resolve(stored string) Plan {
+	if p, ok := registry.Get(PlanType(stored)); ok {
+		return p
+	}
+
+	return registry.Default()
+}
+
+// Normalize returns the canonical plan ID for a stored plan value.
+// Missing values normalize to the default plan. It returns false for unknown values.
+func (registry *Registry) Normalize(stored string) (PlanType, bool) {
+	if normalizeType(stored) == "" {
+		return registry.defaultPlan, true
+	}
+
+	p, ok := registry.Get(PlanType(stored))
+	return p.Type, ok
+}
+
+// UpgradePlan returns the target plan if it is a higher tier than the current plan
+func (registry *Registry) UpgradePlan(currentPlan, targetPlan PlanType) (Plan, error) {
+	current, target, err := registry.pair(currentPlan, targetPlan)
+	if err != nil || target <= current {
+		return Plan{}, fmt.Errorf("invalid upgrade path from %s to %s", currentPlan, targetPlan)
+	}
+
+	return registry.plans[target], nil
+}
+
+// DowngradePlan returns the target plan if it is a lower tier than the current plan
+func (registry *Registry) DowngradePlan(currentPlan, targetPlan PlanType) (Plan, error) {
+	current, target, err := registry.pair(currentPlan, targetPlan)
+	if err != nil || target >= current {
+		return Plan{}, fmt.Errorf("invalid downgrade path from %s to %s", currentPlan, targetPlan)
+	}
+
+	return registry.plans[target], nil
+}
+
+// pair returns the tiers of the current and target plans
+func (registry *Registry) pair(currentPlan, targetPlan PlanType) (int, int, error) {
+	current, target := -1, -1
+	for i, p := range registry.plans {
+		if p.Type == registry.Resolve(string(currentPlan)).Type {
+			current = i
+		}
+		if p.Type == normalizeType(string(targetPlan)) {
+			target = i
+		}
+	}
+
+	if current < 0 || target < 0 {
+		return 0, 0, fmt.Errorf("unknown plan %s", targetPlan)
+	}
+
+	return current, target, nil
+}
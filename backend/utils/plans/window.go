@@ -0,0 +1,29 @@
+package plan
+
+import "time"
+
+// Window is the period a quota applies to. Windows start at midnight in the billing timezone of the user.
+type Window string
+
+const (
+	DailyWindow   Window = "day"
+	MonthlyWindow Window = "month"
+)
+
+// IsValid reports whether the window is known
+func (window Window) IsValid() bool {
+	return window == DailyWindow || window == MonthlyWindow
+}
+
+// Bounds returns the start and end of the window containing t in the given location
+func (window Window) Bounds(t time.Time, loc *time.Location) (time.Time, time.Time) {
+	local := t.In(loc)
+
+	if window == MonthlyWindow {
+		start := time.Date(local.Year(), local.Month(), 1, 0, 0, 0, 0, loc)
+		return start, start.AddDate(0, 1, 0)
+	}
+
+	start := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+	return start, start.AddDate(0, 0, 1)
+}
@@ -0,0 +1,65 @@
+package rbac
+
+import "slices"
+
+type Role string
+
+const (
+	UserRole    Role = "user"
+	SupportRole Role = "support"
+	AdminRole   Role = "admin"
+)
+
+type Permission string
+
+const (
+	ReadUsers        Permission = "users:read"
+	ManageUsers      Permission = "users:manage"
+	ImpersonateUsers Permission = "users:impersonate"
+	ManageRoles      Permission = "roles:manage"
+	RevokeKeys       Permission = "keys:revoke"
+	ManagePlans      Permission = "plans:manage"
+	ReadAuditLog     Permission = "audit:read"
+	ManageDataset    Permission = "dataset:manage"
+)
+
+var rolePermissions = map[Role][]Permission{
+	UserRole: {},
+	SupportRole: {
+		ReadUsers,
+		ImpersonateUsers,
+		RevokeKeys,
+		ReadAuditLog,
+	},
+	AdminRole: {
+		ReadUsers,
+		ManageUsers,
+		ImpersonateUsers,
+		ManageRoles,
+		RevokeKeys,
+		ManagePlans,
+		ReadAuditLog,
+		ManageDataset,
+	},
+}
+
+// Parse returns the role for the given string.
+// Users created before roles existed have no role and are treated as UserRole.
+func Parse(role string) Role {
+	if role == "" {
+		return UserRole
+	}
+
+	return Role(role)
+}
+
+// IsValid reports whether the role is one of the known roles
+func IsValid(role Role) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// HasPermission reports whether the role grants the permission. Unknown roles grant nothing.
+func HasPermission(role Role, permission Permission) bool {
+	return slices.Contains(rolePermissions[role], permission)
+}
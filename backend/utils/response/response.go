@@ -12,19 +12,19 @@ const (
 type Response struct {
 	// Success indicates if the request was successful
 	// @Description Indicates if the request was successful
-	Success bool        `json:"success"`
-	
+	Success bool `json:"success"`
+
 	// Message contains a human-readable response message
 	// @Description Human-readable response message
-	Message string      `json:"message"`
-	
+	Message string `json:"message"`
+
 	// Data contains the actual response payload
 	// @Description Response payload data
-	Data    interface{} `json:"data"`
-	
+	Data interface{} `json:"data"`
+
 	// Error contains error details if Success is false
 	// @Description Error details when Success is false
-	Error   string      `json:"error,omitempty"`
+	Error string `json:"error,omitempty"`
 }
 
 // WithSuccess sends a JSON response with a success status.
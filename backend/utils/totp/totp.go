@@ -0,0 +1,131 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Issuer is the name shown next to the account in authenticator apps
+	Issuer string = "Oxford 5000 API"
+
+	secretSize int           = 20
+	digits     int           = 6
+	period     time.Duration = 30 * time.Second
+	// skew is the number of periods accepted before and after the current one
+	skew int64 = 1
+
+	recoveryCodeSize  int = 5
+	RecoveryCodeCount int = 10
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret creates a new random TOTP secret.
+// Returns the secret as an unpadded base32 string, as expected by authenticator apps.
+func GenerateSecret() (string, error) {
+	randomBytes := make([]byte, secretSize)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", fmt.Errorf("generate totp secret: %w", err)
+	}
+
+	return encoding.EncodeToString(randomBytes), nil
+}
+
+// ProvisioningURI builds the otpauth:// URI that authenticator apps read from a QR code.
+func ProvisioningURI(account string, secret string) string {
+	label := url.PathEscape(Issuer + ":" + account)
+
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", Issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(digits))
+	query.Set("period", fmt.Sprint(int(period.Seconds())))
+
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// Validate checks a code against the secret at the given time, allowing for clock skew.
+// Returns the time step the code matched so callers can reject replays of the same step,
+// or an error if the code does not match.
+func Validate(secret string, code string, at time.Time) (int64, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0, fmt.Errorf("decode totp secret: %w", err)
+	}
+
+	code = strings.TrimSpace(code)
+	if len(code) != digits {
+		return 0, fmt.Errorf("invalid totp code")
+	}
+
+	current := at.Unix() / int64(period.Seconds())
+	for step := current - skew; step <= current+skew; step++ {
+		if subtle.ConstantTimeCompare([]byte(generateCode(key, step)), []byte(code)) == 1 {
+			return step, nil
+		}
+	}
+
+	return 0, fmt.Errorf("invalid totp code")
+}
+
+// GenerateRecoveryCodes creates a set of single-use recovery codes in the format "xxxx-xxxx".
+func GenerateRecoveryCodes() ([]string, error) {
+	codes := make([]string, 0, RecoveryCodeCount)
+
+	for range RecoveryCodeCount {
+		randomBytes := make([]byte, recoveryCodeSize)
+		if _, err := rand.Read(randomBytes); err != nil {
+			return nil, fmt.Errorf("generate recovery codes: %w", err)
+		}
+
+		code := strings.ToLower(encoding.EncodeToString(randomBytes))
+		codes = append(codes, code[:4]+"-"+code[4:])
+	}
+
+	return codes, nil
+}
+
+// IsRecoveryCode reports whether a code has the format of the codes of GenerateRecoveryCodes, in lower case
+func IsRecoveryCode(code string) bool {
+	if len(code) != 9 || code[4] != '-' {
+		return false
+	}
+
+	for i, c := range code {
+		if i != 4 && !('a' <= c && c <= 'z' || '2' <= c && c <= '7') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// generateCode computes the RFC 6238 code for a single time step
+func generateCode(key []byte, step int64) string {
+	message := make([]byte, 8)
+	binary.BigEndian.PutUint64(message, uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(message)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulo := uint32(1)
+	for range digits {
+		modulo *= 10
+	}
+
+	return fmt.Sprintf("%0*d", digits, value%modulo)
+}
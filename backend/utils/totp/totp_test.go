@@ -0,0 +1,136 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 secret of the RFC 6238 test vectors
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestValidateRFC6238(t *testing.T) {
+	// The last six digits of the SHA-1 vectors of RFC 6238 appendix B
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1111111111: "050471",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+
+	for unix, code := range vectors {
+		at := time.Unix(unix, 0)
+		step, err := Validate(rfcSecret, code, at)
+		if err != nil {
+			t.Errorf("Validate(%s) at %d: %v", code, unix, err)
+			continue
+		}
+		if want := unix / 30; step != want {
+			t.Errorf("Validate(%s) at %d matched step %d, want %d", code, unix, step, want)
+		}
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	at := time.Unix(1234567890, 0)
+
+	for _, offset := range []time.Duration{-period, period} {
+		if _, err := Validate(rfcSecret, "005924", at.Add(offset)); err != nil {
+			t.Errorf("code of the adjacent period at %s: %v", offset, err)
+		}
+	}
+
+	for _, offset := range []time.Duration{-2 * period, 2 * period} {
+		if _, err := Validate(rfcSecret, "005924", at.Add(offset)); err == nil {
+			t.Errorf("code accepted %s away, want it rejected", offset)
+		}
+	}
+}
+
+func TestValidateRejects(t *testing.T) {
+	at := time.Unix(1234567890, 0)
+
+	for _, code := range []string{"", "005925", "05924", "0059240", "abcdef"} {
+		if _, err := Validate(rfcSecret, code, at); err == nil {
+			t.Errorf("Validate(%q) succeeded, want an error", code)
+		}
+	}
+
+	if _, err := Validate("not base32!", "005924", at); err == nil {
+		t.Error("Validate with an invalid secret succeeded, want an error")
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatalf("GenerateSecret: %v", err)
+	}
+
+	key, err := encoding.DecodeString(secret)
+	if err != nil {
+		t.Fatalf("decode secret %q: %v", secret, err)
+	}
+	if len(key) != secretSize {
+		t.Errorf("secret has %d bytes, want %d", len(key), secretSize)
+	}
+
+	// Generated secrets validate their own codes
+	at := time.Now()
+	code := generateCode(key, at.Unix()/int64(period.Seconds()))
+	if _, err := Validate(strings.ToLower(secret), code, at); err != nil {
+		t.Errorf("Validate of a code of a generated secret: %v", err)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes()
+	if err != nil {
+		t.Fatalf("GenerateRecoveryCodes: %v", err)
+	}
+	if len(codes) != RecoveryCodeCount {
+		t.Fatalf("got %d recovery codes, want %d", len(codes), RecoveryCodeCount)
+	}
+
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if !IsRecoveryCode(code) {
+			t.Errorf("generated code %q is not a recovery code", code)
+		}
+		if seen[code] {
+			t.Errorf("duplicate recovery code %q", code)
+		}
+		seen[code] = true
+	}
+}
+
+func TestIsRecoveryCode(t *testing.T) {
+	valid := []string{"abcd-efgh", "a2b3-c4d5", "7777-2222"}
+	invalid := []string{"", "123456", "abcdefgh", "abcd-efg", "abcd-efghi", "abcd_efgh", "ABCD-EFGH", "abc1-efgh", "abcd-efg8"}
+
+	for _, code := range valid {
+		if !IsRecoveryCode(code) {
+			t.Errorf("IsRecoveryCode(%q) = false, want true", code)
+		}
+	}
+	for _, code := range invalid {
+		if IsRecoveryCode(code) {
+			t.Errorf("IsRecoveryCode(%q) = true, want false", code)
+		}
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+	uri := ProvisioningURI("ana@example.com", "SECRET")
+
+	if !strings.HasPrefix(uri, "otpauth://totp/") {
+		t.Errorf("URI %q is not an otpauth totp URI", uri)
+	}
+	for _, part := range []string{"secret=SECRET", "digits=6", "period=30", "algorithm=SHA1"} {
+		if !strings.Contains(uri, part) {
+			t.Errorf("URI %q does not contain %q", uri, part)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+const (
+	// Timeout is how long a webhook endpoint has to respond
+	Timeout time.Duration = 10 * time.Second
+	// UserAgent identifies webhook requests
+	UserAgent string = "dictionary-api-webhooks/1.0"
+	// IdHeader carries the ID of the event, which stays the same when it is delivered again
+	IdHeader string = "Webhook-Id"
+)
+
+var (
+	ErrInvalidURL     = errors.New("webhook url must be an absolute http or https url")
+	ErrPrivateAddress = errors.New("webhook url resolves to a private address")
+)
+
+// NewClient returns the HTTP client webhooks are delivered with. Unless allowPrivate is set, it refuses to connect
+// to loopback, private and link-local addresses, so that user supplied URLs cannot reach internal services.
+// The address is checked when connecting, after DNS resolution, so that DNS rebinding cannot get around it.
+func NewClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: Timeout}
+	if !allowPrivate {
+		dialer.Control = func(network string, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || isPrivate(ip) {
+				return fmt.Errorf("%w: %s", ErrPrivateAddress, host)
+			}
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	transport.Proxy = nil
+
+	return &http.Client{
+		Timeout:   Timeout,
+		Transport: transport,
+		// Redirects are not followed, endpoints have to answer themselves
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// Send posts the signed JSON payload of the event with the given ID to endpoint and returns the response status,
+// 0 if there was no response. Endpoints acknowledge webhooks with a 2xx status, any other status is an error.
+func Send(ctx context.Context, client *http.Client, endpoint string, secret string, id string, payload []byte, now time.Time) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return 0, fmt.Errorf("send webhook: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", UserAgent)
+	req.Header.Set(IdHeader, id)
+	req.Header.Set(SignatureHeader, Sign(payload, secret, now))
+
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("send webhook: %w", err)
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("send webhook: %s responded %d", endpoint, res.StatusCode)
+	}
+
+	return res.StatusCode, nil
+}
+
+// ValidateURL checks that raw is an absolute http or https URL
+func ValidateURL(raw string) error {
+	parsed, err := url.Parse(raw)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" || parsed.User != nil {
+		return ErrInvalidURL
+	}
+
+	return nil
+}
+
+// GenerateSecret returns a random secret for signing the webhooks of an endpoint
+func GenerateSecret() string {
+	b := make([]byte, 24)
+	_, _ = rand.Read(b)
+	return "whsec_" + hex.EncodeToString(b)
+}
+
+func isPrivate(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsUnspecified() || ip.IsMulticast() || ip.IsInterfaceLocalMulticast()
+}
@@ -0,0 +1,60 @@
+package webhook
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	signedAt := time.Unix(1700000000, 0)
+
+	// HMAC-SHA256 of "1700000000.{}" with the key "secret", so receivers in other languages can check against it
+	want := "t=1700000000,v1=b8569b78799ff9e3cbff0fc2d63a33a2b57f3282abd07c37ae5e8e7d79a5f163"
+	if got := Sign([]byte("{}"), "secret", signedAt); got != want {
+		t.Errorf("Sign() = %s, want %s", got, want)
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	payload := []byte(`{"type":"quota.threshold"}`)
+	signedAt := time.Unix(1700000000, 0)
+	header := Sign(payload, "secret", signedAt)
+	timestamp := strconv.FormatInt(signedAt.Unix(), 10)
+
+	tests := []struct {
+		name    string
+		payload []byte
+		header  string
+		secret  string
+		now     time.Time
+		valid   bool
+	}{
+		{"valid", payload, header, "secret", signedAt, true},
+		{"within tolerance", payload, header, "secret", signedAt.Add(SignatureTolerance), true},
+		{"clock skew within tolerance", payload, header, "secret", signedAt.Add(-SignatureTolerance), true},
+		{"with spaces", payload, "t=" + timestamp + ", v1=" + signature(payload, "secret", timestamp), "secret", signedAt, true},
+		// Endpoints rotating their secret are sent one signature per secret
+		{"one of several signatures", payload, header + ",v1=" + signature(payload, "old", timestamp), "secret", signedAt, true},
+		{"changed payload", []byte(`{"type":"quota.exceeded"}`), header, "secret", signedAt, false},
+		{"wrong secret", payload, header, "other", signedAt, false},
+		{"replayed", payload, header, "secret", signedAt.Add(SignatureTolerance + time.Second), false},
+		{"from the future", payload, header, "secret", signedAt.Add(-SignatureTolerance - time.Second), false},
+		{"changed timestamp", payload, "t=" + strconv.FormatInt(signedAt.Unix()+60, 10) + ",v1=" + signature(payload, "secret", timestamp), "secret", signedAt, false},
+		{"no timestamp", payload, "v1=" + signature(payload, "secret", timestamp), "secret", signedAt, false},
+		{"no signature", payload, "t=" + timestamp, "secret", signedAt, false},
+		{"empty", payload, "", "secret", signedAt, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := VerifySignature(test.payload, test.header, test.secret, test.now)
+			if test.valid && err != nil {
+				t.Errorf("VerifySignature() = %v, want nil", err)
+			}
+			if !test.valid && err != ErrInvalidSignature {
+				t.Errorf("VerifySignature() = %v, want %v", err, ErrInvalidSignature)
+			}
+		})
+	}
+}